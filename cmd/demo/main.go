@@ -5,12 +5,16 @@ import (
 	"embed"
 	"errors"
 	"log"
+	"sync"
 
 	"github.com/euiko/webapp"
 	"github.com/euiko/webapp/db/sqldb"
 	"github.com/euiko/webapp/module/auth"
+	authlib "github.com/euiko/webapp/module/auth/lib"
+	"github.com/euiko/webapp/module/idempotency"
 	"github.com/euiko/webapp/module/rbac"
 	"github.com/euiko/webapp/module/static"
+	"github.com/euiko/webapp/pkg/password"
 	"github.com/mitchellh/mapstructure"
 )
 
@@ -32,16 +36,33 @@ type (
 	}
 
 	userLoader struct{}
+
+	// passwordHook stores the rehashed password of the demo user
+	passwordHook struct {
+		authlib.NopHook[*User]
+	}
 )
 
 var (
+	// demoUser keeps the password hashed, it is rehashed on login when the
+	// configured algorithm differs from the default one
 	demoUser = User{
 		LoginId:  "demo",
-		Password: "12345678",
+		Password: mustHashPassword("12345678"),
 		Role:     "admin",
 	}
+	demoUserMutex sync.Mutex
 )
 
+func mustHashPassword(plain string) string {
+	hashed, err := password.Hash(plain)
+	if err != nil {
+		panic(err)
+	}
+
+	return hashed
+}
+
 func (u *User) LoginID() string {
 	return u.LoginId
 }
@@ -81,6 +102,9 @@ func newUserLoader() *userLoader {
 }
 
 func (l *userLoader) UserById(ctx context.Context, loginId string) (*User, error) {
+	demoUserMutex.Lock()
+	defer demoUserMutex.Unlock()
+
 	if loginId == demoUser.LoginId {
 		return &User{
 			LoginId:  demoUser.LoginId,
@@ -92,9 +116,11 @@ func (l *userLoader) UserById(ctx context.Context, loginId string) (*User, error
 	return nil, errors.New("users not found")
 }
 
-func (l *userLoader) LoadUser(ctx context.Context, loginId string, password string) (*User, error) {
-	log.Println("loginId", loginId, "password", password)
-	if loginId == demoUser.LoginId && password == demoUser.Password {
+func (l *userLoader) LoadUser(ctx context.Context, loginId string, plain string) (*User, error) {
+	demoUserMutex.Lock()
+	defer demoUserMutex.Unlock()
+
+	if loginId == demoUser.LoginId && password.Verify(ctx, plain, demoUser.Password) == nil {
 		return &User{
 			LoginId:  demoUser.LoginId,
 			Password: demoUser.Password,
@@ -105,6 +131,38 @@ func (l *userLoader) LoadUser(ctx context.Context, loginId string, password stri
 	return nil, errors.New("invalid login")
 }
 
+// UserClaims embeds the role so the user can be rebuilt from the token
+func (l *userLoader) UserClaims(ctx context.Context, user *User) (map[string]any, error) {
+	return map[string]any{"role": user.Role}, nil
+}
+
+func (l *userLoader) UserFromClaims(ctx context.Context, subject string, claims map[string]any) (*User, bool, error) {
+	role, ok := claims["role"].(string)
+	if !ok {
+		return nil, false, nil
+	}
+
+	return &User{
+		LoginId: subject,
+		Role:    role,
+	}, true, nil
+}
+
+func (passwordHook) AfterLogin(ctx context.Context, user *User, token *string) error {
+	rehashed, ok := password.RehashFromContext(ctx)
+	if !ok {
+		return nil
+	}
+
+	demoUserMutex.Lock()
+	defer demoUserMutex.Unlock()
+	if user.LoginId == demoUser.LoginId {
+		demoUser.Password = rehashed
+	}
+
+	return nil
+}
+
 func main() {
 	// register migrations
 	sqldb.AddMigrationFS(migrations)
@@ -113,8 +171,9 @@ func main() {
 
 	// Service modules
 	app.Register(static.ModuleFactory())
-	app.Register(auth.ModuleFactory(newUserLoader()))
+	app.Register(auth.ModuleFactory(newUserLoader(), auth.WithHooks[*User](passwordHook{})))
 	app.Register(rbac.ModuleFactory())
+	app.Register(idempotency.ModuleFactory())
 	app.Register(newHelloService)
 	if err := app.Run(context.Background()); err != nil {
 		log.Fatal(err)
@@ -4,7 +4,12 @@ import (
 	"net/http"
 
 	"github.com/euiko/webapp/core"
+	"github.com/euiko/webapp/db/cache"
+	"github.com/euiko/webapp/db/sqldb"
+	"github.com/euiko/webapp/pkg/compress"
+	"github.com/euiko/webapp/pkg/csrf"
 	"github.com/euiko/webapp/pkg/log"
+	"github.com/euiko/webapp/pkg/secure"
 	"github.com/euiko/webapp/pkg/session"
 	"github.com/euiko/webapp/settings"
 )
@@ -19,23 +24,136 @@ func newInjectAppMiddleware(app core.App) func(http.Handler) http.Handler {
 	}
 }
 
-func newSessionMiddleware(_ *settings.Settings) func(http.Handler) http.Handler {
-	return func(h http.Handler) http.Handler {
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			e := session.NewHTTPCookieEncoding(w, r)
-			sessionValue, err := e.Decode()
-			if err != nil {
-				// just log the error
-				log.Debug("failed to decode session, falling back to empty session", log.WithError(err))
-				sessionValue = session.New()
-			}
-
-			ctx := session.WithContext(r.Context(), sessionValue)
-			r = r.WithContext(ctx)
+func newSessionMiddleware(s *settings.Settings) func(http.Handler) http.Handler {
+	var (
+		store  = newSessionStore(s)
+		codec  = newSessionCodec(s)
+		cookie = session.Cookie{
+			Name:     s.Server.Session.Cookie.Name,
+			Path:     s.Server.Session.Cookie.Path,
+			Domain:   s.Server.Session.Cookie.Domain,
+			MaxAge:   s.Server.Session.Cookie.MaxAge,
+			Secure:   s.Server.Session.Cookie.Secure,
+			HTTPOnly: s.Server.Session.Cookie.HTTPOnly,
+			SameSite: session.ParseSameSite(s.Server.Session.Cookie.SameSite),
+		}
+	)
 
-			h.ServeHTTP(w, r)
+	return session.Middleware(func(w http.ResponseWriter, r *http.Request) session.Encoding {
+		if store != nil {
+			return session.NewStoreEncoding(store, w, r,
+				session.WithStoreCookie(cookie),
+				session.WithStoreTTL(s.Server.Session.TTL, s.Server.Session.Sliding),
+			)
+		}
 
-			e.Encode(sessionValue)
-		})
+		return session.NewHTTPCookieEncoding(w, r,
+			session.WithCookie(cookie),
+			session.WithCodec(codec),
+			session.WithTTL(s.Server.Session.TTL),
+		)
+	}, store)
+}
+
+// newSessionStore returns nil when the sessions are stored in the cookie
+func newSessionStore(s *settings.Settings) session.Store {
+	switch s.Server.Session.Store {
+	case "cache":
+		return session.NewCacheStore(cache.InMemory())
+	case "sql":
+		return session.NewOrmStore(sqldb.ORM())
 	}
+
+	return nil
+}
+
+// newSessionCodec prefers encryption over signing, and falls back to an
+// ephemeral key when none is configured
+func newSessionCodec(s *settings.Settings) session.Codec {
+	var (
+		codec session.Codec
+		err   error
+	)
+
+	switch {
+	case len(s.Server.Session.EncryptionKeys) > 0:
+		codec, err = session.NewEncryptedCodec(sessionKeys(s.Server.Session.EncryptionKeys)...)
+	case len(s.Server.Session.SigningKeys) > 0:
+		codec, err = session.NewSignedCodec(sessionKeys(s.Server.Session.SigningKeys)...)
+	default:
+		log.Warning("no session keys configured, using an ephemeral key which is invalidated on restart")
+		return session.EphemeralCodec()
+	}
+
+	if err != nil {
+		log.Fatal("invalid session keys", log.WithError(err))
+	}
+
+	return codec
+}
+
+func sessionKeys(keys []string) [][]byte {
+	decoded := make([][]byte, len(keys))
+	for i, key := range keys {
+		decoded[i] = []byte(key)
+	}
+
+	return decoded
+}
+
+func newCSRFMiddleware(s *settings.Settings) func(http.Handler) http.Handler {
+	mode := csrf.ModeSynchronizer
+	if s.Server.CSRF.Mode == "double-submit" {
+		mode = csrf.ModeDoubleSubmit
+	}
+
+	return csrf.Middleware(
+		csrf.WithMode(mode),
+		csrf.WithCookie(s.Server.CSRF.CookieName, "/", s.Server.CSRF.CookieSecure),
+		csrf.WithHeaderName(s.Server.CSRF.HeaderName),
+		csrf.WithFieldName(s.Server.CSRF.FieldName),
+		csrf.WithTrustedOrigins(s.Server.CSRF.TrustedOrigins...),
+	)
+}
+
+func newCompressionMiddleware(s *settings.Settings) func(http.Handler) http.Handler {
+	opts := []compress.Option{
+		compress.WithMinSize(s.Server.Compression.MinSize),
+	}
+
+	if len(s.Server.Compression.Encodings) > 0 {
+		opts = append(opts, compress.WithEncodings(s.Server.Compression.Encodings...))
+	}
+
+	if len(s.Server.Compression.ContentTypes) > 0 {
+		opts = append(opts, compress.WithContentTypes(s.Server.Compression.ContentTypes...))
+	}
+
+	return compress.Middleware(opts...)
+}
+
+func newSecureHeadersMiddleware(s *settings.Settings) func(http.Handler) http.Handler {
+	var (
+		headers = s.Server.Secure
+		opts    = []secure.Option{
+			secure.WithHSTS(headers.HSTSMaxAge, headers.HSTSIncludeSubdomains, headers.HSTSPreload),
+			secure.WithContentTypeNosniff(headers.ContentTypeNosniff),
+			secure.WithReferrerPolicy(headers.ReferrerPolicy),
+			secure.WithPermissionsPolicy(headers.PermissionsPolicy),
+			secure.WithFrameAncestors(headers.FrameAncestors...),
+			secure.WithCrossOriginPolicies(headers.CrossOriginOpenerPolicy, headers.CrossOriginEmbedderPolicy),
+		}
+	)
+
+	if len(headers.CSP) > 0 {
+		csp := secure.NewCSP()
+		for directive, sources := range headers.CSP {
+			csp.Set(directive, sources...)
+		}
+		csp.WithNonce(headers.CSPNonceDirectives...)
+
+		opts = append(opts, secure.WithCSP(csp, headers.CSPReportOnly))
+	}
+
+	return secure.Middleware(opts...)
 }
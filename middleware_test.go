@@ -0,0 +1,74 @@
+package webapp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/euiko/webapp/pkg/session"
+	"github.com/euiko/webapp/settings"
+)
+
+// roundTrip serves the request with the cookies of the previous response,
+// as a browser would send them back
+func roundTrip(handler http.Handler, req *http.Request, previous *httptest.ResponseRecorder) *httptest.ResponseRecorder {
+	if previous != nil {
+		for _, cookie := range previous.Result().Cookies() {
+			req.AddCookie(cookie)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestCSRFSynchronizerSession(t *testing.T) {
+	s := settings.New()
+	s.Server.Session.SigningKeys = []string{"test-signing-key"}
+
+	// the handler writes the response before the session is encoded
+	handler := newSessionMiddleware(&s)(newCSRFMiddleware(&s)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})))
+
+	first := roundTrip(handler, httptest.NewRequest(http.MethodGet, "http://example.com/", nil), nil)
+	token := first.Header().Get(s.Server.CSRF.HeaderName)
+	if token == "" {
+		t.Fatal("expected the csrf token to be exposed")
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "http://example.com/", nil)
+	req.Header.Set(s.Server.CSRF.HeaderName, token)
+	if rec := roundTrip(handler, req, first); rec.Code != http.StatusNoContent {
+		t.Fatalf("expected the token stored in the session to be accepted, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestStoreSessionReachesClient(t *testing.T) {
+	s := settings.New()
+	s.Server.Session.Store = "cache"
+
+	// the handler flushes the response before the session is encoded
+	handler := newSessionMiddleware(&s)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			_ = session.Add(r.Context(), "user", "alice")
+			w.WriteHeader(http.StatusNoContent)
+			w.(http.Flusher).Flush()
+			return
+		}
+
+		var user string
+		_ = session.Get(r.Context(), "user", &user)
+		w.Write([]byte(user))
+	}))
+
+	login := roundTrip(handler, httptest.NewRequest(http.MethodPost, "http://example.com/", nil), nil)
+	if len(login.Result().Cookies()) == 0 {
+		t.Fatal("expected the new session cookie to be sent")
+	}
+
+	if rec := roundTrip(handler, httptest.NewRequest(http.MethodGet, "http://example.com/", nil), login); rec.Body.String() != "alice" {
+		t.Fatalf("expected the session stored server-side, got %q", rec.Body.String())
+	}
+}
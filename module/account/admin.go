@@ -0,0 +1,169 @@
+package account
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/euiko/webapp/core"
+	"github.com/euiko/webapp/db/sqldb"
+	api "github.com/euiko/webapp/module/account/internal/api"
+	idempotencylib "github.com/euiko/webapp/module/idempotency/lib"
+	rbaclib "github.com/euiko/webapp/module/rbac/lib"
+	"github.com/euiko/webapp/module/rbac/lib/role"
+	"github.com/euiko/webapp/pkg/common/httpapi"
+	"github.com/euiko/webapp/pkg/helper"
+	"github.com/go-chi/chi/v5"
+)
+
+var (
+	ErrSelfManagement = errors.New("cannot change the role or the status of your own account")
+	ErrRoleNotAllowed = errors.New("cannot assign a role granting permissions you don't have")
+)
+
+// adminRoute registers the endpoints to manage the users, it must be
+// called within the authenticated group
+func (m *Module) adminRoute(r core.Router) {
+	r.Method("GET", "/users", role.Handler(rbaclib.PermissionManageUsers, http.HandlerFunc(m.listUsersHandler)))
+	r.With(idempotencylib.IdempotencyMiddleware(m.app)).
+		Method("POST", "/users", role.Handler(rbaclib.PermissionManageUsers, http.HandlerFunc(m.createUserHandler)))
+	r.Method("GET", "/users/{id}", role.Handler(rbaclib.PermissionManageUsers, http.HandlerFunc(m.getUserHandler)))
+	r.Method("POST", "/users/{id}/disable", role.Handler(rbaclib.PermissionManageUsers, http.HandlerFunc(m.disableUserHandler)))
+	r.Method("POST", "/users/{id}/enable", role.Handler(rbaclib.PermissionManageUsers, http.HandlerFunc(m.enableUserHandler)))
+	r.Method("PUT", "/users/{id}/role", role.Handler(rbaclib.PermissionManageUsers, http.HandlerFunc(m.setRoleHandler)))
+}
+
+func (m *Module) listUsersHandler(w http.ResponseWriter, r *http.Request) {
+	params := api.ListUsersParams{
+		PaginationParams: httpapi.PaginationParams{
+			Page:     1,
+			PageSize: 10,
+		},
+	}
+	if err := helper.DecodeRequest(r, &params); err != nil {
+		helper.WriteResponse(w, err)
+		return
+	}
+
+	users, total, err := m.ListUsers(r.Context(), params.ToBase())
+	if !writeError(w, err) {
+		return
+	}
+
+	helper.WriteResponse(w, api.ToListUsersResponse(params.PaginationParams, users, total))
+}
+
+func (m *Module) createUserHandler(w http.ResponseWriter, r *http.Request) {
+	var payload api.CreateUserPayload
+	if err := helper.DecodeRequestBody(r, &payload); err != nil {
+		helper.WriteResponse(w, err)
+		return
+	}
+
+	newUser := payload.ToBase()
+	if !m.assignableRole(w, r, newUser.Role) {
+		return
+	}
+
+	user, err := m.CreateUser(r.Context(), newUser)
+	if !writeError(w, err) {
+		return
+	}
+
+	helper.WriteResponse(w, api.ToUser(*user), helper.ResponseWithStatus(http.StatusCreated))
+}
+
+func (m *Module) getUserHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := m.GetUser(r.Context(), chi.URLParam(r, "id"))
+	if !writeError(w, err) {
+		return
+	}
+
+	helper.WriteResponse(w, api.ToUser(*user))
+}
+
+func (m *Module) disableUserHandler(w http.ResponseWriter, r *http.Request) {
+	username, ok := m.managedUsername(w, r)
+	if !ok || !writeError(w, m.DisableUser(r.Context(), username)) {
+		return
+	}
+
+	helper.WriteResponse(w, map[string]interface{}{
+		"message": "user disabled",
+	})
+}
+
+func (m *Module) enableUserHandler(w http.ResponseWriter, r *http.Request) {
+	username, ok := m.managedUsername(w, r)
+	if !ok || !writeError(w, m.EnableUser(r.Context(), username)) {
+		return
+	}
+
+	helper.WriteResponse(w, map[string]interface{}{
+		"message": "user enabled",
+	})
+}
+
+func (m *Module) setRoleHandler(w http.ResponseWriter, r *http.Request) {
+	username, ok := m.managedUsername(w, r)
+	if !ok {
+		return
+	}
+
+	var payload api.SetRolePayload
+	if err := helper.DecodeRequestBody(r, &payload); err != nil {
+		helper.WriteResponse(w, err)
+		return
+	}
+
+	if !m.assignableRole(w, r, payload.Role) {
+		return
+	}
+
+	if !writeError(w, m.SetRole(r.Context(), username, payload.Role)) {
+		return
+	}
+
+	helper.WriteResponse(w, map[string]interface{}{
+		"message": "role assigned",
+	})
+}
+
+// managedUsername returns the username of the url, the admins can't change
+// their own account so they don't lock themselves out
+func (m *Module) managedUsername(w http.ResponseWriter, r *http.Request) (string, bool) {
+	username := normalize(chi.URLParam(r, "id"))
+	if username == normalize(currentLoginID(r)) {
+		helper.WriteResponse(w, ErrSelfManagement, helper.ResponseWithStatus(http.StatusForbidden))
+		return "", false
+	}
+
+	return username, true
+}
+
+// assignableRole returns true when the current user holds all of the
+// permissions of the role, so the admins can't grant more than their own
+func (m *Module) assignableRole(w http.ResponseWriter, r *http.Request, name string) bool {
+	if m.getRole == nil {
+		return true
+	}
+
+	if name == "" {
+		name = m.settings.DefaultRole
+	}
+
+	targetRole, err := m.getRole(r.Context(), name)
+	if sqldb.IsNoRows(err) {
+		// the unknown role is rejected when it is assigned
+		return true
+	} else if !writeError(w, err) {
+		return false
+	}
+
+	granted := rbaclib.PermissionsFromContext(r.Context())
+	if granted == nil || !granted.HasAllIDs(targetRole.Permissions...) {
+		helper.WriteResponse(w, ErrRoleNotAllowed, helper.ResponseWithStatus(http.StatusForbidden))
+		return false
+	}
+
+	return true
+}
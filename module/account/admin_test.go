@@ -0,0 +1,64 @@
+package account
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	rbaclib "github.com/euiko/webapp/module/rbac/lib"
+	"github.com/euiko/webapp/module/rbac/lib/role"
+)
+
+func TestAssignableRole(t *testing.T) {
+	var (
+		manageUsers = role.NewPermission("admin", "manage-users")
+		manageRoles = role.NewPermission("admin", "manage-roles")
+		roles       = map[string][]*role.Permission{
+			"user":  {},
+			"staff": {manageUsers},
+			"admin": {manageUsers, manageRoles},
+		}
+	)
+
+	m := NewModule(nil)
+	m.getRole = func(ctx context.Context, name string) (*role.Role, error) {
+		permissions, ok := roles[name]
+		if !ok {
+			return nil, sql.ErrNoRows
+		}
+
+		return &role.Role{Base: role.Base{Name: name, Permissions: role.PermissionsToIDs(permissions...)}}, nil
+	}
+
+	cases := map[string]struct {
+		granted []*role.Permission
+		role    string
+		allowed bool
+	}{
+		"default role":        {granted: roles["staff"], role: "", allowed: true},
+		"same permissions":    {granted: roles["staff"], role: "staff", allowed: true},
+		"more permissions":    {granted: roles["staff"], role: "admin", allowed: false},
+		"fewer permissions":   {granted: roles["admin"], role: "staff", allowed: true},
+		"unknown role":        {granted: roles["staff"], role: "unknown", allowed: true},
+		"without permissions": {granted: nil, role: "user", allowed: false},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPut, "/users/bob/role", nil)
+			if c.granted != nil {
+				req = req.WithContext(rbaclib.ContextWithPermissions(req.Context(), role.NewPermissionManager(c.granted)))
+			}
+
+			rec := httptest.NewRecorder()
+			if allowed := m.assignableRole(rec, req, c.role); allowed != c.allowed {
+				t.Fatalf("expected %v, got %v", c.allowed, allowed)
+			}
+			if !c.allowed && rec.Code != http.StatusForbidden {
+				t.Fatalf("expected 403, got %d", rec.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,150 @@
+package api
+
+import (
+	"time"
+
+	"github.com/euiko/webapp/module/account/lib"
+	"github.com/euiko/webapp/pkg/common/httpapi"
+)
+
+type (
+	User struct {
+		ID            int64      `json:"id"`
+		Username      string     `json:"username"`
+		Email         string     `json:"email"`
+		EmailVerified bool       `json:"email_verified"`
+		Name          string     `json:"name"`
+		Role          string     `json:"role"`
+		Disabled      bool       `json:"disabled"`
+		LastLoginAt   *time.Time `json:"last_login_at"`
+		CreatedAt     time.Time  `json:"created_at"`
+		UpdatedAt     time.Time  `json:"updated_at"`
+	}
+
+	RegisterPayload struct {
+		Username string `json:"username" validate:"required,min=3,max=64,alphanum"`
+		Email    string `json:"email" validate:"required,email,max=320"`
+		Password string `json:"password" validate:"required,password=Username"`
+		Name     string `json:"name" validate:"max=255"`
+	}
+
+	TokenPayload struct {
+		Token string `json:"token" validate:"required"`
+	}
+
+	ForgotPasswordPayload struct {
+		Email string `json:"email" validate:"required,email"`
+	}
+
+	ResetPasswordPayload struct {
+		Token    string `json:"token" validate:"required"`
+		Password string `json:"password" validate:"required"`
+	}
+
+	ProfilePayload struct {
+		Name string `json:"name" validate:"max=255"`
+	}
+
+	ChangePasswordPayload struct {
+		CurrentPassword string `json:"current_password" validate:"required"`
+		Password        string `json:"password" validate:"required"`
+	}
+
+	ChangeEmailPayload struct {
+		Password string `json:"password" validate:"required"`
+		Email    string `json:"email" validate:"required,email,max=320"`
+	}
+
+	PasswordPayload struct {
+		Password string `json:"password" validate:"required"`
+	}
+
+	ListUsersParams struct {
+		httpapi.SearchParams
+		httpapi.PaginationParams
+		Role string `in:"query=role" json:"role"`
+	}
+
+	ListUsersResponse struct {
+		Items      []User             `json:"items"`
+		Pagination httpapi.Pagination `json:"pagination"`
+	}
+
+	CreateUserPayload struct {
+		Username      string `json:"username" validate:"required,min=3,max=64,alphanum"`
+		Email         string `json:"email" validate:"required,email,max=320"`
+		Password      string `json:"password" validate:"required,password=Username"`
+		Name          string `json:"name" validate:"max=255"`
+		Role          string `json:"role" validate:"max=255"`
+		EmailVerified bool   `json:"email_verified"`
+	}
+
+	SetRolePayload struct {
+		Role string `json:"role" validate:"required,max=255"`
+	}
+)
+
+func ToUser(user lib.User) User {
+	u := User{
+		ID:            user.ID,
+		Username:      user.Username,
+		Email:         user.Email,
+		EmailVerified: user.EmailVerified(),
+		Name:          user.DisplayName,
+		Role:          user.Role,
+		Disabled:      user.Disabled(),
+		CreatedAt:     user.CreatedAt,
+		UpdatedAt:     user.UpdatedAt,
+	}
+
+	if !user.LastLoginAt.IsZero() {
+		u.LastLoginAt = &user.LastLoginAt
+	}
+
+	return u
+}
+
+func ToListUsersResponse(pagy httpapi.PaginationParams, users []lib.User, total int) ListUsersResponse {
+	resp := ListUsersResponse{
+		Items: make([]User, len(users)),
+		Pagination: httpapi.Pagination{
+			Page:     pagy.Page,
+			PageSize: pagy.PageSize,
+			Total:    total,
+		},
+	}
+
+	for i, user := range users {
+		resp.Items[i] = ToUser(user)
+	}
+
+	return resp
+}
+
+func (p ListUsersParams) ToBase() lib.ListUsersParams {
+	return lib.ListUsersParams{
+		SearchParams:     p.SearchParams.ToBase(),
+		PaginationParams: p.PaginationParams.ToBase(),
+		Role:             p.Role,
+	}
+}
+
+func (p CreateUserPayload) ToBase() lib.NewUser {
+	return lib.NewUser{
+		Username:      p.Username,
+		Email:         p.Email,
+		Password:      p.Password,
+		Name:          p.Name,
+		Role:          p.Role,
+		EmailVerified: p.EmailVerified,
+	}
+}
+
+func (p RegisterPayload) ToBase() lib.NewUser {
+	return lib.NewUser{
+		Username: p.Username,
+		Email:    p.Email,
+		Password: p.Password,
+		Name:     p.Name,
+	}
+}
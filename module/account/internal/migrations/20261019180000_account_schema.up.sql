@@ -0,0 +1,21 @@
+SET statement_timeout = 0;
+
+--bun:split
+
+CREATE SCHEMA IF NOT EXISTS account;
+
+--bun:split
+
+CREATE TABLE IF NOT EXISTS account.users (
+    id BIGSERIAL PRIMARY KEY,
+    username VARCHAR(255) NOT NULL UNIQUE,
+    email VARCHAR(320) NOT NULL UNIQUE,
+    email_verified_at TIMESTAMPTZ,
+    password_hash TEXT NOT NULL,
+    name VARCHAR(255),
+    role VARCHAR(255) NOT NULL,
+    disabled_at TIMESTAMPTZ,
+    last_login_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
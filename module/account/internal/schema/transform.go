@@ -0,0 +1,19 @@
+package schema
+
+import "github.com/euiko/webapp/module/account/lib"
+
+func (s User) ToBase() lib.User {
+	return lib.User{
+		ID:              s.ID,
+		Username:        s.Username,
+		Email:           s.Email,
+		EmailVerifiedAt: s.EmailVerifiedAt,
+		PasswordHash:    s.PasswordHash,
+		DisplayName:     s.Name,
+		Role:            s.Role,
+		DisabledAt:      s.DisabledAt,
+		LastLoginAt:     s.LastLoginAt,
+		CreatedAt:       s.CreatedAt,
+		UpdatedAt:       s.UpdatedAt,
+	}
+}
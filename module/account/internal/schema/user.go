@@ -0,0 +1,24 @@
+package schema
+
+import (
+	"time"
+
+	"github.com/euiko/webapp/db/sqldb"
+	"github.com/uptrace/bun"
+)
+
+type (
+	User struct {
+		bun.BaseModel `bun:"table:account.users,alias:users"`
+		sqldb.BaseSchema
+
+		Username        string    `bun:"username,unique,notnull"`
+		Email           string    `bun:"email,unique,notnull"`
+		EmailVerifiedAt time.Time `bun:"email_verified_at,nullzero"`
+		PasswordHash    string    `bun:"password_hash,notnull"`
+		Name            string    `bun:"name,nullzero"`
+		Role            string    `bun:"role,notnull"`
+		DisabledAt      time.Time `bun:"disabled_at,nullzero"`
+		LastLoginAt     time.Time `bun:"last_login_at,nullzero"`
+	}
+)
@@ -0,0 +1,35 @@
+package lib
+
+import (
+	"context"
+	"errors"
+)
+
+type (
+	Module interface {
+		GetUser(ctx context.Context, username string) (*User, error)
+		// Authenticate returns the user of the username or the email when
+		// the password matches and the account is active
+		Authenticate(ctx context.Context, loginId string, password string) (*User, error)
+		CreateUser(ctx context.Context, user NewUser) (*User, error)
+		UpdateProfile(ctx context.Context, username string, profile Profile) (*User, error)
+		DeleteUser(ctx context.Context, username string) error
+		ListUsers(ctx context.Context, params ListUsersParams) ([]User, int, error)
+		// DisableUser refuses the logins and the token refreshes of the user
+		DisableUser(ctx context.Context, username string) error
+		EnableUser(ctx context.Context, username string) error
+		// SetRole assigns the role to the user, the role must exist when the
+		// rbac module is registered
+		SetRole(ctx context.Context, username string, role string) error
+	}
+)
+
+var (
+	ErrUserNotFound     = errors.New("user not found")
+	ErrUserExists       = errors.New("username or email already exists")
+	ErrInvalidLogin     = errors.New("invalid login")
+	ErrUserDisabled     = errors.New("user is disabled")
+	ErrEmailNotVerified = errors.New("email is not verified")
+	ErrInvalidToken     = errors.New("invalid or expired token")
+	ErrRoleNotFound     = errors.New("role not found")
+)
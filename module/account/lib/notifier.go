@@ -0,0 +1,15 @@
+package lib
+
+import "context"
+
+type (
+	// Notifier delivers the signed tokens to the users, e.g. by sending an
+	// email with a link to the frontend which submits the token back
+	Notifier interface {
+		SendVerification(ctx context.Context, user User, token string) error
+		SendPasswordReset(ctx context.Context, user User, token string) error
+		// SendEmailChange sends the token to the new email, the change is
+		// only applied after it is confirmed
+		SendEmailChange(ctx context.Context, user User, email string, token string) error
+	}
+)
@@ -0,0 +1,26 @@
+package lib
+
+import "github.com/euiko/webapp/pkg/common/base"
+
+type (
+	NewUser struct {
+		Username string `validate:"required,min=3,max=64,alphanum"`
+		Email    string `validate:"required,email,max=320"`
+		Password string `validate:"required,password=Username"`
+		Name     string `validate:"max=255"`
+		// Role defaults to the configured default role when it is empty
+		Role          string `validate:"max=255"`
+		EmailVerified bool
+	}
+
+	ListUsersParams struct {
+		base.SearchParams
+		base.PaginationParams
+		// Role only lists the users of the role when it is not empty
+		Role string
+	}
+
+	Profile struct {
+		Name string `validate:"max=255"`
+	}
+)
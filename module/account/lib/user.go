@@ -0,0 +1,74 @@
+package lib
+
+import (
+	"time"
+
+	"github.com/mitchellh/mapstructure"
+)
+
+type (
+	// User is the account of the account module, it implements the user of
+	// the auth module and the rbac module
+	User struct {
+		ID              int64
+		Username        string
+		Email           string
+		EmailVerifiedAt time.Time
+		PasswordHash    string
+		DisplayName     string
+		Role            string
+		DisabledAt      time.Time
+		LastLoginAt     time.Time
+		CreatedAt       time.Time
+		UpdatedAt       time.Time
+	}
+
+	userSession struct {
+		Username string `json:"username" mapstructure:"username"`
+		Role     string `json:"role" mapstructure:"role"`
+	}
+)
+
+// LoginID returns the username, it is the subject of the issued tokens
+func (u *User) LoginID() string {
+	return u.Username
+}
+
+// Name returns the display name, falling back to the username
+func (u *User) Name() string {
+	if u.DisplayName != "" {
+		return u.DisplayName
+	}
+
+	return u.Username
+}
+
+func (u *User) RoleName() string {
+	return u.Role
+}
+
+func (u *User) EmailVerified() bool {
+	return !u.EmailVerifiedAt.IsZero()
+}
+
+func (u *User) Disabled() bool {
+	return !u.DisabledAt.IsZero()
+}
+
+func (u *User) MarshalSession() (interface{}, error) {
+	return userSession{
+		Username: u.Username,
+		Role:     u.Role,
+	}, nil
+}
+
+func (u *User) UnmarshalSession(value interface{}) error {
+	var session userSession
+	if err := mapstructure.Decode(value, &session); err != nil {
+		return err
+	}
+
+	u.Username = session.Username
+	u.Role = session.Role
+	return nil
+}
@@ -0,0 +1,564 @@
+package account
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/euiko/webapp/core"
+	"github.com/euiko/webapp/db/sqldb"
+	"github.com/euiko/webapp/module/account/lib"
+	authlib "github.com/euiko/webapp/module/auth/lib"
+	rbaclib "github.com/euiko/webapp/module/rbac/lib"
+	"github.com/euiko/webapp/module/rbac/lib/role"
+	"github.com/euiko/webapp/pkg/password"
+	"github.com/euiko/webapp/pkg/validator"
+	"github.com/euiko/webapp/settings"
+)
+
+type (
+	Module struct {
+		app          core.App
+		settings     Settings
+		storeFactory StoreFactory
+		store        Store
+		notifier     lib.Notifier
+		signer       *tokenSigner
+		// getRole looks up the roles when the rbac module is registered
+		getRole func(ctx context.Context, name string) (*role.Role, error)
+		// revokeUser revokes the tokens and the sessions of the user when
+		// the auth module is registered
+		revokeUser func(ctx context.Context, username string) error
+		// dummyHash is verified when the user is not found, so the missing
+		// users take as long as the wrong passwords
+		dummyHash string
+	}
+
+	ModuleOption func(*Module)
+
+	// newPassword validates the password against the policy of the auth
+	// module
+	newPassword struct {
+		Username string
+		Password string `validate:"required,password=Username"`
+	}
+)
+
+var (
+	//go:embed internal/migrations
+	embededMigrationFS embed.FS
+)
+
+func ModuleFactory(options ...ModuleOption) core.ModuleFactory {
+	return func(app core.App) core.Module {
+		return NewModule(app, options...)
+	}
+}
+
+func WithStoreFactory(factory StoreFactory) ModuleOption {
+	return func(m *Module) {
+		m.storeFactory = factory
+	}
+}
+
+// WithNotifier sets the notifier delivering the tokens to the users, the
+// tokens are only logged by default
+func WithNotifier(notifier lib.Notifier) ModuleOption {
+	return func(m *Module) {
+		m.notifier = notifier
+	}
+}
+
+func NewModule(app core.App, options ...ModuleOption) *Module {
+	m := Module{
+		app: app,
+		settings: Settings{
+			Enabled:              false,
+			AllowRegistration:    true,
+			DefaultRole:          "user",
+			RequireVerifiedEmail: false,
+			VerificationTimeout:  24 * time.Hour,
+			ResetTimeout:         time.Hour,
+			EmailChangeTimeout:   24 * time.Hour,
+		},
+		storeFactory: defaultStoreFactory,
+		notifier:     logNotifier{},
+	}
+
+	for _, opt := range options {
+		opt(&m)
+	}
+
+	return &m
+}
+
+func (m *Module) DefaultSettings(s *settings.Settings) {
+	s.SetExtra("account", &m.settings)
+}
+
+func (m *Module) Init(ctx context.Context, s *settings.Settings) error {
+	if !m.settings.Enabled {
+		return nil
+	}
+
+	if m.settings.TokenSecret == "" {
+		return errors.New("account token secret is required")
+	}
+
+	m.signer = newTokenSigner(m.settings.TokenSecret)
+	sqldb.AddMigrationFS(embededMigrationFS)
+	return nil
+}
+
+func (m *Module) Close() error {
+	return nil
+}
+
+func (m *Module) BeforeStart(ctx context.Context) error {
+	if !m.settings.Enabled {
+		return nil
+	}
+
+	var err error
+	m.store, err = m.storeFactory(m.app, &m.settings)
+	if err != nil {
+		return err
+	}
+
+	if rbacModule, ok := core.GetModule[rbaclib.Module](m.app); ok {
+		m.getRole = rbacModule.GetRole
+	}
+
+	if authModule, ok := core.GetModule[authlib.Module](m.app); ok {
+		m.revokeUser = authModule.RevokeUser
+	}
+
+	// hash after the auth module configured the default hasher
+	m.dummyHash, err = password.Hash("dummy password")
+	return err
+}
+
+// GetUser implements lib.Module.
+func (m *Module) GetUser(ctx context.Context, username string) (*lib.User, error) {
+	return m.store.GetUser(ctx, normalize(username))
+}
+
+// Authenticate implements lib.Module.
+func (m *Module) Authenticate(ctx context.Context, loginId string, plain string) (*lib.User, error) {
+	user, err := m.store.FindUser(ctx, normalize(loginId))
+	if errors.Is(err, lib.ErrUserNotFound) {
+		password.Verify(ctx, plain, m.dummyHash)
+		return nil, lib.ErrInvalidLogin
+	} else if err != nil {
+		return nil, err
+	}
+
+	err = password.Verify(ctx, plain, user.PasswordHash)
+	if errors.Is(err, password.ErrMismatch) {
+		return nil, lib.ErrInvalidLogin
+	} else if err != nil {
+		return nil, err
+	}
+
+	if user.Disabled() {
+		return nil, lib.ErrUserDisabled
+	}
+
+	if m.settings.RequireVerifiedEmail && !user.EmailVerified() {
+		return nil, lib.ErrEmailNotVerified
+	}
+
+	return user, nil
+}
+
+// CreateUser implements lib.Module.
+func (m *Module) CreateUser(ctx context.Context, user lib.NewUser) (*lib.User, error) {
+	user.Username = normalize(user.Username)
+	user.Email = normalize(user.Email)
+	if err := validator.Validate(user); err != nil {
+		return nil, err
+	}
+
+	if user.Role == "" {
+		user.Role = m.settings.DefaultRole
+	} else if err := m.ensureRole(ctx, user.Role); err != nil {
+		return nil, err
+	}
+
+	hash, err := password.Hash(user.Password)
+	if err != nil {
+		return nil, err
+	}
+
+	var verifiedAt time.Time
+	if user.EmailVerified {
+		verifiedAt = time.Now()
+	}
+
+	return m.store.CreateUser(ctx, lib.User{
+		Username:        user.Username,
+		Email:           user.Email,
+		EmailVerifiedAt: verifiedAt,
+		PasswordHash:    hash,
+		DisplayName:     user.Name,
+		Role:            user.Role,
+	})
+}
+
+// UpdateProfile implements lib.Module.
+func (m *Module) UpdateProfile(ctx context.Context, username string, profile lib.Profile) (*lib.User, error) {
+	if err := validator.Validate(profile); err != nil {
+		return nil, err
+	}
+
+	username = normalize(username)
+	if err := m.store.UpdateProfile(ctx, username, profile); err != nil {
+		return nil, err
+	}
+
+	return m.store.GetUser(ctx, username)
+}
+
+// DeleteUser implements lib.Module.
+func (m *Module) DeleteUser(ctx context.Context, username string) error {
+	return m.store.DeleteUser(ctx, normalize(username))
+}
+
+// ListUsers implements lib.Module.
+func (m *Module) ListUsers(ctx context.Context, params lib.ListUsersParams) ([]lib.User, int, error) {
+	if err := validator.Validate(params); err != nil {
+		return nil, 0, err
+	}
+
+	return m.store.ListUsers(ctx, params)
+}
+
+// DisableUser implements lib.Module, the user is signed out everywhere
+func (m *Module) DisableUser(ctx context.Context, username string) error {
+	username = normalize(username)
+	if err := m.store.SetDisabled(ctx, username, time.Now()); err != nil {
+		return err
+	}
+
+	return m.revoke(ctx, username)
+}
+
+// EnableUser implements lib.Module.
+func (m *Module) EnableUser(ctx context.Context, username string) error {
+	return m.store.SetDisabled(ctx, normalize(username), time.Time{})
+}
+
+// SetRole implements lib.Module, the user is signed out everywhere
+func (m *Module) SetRole(ctx context.Context, username string, role string) error {
+	if role == "" {
+		return errors.New("role name is empty")
+	}
+
+	if err := m.ensureRole(ctx, role); err != nil {
+		return err
+	}
+
+	// the user signs in again to get the permissions of the new role
+	username = normalize(username)
+	if err := m.store.SetRole(ctx, username, role); err != nil {
+		return err
+	}
+
+	return m.revoke(ctx, username)
+}
+
+// Register creates the user with the default role and sends the email
+// verification
+func (m *Module) Register(ctx context.Context, user lib.NewUser) (*lib.User, error) {
+	user.Role = ""
+	user.EmailVerified = false
+	created, err := m.CreateUser(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := m.sendVerification(ctx, *created); err != nil {
+		return nil, err
+	}
+
+	return created, nil
+}
+
+// SendVerification sends a new email verification unless the email is
+// already verified
+func (m *Module) SendVerification(ctx context.Context, username string) error {
+	user, err := m.GetUser(ctx, username)
+	if err != nil {
+		return err
+	}
+
+	if user.EmailVerified() {
+		return nil
+	}
+
+	return m.sendVerification(ctx, *user)
+}
+
+// VerifyEmail marks the email of the token verified, the token is invalid
+// once the email is verified or changed
+func (m *Module) VerifyEmail(ctx context.Context, token string) (*lib.User, error) {
+	var user *lib.User
+	_, err := m.signer.verify(token, tokenPurposeVerification, func(claims tokenClaims) (string, error) {
+		var err error
+		user, err = m.userOfToken(ctx, claims)
+		if err != nil {
+			return "", err
+		}
+
+		return verificationStamp(*user), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := m.store.SetEmail(ctx, user.Username, user.Email, time.Now()); err != nil {
+		return nil, err
+	}
+
+	return m.store.GetUser(ctx, user.Username)
+}
+
+// ForgotPassword sends the password reset of the user owning the email,
+// it succeeds when no user owns it to not reveal the registered emails
+func (m *Module) ForgotPassword(ctx context.Context, email string) error {
+	user, err := m.store.FindUser(ctx, normalize(email))
+	if errors.Is(err, lib.ErrUserNotFound) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if user.Email != normalize(email) || user.Disabled() {
+		return nil
+	}
+
+	token, err := m.signer.sign(tokenClaims{
+		Purpose: tokenPurposeReset,
+		Subject: user.Username,
+	}, m.settings.ResetTimeout, user.PasswordHash)
+	if err != nil {
+		return err
+	}
+
+	return m.notifier.SendPasswordReset(ctx, *user, token)
+}
+
+// ResetPassword sets the password of the token subject, the token is
+// invalid once the password is changed. The reset also verifies the email
+// since the token was delivered to it, and signs the user out everywhere.
+func (m *Module) ResetPassword(ctx context.Context, token string, plain string) error {
+	var user *lib.User
+	_, err := m.signer.verify(token, tokenPurposeReset, func(claims tokenClaims) (string, error) {
+		var err error
+		user, err = m.userOfToken(ctx, claims)
+		if err != nil {
+			return "", err
+		}
+
+		return user.PasswordHash, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := m.setPassword(ctx, user.Username, plain); err != nil {
+		return err
+	}
+
+	if err := m.revoke(ctx, user.Username); err != nil {
+		return err
+	}
+
+	if user.EmailVerified() {
+		return nil
+	}
+
+	return m.store.SetEmail(ctx, user.Username, user.Email, time.Now())
+}
+
+// ChangePassword sets the password after verifying the current one, the
+// other sessions and the refresh tokens of the user are revoked
+func (m *Module) ChangePassword(ctx context.Context, username string, current string, plain string) error {
+	user, err := m.verifyPassword(ctx, username, current)
+	if err != nil {
+		return err
+	}
+
+	if err := m.setPassword(ctx, user.Username, plain); err != nil {
+		return err
+	}
+
+	return m.revoke(ctx, user.Username)
+}
+
+// ChangeEmail sends the confirmation to the new email after verifying the
+// password, the email is only changed once it is confirmed
+func (m *Module) ChangeEmail(ctx context.Context, username string, current string, email string) error {
+	user, err := m.verifyPassword(ctx, username, current)
+	if err != nil {
+		return err
+	}
+
+	email = normalize(email)
+	if err := validator.Validate(struct {
+		Email string `validate:"required,email,max=320"`
+	}{email}); err != nil {
+		return err
+	}
+
+	if _, err := m.store.FindUser(ctx, email); err == nil {
+		return lib.ErrUserExists
+	} else if !errors.Is(err, lib.ErrUserNotFound) {
+		return err
+	}
+
+	token, err := m.signer.sign(tokenClaims{
+		Purpose: tokenPurposeEmailChange,
+		Subject: user.Username,
+		Email:   email,
+	}, m.settings.EmailChangeTimeout, user.Email)
+	if err != nil {
+		return err
+	}
+
+	return m.notifier.SendEmailChange(ctx, *user, email, token)
+}
+
+// ConfirmEmailChange sets the verified email of the token, the token is
+// invalid once the email is changed
+func (m *Module) ConfirmEmailChange(ctx context.Context, token string) (*lib.User, error) {
+	var user *lib.User
+	claims, err := m.signer.verify(token, tokenPurposeEmailChange, func(claims tokenClaims) (string, error) {
+		var err error
+		user, err = m.userOfToken(ctx, claims)
+		if err != nil {
+			return "", err
+		}
+
+		return user.Email, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := m.store.SetEmail(ctx, user.Username, claims.Email, time.Now()); err != nil {
+		return nil, err
+	}
+
+	return m.store.GetUser(ctx, user.Username)
+}
+
+// recordLogin stores the login time and the new hash of an outdated
+// password verified during the login
+func (m *Module) recordLogin(ctx context.Context, username string) error {
+	if rehashed, ok := password.RehashFromContext(ctx); ok {
+		if err := m.store.SetPasswordHash(ctx, username, rehashed); err != nil {
+			return err
+		}
+	}
+
+	return m.store.SetLastLogin(ctx, username, time.Now())
+}
+
+func (m *Module) sendVerification(ctx context.Context, user lib.User) error {
+	token, err := m.signer.sign(tokenClaims{
+		Purpose: tokenPurposeVerification,
+		Subject: user.Username,
+	}, m.settings.VerificationTimeout, verificationStamp(user))
+	if err != nil {
+		return err
+	}
+
+	return m.notifier.SendVerification(ctx, user, token)
+}
+
+func (m *Module) verifyPassword(ctx context.Context, username string, plain string) (*lib.User, error) {
+	user, err := m.GetUser(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+
+	err = password.Verify(ctx, plain, user.PasswordHash)
+	if errors.Is(err, password.ErrMismatch) {
+		return nil, lib.ErrInvalidLogin
+	} else if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+func (m *Module) setPassword(ctx context.Context, username string, plain string) error {
+	if err := validator.Validate(newPassword{Username: username, Password: plain}); err != nil {
+		return err
+	}
+
+	hash, err := password.Hash(plain)
+	if err != nil {
+		return err
+	}
+
+	return m.store.SetPasswordHash(ctx, username, hash)
+}
+
+// revoke signs the user out of the sessions and the devices, e.g. after its
+// password or its permissions are changed
+func (m *Module) revoke(ctx context.Context, username string) error {
+	if m.revokeUser == nil {
+		return nil
+	}
+
+	return m.revokeUser(ctx, username)
+}
+
+// ensureRole returns lib.ErrRoleNotFound when the rbac module is
+// registered and the role doesn't exist
+func (m *Module) ensureRole(ctx context.Context, name string) error {
+	if m.getRole == nil {
+		return nil
+	}
+
+	_, err := m.getRole(ctx, name)
+	if sqldb.IsNoRows(err) {
+		return lib.ErrRoleNotFound
+	}
+
+	return err
+}
+
+// userOfToken loads the active subject of the token, the missing or
+// disabled users make the token invalid
+func (m *Module) userOfToken(ctx context.Context, claims tokenClaims) (*lib.User, error) {
+	user, err := m.store.GetUser(ctx, claims.Subject)
+	if errors.Is(err, lib.ErrUserNotFound) {
+		return nil, lib.ErrInvalidToken
+	} else if err != nil {
+		return nil, err
+	}
+
+	if user.Disabled() {
+		return nil, lib.ErrInvalidToken
+	}
+
+	return user, nil
+}
+
+// verificationStamp binds the verification token to the unverified email
+func verificationStamp(user lib.User) string {
+	if user.EmailVerified() {
+		return ""
+	}
+
+	return user.Email
+}
+
+func normalize(value string) string {
+	return strings.ToLower(strings.TrimSpace(value))
+}
@@ -0,0 +1,44 @@
+package account
+
+import (
+	"context"
+
+	"github.com/euiko/webapp/module/account/lib"
+	"github.com/euiko/webapp/pkg/log"
+)
+
+type (
+	// logNotifier logs the tokens instead of delivering them, it is only
+	// suitable for development, use WithNotifier to send the emails
+	logNotifier struct{}
+)
+
+// SendVerification implements lib.Notifier.
+func (logNotifier) SendVerification(ctx context.Context, user lib.User, token string) error {
+	log.Debug("email verification requested",
+		log.WithField("username", user.Username),
+		log.WithField("email", user.Email),
+		log.WithField("token", token),
+	)
+	return nil
+}
+
+// SendPasswordReset implements lib.Notifier.
+func (logNotifier) SendPasswordReset(ctx context.Context, user lib.User, token string) error {
+	log.Debug("password reset requested",
+		log.WithField("username", user.Username),
+		log.WithField("email", user.Email),
+		log.WithField("token", token),
+	)
+	return nil
+}
+
+// SendEmailChange implements lib.Notifier.
+func (logNotifier) SendEmailChange(ctx context.Context, user lib.User, email string, token string) error {
+	log.Debug("email change requested",
+		log.WithField("username", user.Username),
+		log.WithField("email", email),
+		log.WithField("token", token),
+	)
+	return nil
+}
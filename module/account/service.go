@@ -0,0 +1,256 @@
+package account
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/euiko/webapp/core"
+	api "github.com/euiko/webapp/module/account/internal/api"
+	"github.com/euiko/webapp/module/account/lib"
+	authlib "github.com/euiko/webapp/module/auth/lib"
+	"github.com/euiko/webapp/pkg/helper"
+	"github.com/euiko/webapp/pkg/session"
+	"github.com/euiko/webapp/pkg/validator"
+)
+
+func (m *Module) APIRoute(r core.Router) {
+	if !m.settings.Enabled {
+		return
+	}
+
+	// public accessible routes
+	if m.settings.AllowRegistration {
+		r.Post("/account/register", m.registerHandler)
+	}
+	r.Post("/account/verify-email", m.verifyEmailHandler)
+	r.Post("/account/confirm-email", m.confirmEmailHandler)
+	r.Post("/account/forgot-password", m.forgotPasswordHandler)
+	r.Post("/account/reset-password", m.resetPasswordHandler)
+
+	r.Group(func(r core.Router) {
+		r.Use(authlib.AuthRequiredMiddleware(m.app))
+
+		// the scoped credentials, e.g. the api keys, only act on the
+		// resources of their scopes
+		r.Group(func(r core.Router) {
+			r.Use(authlib.UnscopedMiddleware)
+			r.Get("/account/me", m.getProfileHandler)
+			r.Put("/account/me", m.updateProfileHandler)
+			r.Delete("/account/me", m.deleteProfileHandler)
+			r.Post("/account/me/verification", m.resendVerificationHandler)
+			r.Put("/account/me/password", m.changePasswordHandler)
+			r.Put("/account/me/email", m.changeEmailHandler)
+		})
+		m.adminRoute(r)
+	})
+}
+
+func (m *Module) registerHandler(w http.ResponseWriter, r *http.Request) {
+	var payload api.RegisterPayload
+	if err := helper.DecodeRequestBody(r, &payload); err != nil {
+		helper.WriteResponse(w, err)
+		return
+	}
+
+	user, err := m.Register(r.Context(), payload.ToBase())
+	if !writeError(w, err) {
+		return
+	}
+
+	helper.WriteResponse(w, api.ToUser(*user), helper.ResponseWithStatus(http.StatusCreated))
+}
+
+func (m *Module) verifyEmailHandler(w http.ResponseWriter, r *http.Request) {
+	var payload api.TokenPayload
+	if err := helper.DecodeRequestBody(r, &payload); err != nil {
+		helper.WriteResponse(w, err)
+		return
+	}
+
+	user, err := m.VerifyEmail(r.Context(), payload.Token)
+	if !writeError(w, err) {
+		return
+	}
+
+	helper.WriteResponse(w, api.ToUser(*user))
+}
+
+func (m *Module) confirmEmailHandler(w http.ResponseWriter, r *http.Request) {
+	var payload api.TokenPayload
+	if err := helper.DecodeRequestBody(r, &payload); err != nil {
+		helper.WriteResponse(w, err)
+		return
+	}
+
+	user, err := m.ConfirmEmailChange(r.Context(), payload.Token)
+	if !writeError(w, err) {
+		return
+	}
+
+	helper.WriteResponse(w, api.ToUser(*user))
+}
+
+func (m *Module) forgotPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var payload api.ForgotPasswordPayload
+	if err := helper.DecodeRequestBody(r, &payload); err != nil {
+		helper.WriteResponse(w, err)
+		return
+	}
+
+	if !writeError(w, m.ForgotPassword(r.Context(), payload.Email)) {
+		return
+	}
+
+	// the same response whether the email is registered or not
+	helper.WriteResponse(w, map[string]interface{}{
+		"message": "password reset sent when the email is registered",
+	}, helper.ResponseWithStatus(http.StatusAccepted))
+}
+
+func (m *Module) resetPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var payload api.ResetPasswordPayload
+	if err := helper.DecodeRequestBody(r, &payload); err != nil {
+		helper.WriteResponse(w, err)
+		return
+	}
+
+	if !writeError(w, m.ResetPassword(r.Context(), payload.Token, payload.Password)) {
+		return
+	}
+
+	helper.WriteResponse(w, map[string]interface{}{
+		"message": "password reset",
+	})
+}
+
+func (m *Module) getProfileHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := m.GetUser(r.Context(), currentLoginID(r))
+	if !writeError(w, err) {
+		return
+	}
+
+	helper.WriteResponse(w, api.ToUser(*user))
+}
+
+func (m *Module) updateProfileHandler(w http.ResponseWriter, r *http.Request) {
+	var payload api.ProfilePayload
+	if err := helper.DecodeRequestBody(r, &payload); err != nil {
+		helper.WriteResponse(w, err)
+		return
+	}
+
+	user, err := m.UpdateProfile(r.Context(), currentLoginID(r), lib.Profile{Name: payload.Name})
+	if !writeError(w, err) {
+		return
+	}
+
+	helper.WriteResponse(w, api.ToUser(*user))
+}
+
+func (m *Module) deleteProfileHandler(w http.ResponseWriter, r *http.Request) {
+	var payload api.PasswordPayload
+	if err := helper.DecodeRequestBody(r, &payload); err != nil {
+		helper.WriteResponse(w, err)
+		return
+	}
+
+	// confirm the password so a stolen token can't delete the account
+	user, err := m.verifyPassword(r.Context(), currentLoginID(r), payload.Password)
+	if !writeError(w, err) {
+		return
+	}
+
+	if !writeError(w, m.DeleteUser(r.Context(), user.Username)) {
+		return
+	}
+
+	helper.WriteResponse(w, map[string]interface{}{
+		"message": "account deleted",
+	})
+}
+
+func (m *Module) resendVerificationHandler(w http.ResponseWriter, r *http.Request) {
+	if !writeError(w, m.SendVerification(r.Context(), currentLoginID(r))) {
+		return
+	}
+
+	helper.WriteResponse(w, map[string]interface{}{
+		"message": "verification sent when the email is not verified",
+	}, helper.ResponseWithStatus(http.StatusAccepted))
+}
+
+func (m *Module) changePasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var payload api.ChangePasswordPayload
+	if err := helper.DecodeRequestBody(r, &payload); err != nil {
+		helper.WriteResponse(w, err)
+		return
+	}
+
+	err := m.ChangePassword(r.Context(), currentLoginID(r), payload.CurrentPassword, payload.Password)
+	if !writeError(w, err) {
+		return
+	}
+
+	// the current session was revoked along with the others, it is stored
+	// again under a new ID to stay signed in
+	session.Regenerate(r.Context())
+
+	helper.WriteResponse(w, map[string]interface{}{
+		"message": "password changed",
+	})
+}
+
+func (m *Module) changeEmailHandler(w http.ResponseWriter, r *http.Request) {
+	var payload api.ChangeEmailPayload
+	if err := helper.DecodeRequestBody(r, &payload); err != nil {
+		helper.WriteResponse(w, err)
+		return
+	}
+
+	err := m.ChangeEmail(r.Context(), currentLoginID(r), payload.Password, payload.Email)
+	if !writeError(w, err) {
+		return
+	}
+
+	helper.WriteResponse(w, map[string]interface{}{
+		"message": "confirmation sent to the new email",
+	}, helper.ResponseWithStatus(http.StatusAccepted))
+}
+
+// currentLoginID returns the login id of the authenticated user, the
+// routes are guarded by the auth middleware
+func currentLoginID(r *http.Request) string {
+	user, ok := authlib.CurrentUser(r.Context())
+	if !ok {
+		return ""
+	}
+
+	return user.LoginID()
+}
+
+// writeError writes the error with its status, it returns true when there
+// is no error
+func writeError(w http.ResponseWriter, err error) bool {
+	if err == nil {
+		return true
+	}
+
+	status := http.StatusInternalServerError
+	if _, ok := validator.GetValidationErrors(err); ok {
+		status = http.StatusBadRequest
+	}
+
+	switch {
+	case errors.Is(err, lib.ErrInvalidToken), errors.Is(err, lib.ErrRoleNotFound):
+		status = http.StatusBadRequest
+	case errors.Is(err, lib.ErrInvalidLogin):
+		status = http.StatusForbidden
+	case errors.Is(err, lib.ErrUserNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, lib.ErrUserExists):
+		status = http.StatusConflict
+	}
+
+	helper.WriteResponse(w, err, helper.ResponseWithStatus(status))
+	return false
+}
@@ -0,0 +1,21 @@
+package account
+
+import "time"
+
+type (
+	Settings struct {
+		Enabled bool `mapstructure:"enabled"`
+		// TokenSecret signs the verification, reset and email change
+		// tokens, it is required when the module is enabled
+		TokenSecret string `mapstructure:"token_secret"`
+		// AllowRegistration enables the public registration endpoint
+		AllowRegistration bool `mapstructure:"allow_registration"`
+		// DefaultRole is the role of the registered users
+		DefaultRole string `mapstructure:"default_role"`
+		// RequireVerifiedEmail refuses the login until the email is verified
+		RequireVerifiedEmail bool          `mapstructure:"require_verified_email"`
+		VerificationTimeout  time.Duration `mapstructure:"verification_timeout"`
+		ResetTimeout         time.Duration `mapstructure:"reset_timeout"`
+		EmailChangeTimeout   time.Duration `mapstructure:"email_change_timeout"`
+	}
+)
@@ -0,0 +1,277 @@
+package account
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/euiko/webapp/core"
+	"github.com/euiko/webapp/db/sqldb"
+	"github.com/euiko/webapp/module/account/internal/schema"
+	"github.com/euiko/webapp/module/account/lib"
+	"github.com/uptrace/bun"
+)
+
+type (
+	// Store persists the users, the usernames and the emails are expected
+	// to be normalized by the caller
+	Store interface {
+		GetUser(ctx context.Context, username string) (*lib.User, error)
+		// FindUser returns the user of the username or the email
+		FindUser(ctx context.Context, loginId string) (*lib.User, error)
+		// CreateUser fails with lib.ErrUserExists when the username or the
+		// email is taken
+		CreateUser(ctx context.Context, user lib.User) (*lib.User, error)
+		UpdateProfile(ctx context.Context, username string, profile lib.Profile) error
+		SetPasswordHash(ctx context.Context, username string, hash string) error
+		// SetEmail fails with lib.ErrUserExists when the email is taken by
+		// another user, a zero verified at marks the email unverified
+		SetEmail(ctx context.Context, username string, email string, verifiedAt time.Time) error
+		SetLastLogin(ctx context.Context, username string, at time.Time) error
+		// ListUsers returns the page of the users ordered by their id and
+		// the total of the matching users
+		ListUsers(ctx context.Context, params lib.ListUsersParams) ([]lib.User, int, error)
+		// SetDisabled disables the user at the time, a zero time enables it
+		SetDisabled(ctx context.Context, username string, at time.Time) error
+		SetRole(ctx context.Context, username string, role string) error
+		DeleteUser(ctx context.Context, username string) error
+	}
+
+	StoreFactory func(app core.App, s *Settings) (Store, error)
+
+	ormStore struct {
+		db sqldb.OrmDB
+	}
+)
+
+func NewOrmStore(db sqldb.OrmDB) Store {
+	return &ormStore{
+		db: db,
+	}
+}
+
+func defaultStoreFactory(app core.App, s *Settings) (Store, error) {
+	return NewOrmStore(sqldb.ORM()), nil
+}
+
+// GetUser implements Store.
+func (s *ormStore) GetUser(ctx context.Context, username string) (*lib.User, error) {
+	return s.getUser(ctx, func(q *bun.SelectQuery) *bun.SelectQuery {
+		return q.Where("username = ?", username)
+	})
+}
+
+// FindUser implements Store.
+func (s *ormStore) FindUser(ctx context.Context, loginId string) (*lib.User, error) {
+	return s.getUser(ctx, func(q *bun.SelectQuery) *bun.SelectQuery {
+		return q.Where("username = ? OR email = ?", loginId, loginId)
+	})
+}
+
+// CreateUser implements Store.
+func (s *ormStore) CreateUser(ctx context.Context, user lib.User) (*lib.User, error) {
+	exists, err := s.db.NewSelect().
+		Model((*schema.User)(nil)).
+		Where("username = ? OR email = ?", user.Username, user.Email).
+		Exists(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if exists {
+		return nil, lib.ErrUserExists
+	}
+
+	now := time.Now()
+	model := schema.User{
+		BaseSchema: sqldb.BaseSchema{
+			CreatedAt: now,
+			UpdatedAt: now,
+		},
+		Username:        user.Username,
+		Email:           user.Email,
+		EmailVerifiedAt: user.EmailVerifiedAt,
+		PasswordHash:    user.PasswordHash,
+		Name:            user.DisplayName,
+		Role:            user.Role,
+		DisabledAt:      user.DisabledAt,
+	}
+
+	_, err = s.db.NewInsert().
+		Model(&model).
+		Returning("id").
+		Exec(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	created := model.ToBase()
+	return &created, nil
+}
+
+// UpdateProfile implements Store.
+func (s *ormStore) UpdateProfile(ctx context.Context, username string, profile lib.Profile) error {
+	return s.update(ctx, username, func(q *bun.UpdateQuery) *bun.UpdateQuery {
+		return q.Set("name = ?", profile.Name)
+	})
+}
+
+// SetPasswordHash implements Store.
+func (s *ormStore) SetPasswordHash(ctx context.Context, username string, hash string) error {
+	return s.update(ctx, username, func(q *bun.UpdateQuery) *bun.UpdateQuery {
+		return q.Set("password_hash = ?", hash)
+	})
+}
+
+// SetEmail implements Store.
+func (s *ormStore) SetEmail(ctx context.Context, username string, email string, verifiedAt time.Time) error {
+	exists, err := s.db.NewSelect().
+		Model((*schema.User)(nil)).
+		Where("email = ?", email).
+		Where("username != ?", username).
+		Exists(ctx)
+	if err != nil {
+		return err
+	}
+
+	if exists {
+		return lib.ErrUserExists
+	}
+
+	var verified any
+	if !verifiedAt.IsZero() {
+		verified = verifiedAt
+	}
+
+	return s.update(ctx, username, func(q *bun.UpdateQuery) *bun.UpdateQuery {
+		return q.Set("email = ?", email).
+			Set("email_verified_at = ?", verified)
+	})
+}
+
+// SetLastLogin implements Store.
+func (s *ormStore) SetLastLogin(ctx context.Context, username string, at time.Time) error {
+	_, err := s.db.NewUpdate().
+		Model((*schema.User)(nil)).
+		Set("last_login_at = ?", at).
+		Where("username = ?", username).
+		Exec(ctx)
+	return err
+}
+
+// ListUsers implements Store.
+func (s *ormStore) ListUsers(ctx context.Context, params lib.ListUsersParams) ([]lib.User, int, error) {
+	var models []schema.User
+	query := s.db.NewSelect().
+		Model(&models)
+
+	if params.Keyword != "" {
+		keyword := "%" + strings.ToLower(params.Keyword) + "%"
+		query = query.WhereGroup(" AND ", func(q *bun.SelectQuery) *bun.SelectQuery {
+			return q.Where("username LIKE ?", keyword).
+				WhereOr("email LIKE ?", keyword).
+				WhereOr("LOWER(name) LIKE ?", keyword)
+		})
+	}
+
+	if params.Role != "" {
+		query = query.Where("role = ?", params.Role)
+	}
+
+	count, err := query.Count(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	err = query.
+		Order("id ASC").
+		Limit(params.Limit).
+		Offset(params.Offset).
+		Scan(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	users := make([]lib.User, len(models))
+	for i, model := range models {
+		users[i] = model.ToBase()
+	}
+
+	return users, count, nil
+}
+
+// SetDisabled implements Store.
+func (s *ormStore) SetDisabled(ctx context.Context, username string, at time.Time) error {
+	var disabled any
+	if !at.IsZero() {
+		disabled = at
+	}
+
+	return s.update(ctx, username, func(q *bun.UpdateQuery) *bun.UpdateQuery {
+		return q.Set("disabled_at = ?", disabled)
+	})
+}
+
+// SetRole implements Store.
+func (s *ormStore) SetRole(ctx context.Context, username string, role string) error {
+	return s.update(ctx, username, func(q *bun.UpdateQuery) *bun.UpdateQuery {
+		return q.Set("role = ?", role)
+	})
+}
+
+// DeleteUser implements Store.
+func (s *ormStore) DeleteUser(ctx context.Context, username string) error {
+	result, err := s.db.NewDelete().
+		Model((*schema.User)(nil)).
+		Where("username = ?", username).
+		Exec(ctx)
+	if err != nil {
+		return err
+	}
+
+	return userAffected(result)
+}
+
+func (s *ormStore) getUser(ctx context.Context, where func(*bun.SelectQuery) *bun.SelectQuery) (*lib.User, error) {
+	var model schema.User
+	err := where(s.db.NewSelect().Model(&model)).
+		Limit(1).
+		Scan(ctx)
+	if sqldb.IsNoRows(err) {
+		return nil, lib.ErrUserNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	user := model.ToBase()
+	return &user, nil
+}
+
+// update sets the columns of the user and touches its updated at
+func (s *ormStore) update(ctx context.Context, username string, set func(*bun.UpdateQuery) *bun.UpdateQuery) error {
+	query := s.db.NewUpdate().
+		Model((*schema.User)(nil)).
+		Set("updated_at = ?", time.Now()).
+		Where("username = ?", username)
+
+	result, err := set(query).Exec(ctx)
+	if err != nil {
+		return err
+	}
+
+	return userAffected(result)
+}
+
+func userAffected(result sql.Result) error {
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return lib.ErrUserNotFound
+	}
+
+	return nil
+}
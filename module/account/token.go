@@ -0,0 +1,106 @@
+package account
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/euiko/webapp/module/account/lib"
+)
+
+type (
+	// tokenClaims is the payload of the signed tokens sent to the users
+	tokenClaims struct {
+		Purpose   string `json:"p"`
+		Subject   string `json:"s"`
+		Email     string `json:"m,omitempty"`
+		ExpiresAt int64  `json:"e"`
+	}
+
+	// tokenSigner signs the stateless tokens using hmac. The signature also
+	// covers a stamp of the user state which is not part of the token, e.g.
+	// the password hash, so the token is invalidated once it is used.
+	tokenSigner struct {
+		secret []byte
+		now    func() time.Time
+	}
+)
+
+const (
+	tokenPurposeVerification = "verify-email"
+	tokenPurposeReset        = "reset-password"
+	tokenPurposeEmailChange  = "change-email"
+)
+
+var (
+	tokenEncoding = base64.RawURLEncoding
+)
+
+func newTokenSigner(secret string) *tokenSigner {
+	return &tokenSigner{
+		secret: []byte(secret),
+		now:    time.Now,
+	}
+}
+
+// sign returns the token of the claims expiring after the timeout
+func (s *tokenSigner) sign(claims tokenClaims, timeout time.Duration, stamp string) (string, error) {
+	claims.ExpiresAt = s.now().Add(timeout).Unix()
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	encoded := tokenEncoding.EncodeToString(payload)
+	return encoded + "." + tokenEncoding.EncodeToString(s.mac(encoded, stamp)), nil
+}
+
+// verify returns the claims of the unexpired token of the purpose, the
+// stamp is computed from the state of the token subject
+func (s *tokenSigner) verify(token string, purpose string, stamp func(claims tokenClaims) (string, error)) (*tokenClaims, error) {
+	encoded, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, lib.ErrInvalidToken
+	}
+
+	payload, err := tokenEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, lib.ErrInvalidToken
+	}
+
+	mac, err := tokenEncoding.DecodeString(signature)
+	if err != nil {
+		return nil, lib.ErrInvalidToken
+	}
+
+	var claims tokenClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, lib.ErrInvalidToken
+	}
+
+	if claims.Purpose != purpose || s.now().Unix() >= claims.ExpiresAt {
+		return nil, lib.ErrInvalidToken
+	}
+
+	expected, err := stamp(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	if !hmac.Equal(mac, s.mac(encoded, expected)) {
+		return nil, lib.ErrInvalidToken
+	}
+
+	return &claims, nil
+}
+
+func (s *tokenSigner) mac(encoded string, stamp string) []byte {
+	h := hmac.New(sha256.New, s.secret)
+	h.Write([]byte(encoded))
+	h.Write([]byte{0})
+	h.Write([]byte(stamp))
+	return h.Sum(nil)
+}
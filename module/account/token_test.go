@@ -0,0 +1,59 @@
+package account
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/euiko/webapp/module/account/lib"
+)
+
+func TestTokenSigner(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	signer := newTokenSigner("secret")
+	signer.now = func() time.Time { return now }
+
+	token, err := signer.sign(tokenClaims{Purpose: tokenPurposeReset, Subject: "alice"}, time.Hour, "hash-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stamp := func(stamp string) func(tokenClaims) (string, error) {
+		return func(claims tokenClaims) (string, error) {
+			if claims.Subject != "alice" {
+				t.Fatalf("unexpected subject %q", claims.Subject)
+			}
+			return stamp, nil
+		}
+	}
+
+	if _, err := signer.verify(token, tokenPurposeReset, stamp("hash-1")); err != nil {
+		t.Fatalf("expected valid token, got %v", err)
+	}
+
+	cases := map[string]struct {
+		token   string
+		purpose string
+		stamp   string
+		at      time.Time
+	}{
+		"used":      {token, tokenPurposeReset, "hash-2", now},
+		"purpose":   {token, tokenPurposeVerification, "hash-1", now},
+		"expired":   {token, tokenPurposeReset, "hash-1", now.Add(time.Hour)},
+		"tampered":  {token[:len(token)-2] + "AA", tokenPurposeReset, "hash-1", now},
+		"malformed": {"not-a-token", tokenPurposeReset, "hash-1", now},
+	}
+
+	for name, c := range cases {
+		signer.now = func() time.Time { return c.at }
+		if _, err := signer.verify(c.token, c.purpose, stamp(c.stamp)); !errors.Is(err, lib.ErrInvalidToken) {
+			t.Errorf("%s: expected invalid token, got %v", name, err)
+		}
+	}
+
+	other := newTokenSigner("other")
+	other.now = func() time.Time { return now }
+	if _, err := other.verify(token, tokenPurposeReset, stamp("hash-1")); !errors.Is(err, lib.ErrInvalidToken) {
+		t.Errorf("expected invalid token with other secret, got %v", err)
+	}
+}
@@ -0,0 +1,82 @@
+package account
+
+import (
+	"context"
+
+	"github.com/euiko/webapp/core"
+	"github.com/euiko/webapp/module/account/lib"
+	authlib "github.com/euiko/webapp/module/auth/lib"
+	"github.com/euiko/webapp/pkg/log"
+)
+
+type (
+	// UserLoader loads the users of the account module for the auth module,
+	// register it as a hook too so the logins are recorded:
+	//
+	//	loader := account.NewUserLoader(app)
+	//	app.Register(auth.ModuleFactory(loader, auth.WithHooks[*accountlib.User](loader)))
+	UserLoader struct {
+		authlib.NopHook[*lib.User]
+		app core.App
+	}
+)
+
+// NewUserLoader creates the user loader, the account module is looked up
+// on use so it can be registered after the auth module
+func NewUserLoader(app core.App) *UserLoader {
+	return &UserLoader{
+		app: app,
+	}
+}
+
+// UserById implements authlib.UserLoader.
+func (l *UserLoader) UserById(ctx context.Context, loginId string) (*lib.User, error) {
+	user, err := l.module().GetUser(ctx, loginId)
+	if err != nil {
+		return nil, err
+	}
+
+	if user.Disabled() {
+		return nil, lib.ErrUserDisabled
+	}
+
+	return user, nil
+}
+
+// LoadUser implements authlib.UserLoader.
+func (l *UserLoader) LoadUser(ctx context.Context, loginId string, password string) (*lib.User, error) {
+	return l.module().Authenticate(ctx, loginId, password)
+}
+
+// UserClaims implements authlib.ClaimsUserLoader, the role is embedded so
+// the user is rebuilt from the token without loading it
+func (l *UserLoader) UserClaims(ctx context.Context, user *lib.User) (map[string]any, error) {
+	return map[string]any{"role": user.Role}, nil
+}
+
+// UserFromClaims implements authlib.ClaimsUserLoader.
+func (l *UserLoader) UserFromClaims(ctx context.Context, subject string, claims map[string]any) (*lib.User, bool, error) {
+	role, ok := claims["role"].(string)
+	if !ok {
+		return nil, false, nil
+	}
+
+	return &lib.User{
+		Username: subject,
+		Role:     role,
+	}, true, nil
+}
+
+// AfterLogin implements authlib.Hook, it stores the login time and the
+// rehashed password
+func (l *UserLoader) AfterLogin(ctx context.Context, user *lib.User, token *string) error {
+	if err := l.module().recordLogin(ctx, user.Username); err != nil {
+		log.Error("failed to record the login", log.WithField("username", user.Username), log.WithError(err))
+	}
+
+	return nil
+}
+
+func (l *UserLoader) module() *Module {
+	return core.MustGetModule[*Module](l.app)
+}
@@ -0,0 +1,234 @@
+package account
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/euiko/webapp/core"
+	"github.com/euiko/webapp/db"
+	"github.com/euiko/webapp/db/sqldb"
+	"github.com/euiko/webapp/module/account/lib"
+	authlib "github.com/euiko/webapp/module/auth/lib"
+	"github.com/euiko/webapp/module/rbac"
+	rbaclib "github.com/euiko/webapp/module/rbac/lib"
+	"github.com/euiko/webapp/pkg/common/httpapi"
+	"github.com/spf13/cobra"
+)
+
+func (m *Module) Command(cmd *cobra.Command) {
+	cmd.AddCommand(m.usersCmd())
+}
+
+func (m *Module) usersCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "users",
+		Short: "Manage the user accounts and their roles",
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			if err := db.Init(&m.app.Settings().DB); err != nil {
+				return err
+			}
+
+			var err error
+			m.store, err = m.storeFactory(m.app, &m.settings)
+			if err != nil {
+				return err
+			}
+
+			// the rbac module only opens its store on start, look up the
+			// roles from its tables directly
+			if _, ok := core.GetModule[rbaclib.Module](m.app); ok {
+				m.getRole = rbac.NewOrmStore(sqldb.ORM()).Get
+			}
+
+			// the auth module opens its stores on the first revocation
+			if authModule, ok := core.GetModule[authlib.Module](m.app); ok {
+				m.revokeUser = authModule.RevokeUser
+			}
+
+			return nil
+		},
+		PersistentPostRunE: func(cmd *cobra.Command, args []string) error {
+			return db.Close()
+		},
+	}
+
+	cmd.AddCommand(m.listUsersCmd())
+	cmd.AddCommand(m.createUserCmd())
+	cmd.AddCommand(m.disableUserCmd())
+	cmd.AddCommand(m.enableUserCmd())
+	cmd.AddCommand(m.setRoleCmd())
+	return cmd
+}
+
+func (m *Module) listUsersCmd() *cobra.Command {
+	var (
+		params     = httpapi.PaginationParams{Page: 1, PageSize: 50}
+		keyword    string
+		roleFilter string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List the users",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			listParams := lib.ListUsersParams{
+				PaginationParams: params.ToBase(),
+				Role:             roleFilter,
+			}
+			listParams.Keyword = keyword
+
+			users, total, err := m.ListUsers(cmd.Context(), listParams)
+			if err != nil {
+				return err
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "ID\tUSERNAME\tEMAIL\tVERIFIED\tNAME\tROLE\tLAST LOGIN AT\tDISABLED AT")
+			for _, user := range users {
+				fmt.Fprintf(w, "%d\t%s\t%s\t%t\t%s\t%s\t%s\t%s\n",
+					user.ID,
+					user.Username,
+					user.Email,
+					user.EmailVerified(),
+					user.DisplayName,
+					user.Role,
+					formatUserTime(user.LastLoginAt),
+					formatUserTime(user.DisabledAt),
+				)
+			}
+
+			if err := w.Flush(); err != nil {
+				return err
+			}
+
+			fmt.Printf("page %d, %d users in total\n", params.Page, total)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&keyword, "keyword", "k", "", "Only list the users whose username, email or name contains the keyword")
+	cmd.Flags().StringVar(&roleFilter, "role", "", "Only list the users of the role")
+	cmd.Flags().IntVar(&params.Page, "page", params.Page, "Page to list, starting from 1")
+	cmd.Flags().IntVar(&params.PageSize, "page-size", params.PageSize, "Number of the users per page")
+	return cmd
+}
+
+func (m *Module) createUserCmd() *cobra.Command {
+	var user lib.NewUser
+
+	cmd := &cobra.Command{
+		Use:   "create",
+		Short: "Create a user, a generated password is only printed once",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			generated := user.Password == ""
+			if generated {
+				var err error
+				user.Password, err = generatePassword()
+				if err != nil {
+					return err
+				}
+			}
+
+			created, err := m.CreateUser(cmd.Context(), user)
+			if err != nil {
+				return err
+			}
+
+			fmt.Println("created user", created.Username, "with role", created.Role)
+			if generated {
+				fmt.Println(user.Password)
+			}
+
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&user.Username, "username", "u", "", "Username used to log in")
+	cmd.Flags().StringVarP(&user.Email, "email", "e", "", "Email of the user")
+	cmd.Flags().StringVarP(&user.Name, "name", "n", "", "Display name of the user")
+	cmd.Flags().StringVarP(&user.Role, "role", "r", "", "Role of the user, defaults to the configured default role")
+	cmd.Flags().StringVarP(&user.Password, "password", "p", "", "Password of the user, generated when empty")
+	cmd.Flags().BoolVar(&user.EmailVerified, "email-verified", false, "Mark the email verified")
+	_ = cmd.MarkFlagRequired("username")
+	_ = cmd.MarkFlagRequired("email")
+	return cmd
+}
+
+func (m *Module) disableUserCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "disable [USERNAME]",
+		Short: "Disable the user, its logins and token refreshes are refused",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			err := m.DisableUser(cmd.Context(), args[0])
+			if errors.Is(err, authlib.ErrRevocationUnavailable) {
+				return fmt.Errorf("disabled user %s but can't sign it out: %w", args[0], err)
+			} else if err != nil {
+				return err
+			}
+
+			fmt.Println("disabled user", args[0])
+			return nil
+		},
+	}
+}
+
+func (m *Module) enableUserCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "enable [USERNAME]",
+		Short: "Enable the disabled user",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := m.EnableUser(cmd.Context(), args[0]); err != nil {
+				return err
+			}
+
+			fmt.Println("enabled user", args[0])
+			return nil
+		},
+	}
+}
+
+func (m *Module) setRoleCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "set-role [USERNAME] [ROLE]",
+		Short: "Assign the role to the user",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			err := m.SetRole(cmd.Context(), args[0], args[1])
+			if errors.Is(err, authlib.ErrRevocationUnavailable) {
+				return fmt.Errorf("assigned role %s to user %s but can't sign it out: %w", args[1], args[0], err)
+			} else if err != nil {
+				return err
+			}
+
+			fmt.Println("assigned role", args[1], "to user", args[0])
+			return nil
+		},
+	}
+}
+
+// generatePassword returns a random password of 24 characters
+func generatePassword() (string, error) {
+	b := make([]byte, 18)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func formatUserTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+
+	return t.Local().Format(time.RFC3339)
+}
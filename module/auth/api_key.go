@@ -0,0 +1,305 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/euiko/webapp/core"
+	"github.com/euiko/webapp/module/auth/lib"
+	"github.com/euiko/webapp/module/rbac/lib/role"
+	"github.com/euiko/webapp/pkg/helper"
+	"github.com/euiko/webapp/pkg/log"
+	"github.com/euiko/webapp/pkg/token"
+	"github.com/go-chi/chi/v5"
+)
+
+type (
+	CreateAPIKeyPayload struct {
+		Name   string   `json:"name" validate:"required"`
+		Scopes []string `json:"scopes"`
+		// ExpiresIn is the lifetime of the key in seconds, zero never expires
+		ExpiresIn int64 `json:"expires_in" validate:"gte=0"`
+	}
+
+	APIKeyResponse struct {
+		ID         string     `json:"id"`
+		Prefix     string     `json:"prefix"`
+		Name       string     `json:"name"`
+		Subject    string     `json:"subject"`
+		Scopes     []string   `json:"scopes"`
+		ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+		LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+		RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+		CreatedAt  time.Time  `json:"created_at"`
+	}
+
+	// CreateAPIKeyResponse contains the plain key, it is only shown once
+	CreateAPIKeyResponse struct {
+		APIKeyResponse
+		Key string `json:"key"`
+	}
+)
+
+const (
+	// apiKeyTouchInterval throttles the writes of the last used time
+	apiKeyTouchInterval = time.Minute
+	apiKeyScheme        = "ApiKey"
+)
+
+var (
+	ErrInvalidAPIKey = errors.New("invalid api key")
+)
+
+// apiKeyRoute registers the endpoints to manage the api keys of the current
+// user, the keys can't manage other keys
+func (m *Module[U]) apiKeyRoute(r core.Router) {
+	if !m.settings.APIKeys.Enabled {
+		return
+	}
+
+	r.Group(func(r core.Router) {
+		r.Use(m.Middleware())
+		r.Post("/auth/api-keys", m.createAPIKeyHandler)
+		r.Get("/auth/api-keys", m.listAPIKeysHandler)
+		r.Delete("/auth/api-keys/{id}", m.revokeAPIKeyHandler)
+		r.Method("GET", "/auth/users/{id}/api-keys", role.Handler(lib.PermissionManageAPIKeys, http.HandlerFunc(m.listUserAPIKeysHandler)))
+	})
+}
+
+// CreateAPIKey issues a new key of the subject, the returned plain key is
+// never stored
+func (m *Module[U]) CreateAPIKey(ctx context.Context, subject, name string, scopes []string, expiresAt time.Time) (*APIKey, string, error) {
+	if m.apiKeyStore == nil {
+		return nil, "", errors.New("api keys are not enabled")
+	}
+
+	// the lookup part is hex encoded so the key is split unambiguously
+	lookup := make([]byte, 6)
+	id := make([]byte, 16)
+	_, _ = rand.Read(lookup)
+	_, _ = rand.Read(id)
+
+	prefix := m.settings.APIKeys.Prefix + "_" + hex.EncodeToString(lookup)
+	plain := prefix + "_" + newRandomToken()
+	if scopes == nil {
+		scopes = []string{}
+	}
+
+	key := APIKey{
+		ID:        hex.EncodeToString(id),
+		Prefix:    prefix,
+		Hash:      hashToken(plain),
+		Name:      name,
+		Subject:   subject,
+		Scopes:    scopes,
+		ExpiresAt: expiresAt,
+		CreatedAt: time.Now(),
+	}
+
+	if err := m.apiKeyStore.SaveAPIKey(ctx, key); err != nil {
+		return nil, "", err
+	}
+
+	return &key, plain, nil
+}
+
+// authenticateAPIKey looks the key up by its prefix and verifies the hash of
+// the whole key
+func (m *Module[U]) authenticateAPIKey(ctx context.Context, plain string) (*APIKey, error) {
+	parts := strings.SplitN(plain, "_", 3)
+	if len(parts) != 3 || parts[0] != m.settings.APIKeys.Prefix {
+		return nil, ErrInvalidAPIKey
+	}
+
+	key, err := m.apiKeyStore.GetAPIKeyByPrefix(ctx, parts[0]+"_"+parts[1])
+	if errors.Is(err, ErrAPIKeyNotFound) {
+		return nil, ErrInvalidAPIKey
+	} else if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if subtle.ConstantTimeCompare([]byte(hashToken(plain)), []byte(key.Hash)) != 1 || !key.IsActive(now) {
+		return nil, ErrInvalidAPIKey
+	}
+
+	if now.Sub(key.LastUsedAt) >= apiKeyTouchInterval {
+		if err := m.apiKeyStore.TouchAPIKey(ctx, key.ID, now); err != nil {
+			log.Error("failed to update api key last used time", log.WithField("id", key.ID), log.WithError(err))
+		}
+	}
+
+	return key, nil
+}
+
+// apiKeyFromRequest returns the key of the api key header or the ApiKey
+// authorization scheme
+func (m *Module[U]) apiKeyFromRequest(r *http.Request) string {
+	if !m.settings.APIKeys.Enabled || m.apiKeyStore == nil {
+		return ""
+	}
+
+	if key := r.Header.Get(m.settings.APIKeys.HeaderName); key != "" {
+		return strings.TrimSpace(key)
+	}
+
+	authorization := r.Header.Get("Authorization")
+	if !strings.HasPrefix(authorization, apiKeyScheme+" ") {
+		return ""
+	}
+
+	return strings.TrimSpace(strings.TrimPrefix(authorization, apiKeyScheme))
+}
+
+// apiKeyToken represents the api key as the token of the request, it has
+// no ID since it can't be revoked through the token store
+func apiKeyToken(key *APIKey) *token.Token {
+	return &token.Token{
+		Subject:   key.Subject,
+		ExpiresAt: key.ExpiresAt,
+		Claims: map[string]any{
+			"api_key_id": key.ID,
+			claimScope:   strings.Join(key.Scopes, " "),
+		},
+	}
+}
+
+func (m *Module[U]) createAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := m.unscopedUser(w, r, "api keys")
+	if !ok {
+		return
+	}
+
+	var payload CreateAPIKeyPayload
+	if err := helper.DecodeRequestBody(r, &payload); err != nil {
+		helper.WriteResponse(w, err)
+		return
+	}
+
+	var expiresAt time.Time
+	if payload.ExpiresIn > 0 {
+		expiresAt = time.Now().Add(time.Duration(payload.ExpiresIn) * time.Second)
+	}
+
+	key, plain, err := m.CreateAPIKey(r.Context(), user.LoginID(), payload.Name, payload.Scopes, expiresAt)
+	if err != nil {
+		helper.WriteResponse(w, err)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	helper.WriteResponse(w, CreateAPIKeyResponse{
+		APIKeyResponse: toAPIKeyResponse(*key),
+		Key:            plain,
+	}, helper.ResponseWithStatus(http.StatusCreated))
+}
+
+func (m *Module[U]) listAPIKeysHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := m.unscopedUser(w, r, "api keys")
+	if !ok {
+		return
+	}
+
+	m.writeAPIKeys(w, r, user.LoginID())
+}
+
+func (m *Module[U]) listUserAPIKeysHandler(w http.ResponseWriter, r *http.Request) {
+	m.writeAPIKeys(w, r, chi.URLParam(r, "id"))
+}
+
+func (m *Module[U]) revokeAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := m.unscopedUser(w, r, "api keys")
+	if !ok {
+		return
+	}
+
+	// only allow revoking the keys owned by the current user
+	key, err := m.apiKeyStore.GetAPIKey(r.Context(), chi.URLParam(r, "id"))
+	if errors.Is(err, ErrAPIKeyNotFound) || (err == nil && key.Subject != user.LoginID()) {
+		helper.WriteResponse(w, ErrAPIKeyNotFound, helper.ResponseWithStatus(http.StatusNotFound))
+		return
+	} else if err != nil {
+		helper.WriteResponse(w, err)
+		return
+	}
+
+	err = m.apiKeyStore.RevokeAPIKey(r.Context(), key.ID)
+	if errors.Is(err, ErrAPIKeyNotFound) {
+		helper.WriteResponse(w, err, helper.ResponseWithStatus(http.StatusNotFound))
+		return
+	} else if err != nil {
+		helper.WriteResponse(w, err)
+		return
+	}
+
+	helper.WriteResponse(w, map[string]interface{}{
+		"message": "api key revoked",
+	})
+}
+
+// unscopedUser returns the current user, the requests of the scoped
+// credentials are denied so they can't manage the credentials, e.g. issue
+// keys of broader scopes. The impersonations are denied as well, otherwise
+// the actor could keep the access of the user after they expire
+func (m *Module[U]) unscopedUser(w http.ResponseWriter, r *http.Request, resource string) (lib.User, bool) {
+	user, ok := lib.CurrentUser(r.Context())
+	if !ok {
+		helper.WriteResponse(w, errors.New("unauthorized"), helper.ResponseWithStatus(http.StatusUnauthorized))
+		return nil, false
+	}
+
+	if _, ok := lib.ScopesFromContext(r.Context()); ok {
+		helper.WriteResponse(w, errors.New("scoped credentials can't manage "+resource), helper.ResponseWithStatus(http.StatusForbidden))
+		return nil, false
+	}
+
+	if lib.IsImpersonated(r.Context()) {
+		helper.WriteResponse(w, errors.New("impersonations can't manage "+resource), helper.ResponseWithStatus(http.StatusForbidden))
+		return nil, false
+	}
+
+	return user, true
+}
+
+func (m *Module[U]) writeAPIKeys(w http.ResponseWriter, r *http.Request, subject string) {
+	keys, err := m.apiKeyStore.ListAPIKeys(r.Context(), subject)
+	if err != nil {
+		helper.WriteResponse(w, err)
+		return
+	}
+
+	response := make([]APIKeyResponse, len(keys))
+	for i, key := range keys {
+		response[i] = toAPIKeyResponse(key)
+	}
+
+	helper.WriteResponse(w, response)
+}
+
+func toAPIKeyResponse(key APIKey) APIKeyResponse {
+	optionalTime := func(t time.Time) *time.Time {
+		if t.IsZero() {
+			return nil
+		}
+
+		return &t
+	}
+
+	return APIKeyResponse{
+		ID:         key.ID,
+		Prefix:     key.Prefix,
+		Name:       key.Name,
+		Subject:    key.Subject,
+		Scopes:     key.Scopes,
+		ExpiresAt:  optionalTime(key.ExpiresAt),
+		LastUsedAt: optionalTime(key.LastUsedAt),
+		RevokedAt:  optionalTime(key.RevokedAt),
+		CreatedAt:  key.CreatedAt,
+	}
+}
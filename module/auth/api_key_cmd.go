@@ -0,0 +1,133 @@
+package auth
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/euiko/webapp/db"
+	"github.com/spf13/cobra"
+)
+
+func (m *Module[U]) apiKeysCmd() *cobra.Command {
+	var dbOpened bool
+
+	cmd := &cobra.Command{
+		Use:   "api-keys",
+		Short: "Manage the api keys of the machine clients",
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			var err error
+			dbOpened, err = m.openCommandStore(m.settings.APIKeys.Store)
+			if err != nil {
+				return err
+			}
+
+			m.apiKeyStore, err = m.apiKeyStoreFactory(&m.settings)
+			return err
+		},
+		PersistentPostRunE: func(cmd *cobra.Command, args []string) error {
+			if dbOpened {
+				return db.Close()
+			}
+
+			return nil
+		},
+	}
+
+	cmd.AddCommand(m.createAPIKeyCmd())
+	cmd.AddCommand(m.listAPIKeysCmd())
+	cmd.AddCommand(m.revokeAPIKeyCmd())
+	return cmd
+}
+
+func (m *Module[U]) createAPIKeyCmd() *cobra.Command {
+	var (
+		subject   string
+		name      string
+		scopes    []string
+		expiresIn time.Duration
+	)
+
+	cmd := &cobra.Command{
+		Use:   "create",
+		Short: "Create an api key, the key is only printed once",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var expiresAt time.Time
+			if expiresIn > 0 {
+				expiresAt = time.Now().Add(expiresIn)
+			}
+
+			key, plain, err := m.CreateAPIKey(cmd.Context(), subject, name, scopes, expiresAt)
+			if err != nil {
+				return err
+			}
+
+			fmt.Println("created api key", key.ID)
+			fmt.Println(plain)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&subject, "subject", "s", "", "Login ID of the user the key acts as")
+	cmd.Flags().StringVarP(&name, "name", "n", "", "Name describing the key usage")
+	cmd.Flags().StringSliceVar(&scopes, "scope", nil, "Permissions granted to the key, e.g. admin:manage-sessions, admin:* or *")
+	cmd.Flags().DurationVar(&expiresIn, "expires-in", 0, "Lifetime of the key, zero never expires")
+	_ = cmd.MarkFlagRequired("subject")
+	_ = cmd.MarkFlagRequired("name")
+	return cmd
+}
+
+func (m *Module[U]) listAPIKeysCmd() *cobra.Command {
+	var subject string
+
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List the api keys",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			keys, err := m.apiKeyStore.ListAPIKeys(cmd.Context(), subject)
+			if err != nil {
+				return err
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "ID\tPREFIX\tNAME\tSUBJECT\tSCOPES\tEXPIRES AT\tLAST USED AT\tREVOKED AT")
+			for _, key := range keys {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+					key.ID,
+					key.Prefix,
+					key.Name,
+					key.Subject,
+					strings.Join(key.Scopes, ","),
+					formatKeyTime(key.ExpiresAt),
+					formatKeyTime(key.LastUsedAt),
+					formatKeyTime(key.RevokedAt),
+				)
+			}
+
+			return w.Flush()
+		},
+	}
+
+	cmd.Flags().StringVarP(&subject, "subject", "s", "", "Only list the keys of the user")
+	return cmd
+}
+
+func (m *Module[U]) revokeAPIKeyCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "revoke [ID]",
+		Short: "Revoke the api key immediately",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := m.apiKeyStore.RevokeAPIKey(cmd.Context(), args[0]); err != nil {
+				return err
+			}
+
+			fmt.Println("revoked api key", args[0])
+			return nil
+		},
+	}
+}
@@ -0,0 +1,334 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/euiko/webapp/db/cache"
+	"github.com/euiko/webapp/db/sqldb"
+	"github.com/euiko/webapp/module/auth/internal/schema"
+)
+
+type (
+	// APIKey authenticates the machine clients as the subject, restricted
+	// to the scopes. Only the hash of the key is stored, the prefix is a
+	// public part of the key used to look it up.
+	APIKey struct {
+		ID         string
+		Prefix     string
+		Hash       string
+		Name       string
+		Subject    string
+		Scopes     []string
+		ExpiresAt  time.Time
+		LastUsedAt time.Time
+		RevokedAt  time.Time
+		CreatedAt  time.Time
+	}
+
+	APIKeyStore interface {
+		SaveAPIKey(ctx context.Context, key APIKey) error
+		GetAPIKey(ctx context.Context, id string) (*APIKey, error)
+		GetAPIKeyByPrefix(ctx context.Context, prefix string) (*APIKey, error)
+		// ListAPIKeys returns the keys of the subject, or all keys when the
+		// subject is empty, newest first
+		ListAPIKeys(ctx context.Context, subject string) ([]APIKey, error)
+		RevokeAPIKey(ctx context.Context, id string) error
+		// TouchAPIKey records the last time the key was used
+		TouchAPIKey(ctx context.Context, id string, usedAt time.Time) error
+	}
+
+	APIKeyStoreFactory func(s *Settings) (APIKeyStore, error)
+
+	ormAPIKeyStore struct {
+		db sqldb.OrmDB
+	}
+
+	cacheAPIKeyStore struct {
+		mutex sync.Mutex
+		cache cache.Cache
+	}
+)
+
+const (
+	cacheKeyAPIKeys = "auth:api-keys"
+)
+
+var (
+	ErrAPIKeyNotFound = errors.New("api key not found")
+)
+
+func NewOrmAPIKeyStore(db sqldb.OrmDB) APIKeyStore {
+	return &ormAPIKeyStore{
+		db: db,
+	}
+}
+
+// NewCacheAPIKeyStore creates an api key store backed by the cache, the
+// keys are lost on restart so it is only suitable for development
+func NewCacheAPIKeyStore(c cache.Cache) APIKeyStore {
+	return &cacheAPIKeyStore{
+		cache: c,
+	}
+}
+
+func defaultAPIKeyStoreFactory(s *Settings) (APIKeyStore, error) {
+	switch s.APIKeys.Store {
+	case "cache":
+		return NewCacheAPIKeyStore(cache.InMemory()), nil
+	case "sql":
+		return NewOrmAPIKeyStore(sqldb.ORM()), nil
+	}
+
+	return nil, errors.New("invalid api key store (valid stores: cache, sql)")
+}
+
+// IsActive returns true when the key is neither revoked nor expired
+func (k APIKey) IsActive(now time.Time) bool {
+	return k.RevokedAt.IsZero() && (k.ExpiresAt.IsZero() || k.ExpiresAt.After(now))
+}
+
+// SaveAPIKey implements APIKeyStore.
+func (s *ormAPIKeyStore) SaveAPIKey(ctx context.Context, key APIKey) error {
+	model := schema.APIKey{
+		ID:        key.ID,
+		Prefix:    key.Prefix,
+		Hash:      key.Hash,
+		Name:      key.Name,
+		Subject:   key.Subject,
+		Scopes:    key.Scopes,
+		ExpiresAt: key.ExpiresAt,
+		CreatedAt: key.CreatedAt,
+	}
+
+	_, err := s.db.NewInsert().
+		Model(&model).
+		Exec(ctx)
+	return err
+}
+
+// GetAPIKey implements APIKeyStore.
+func (s *ormAPIKeyStore) GetAPIKey(ctx context.Context, id string) (*APIKey, error) {
+	return s.get(ctx, "id = ?", id)
+}
+
+// GetAPIKeyByPrefix implements APIKeyStore.
+func (s *ormAPIKeyStore) GetAPIKeyByPrefix(ctx context.Context, prefix string) (*APIKey, error) {
+	return s.get(ctx, "prefix = ?", prefix)
+}
+
+// ListAPIKeys implements APIKeyStore.
+func (s *ormAPIKeyStore) ListAPIKeys(ctx context.Context, subject string) ([]APIKey, error) {
+	var models []schema.APIKey
+	query := s.db.NewSelect().
+		Model(&models).
+		Order("created_at DESC")
+	if subject != "" {
+		query = query.Where("subject = ?", subject)
+	}
+
+	if err := query.Scan(ctx); err != nil {
+		return nil, err
+	}
+
+	keys := make([]APIKey, len(models))
+	for i, model := range models {
+		keys[i] = toAPIKey(model)
+	}
+
+	return keys, nil
+}
+
+// RevokeAPIKey implements APIKeyStore.
+func (s *ormAPIKeyStore) RevokeAPIKey(ctx context.Context, id string) error {
+	result, err := s.db.NewUpdate().
+		Model((*schema.APIKey)(nil)).
+		Set("revoked_at = ?", time.Now()).
+		Where("id = ?", id).
+		Where("revoked_at IS NULL").
+		Exec(ctx)
+	if err != nil {
+		return err
+	}
+
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if updated == 0 {
+		return ErrAPIKeyNotFound
+	}
+
+	return nil
+}
+
+// TouchAPIKey implements APIKeyStore.
+func (s *ormAPIKeyStore) TouchAPIKey(ctx context.Context, id string, usedAt time.Time) error {
+	_, err := s.db.NewUpdate().
+		Model((*schema.APIKey)(nil)).
+		Set("last_used_at = ?", usedAt).
+		Where("id = ?", id).
+		Exec(ctx)
+	return err
+}
+
+func (s *ormAPIKeyStore) get(ctx context.Context, where string, args ...any) (*APIKey, error) {
+	var model schema.APIKey
+	err := s.db.NewSelect().
+		Model(&model).
+		Where(where, args...).
+		Limit(1).
+		Scan(ctx)
+	if sqldb.IsNoRows(err) {
+		return nil, ErrAPIKeyNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	key := toAPIKey(model)
+	return &key, nil
+}
+
+// SaveAPIKey implements APIKeyStore.
+func (s *cacheAPIKeyStore) SaveAPIKey(ctx context.Context, key APIKey) error {
+	return s.update(func(keys map[string]APIKey) error {
+		keys[key.ID] = key
+		return nil
+	})
+}
+
+// GetAPIKey implements APIKeyStore.
+func (s *cacheAPIKeyStore) GetAPIKey(ctx context.Context, id string) (*APIKey, error) {
+	return s.find(func(key APIKey) bool {
+		return key.ID == id
+	})
+}
+
+// GetAPIKeyByPrefix implements APIKeyStore.
+func (s *cacheAPIKeyStore) GetAPIKeyByPrefix(ctx context.Context, prefix string) (*APIKey, error) {
+	return s.find(func(key APIKey) bool {
+		return key.Prefix == prefix
+	})
+}
+
+// ListAPIKeys implements APIKeyStore.
+func (s *cacheAPIKeyStore) ListAPIKeys(ctx context.Context, subject string) ([]APIKey, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	keys, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]APIKey, 0, len(keys))
+	for _, key := range keys {
+		if subject == "" || key.Subject == subject {
+			list = append(list, key)
+		}
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].CreatedAt.After(list[j].CreatedAt)
+	})
+
+	return list, nil
+}
+
+// RevokeAPIKey implements APIKeyStore.
+func (s *cacheAPIKeyStore) RevokeAPIKey(ctx context.Context, id string) error {
+	return s.update(func(keys map[string]APIKey) error {
+		key, ok := keys[id]
+		if !ok || !key.RevokedAt.IsZero() {
+			return ErrAPIKeyNotFound
+		}
+
+		key.RevokedAt = time.Now()
+		keys[id] = key
+		return nil
+	})
+}
+
+// TouchAPIKey implements APIKeyStore.
+func (s *cacheAPIKeyStore) TouchAPIKey(ctx context.Context, id string, usedAt time.Time) error {
+	return s.update(func(keys map[string]APIKey) error {
+		if key, ok := keys[id]; ok {
+			key.LastUsedAt = usedAt
+			keys[id] = key
+		}
+
+		return nil
+	})
+}
+
+func (s *cacheAPIKeyStore) find(match func(APIKey) bool) (*APIKey, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	keys, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, key := range keys {
+		if match(key) {
+			return &key, nil
+		}
+	}
+
+	return nil, ErrAPIKeyNotFound
+}
+
+func (s *cacheAPIKeyStore) update(fn func(map[string]APIKey) error) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	keys, err := s.load()
+	if err != nil {
+		return err
+	}
+
+	if err := fn(keys); err != nil {
+		return err
+	}
+
+	return s.cache.Set(cacheKeyAPIKeys, keys)
+}
+
+// load returns a copy of the stored keys so it can be modified freely
+func (s *cacheAPIKeyStore) load() (map[string]APIKey, error) {
+	keys := make(map[string]APIKey)
+
+	cached, err := s.cache.Get(cacheKeyAPIKeys)
+	if errors.Is(err, cache.ErrKeyNotFound) {
+		return keys, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	stored, _ := cached.(map[string]APIKey)
+	for id, key := range stored {
+		keys[id] = key
+	}
+
+	return keys, nil
+}
+
+func toAPIKey(model schema.APIKey) APIKey {
+	return APIKey{
+		ID:         model.ID,
+		Prefix:     model.Prefix,
+		Hash:       model.Hash,
+		Name:       model.Name,
+		Subject:    model.Subject,
+		Scopes:     model.Scopes,
+		ExpiresAt:  model.ExpiresAt,
+		LastUsedAt: model.LastUsedAt,
+		RevokedAt:  model.RevokedAt,
+		CreatedAt:  model.CreatedAt,
+	}
+}
@@ -0,0 +1,91 @@
+package auth
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/euiko/webapp/module/auth/lib"
+	"github.com/euiko/webapp/pkg/token"
+)
+
+type (
+	// identityCertMapper loads the user whose login id is the configured
+	// identity of the certificate
+	identityCertMapper[U lib.User] struct {
+		identity   string
+		userLoader lib.UserLoader[U]
+	}
+)
+
+func newIdentityCertMapper[U lib.User](identity string, userLoader lib.UserLoader[U]) (*identityCertMapper[U], error) {
+	switch identity {
+	case "common_name", "dns_san", "email_san", "uri_san":
+	default:
+		return nil, errors.New("invalid client cert identity (valid identities: common_name, dns_san, email_san, uri_san)")
+	}
+
+	return &identityCertMapper[U]{identity: identity, userLoader: userLoader}, nil
+}
+
+func (m *identityCertMapper[U]) UserFromCertificate(ctx context.Context, cert *x509.Certificate) (U, error) {
+	var (
+		user     U
+		loginIds []string
+	)
+
+	switch m.identity {
+	case "common_name":
+		loginIds = []string{cert.Subject.CommonName}
+	case "dns_san":
+		loginIds = cert.DNSNames
+	case "email_san":
+		loginIds = cert.EmailAddresses
+	case "uri_san":
+		for _, uri := range cert.URIs {
+			loginIds = append(loginIds, uri.String())
+		}
+	}
+
+	// the first of the SANs is the identity, the others are the aliases of
+	// the same service
+	if len(loginIds) == 0 || strings.TrimSpace(loginIds[0]) == "" {
+		return user, lib.ErrCertificateNotMapped
+	}
+
+	return m.userLoader.UserById(ctx, loginIds[0])
+}
+
+// clientCertificate returns the leaf of the verified client certificate
+// chain, the unverified certificates are ignored
+func (m *Module[U]) clientCertificate(r *http.Request) *x509.Certificate {
+	if !m.settings.ClientCert.Enabled || r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return nil
+	}
+
+	chain := r.TLS.VerifiedChains[0]
+	if len(chain) == 0 {
+		return nil
+	}
+
+	return chain[0]
+}
+
+// clientCertToken represents the client certificate as the token of the
+// request, like the api keys it has no ID and can't be revoked
+func (m *Module[U]) clientCertToken(cert *x509.Certificate, user lib.User) *token.Token {
+	claims := map[string]any{}
+
+	if len(m.settings.ClientCert.Scopes) > 0 {
+		claims[claimScope] = strings.Join(m.settings.ClientCert.Scopes, " ")
+	}
+
+	return &token.Token{
+		Subject:   user.LoginID(),
+		IssuedAt:  cert.NotBefore,
+		ExpiresAt: cert.NotAfter,
+		Claims:    claims,
+	}
+}
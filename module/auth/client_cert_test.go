@@ -0,0 +1,239 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/euiko/webapp/module/auth/lib"
+	"github.com/euiko/webapp/pkg/session"
+	"github.com/go-chi/chi/v5"
+)
+
+type (
+	testCA struct {
+		cert *x509.Certificate
+		key  *ecdsa.PrivateKey
+	}
+)
+
+func newTestCA(t *testing.T, name string) *testCA {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &testCA{cert: cert, key: key}
+}
+
+// issue signs a client certificate of the common name
+func (ca *testCA) issue(t *testing.T, commonName string) tls.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// newClientCertTestServer serves the current user behind the auth
+// middleware of the methods, the client certificates of the ca are verified
+// when given
+func newClientCertTestServer(t *testing.T, ca *testCA, methods lib.AuthMethod) (*Module[*testUser], *httptest.Server) {
+	t.Helper()
+
+	m := NewModule[*testUser](nil, testUserLoader{})
+	m.settings.Enabled = true
+	m.settings.ClientCert.Enabled = true
+
+	ctx := context.Background()
+	if err := m.Init(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.BeforeStart(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	r := chi.NewRouter()
+	r.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(session.WithContext(r.Context(), session.New())))
+		})
+	})
+	r.Use(m.MethodsMiddleware(methods))
+	r.Get("/me", func(w http.ResponseWriter, r *http.Request) {
+		user, _ := lib.CurrentUser(r.Context())
+		if _, ok := lib.ClientCertificate(r.Context()); ok {
+			w.Header().Set("X-Client-Cert", "true")
+		}
+		io.WriteString(w, user.LoginID())
+	})
+
+	server := httptest.NewUnstartedServer(r)
+	server.TLS = &tls.Config{
+		ClientAuth: tls.VerifyClientCertIfGiven,
+		ClientCAs:  x509.NewCertPool(),
+	}
+	server.TLS.ClientCAs.AddCert(ca.cert)
+	server.StartTLS()
+	t.Cleanup(server.Close)
+
+	return m, server
+}
+
+func getMe(t *testing.T, server *httptest.Server, cert *tls.Certificate, bearer string) (*http.Response, string, error) {
+	t.Helper()
+
+	transport := server.Client().Transport.(*http.Transport).Clone()
+	if cert != nil {
+		transport.TLSClientConfig.Certificates = []tls.Certificate{*cert}
+	}
+
+	req, err := http.NewRequest(http.MethodGet, server.URL+"/me", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bearer != "" {
+		req.Header.Set("Authorization", "Bearer "+bearer)
+	}
+
+	res, err := (&http.Client{Transport: transport}).Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer res.Body.Close()
+
+	body, _ := io.ReadAll(res.Body)
+	return res, string(body), nil
+}
+
+func TestClientCertAuthentication(t *testing.T) {
+	ca := newTestCA(t, "webapp test ca")
+	m, server := newClientCertTestServer(t, ca, lib.AuthMethodAll)
+	billing := ca.issue(t, "svc-billing")
+
+	t.Run("verified certificate", func(t *testing.T) {
+		res, body, err := getMe(t, server, &billing, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != http.StatusOK || body != "svc-billing" {
+			t.Fatalf("expected svc-billing, got %d: %s", res.StatusCode, body)
+		}
+	})
+
+	t.Run("no credentials", func(t *testing.T) {
+		res, _, err := getMe(t, server, nil, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != http.StatusUnauthorized {
+			t.Fatalf("expected 401, got %d", res.StatusCode)
+		}
+	})
+
+	t.Run("untrusted ca", func(t *testing.T) {
+		// the client doesn't send the certificate of a ca unknown to the
+		// server, or the server refuses the handshake
+		rogue := newTestCA(t, "rogue ca").issue(t, "svc-billing")
+		res, _, err := getMe(t, server, &rogue, "")
+		if err == nil && res.StatusCode != http.StatusUnauthorized {
+			t.Fatalf("expected 401, got %d", res.StatusCode)
+		}
+	})
+
+	t.Run("bearer token with certificate", func(t *testing.T) {
+		response, err := m.issueTokens(context.Background(), &testUser{id: "alice"}, tokenGrant{WithoutRefreshToken: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		res, body, err := getMe(t, server, &billing, response.Token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != http.StatusOK || body != "alice" || res.Header.Get("X-Client-Cert") != "true" {
+			t.Fatalf("expected alice through svc-billing, got %d: %s", res.StatusCode, body)
+		}
+	})
+}
+
+func TestClientCertMethods(t *testing.T) {
+	ca := newTestCA(t, "webapp test ca")
+	billing := ca.issue(t, "svc-billing")
+
+	t.Run("token only", func(t *testing.T) {
+		_, server := newClientCertTestServer(t, ca, lib.AuthMethodToken)
+		res, _, err := getMe(t, server, &billing, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != http.StatusUnauthorized {
+			t.Fatalf("expected 401, got %d", res.StatusCode)
+		}
+	})
+
+	t.Run("client cert only", func(t *testing.T) {
+		m, server := newClientCertTestServer(t, ca, lib.AuthMethodClientCert)
+		response, err := m.issueTokens(context.Background(), &testUser{id: "alice"}, tokenGrant{WithoutRefreshToken: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		res, _, err := getMe(t, server, nil, response.Token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != http.StatusUnauthorized {
+			t.Fatalf("expected 401, got %d", res.StatusCode)
+		}
+	})
+}
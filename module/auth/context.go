@@ -7,13 +7,15 @@ import (
 )
 
 type (
-	tokenContextKeyType struct{}
-	userContextKeyType  struct{}
+	tokenContextKeyType  struct{}
+	userContextKeyType   struct{}
+	apiKeyContextKeyType struct{}
 )
 
 var (
-	tokenContextKey = tokenContextKeyType{}
-	userContextKey  = userContextKeyType{}
+	tokenContextKey  = tokenContextKeyType{}
+	userContextKey   = userContextKeyType{}
+	apiKeyContextKey = apiKeyContextKeyType{}
 )
 
 func IsAuthenticated(ctx context.Context) bool {
@@ -35,6 +37,12 @@ func TokenFromContext(ctx context.Context) (*token.Token, bool) {
 	return token, true
 }
 
+// APIKeyFromContext returns the api key authenticating the request
+func APIKeyFromContext(ctx context.Context) (*APIKey, bool) {
+	key, ok := ctx.Value(apiKeyContextKey).(*APIKey)
+	return key, ok
+}
+
 func UserFromContext[User any](ctx context.Context) (*User, bool) {
 	v := ctx.Value(userContextKey)
 	if v == nil {
@@ -56,3 +64,7 @@ func contextWithToken(ctx context.Context, token *token.Token) context.Context {
 func contextWithUser[User any](ctx context.Context, user *User) context.Context {
 	return context.WithValue(ctx, userContextKey, user)
 }
+
+func contextWithAPIKey(ctx context.Context, key *APIKey) context.Context {
+	return context.WithValue(ctx, apiKeyContextKey, key)
+}
@@ -0,0 +1,212 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/euiko/webapp/core"
+	"github.com/euiko/webapp/db/sqldb"
+	"github.com/euiko/webapp/module/auth/lib"
+	rbaclib "github.com/euiko/webapp/module/rbac/lib"
+	"github.com/euiko/webapp/module/rbac/lib/role"
+	"github.com/euiko/webapp/pkg/helper"
+	"github.com/euiko/webapp/pkg/log"
+	"github.com/euiko/webapp/pkg/token"
+)
+
+type (
+	ImpersonatePayload struct {
+		LoginId string `json:"login_id" validate:"required"`
+		// Reason is recorded in the logs, e.g. the support ticket
+		Reason string `json:"reason" validate:"max=255"`
+	}
+
+	ImpersonationResponse struct {
+		Token     string    `json:"token"`
+		ExpiresIn int64     `json:"expires_in"`
+		ExpiresAt time.Time `json:"expires_at"`
+		Subject   string    `json:"subject"`
+		Actor     string    `json:"actor"`
+	}
+)
+
+var (
+	ErrImpersonationNotAllowed = errors.New("impersonation not allowed")
+	ErrImpersonationExpired    = errors.New("impersonation expired")
+)
+
+// impersonationRoute registers the endpoints to start and stop impersonating
+// the users
+func (m *Module[U]) impersonationRoute(r core.Router) {
+	if !m.settings.Impersonation.Enabled {
+		return
+	}
+
+	r.Group(func(r core.Router) {
+		r.Use(m.Middleware())
+		r.Method("POST", "/auth/impersonate", role.Handler(lib.PermissionImpersonateUsers, http.HandlerFunc(m.impersonateHandler)))
+		r.Delete("/auth/impersonate", m.stopImpersonationHandler)
+	})
+}
+
+// tokenImpersonation returns the impersonation of the token carrying an
+// actor, it is expired after the impersonation timeout since the token was
+// issued
+func (m *Module[U]) tokenImpersonation(t *token.Token) (*lib.Impersonation, bool, error) {
+	actor, ok := token.Claim[map[string]any](t, claimActor)
+	if !ok {
+		return nil, false, nil
+	}
+
+	actorID, _ := actor["sub"].(string)
+	if !m.settings.Impersonation.Enabled || actorID == "" || t.IssuedAt.IsZero() {
+		return nil, true, ErrImpersonationNotAllowed
+	}
+
+	impersonation := lib.Impersonation{
+		ActorID:   actorID,
+		StartedAt: t.IssuedAt,
+		ExpiresAt: t.IssuedAt.Add(m.settings.Impersonation.Timeout),
+	}
+	if !t.ExpiresAt.IsZero() && t.ExpiresAt.Before(impersonation.ExpiresAt) {
+		impersonation.ExpiresAt = t.ExpiresAt
+	}
+
+	if !time.Now().Before(impersonation.ExpiresAt) {
+		return nil, true, ErrImpersonationExpired
+	}
+
+	return &impersonation, true, nil
+}
+
+// withImpersonation flags the context and attributes its logs to both the
+// user and the actor, every request is logged as an audit trail
+func withImpersonation(r *http.Request, user lib.User, impersonation *lib.Impersonation) context.Context {
+	ctx := lib.WithImpersonation(r.Context(), impersonation)
+	ctx = log.SetFieldsContext(ctx, log.Fields{
+		"user":         user.LoginID(),
+		"impersonator": impersonation.ActorID,
+	})
+
+	log.Info("impersonated request",
+		log.WithContext(ctx),
+		log.WithField("method", r.Method),
+		log.WithField("path", r.URL.Path),
+	)
+	return ctx
+}
+
+func (m *Module[U]) impersonateHandler(w http.ResponseWriter, r *http.Request) {
+	// the impersonations can't be chained to escalate the privileges
+	actor, ok := m.unscopedUser(w, r, "impersonations")
+	if !ok {
+		return
+	}
+
+	var payload ImpersonatePayload
+	if err := helper.DecodeRequestBody(r, &payload); err != nil {
+		helper.WriteResponse(w, err)
+		return
+	}
+
+	if payload.LoginId == actor.LoginID() {
+		helper.WriteResponse(w, ErrImpersonationNotAllowed, helper.ResponseWithStatus(http.StatusForbidden))
+		return
+	}
+
+	target, err := m.userLoader.UserById(r.Context(), payload.LoginId)
+	if err != nil {
+		helper.WriteResponse(w, errors.New("user not found"), helper.ResponseWithStatus(http.StatusNotFound))
+		return
+	}
+
+	if err := m.canImpersonate(r.Context(), target); err != nil {
+		if errors.Is(err, ErrImpersonationNotAllowed) {
+			log.Warning("refused to impersonate a higher privileged user",
+				log.WithField("impersonator", actor.LoginID()),
+				log.WithField("user", target.LoginID()),
+			)
+			helper.WriteResponse(w, err, helper.ResponseWithStatus(http.StatusForbidden))
+			return
+		}
+
+		helper.WriteResponse(w, err)
+		return
+	}
+
+	// the token isn't refreshable so the impersonation ends automatically
+	response, err := m.issueTokens(r.Context(), target, tokenGrant{
+		Actor:               actor.LoginID(),
+		WithoutRefreshToken: true,
+	})
+	if err != nil {
+		helper.WriteResponse(w, err)
+		return
+	}
+
+	log.Info("impersonation started",
+		log.WithField("impersonator", actor.LoginID()),
+		log.WithField("user", target.LoginID()),
+		log.WithField("reason", payload.Reason),
+		log.WithField("expires_in", response.ExpiresIn),
+	)
+
+	helper.WriteResponse(w, ImpersonationResponse{
+		Token:     response.Token,
+		ExpiresIn: response.ExpiresIn,
+		ExpiresAt: time.Now().Add(time.Duration(response.ExpiresIn) * time.Second),
+		Subject:   target.LoginID(),
+		Actor:     actor.LoginID(),
+	}, helper.ResponseWithStatus(http.StatusCreated))
+}
+
+// stopImpersonationHandler revokes the impersonation token before it
+// expires
+func (m *Module[U]) stopImpersonationHandler(w http.ResponseWriter, r *http.Request) {
+	impersonation, ok := lib.CurrentImpersonation(r.Context())
+	if !ok {
+		helper.WriteResponse(w, errors.New("not impersonating"), helper.ResponseWithStatus(http.StatusBadRequest))
+		return
+	}
+
+	if err := m.revokeTokens(r); err != nil {
+		helper.WriteResponse(w, err)
+		return
+	}
+
+	log.Info("impersonation stopped", log.WithContext(r.Context()), log.WithField("started_at", impersonation.StartedAt))
+	helper.WriteResponse(w, map[string]interface{}{
+		"message": "impersonation stopped",
+	})
+}
+
+// canImpersonate returns ErrImpersonationNotAllowed when the role of the
+// target has a permission not granted to the current user
+func (m *Module[U]) canImpersonate(ctx context.Context, target lib.User) error {
+	granted := rbaclib.PermissionsFromContext(ctx)
+	rbacModule, ok := core.GetModule[rbaclib.Module](m.app)
+	if granted == nil || !ok {
+		return ErrImpersonationNotAllowed
+	}
+
+	roleUser, ok := target.(rbaclib.User)
+	if !ok || roleUser.RoleName() == "" {
+		return nil
+	}
+
+	targetRole, err := rbacModule.GetRole(ctx, roleUser.RoleName())
+	if sqldb.IsNoRows(err) {
+		// an unknown role grants no permission
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if !granted.HasAllIDs(targetRole.Permissions...) {
+		return ErrImpersonationNotAllowed
+	}
+
+	return nil
+}
@@ -0,0 +1,37 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestImpersonationCredentials(t *testing.T) {
+	m, r := newLoginTestServer(t, func(s *Settings) {
+		s.Impersonation.Enabled = true
+		s.APIKeys.Enabled = true
+		s.APIKeys.Store = "cache"
+	})
+	r.With(m.Middleware()).Post("/auth/api-keys", m.createAPIKeyHandler)
+
+	response, err := m.issueTokens(context.Background(), &testUser{id: "bob"}, tokenGrant{
+		Actor:               "admin",
+		WithoutRefreshToken: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the actor can't mint a key outliving the impersonation
+	req := httptest.NewRequest(http.MethodPost, "/auth/api-keys", strings.NewReader(`{"name":"backdoor"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+response.Token)
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("expected 403, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
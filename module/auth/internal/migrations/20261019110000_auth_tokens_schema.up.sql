@@ -0,0 +1,37 @@
+SET statement_timeout = 0;
+
+--bun:split
+
+CREATE SCHEMA IF NOT EXISTS auth;
+
+--bun:split
+
+CREATE TABLE IF NOT EXISTS auth.refresh_tokens (
+    id VARCHAR(64) PRIMARY KEY,
+    family VARCHAR(64) NOT NULL,
+    subject VARCHAR(255) NOT NULL,
+    used_at TIMESTAMPTZ,
+    revoked_at TIMESTAMPTZ,
+    expires_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+--bun:split
+
+CREATE INDEX IF NOT EXISTS refresh_tokens_family_idx ON auth.refresh_tokens (family);
+
+--bun:split
+
+CREATE INDEX IF NOT EXISTS refresh_tokens_expires_at_idx ON auth.refresh_tokens (expires_at);
+
+--bun:split
+
+CREATE TABLE IF NOT EXISTS auth.revoked_tokens (
+    jti VARCHAR(64) PRIMARY KEY,
+    expires_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+--bun:split
+
+CREATE INDEX IF NOT EXISTS revoked_tokens_expires_at_idx ON auth.revoked_tokens (expires_at);
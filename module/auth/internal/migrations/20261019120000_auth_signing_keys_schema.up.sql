@@ -0,0 +1,22 @@
+SET statement_timeout = 0;
+
+--bun:split
+
+CREATE SCHEMA IF NOT EXISTS auth;
+
+--bun:split
+
+CREATE TABLE IF NOT EXISTS auth.signing_keys (
+    id VARCHAR(64) PRIMARY KEY,
+    algorithm VARCHAR(16) NOT NULL,
+    state VARCHAR(16) NOT NULL,
+    material BYTEA NOT NULL,
+    activates_at TIMESTAMPTZ NOT NULL,
+    expires_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+--bun:split
+
+CREATE INDEX IF NOT EXISTS signing_keys_state_idx ON auth.signing_keys (state);
@@ -0,0 +1,26 @@
+SET statement_timeout = 0;
+
+--bun:split
+
+CREATE SCHEMA IF NOT EXISTS auth;
+
+--bun:split
+
+CREATE TABLE IF NOT EXISTS auth.access_tokens (
+    id VARCHAR(64) PRIMARY KEY,
+    jti VARCHAR(64) NOT NULL,
+    issuer VARCHAR(255) NOT NULL,
+    subject VARCHAR(255) NOT NULL,
+    audience JSONB,
+    claims JSONB,
+    issued_at TIMESTAMPTZ NOT NULL,
+    expires_at TIMESTAMPTZ
+);
+
+--bun:split
+
+CREATE INDEX IF NOT EXISTS access_tokens_subject_idx ON auth.access_tokens (subject);
+
+--bun:split
+
+CREATE INDEX IF NOT EXISTS access_tokens_expires_at_idx ON auth.access_tokens (expires_at);
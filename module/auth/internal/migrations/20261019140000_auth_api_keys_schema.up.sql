@@ -0,0 +1,28 @@
+SET statement_timeout = 0;
+
+--bun:split
+
+CREATE SCHEMA IF NOT EXISTS auth;
+
+--bun:split
+
+CREATE TABLE IF NOT EXISTS auth.api_keys (
+    id VARCHAR(64) PRIMARY KEY,
+    prefix VARCHAR(32) NOT NULL,
+    hash VARCHAR(64) NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    subject VARCHAR(255) NOT NULL,
+    scopes JSONB,
+    expires_at TIMESTAMPTZ,
+    last_used_at TIMESTAMPTZ,
+    revoked_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+--bun:split
+
+CREATE UNIQUE INDEX IF NOT EXISTS api_keys_prefix_idx ON auth.api_keys (prefix);
+
+--bun:split
+
+CREATE INDEX IF NOT EXISTS api_keys_subject_idx ON auth.api_keys (subject);
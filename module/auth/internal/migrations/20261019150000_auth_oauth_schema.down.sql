@@ -0,0 +1,13 @@
+SET statement_timeout = 0;
+
+--bun:split
+
+ALTER TABLE auth.refresh_tokens DROP COLUMN IF EXISTS scopes;
+
+--bun:split
+
+ALTER TABLE auth.refresh_tokens DROP COLUMN IF EXISTS client_id;
+
+--bun:split
+
+DROP TABLE IF EXISTS auth.oauth_clients;
@@ -0,0 +1,27 @@
+SET statement_timeout = 0;
+
+--bun:split
+
+CREATE SCHEMA IF NOT EXISTS auth;
+
+--bun:split
+
+CREATE TABLE IF NOT EXISTS auth.oauth_clients (
+    id VARCHAR(64) PRIMARY KEY,
+    secret_hash VARCHAR(64),
+    name VARCHAR(255) NOT NULL,
+    redirect_uris JSONB,
+    grant_types JSONB,
+    scopes JSONB,
+    subject VARCHAR(255),
+    trusted BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+--bun:split
+
+ALTER TABLE auth.refresh_tokens ADD COLUMN IF NOT EXISTS client_id VARCHAR(64);
+
+--bun:split
+
+ALTER TABLE auth.refresh_tokens ADD COLUMN IF NOT EXISTS scopes JSONB;
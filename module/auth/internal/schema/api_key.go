@@ -0,0 +1,24 @@
+package schema
+
+import (
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+type (
+	APIKey struct {
+		bun.BaseModel `bun:"table:auth.api_keys"`
+
+		ID         string    `bun:"id,pk"`
+		Prefix     string    `bun:"prefix,notnull"`
+		Hash       string    `bun:"hash,notnull"`
+		Name       string    `bun:"name,notnull"`
+		Subject    string    `bun:"subject,notnull"`
+		Scopes     []string  `bun:"scopes,type:jsonb"`
+		ExpiresAt  time.Time `bun:"expires_at,nullzero"`
+		LastUsedAt time.Time `bun:"last_used_at,nullzero"`
+		RevokedAt  time.Time `bun:"revoked_at,nullzero"`
+		CreatedAt  time.Time `bun:"created_at,notnull,nullzero,default:current_timestamp"`
+	}
+)
@@ -0,0 +1,22 @@
+package schema
+
+import (
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+type (
+	SigningKey struct {
+		bun.BaseModel `bun:"table:auth.signing_keys"`
+
+		ID          string    `bun:"id,pk"`
+		Algorithm   string    `bun:"algorithm,notnull"`
+		State       string    `bun:"state,notnull"`
+		Material    []byte    `bun:"material,notnull"`
+		ActivatesAt time.Time `bun:"activates_at,notnull"`
+		ExpiresAt   time.Time `bun:"expires_at,nullzero"`
+		CreatedAt   time.Time `bun:"created_at,notnull,nullzero,default:current_timestamp"`
+		UpdatedAt   time.Time `bun:"updated_at,notnull,nullzero,default:current_timestamp"`
+	}
+)
@@ -0,0 +1,18 @@
+package schema
+
+import (
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+type (
+	LoginAttempt struct {
+		bun.BaseModel `bun:"table:auth.login_attempts,alias:login_attempts"`
+
+		Key           string    `bun:"key,pk"`
+		Failures      int       `bun:"failures,notnull"`
+		LastFailureAt time.Time `bun:"last_failure_at,notnull"`
+		LockedUntil   time.Time `bun:"locked_until,nullzero"`
+	}
+)
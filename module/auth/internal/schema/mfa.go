@@ -0,0 +1,21 @@
+package schema
+
+import (
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+type (
+	MFA struct {
+		bun.BaseModel `bun:"table:auth.mfa"`
+
+		Subject       string    `bun:"subject,pk"`
+		Secret        string    `bun:"secret,notnull"`
+		Enabled       bool      `bun:"enabled,notnull"`
+		LastCounter   int64     `bun:"last_counter,notnull"`
+		RecoveryCodes []string  `bun:"recovery_codes,type:jsonb"`
+		CreatedAt     time.Time `bun:"created_at,notnull,nullzero,default:current_timestamp"`
+		EnabledAt     time.Time `bun:"enabled_at,nullzero"`
+	}
+)
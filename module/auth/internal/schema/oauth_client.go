@@ -0,0 +1,23 @@
+package schema
+
+import (
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+type (
+	OAuthClient struct {
+		bun.BaseModel `bun:"table:auth.oauth_clients"`
+
+		ID           string    `bun:"id,pk"`
+		SecretHash   string    `bun:"secret_hash,nullzero"`
+		Name         string    `bun:"name,notnull"`
+		RedirectURIs []string  `bun:"redirect_uris,type:jsonb"`
+		GrantTypes   []string  `bun:"grant_types,type:jsonb"`
+		Scopes       []string  `bun:"scopes,type:jsonb"`
+		Subject      string    `bun:"subject,nullzero"`
+		Trusted      bool      `bun:"trusted,notnull"`
+		CreatedAt    time.Time `bun:"created_at,notnull,nullzero,default:current_timestamp"`
+	}
+)
@@ -0,0 +1,44 @@
+package schema
+
+import (
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+type (
+	RefreshToken struct {
+		bun.BaseModel `bun:"table:auth.refresh_tokens"`
+
+		ID        string    `bun:"id,pk"`
+		Family    string    `bun:"family,notnull"`
+		Subject   string    `bun:"subject,notnull"`
+		ClientID  string    `bun:"client_id,nullzero"`
+		Scopes    []string  `bun:"scopes,type:jsonb"`
+		UsedAt    time.Time `bun:"used_at,nullzero"`
+		RevokedAt time.Time `bun:"revoked_at,nullzero"`
+		ExpiresAt time.Time `bun:"expires_at,notnull"`
+		CreatedAt time.Time `bun:"created_at,notnull,nullzero,default:current_timestamp"`
+	}
+
+	RevokedToken struct {
+		bun.BaseModel `bun:"table:auth.revoked_tokens"`
+
+		JTI       string    `bun:"jti,pk"`
+		ExpiresAt time.Time `bun:"expires_at,notnull"`
+		CreatedAt time.Time `bun:"created_at,notnull,nullzero,default:current_timestamp"`
+	}
+
+	AccessToken struct {
+		bun.BaseModel `bun:"table:auth.access_tokens"`
+
+		ID        string         `bun:"id,pk"`
+		JTI       string         `bun:"jti,notnull"`
+		Issuer    string         `bun:"issuer,notnull"`
+		Subject   string         `bun:"subject,notnull"`
+		Audience  []string       `bun:"audience,type:jsonb"`
+		Claims    map[string]any `bun:"claims,type:jsonb"`
+		IssuedAt  time.Time      `bun:"issued_at,notnull"`
+		ExpiresAt time.Time      `bun:"expires_at,nullzero"`
+	}
+)
@@ -0,0 +1,111 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+
+	"github.com/euiko/webapp/pkg/helper"
+	"github.com/euiko/webapp/pkg/log"
+)
+
+type (
+	// IntrospectPayload is the RFC 7662 introspection request
+	IntrospectPayload struct {
+		Token         string `in:"form=token" json:"token" validate:"required"`
+		TokenTypeHint string `in:"form=token_type_hint" json:"token_type_hint"`
+	}
+)
+
+var (
+	ErrInvalidClient = errors.New("invalid client")
+)
+
+// introspectHandler tells the resource servers whether the access token is
+// active along with its claims, the resource servers authenticate using the
+// HTTP basic credentials of the configured clients
+func (m *Module[U]) introspectHandler(w http.ResponseWriter, r *http.Request) {
+	if !m.authenticateIntrospection(r) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="introspect"`)
+		helper.WriteResponse(w, ErrInvalidClient, helper.ResponseWithStatus(http.StatusUnauthorized))
+		return
+	}
+
+	var payload IntrospectPayload
+	if err := helper.DecodeRequestBody(r, &payload); err != nil {
+		helper.WriteResponse(w, err)
+		return
+	}
+
+	// the refresh tokens are never introspected, any invalid token is
+	// simply reported as inactive
+	inactive := map[string]any{"active": false}
+	if payload.TokenTypeHint == "refresh_token" {
+		helper.WriteResponse(w, inactive)
+		return
+	}
+
+	ctx := r.Context()
+	decoded, err := m.decodeToken(ctx, []byte(payload.Token))
+	if err != nil {
+		helper.WriteResponse(w, inactive)
+		return
+	}
+
+	if decoded.ID != "" {
+		revoked, err := m.tokenStore.IsTokenRevoked(ctx, decoded.ID)
+		if err != nil {
+			log.Error("failed to check token revocation", log.WithError(err))
+			helper.WriteResponse(w, err)
+			return
+		}
+
+		if revoked {
+			helper.WriteResponse(w, inactive)
+			return
+		}
+	}
+
+	// the private claims are written first so they can't override the
+	// registered ones
+	response := make(map[string]any, len(decoded.Claims)+8)
+	for name, value := range decoded.Claims {
+		response[name] = value
+	}
+
+	response["active"] = true
+	response["token_type"] = "Bearer"
+	response["sub"] = decoded.Subject
+	if decoded.ID != "" {
+		response["jti"] = decoded.ID
+	}
+	if decoded.Issuer != "" {
+		response["iss"] = decoded.Issuer
+	}
+	if len(decoded.Audience) > 0 {
+		response["aud"] = decoded.Audience
+	}
+	if !decoded.IssuedAt.IsZero() {
+		response["iat"] = decoded.IssuedAt.Unix()
+	}
+	if !decoded.ExpiresAt.IsZero() {
+		response["exp"] = decoded.ExpiresAt.Unix()
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	helper.WriteResponse(w, response)
+}
+
+func (m *Module[U]) authenticateIntrospection(r *http.Request) bool {
+	clientID, secret, ok := r.BasicAuth()
+	if !ok {
+		return false
+	}
+
+	expected, ok := m.settings.Introspection.Clients[clientID]
+	if !ok || expected == "" {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(secret), []byte(expected)) == 1
+}
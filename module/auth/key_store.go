@@ -0,0 +1,264 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/euiko/webapp/db/cache"
+	"github.com/euiko/webapp/db/sqldb"
+	"github.com/euiko/webapp/module/auth/internal/schema"
+)
+
+type (
+	KeyState string
+
+	// SigningKey is a managed key of the token encoding, the material is
+	// the secret of the symmetric algorithms or the PEM encoded private key
+	// of the asymmetric ones
+	SigningKey struct {
+		ID        string
+		Algorithm string
+		State     KeyState
+		Material  []byte
+		// ActivatesAt is when the key starts signing, it only verifies
+		// before then so the key can be published in advance
+		ActivatesAt time.Time
+		// ExpiresAt is when the key stops verifying, zero means never
+		ExpiresAt time.Time
+		CreatedAt time.Time
+	}
+
+	SigningKeyStore interface {
+		// SaveKey inserts the key or replaces the existing key of the ID
+		SaveKey(ctx context.Context, key SigningKey) error
+		// ListKeys returns all keys ordered by the activation, newest first
+		ListKeys(ctx context.Context) ([]SigningKey, error)
+		// GetKey returns the key of the ID
+		GetKey(ctx context.Context, id string) (*SigningKey, error)
+	}
+
+	// SigningKeyStoreFactory creates the store of the managed keys, a nil
+	// store means only the keys in the settings are used
+	SigningKeyStoreFactory func(s *Settings) (SigningKeyStore, error)
+
+	ormSigningKeyStore struct {
+		db sqldb.OrmDB
+	}
+
+	cacheSigningKeyStore struct {
+		mutex sync.Mutex
+		cache cache.Cache
+	}
+)
+
+const (
+	// KeyStateActive keys sign once activated and verify the tokens
+	KeyStateActive KeyState = "active"
+	// KeyStateVerifyOnly keys only verify the tokens until expired, the
+	// rotated keys are kept in this state for the outstanding tokens
+	KeyStateVerifyOnly KeyState = "verify-only"
+	// KeyStateRetired keys neither sign nor verify
+	KeyStateRetired KeyState = "retired"
+
+	cacheKeySigningKeys = "auth:signing-keys"
+)
+
+var (
+	ErrSigningKeyNotFound = errors.New("signing key not found")
+)
+
+func NewOrmSigningKeyStore(db sqldb.OrmDB) SigningKeyStore {
+	return &ormSigningKeyStore{
+		db: db,
+	}
+}
+
+// NewCacheSigningKeyStore creates a key store backed by the cache, the keys
+// are lost on restart so it is only suitable for a single instance
+func NewCacheSigningKeyStore(c cache.Cache) SigningKeyStore {
+	return &cacheSigningKeyStore{
+		cache: c,
+	}
+}
+
+func defaultSigningKeyStoreFactory(s *Settings) (SigningKeyStore, error) {
+	switch s.KeyManagement.Store {
+	case "", "config":
+		return nil, nil
+	case "cache":
+		return NewCacheSigningKeyStore(cache.InMemory()), nil
+	case "sql":
+		return NewOrmSigningKeyStore(sqldb.ORM()), nil
+	}
+
+	return nil, errors.New("invalid key store (valid stores: config, cache, sql)")
+}
+
+// CanSign returns true when the key is active and already activated
+func (k SigningKey) CanSign(now time.Time) bool {
+	return k.State == KeyStateActive && !k.ActivatesAt.After(now) && k.CanVerify(now)
+}
+
+// CanVerify returns true when the key is not retired nor expired
+func (k SigningKey) CanVerify(now time.Time) bool {
+	return k.State != KeyStateRetired && (k.ExpiresAt.IsZero() || k.ExpiresAt.After(now))
+}
+
+// SaveKey implements SigningKeyStore.
+func (s *ormSigningKeyStore) SaveKey(ctx context.Context, key SigningKey) error {
+	model := schema.SigningKey{
+		ID:          key.ID,
+		Algorithm:   key.Algorithm,
+		State:       string(key.State),
+		Material:    key.Material,
+		ActivatesAt: key.ActivatesAt,
+		ExpiresAt:   key.ExpiresAt,
+		UpdatedAt:   time.Now(),
+	}
+
+	_, err := s.db.NewInsert().
+		Model(&model).
+		On("CONFLICT (id) DO UPDATE").
+		Set("state = EXCLUDED.state").
+		Set("activates_at = EXCLUDED.activates_at").
+		Set("expires_at = EXCLUDED.expires_at").
+		Set("updated_at = EXCLUDED.updated_at").
+		Exec(ctx)
+	return err
+}
+
+// ListKeys implements SigningKeyStore.
+func (s *ormSigningKeyStore) ListKeys(ctx context.Context) ([]SigningKey, error) {
+	var models []schema.SigningKey
+	err := s.db.NewSelect().
+		Model(&models).
+		Order("activates_at DESC", "created_at DESC").
+		Scan(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]SigningKey, len(models))
+	for i, model := range models {
+		keys[i] = toSigningKey(model)
+	}
+
+	return keys, nil
+}
+
+// GetKey implements SigningKeyStore.
+func (s *ormSigningKeyStore) GetKey(ctx context.Context, id string) (*SigningKey, error) {
+	var model schema.SigningKey
+	err := s.db.NewSelect().
+		Model(&model).
+		Where("id = ?", id).
+		Limit(1).
+		Scan(ctx)
+	if sqldb.IsNoRows(err) {
+		return nil, ErrSigningKeyNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	key := toSigningKey(model)
+	return &key, nil
+}
+
+// SaveKey implements SigningKeyStore.
+func (s *cacheSigningKeyStore) SaveKey(ctx context.Context, key SigningKey) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	keys, err := s.load()
+	if err != nil {
+		return err
+	}
+
+	if existing, ok := keys[key.ID]; ok {
+		key.Material = existing.Material
+		key.CreatedAt = existing.CreatedAt
+	} else if key.CreatedAt.IsZero() {
+		key.CreatedAt = time.Now()
+	}
+
+	keys[key.ID] = key
+	return s.cache.Set(cacheKeySigningKeys, keys)
+}
+
+// ListKeys implements SigningKeyStore.
+func (s *cacheSigningKeyStore) ListKeys(ctx context.Context) ([]SigningKey, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	keys, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]SigningKey, 0, len(keys))
+	for _, key := range keys {
+		list = append(list, key)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].ActivatesAt.Equal(list[j].ActivatesAt) {
+			return list[i].ActivatesAt.After(list[j].ActivatesAt)
+		}
+
+		return list[i].CreatedAt.After(list[j].CreatedAt)
+	})
+
+	return list, nil
+}
+
+// GetKey implements SigningKeyStore.
+func (s *cacheSigningKeyStore) GetKey(ctx context.Context, id string) (*SigningKey, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	keys, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+
+	key, ok := keys[id]
+	if !ok {
+		return nil, ErrSigningKeyNotFound
+	}
+
+	return &key, nil
+}
+
+// load returns a copy of the stored keys so it can be modified freely
+func (s *cacheSigningKeyStore) load() (map[string]SigningKey, error) {
+	keys := make(map[string]SigningKey)
+
+	cached, err := s.cache.Get(cacheKeySigningKeys)
+	if errors.Is(err, cache.ErrKeyNotFound) {
+		return keys, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	stored, _ := cached.(map[string]SigningKey)
+	for id, key := range stored {
+		keys[id] = key
+	}
+
+	return keys, nil
+}
+
+func toSigningKey(model schema.SigningKey) SigningKey {
+	return SigningKey{
+		ID:          model.ID,
+		Algorithm:   model.Algorithm,
+		State:       KeyState(model.State),
+		Material:    model.Material,
+		ActivatesAt: model.ActivatesAt,
+		ExpiresAt:   model.ExpiresAt,
+		CreatedAt:   model.CreatedAt,
+	}
+}
@@ -0,0 +1,307 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/euiko/webapp/pkg/log"
+	"github.com/euiko/webapp/pkg/token"
+)
+
+type (
+	// keyring is an immutable snapshot of the keys used by the module, it
+	// is swapped entirely on reload
+	keyring struct {
+		signing  token.Key
+		keys     []token.Key
+		byID     map[string]token.Key
+		loadedAt time.Time
+	}
+)
+
+const (
+	// minKeyReloadInterval throttles the reload caused by the tokens of
+	// unknown key IDs
+	minKeyReloadInterval = 10 * time.Second
+)
+
+// NewSigningKey generates the key material of the algorithm, the key
+// becomes the signing key once activated
+func NewSigningKey(algorithm string, activatesAt time.Time) (SigningKey, error) {
+	symmetric, err := isSymmetricAlgorithm(algorithm)
+	if err != nil {
+		return SigningKey{}, err
+	}
+
+	key := SigningKey{
+		Algorithm:   algorithm,
+		State:       KeyStateActive,
+		ActivatesAt: activatesAt,
+		CreatedAt:   time.Now(),
+	}
+
+	if symmetric {
+		size := map[string]int{"HS256": 32, "HS384": 48, "HS512": 64, pasetoV4Local: 32}[algorithm]
+		if size == 0 {
+			return SigningKey{}, fmt.Errorf("unsupported jwt algorithm: %s", algorithm)
+		}
+
+		key.ID = newRandomToken()
+		key.Material = make([]byte, size)
+		_, _ = rand.Read(key.Material)
+		return key, nil
+	}
+
+	var private crypto.Signer
+	switch algorithm {
+	case "RS256", "RS384", "RS512", "PS256", "PS384", "PS512":
+		private, err = rsa.GenerateKey(rand.Reader, 2048)
+	case "ES256":
+		private, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case "ES384":
+		private, err = ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case "ES512":
+		private, err = ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	case "EdDSA", pasetoV4Public:
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return SigningKey{}, fmt.Errorf("unsupported jwt algorithm: %s", algorithm)
+	}
+
+	if err != nil {
+		return SigningKey{}, err
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return SigningKey{}, err
+	}
+
+	key.Material = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	parsed, err := token.ParsePEMKey(key.Material)
+	if err != nil {
+		return SigningKey{}, err
+	}
+
+	key.ID = parsed.KeyID()
+	return key, nil
+}
+
+// toTokenKey converts the managed key into the key of the token encoding
+func (k SigningKey) toTokenKey() (token.Key, error) {
+	symmetric, err := isSymmetricAlgorithm(k.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	if symmetric {
+		return token.WithKeyID(k.ID, token.NewSymetricKey(k.Material)), nil
+	}
+
+	key, err := token.ParsePEMKey(k.Material)
+	if err != nil {
+		return nil, err
+	}
+
+	if key.KeyID() != k.ID {
+		return token.WithKeyID(k.ID, key), nil
+	}
+
+	return key, nil
+}
+
+func (r *keyring) add(key token.Key) {
+	r.keys = append(r.keys, key)
+	if id := token.KeyID(key); id != "" {
+		r.byID[id] = key
+	}
+}
+
+// reloadKeys builds the keyring from the managed keys followed by the keys
+// in the settings, the newest activated managed key signs the tokens
+func (m *Module[U]) reloadKeys(ctx context.Context) error {
+	var (
+		now  = time.Now()
+		ring = keyring{
+			byID:     make(map[string]token.Key),
+			loadedAt: now,
+		}
+	)
+
+	if m.keyStore != nil {
+		managed, err := m.keyStore.ListKeys(ctx)
+		if err != nil {
+			return err
+		}
+
+		for _, k := range managed {
+			// the keys of other algorithms can't be used by the encoding
+			if !k.CanVerify(now) || k.Algorithm != m.settings.TokenEncoding.keyAlgorithm() {
+				continue
+			}
+
+			key, err := k.toTokenKey()
+			if err != nil {
+				log.Error("failed to parse signing key", log.WithField("id", k.ID), log.WithError(err))
+				continue
+			}
+
+			if ring.signing == nil && k.CanSign(now) {
+				ring.signing = key
+			}
+
+			ring.add(key)
+		}
+	}
+
+	for _, key := range m.configKeys {
+		if ring.signing == nil && token.CanSign(key) {
+			ring.signing = key
+		}
+
+		ring.add(key)
+	}
+
+	m.keyring.Store(&ring)
+	return nil
+}
+
+// lookupKey returns the key of the ID, the keys are reloaded when the ID is
+// unknown since it may be created by another instance
+func (m *Module[U]) lookupKey(ctx context.Context, id string) (token.Key, bool) {
+	ring := m.keyring.Load()
+	if key, ok := ring.byID[id]; ok {
+		return key, true
+	}
+
+	if m.keyStore == nil || time.Since(ring.loadedAt) < minKeyReloadInterval {
+		return nil, false
+	}
+
+	if err := m.reloadKeys(ctx); err != nil {
+		log.Error("failed to reload keys", log.WithError(err))
+		return nil, false
+	}
+
+	key, ok := m.keyring.Load().byID[id]
+	return key, ok
+}
+
+// rotateKeys creates a new signing key and keeps the previous signing keys
+// verifying the outstanding tokens until retire after elapsed
+func (m *Module[U]) rotateKeys(ctx context.Context) (*SigningKey, error) {
+	if m.keyStore == nil {
+		return nil, errors.New("key rotation requires a key store")
+	}
+
+	now := time.Now()
+	newKey, err := NewSigningKey(m.settings.TokenEncoding.keyAlgorithm(), now)
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := m.keyStore.ListKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := m.keyStore.SaveKey(ctx, newKey); err != nil {
+		return nil, err
+	}
+
+	for _, key := range keys {
+		// the pending keys are kept to be activated as scheduled
+		if key.State != KeyStateActive || key.ActivatesAt.After(now) {
+			continue
+		}
+
+		key.State = KeyStateVerifyOnly
+		key.ExpiresAt = now.Add(m.settings.KeyManagement.RetireAfter)
+		if err := m.keyStore.SaveKey(ctx, key); err != nil {
+			return nil, err
+		}
+	}
+
+	return &newKey, nil
+}
+
+// retireKey stops the key from signing and verifying immediately, e.g. when
+// the key is compromised
+func (m *Module[U]) retireKey(ctx context.Context, id string) error {
+	if m.keyStore == nil {
+		return errors.New("key retirement requires a key store")
+	}
+
+	key, err := m.keyStore.GetKey(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	key.State = KeyStateRetired
+	return m.keyStore.SaveKey(ctx, *key)
+}
+
+// maintainKeys retires the expired keys, rotates the signing key when it is
+// older than the rotation interval and reloads the keyring
+func (m *Module[U]) maintainKeys(ctx context.Context) error {
+	keys, err := m.keyStore.ListKeys(ctx)
+	if err != nil {
+		return err
+	}
+
+	var (
+		now    = time.Now()
+		latest *SigningKey
+	)
+	for i, key := range keys {
+		if key.State == KeyStateVerifyOnly && !key.CanVerify(now) {
+			key.State = KeyStateRetired
+			if err := m.keyStore.SaveKey(ctx, key); err != nil {
+				return err
+			}
+		}
+
+		// the keys are ordered by the activation, newest first
+		if latest == nil && key.CanSign(now) {
+			latest = &keys[i]
+		}
+	}
+
+	interval := m.settings.KeyManagement.RotationInterval
+	if interval > 0 && (latest == nil || !latest.ActivatesAt.Add(interval).After(now)) {
+		rotated, err := m.rotateKeys(ctx)
+		if err != nil {
+			return err
+		}
+
+		log.Info("signing key rotated", log.WithField("id", rotated.ID))
+	}
+
+	return m.reloadKeys(ctx)
+}
+
+func (m *Module[U]) runKeyMaintenance(ctx context.Context) {
+	ticker := time.NewTicker(m.settings.KeyManagement.RefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := m.maintainKeys(ctx); err != nil {
+				log.Error("failed to maintain signing keys", log.WithError(err))
+			}
+		}
+	}
+}
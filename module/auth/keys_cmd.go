@@ -0,0 +1,184 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/euiko/webapp/db"
+	"github.com/spf13/cobra"
+)
+
+func (m *Module[U]) Command(cmd *cobra.Command) {
+	authCmd := cobra.Command{
+		Use:   "auth",
+		Short: "Authentication related commands",
+	}
+	authCmd.AddCommand(m.keysCmd())
+	authCmd.AddCommand(m.apiKeysCmd())
+	authCmd.AddCommand(m.oauthClientsCmd())
+	cmd.AddCommand(&authCmd)
+}
+
+func (m *Module[U]) keysCmd() *cobra.Command {
+	var dbOpened bool
+
+	cmd := &cobra.Command{
+		Use:   "keys",
+		Short: "Manage the token signing keys",
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			var err error
+			dbOpened, err = m.openCommandStore(m.settings.KeyManagement.Store)
+			if err != nil {
+				return err
+			}
+
+			m.keyStore, err = m.keyStoreFactory(&m.settings)
+			if err == nil && m.keyStore == nil {
+				err = errors.New("key management requires a key store, set the auth key_management.store")
+			}
+
+			return err
+		},
+		PersistentPostRunE: func(cmd *cobra.Command, args []string) error {
+			if dbOpened {
+				return db.Close()
+			}
+
+			return nil
+		},
+	}
+
+	cmd.AddCommand(m.generateKeyCmd())
+	cmd.AddCommand(m.listKeysCmd())
+	cmd.AddCommand(m.rotateKeysCmd())
+	cmd.AddCommand(m.retireKeyCmd())
+	return cmd
+}
+
+func (m *Module[U]) generateKeyCmd() *cobra.Command {
+	var (
+		algorithm  string
+		activateAt string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "generate",
+		Short: "Generate a new signing key",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			activatesAt := time.Now()
+			if activateAt != "" {
+				var err error
+				activatesAt, err = time.Parse(time.RFC3339, activateAt)
+				if err != nil {
+					return err
+				}
+			}
+
+			key, err := NewSigningKey(algorithm, activatesAt)
+			if err != nil {
+				return err
+			}
+
+			if err := m.keyStore.SaveKey(cmd.Context(), key); err != nil {
+				return err
+			}
+
+			fmt.Println("generated key", key.ID)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&algorithm, "algorithm", "a", m.settings.TokenEncoding.keyAlgorithm(), "Algorithm of the key")
+	cmd.Flags().StringVar(&activateAt, "activate-at", "", "Time to start signing in RFC3339 format, defaults to now")
+	return cmd
+}
+
+func (m *Module[U]) listKeysCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "list",
+		Short: "List the signing keys",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			keys, err := m.keyStore.ListKeys(cmd.Context())
+			if err != nil {
+				return err
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "ID\tALGORITHM\tSTATE\tACTIVATES AT\tEXPIRES AT\tCREATED AT")
+			for _, key := range keys {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
+					key.ID,
+					key.Algorithm,
+					key.State,
+					formatKeyTime(key.ActivatesAt),
+					formatKeyTime(key.ExpiresAt),
+					formatKeyTime(key.CreatedAt),
+				)
+			}
+
+			return w.Flush()
+		},
+	}
+}
+
+func (m *Module[U]) rotateKeysCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "rotate",
+		Short: "Replace the signing key, the previous keys only verify until retired",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			key, err := m.rotateKeys(cmd.Context())
+			if err != nil {
+				return err
+			}
+
+			fmt.Println("rotated to key", key.ID)
+			return nil
+		},
+	}
+}
+
+func (m *Module[U]) retireKeyCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "retire [ID]",
+		Short: "Stop the key from signing and verifying immediately",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := m.retireKey(cmd.Context(), args[0]); err != nil {
+				return err
+			}
+
+			fmt.Println("retired key", args[0])
+			return nil
+		},
+	}
+}
+
+// openCommandStore opens the database of the sql stores, the cache stores
+// are rejected since they only live within the server process
+func (m *Module[U]) openCommandStore(store string) (bool, error) {
+	switch store {
+	case "sql":
+		if err := db.Init(&m.app.Settings().DB); err != nil {
+			return false, err
+		}
+		return true, nil
+	case "cache":
+		return false, errors.New("the cache store only lives within the server process")
+	}
+
+	return false, nil
+}
+
+func formatKeyTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+
+	return t.Local().Format(time.RFC3339)
+}
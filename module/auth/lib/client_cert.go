@@ -0,0 +1,42 @@
+package lib
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+)
+
+type (
+	// ClientCertMapper maps the verified client certificate to its user,
+	// e.g. by the subject or the SAN of a service
+	ClientCertMapper[U User] interface {
+		UserFromCertificate(ctx context.Context, cert *x509.Certificate) (U, error)
+	}
+
+	ClientCertMapperFunc[U User] func(ctx context.Context, cert *x509.Certificate) (U, error)
+
+	clientCertContextKeyType struct{}
+)
+
+var (
+	// ErrCertificateNotMapped is returned by the mappers when the
+	// certificate doesn't belong to any user
+	ErrCertificateNotMapped = errors.New("certificate not mapped to a user")
+
+	clientCertContextKey = clientCertContextKeyType{}
+)
+
+func (f ClientCertMapperFunc[U]) UserFromCertificate(ctx context.Context, cert *x509.Certificate) (U, error) {
+	return f(ctx, cert)
+}
+
+// ClientCertificate returns the client certificate authenticating the
+// current user
+func ClientCertificate(ctx context.Context) (*x509.Certificate, bool) {
+	cert, ok := ctx.Value(clientCertContextKey).(*x509.Certificate)
+	return cert, ok
+}
+
+func WithClientCertificate(ctx context.Context, cert *x509.Certificate) context.Context {
+	return context.WithValue(ctx, clientCertContextKey, cert)
+}
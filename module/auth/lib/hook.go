@@ -1,12 +1,128 @@
 package lib
 
-import "context"
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+)
 
 type (
 	Hook[U User] interface {
 		BeforeLogin(ctx context.Context, loginId string, password string) error
+		// AfterLogin is called after the user is authenticated, the new hash
+		// of an outdated password verified by password.Verify is available
+		// from password.RehashFromContext to be stored
 		AfterLogin(ctx context.Context, user U, token *string) error
 		BeforeLogout(ctx context.Context) error
 		AfterLogout(ctx context.Context) error
 	}
+
+	// RefreshHook is an optional extension of Hook to observe the refresh
+	// token rotations
+	RefreshHook[U User] interface {
+		BeforeRefresh(ctx context.Context, subject string) error
+		AfterRefresh(ctx context.Context, user U, token *string) error
+		// RefreshTokenReused is called after the family of the reused
+		// refresh token is revoked, it may indicate a stolen token
+		RefreshTokenReused(ctx context.Context, subject string) error
+	}
+
+	// LockoutHook is an optional extension of Hook to observe the failed
+	// logins, e.g. to notify the users about their locked account
+	LockoutHook interface {
+		LoginFailed(ctx context.Context, loginId string, remoteAddr string, failures int) error
+		AccountLocked(ctx context.Context, loginId string, until time.Time) error
+		// AccountUnlocked is called when an admin unlocks the account
+		AccountUnlocked(ctx context.Context, loginId string) error
+	}
+
+	// ExternalIdentity is the user authenticated by an external identity
+	// provider, taken from the verified ID token
+	ExternalIdentity struct {
+		Provider      string
+		Subject       string
+		Email         string
+		EmailVerified bool
+		Name          string
+		// Claims are all of the ID token claims
+		Claims map[string]any
+	}
+
+	// IdentityHook is an optional extension of Hook to sign in the users of
+	// the external identity providers, it maps or provisions the identity
+	// to the local user before the token is issued
+	IdentityHook[U User] interface {
+		// MapIdentity returns false when the hook doesn't handle the
+		// identity, the sign in is denied when no hook handles it
+		MapIdentity(ctx context.Context, identity ExternalIdentity) (U, bool, error)
+	}
+
+	// AuthorizationRequest is the validated request of an oauth client to
+	// access the account of the user
+	AuthorizationRequest struct {
+		ClientID    string
+		ClientName  string
+		RedirectURI string
+		Scopes      []string
+	}
+
+	// ConsentHook is an optional extension of Hook to ask the user for
+	// granting the scopes to the oauth clients, without it only the trusted
+	// clients are authorized
+	ConsentHook[U User] interface {
+		// Consent returns the scopes granted by the user or ErrAccessDenied
+		// when the user refuses. It returns false after writing the
+		// response itself, e.g. rendering the consent screen which submits
+		// the decision back to the authorization endpoint
+		Consent(w http.ResponseWriter, r *http.Request, user U, request AuthorizationRequest) ([]string, bool, error)
+	}
+
+	// NopHook implements Hook, RefreshHook and LockoutHook doing nothing,
+	// embed it to only implement the needed callbacks
+	NopHook[U User] struct{}
+)
+
+var (
+	ErrAccessDenied = errors.New("access denied")
 )
+
+func (NopHook[U]) BeforeLogin(ctx context.Context, loginId string, password string) error {
+	return nil
+}
+
+func (NopHook[U]) AfterLogin(ctx context.Context, user U, token *string) error {
+	return nil
+}
+
+func (NopHook[U]) BeforeLogout(ctx context.Context) error {
+	return nil
+}
+
+func (NopHook[U]) AfterLogout(ctx context.Context) error {
+	return nil
+}
+
+func (NopHook[U]) BeforeRefresh(ctx context.Context, subject string) error {
+	return nil
+}
+
+func (NopHook[U]) AfterRefresh(ctx context.Context, user U, token *string) error {
+	return nil
+}
+
+func (NopHook[U]) RefreshTokenReused(ctx context.Context, subject string) error {
+	return nil
+}
+
+func (NopHook[U]) LoginFailed(ctx context.Context, loginId string, remoteAddr string, failures int) error {
+	return nil
+}
+
+func (NopHook[U]) AccountLocked(ctx context.Context, loginId string, until time.Time) error {
+	return nil
+}
+
+func (NopHook[U]) AccountUnlocked(ctx context.Context, loginId string) error {
+	return nil
+}
@@ -0,0 +1,40 @@
+package lib
+
+import (
+	"context"
+	"time"
+)
+
+type (
+	// Impersonation describes the actor acting as the current user, e.g. a
+	// support staff seeing what the user sees
+	Impersonation struct {
+		// ActorID is the login id of the impersonating user
+		ActorID   string
+		StartedAt time.Time
+		ExpiresAt time.Time
+	}
+
+	impersonationContextKeyType struct{}
+)
+
+var (
+	impersonationContextKey = impersonationContextKeyType{}
+)
+
+// CurrentImpersonation returns the impersonation when the current user is
+// impersonated by another user
+func CurrentImpersonation(ctx context.Context) (*Impersonation, bool) {
+	impersonation, ok := ctx.Value(impersonationContextKey).(*Impersonation)
+	return impersonation, ok
+}
+
+// IsImpersonated returns true when the current user is impersonated
+func IsImpersonated(ctx context.Context) bool {
+	_, ok := CurrentImpersonation(ctx)
+	return ok
+}
+
+func WithImpersonation(ctx context.Context, impersonation *Impersonation) context.Context {
+	return context.WithValue(ctx, impersonationContextKey, impersonation)
+}
@@ -1,9 +1,54 @@
 package lib
 
 import (
+	"errors"
+	"net/http"
+
 	"github.com/euiko/webapp/core"
+	"github.com/euiko/webapp/pkg/helper"
+)
+
+type (
+	// AuthMethod is the set of the credentials accepted by the auth
+	// middleware
+	AuthMethod int
+)
+
+const (
+	// AuthMethodToken accepts the bearer tokens and the api keys
+	AuthMethodToken AuthMethod = 1 << iota
+	// AuthMethodClientCert accepts the verified client certificates of the
+	// tls connection
+	AuthMethodClientCert
+
+	AuthMethodAll = AuthMethodToken | AuthMethodClientCert
 )
 
 func AuthRequiredMiddleware(app core.App) core.MiddlewareFunc {
 	return core.MustGetModule[Module](app).Middleware()
 }
+
+// AuthMethodsMiddleware only accepts the credentials of the methods, e.g.
+// to restrict a route group to the client certificates of the services
+func AuthMethodsMiddleware(app core.App, methods AuthMethod) core.MiddlewareFunc {
+	return core.MustGetModule[Module](app).MethodsMiddleware(methods)
+}
+
+// UnscopedMiddleware denies the requests of the scoped credentials, e.g. an
+// api key or an oauth token, and of the impersonations on the routes managing
+// the account of the user. It must be used after the auth middleware
+func UnscopedMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := ScopesFromContext(r.Context()); ok {
+			helper.WriteResponse(w, errors.New("scoped credentials can't manage the account"), helper.ResponseWithStatus(http.StatusForbidden))
+			return
+		}
+
+		if IsImpersonated(r.Context()) {
+			helper.WriteResponse(w, errors.New("impersonations can't manage the account"), helper.ResponseWithStatus(http.StatusForbidden))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -1,6 +1,9 @@
 package lib
 
 import (
+	"context"
+	"errors"
+
 	"github.com/euiko/webapp/core"
 	"github.com/euiko/webapp/pkg/token"
 )
@@ -11,5 +14,15 @@ type (
 		UserLoader() UserLoader[User]
 		TokenEncoding() token.Encoding
 		Middleware() core.MiddlewareFunc
+		MethodsMiddleware(methods AuthMethod) core.MiddlewareFunc
+		// RevokeUser revokes the refresh tokens and the sessions of the
+		// user, e.g. after its password is changed
+		RevokeUser(ctx context.Context, subject string) error
 	}
 )
+
+var (
+	// ErrRevocationUnavailable is returned when revoking outside the server
+	// while the tokens or the sessions are kept in its memory
+	ErrRevocationUnavailable = errors.New("the tokens and the sessions kept in the cache store can only be revoked by the server")
+)
@@ -0,0 +1,13 @@
+package lib
+
+import (
+	"github.com/euiko/webapp/module/rbac/lib/role"
+)
+
+var (
+	PermissionManageSessions     = role.Group("admin").NewPermission("manage-sessions", "Manage sessions")
+	PermissionManageAPIKeys      = role.Group("admin").NewPermission("manage-api-keys", "Manage API keys")
+	PermissionManageOAuthClients = role.Group("admin").NewPermission("manage-oauth-clients", "Manage OAuth clients")
+	PermissionManageLockouts     = role.Group("admin").NewPermission("manage-lockouts", "Manage login lockouts")
+	PermissionImpersonateUsers   = role.Group("admin").NewPermission("impersonate-users", "Impersonate users")
+)
@@ -0,0 +1,41 @@
+package lib
+
+import (
+	"context"
+
+	"github.com/euiko/webapp/module/rbac/lib/role"
+)
+
+type (
+	scopesContextKeyType struct{}
+)
+
+var (
+	scopesContextKey = scopesContextKeyType{}
+)
+
+// WithScopes restricts the request to the permissions matching the scopes,
+// e.g. when it is authenticated by an api key
+func WithScopes(ctx context.Context, scopes []string) context.Context {
+	return context.WithValue(ctx, scopesContextKey, scopes)
+}
+
+// ScopesFromContext returns the scopes of the request, it is false when the
+// request is not restricted
+func ScopesFromContext(ctx context.Context) ([]string, bool) {
+	scopes, ok := ctx.Value(scopesContextKey).([]string)
+	return scopes, ok
+}
+
+// ScopesAllow returns true when any of the scopes grants the permission, a
+// scope is either "*", "group:*" or "group:name"
+func ScopesAllow(scopes []string, p *role.Permission) bool {
+	for _, scope := range scopes {
+		switch scope {
+		case "*", p.Group + ":*", p.Group + ":" + p.Name:
+			return true
+		}
+	}
+
+	return false
+}
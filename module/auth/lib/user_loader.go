@@ -8,4 +8,16 @@ type (
 		UserById(ctx context.Context, loginId string) (U, error)
 		LoadUser(ctx context.Context, loginId string, password string) (U, error)
 	}
+
+	// ClaimsUserLoader is an optional extension of UserLoader to embed the
+	// user attributes into the access token, so the user can be rebuilt
+	// from the token without loading it
+	ClaimsUserLoader[U User] interface {
+		// UserClaims returns the private claims of the user token, e.g.
+		// tenant or role
+		UserClaims(ctx context.Context, user U) (map[string]any, error)
+		// UserFromClaims rebuilds the user from the verified token claims,
+		// returning false falls back to UserById
+		UserFromClaims(ctx context.Context, subject string, claims map[string]any) (U, bool, error)
+	}
 )
@@ -0,0 +1,309 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/euiko/webapp/core"
+	"github.com/euiko/webapp/module/auth/lib"
+	"github.com/euiko/webapp/module/rbac/lib/role"
+	"github.com/euiko/webapp/pkg/helper"
+	"github.com/euiko/webapp/pkg/log"
+	"github.com/go-chi/chi/v5"
+)
+
+type (
+	LockoutResponse struct {
+		// Type is either login_id or ip
+		Type          string    `json:"type"`
+		Value         string    `json:"value"`
+		LastFailureAt time.Time `json:"last_failure_at"`
+		LockedUntil   time.Time `json:"locked_until"`
+	}
+
+	// lockoutError refuses the login attempt until the retry after elapsed
+	lockoutError struct {
+		err        error
+		status     int
+		retryAfter time.Duration
+	}
+)
+
+const (
+	lockoutTypeLoginID = "login_id"
+	lockoutTypeIP      = "ip"
+)
+
+var (
+	ErrAccountLocked   = errors.New("account is temporarily locked")
+	ErrTooManyAttempts = errors.New("too many login attempts")
+)
+
+func (e *lockoutError) Error() string {
+	return e.err.Error()
+}
+
+func (e *lockoutError) Unwrap() error {
+	return e.err
+}
+
+// lockoutRoute registers the endpoints to unlock the accounts and the ips
+// before their lockout expires
+func (m *Module[U]) lockoutRoute(r core.Router) {
+	if !m.settings.Lockout.Enabled {
+		return
+	}
+
+	r.Group(func(r core.Router) {
+		r.Use(m.Middleware())
+		r.Method("GET", "/auth/lockouts", role.Handler(lib.PermissionManageLockouts, http.HandlerFunc(m.listLockoutsHandler)))
+		r.Method("DELETE", "/auth/lockouts/ip/{ip}", role.Handler(lib.PermissionManageLockouts, http.HandlerFunc(m.unlockIPHandler)))
+		r.Method("DELETE", "/auth/users/{id}/lockout", role.Handler(lib.PermissionManageLockouts, http.HandlerFunc(m.unlockUserHandler)))
+	})
+}
+
+// checkLockout returns a lockoutError when the login id or the ip is locked,
+// or has to wait for the progressive delay of its previous failures
+func (m *Module[U]) checkLockout(ctx context.Context, loginId string, ip string) error {
+	if !m.settings.Lockout.Enabled {
+		return nil
+	}
+
+	now := time.Now()
+	for _, key := range m.lockoutKeys(loginId, ip) {
+		attempts, err := m.loginAttemptStore.GetAttempts(ctx, key)
+		if errors.Is(err, ErrLoginAttemptsNotFound) {
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		if attempts.IsLocked(now) {
+			lockErr := &lockoutError{err: ErrAccountLocked, status: http.StatusLocked, retryAfter: attempts.LockedUntil.Sub(now)}
+			if strings.HasPrefix(key, lockoutTypeIP+":") {
+				lockErr.err = ErrTooManyAttempts
+				lockErr.status = http.StatusTooManyRequests
+			}
+
+			return lockErr
+		}
+
+		// the ips are shared, e.g. behind a NAT, so they are only locked
+		if strings.HasPrefix(key, lockoutTypeIP+":") || attempts.LastFailureAt.Before(now.Add(-m.settings.Lockout.Window)) {
+			continue
+		}
+
+		if next := attempts.LastFailureAt.Add(m.lockoutDelay(attempts.Failures)); now.Before(next) {
+			return &lockoutError{err: ErrTooManyAttempts, status: http.StatusTooManyRequests, retryAfter: next.Sub(now)}
+		}
+	}
+
+	return nil
+}
+
+// recordLoginFailure counts the failure of the login id and the ip, and
+// locks them when reaching the thresholds
+func (m *Module[U]) recordLoginFailure(ctx context.Context, loginId string, ip string) {
+	if !m.settings.Lockout.Enabled {
+		return
+	}
+
+	now := time.Now()
+	settings := m.settings.Lockout
+	for _, key := range m.lockoutKeys(loginId, ip) {
+		attempts, err := m.loginAttemptStore.RecordFailure(ctx, key, now, now.Add(-settings.Window))
+		if err != nil {
+			log.Error("failed to record the login failure", log.WithField("key", key), log.WithError(err))
+			continue
+		}
+
+		isIP := strings.HasPrefix(key, lockoutTypeIP+":")
+		threshold := settings.MaxFailures
+		if isIP {
+			threshold = settings.MaxIPFailures
+		}
+
+		if !isIP {
+			for _, hook := range m.lockoutHooks() {
+				if err := hook.LoginFailed(ctx, loginId, ip, attempts.Failures); err != nil {
+					log.Error("login failed hook failed", log.WithError(err))
+				}
+			}
+		}
+
+		if threshold <= 0 || attempts.Failures < threshold {
+			continue
+		}
+
+		until := now.Add(settings.LockoutDuration)
+		if err := m.loginAttemptStore.LockAttempts(ctx, key, until); err != nil {
+			log.Error("failed to lock the login attempts", log.WithField("key", key), log.WithError(err))
+			continue
+		}
+
+		if isIP {
+			log.Info("locked the login attempts of the ip", log.WithField("ip", ip), log.WithField("until", until))
+			continue
+		}
+
+		for _, hook := range m.lockoutHooks() {
+			if err := hook.AccountLocked(ctx, loginId, until); err != nil {
+				log.Error("account locked hook failed", log.WithError(err))
+			}
+		}
+	}
+}
+
+// resetLoginFailures forgets the failures of the login id after a
+// successful login, the failures of the ip are kept since an attacker may
+// own a valid account
+func (m *Module[U]) resetLoginFailures(ctx context.Context, loginId string) {
+	if !m.settings.Lockout.Enabled {
+		return
+	}
+
+	err := m.loginAttemptStore.ResetAttempts(ctx, lockoutKey(lockoutTypeLoginID, normalizeLoginID(loginId)))
+	if err != nil && !errors.Is(err, ErrLoginAttemptsNotFound) {
+		log.Error("failed to reset the login failures", log.WithError(err))
+	}
+}
+
+// lockoutDelay returns the progressive delay after the failures, it is
+// doubled on every failure after the first delayed one
+func (m *Module[U]) lockoutDelay(failures int) time.Duration {
+	settings := m.settings.Lockout
+	if failures < settings.DelayAfter || settings.BaseDelay <= 0 {
+		return 0
+	}
+
+	maxDelay := settings.MaxDelay
+	if maxDelay <= 0 {
+		maxDelay = settings.Window
+	}
+
+	delay := float64(settings.BaseDelay) * math.Pow(2, float64(failures-settings.DelayAfter))
+	if delay > float64(maxDelay) {
+		return maxDelay
+	}
+
+	return time.Duration(delay)
+}
+
+func (m *Module[U]) lockoutKeys(loginId string, ip string) []string {
+	keys := []string{lockoutKey(lockoutTypeLoginID, normalizeLoginID(loginId))}
+	if ip != "" {
+		keys = append(keys, lockoutKey(lockoutTypeIP, ip))
+	}
+
+	return keys
+}
+
+func (m *Module[U]) lockoutHooks() []lib.LockoutHook {
+	hooks := make([]lib.LockoutHook, 0, len(m.hooks))
+	for _, hook := range m.hooks {
+		if lockoutHook, ok := hook.(lib.LockoutHook); ok {
+			hooks = append(hooks, lockoutHook)
+		}
+	}
+
+	return hooks
+}
+
+// writeLockoutError writes the error with the retry after header, it
+// returns false when the error is not a lockout
+func writeLockoutError(w http.ResponseWriter, err error) bool {
+	var lockErr *lockoutError
+	if !errors.As(err, &lockErr) {
+		return false
+	}
+
+	w.Header().Set("Retry-After", fmt.Sprint(int64(math.Ceil(lockErr.retryAfter.Seconds()))))
+	helper.WriteResponse(w, lockErr.err, helper.ResponseWithStatus(lockErr.status))
+	return true
+}
+
+// clientIP returns the ip of the remote address, use a real ip middleware
+// to take it from the forwarded headers behind a trusted proxy
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+func normalizeLoginID(loginId string) string {
+	return strings.ToLower(strings.TrimSpace(loginId))
+}
+
+func lockoutKey(keyType string, value string) string {
+	return keyType + ":" + value
+}
+
+func (m *Module[U]) listLockoutsHandler(w http.ResponseWriter, r *http.Request) {
+	locked, err := m.loginAttemptStore.ListLocked(r.Context(), time.Now())
+	if err != nil {
+		helper.WriteResponse(w, err)
+		return
+	}
+
+	response := make([]LockoutResponse, len(locked))
+	for i, attempts := range locked {
+		keyType, value, _ := strings.Cut(attempts.Key, ":")
+		response[i] = LockoutResponse{
+			Type:          keyType,
+			Value:         value,
+			LastFailureAt: attempts.LastFailureAt,
+			LockedUntil:   attempts.LockedUntil,
+		}
+	}
+
+	helper.WriteResponse(w, response)
+}
+
+func (m *Module[U]) unlockUserHandler(w http.ResponseWriter, r *http.Request) {
+	loginId := chi.URLParam(r, "id")
+	if !m.unlock(w, r, lockoutKey(lockoutTypeLoginID, normalizeLoginID(loginId))) {
+		return
+	}
+
+	for _, hook := range m.lockoutHooks() {
+		if err := hook.AccountUnlocked(r.Context(), loginId); err != nil {
+			log.Error("account unlocked hook failed", log.WithError(err))
+		}
+	}
+
+	helper.WriteResponse(w, map[string]interface{}{
+		"message": "account unlocked",
+	})
+}
+
+func (m *Module[U]) unlockIPHandler(w http.ResponseWriter, r *http.Request) {
+	if !m.unlock(w, r, lockoutKey(lockoutTypeIP, chi.URLParam(r, "ip"))) {
+		return
+	}
+
+	helper.WriteResponse(w, map[string]interface{}{
+		"message": "ip unlocked",
+	})
+}
+
+func (m *Module[U]) unlock(w http.ResponseWriter, r *http.Request, key string) bool {
+	err := m.loginAttemptStore.ResetAttempts(r.Context(), key)
+	if errors.Is(err, ErrLoginAttemptsNotFound) {
+		helper.WriteResponse(w, err, helper.ResponseWithStatus(http.StatusNotFound))
+		return false
+	} else if err != nil {
+		helper.WriteResponse(w, err)
+		return false
+	}
+
+	return true
+}
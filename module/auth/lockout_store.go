@@ -0,0 +1,333 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/euiko/webapp/db/cache"
+	"github.com/euiko/webapp/db/sqldb"
+	"github.com/euiko/webapp/module/auth/internal/schema"
+)
+
+type (
+	// LoginAttempts tracks the failed logins of a key, e.g. a login id or a
+	// client ip
+	LoginAttempts struct {
+		Key           string
+		Failures      int
+		LastFailureAt time.Time
+		LockedUntil   time.Time
+	}
+
+	LoginAttemptStore interface {
+		GetAttempts(ctx context.Context, key string) (*LoginAttempts, error)
+		// RecordFailure counts a failure of the key, the count restarts when
+		// the last failure is before the window start. The attempts without
+		// a failure since the window start are pruned.
+		RecordFailure(ctx context.Context, key string, at time.Time, windowStart time.Time) (*LoginAttempts, error)
+		// LockAttempts locks the key until the time and restarts its count
+		LockAttempts(ctx context.Context, key string, until time.Time) error
+		ResetAttempts(ctx context.Context, key string) error
+		// ListLocked returns the keys locked at the time, the latest locked
+		// until first
+		ListLocked(ctx context.Context, at time.Time) ([]LoginAttempts, error)
+	}
+
+	LoginAttemptStoreFactory func(s *Settings) (LoginAttemptStore, error)
+
+	ormLoginAttemptStore struct {
+		db sqldb.OrmDB
+	}
+
+	cacheLoginAttemptStore struct {
+		mutex sync.Mutex
+		cache cache.Cache
+	}
+)
+
+const (
+	cacheKeyLoginAttempts = "auth:login-attempts"
+)
+
+var (
+	ErrLoginAttemptsNotFound = errors.New("login attempts not found")
+)
+
+func NewOrmLoginAttemptStore(db sqldb.OrmDB) LoginAttemptStore {
+	return &ormLoginAttemptStore{
+		db: db,
+	}
+}
+
+// NewCacheLoginAttemptStore creates a login attempt store backed by the
+// cache, the attempts are only tracked per instance
+func NewCacheLoginAttemptStore(c cache.Cache) LoginAttemptStore {
+	return &cacheLoginAttemptStore{
+		cache: c,
+	}
+}
+
+func defaultLoginAttemptStoreFactory(s *Settings) (LoginAttemptStore, error) {
+	switch s.Lockout.Store {
+	case "cache":
+		return NewCacheLoginAttemptStore(cache.InMemory()), nil
+	case "sql":
+		return NewOrmLoginAttemptStore(sqldb.ORM()), nil
+	}
+
+	return nil, errors.New("invalid login attempt store (valid stores: cache, sql)")
+}
+
+// IsLocked returns true when the key is locked at the time
+func (a LoginAttempts) IsLocked(at time.Time) bool {
+	return at.Before(a.LockedUntil)
+}
+
+// GetAttempts implements LoginAttemptStore.
+func (s *ormLoginAttemptStore) GetAttempts(ctx context.Context, key string) (*LoginAttempts, error) {
+	var model schema.LoginAttempt
+	err := s.db.NewSelect().
+		Model(&model).
+		Where("key = ?", key).
+		Limit(1).
+		Scan(ctx)
+	if sqldb.IsNoRows(err) {
+		return nil, ErrLoginAttemptsNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	attempts := toLoginAttempts(model)
+	return &attempts, nil
+}
+
+// RecordFailure implements LoginAttemptStore.
+func (s *ormLoginAttemptStore) RecordFailure(ctx context.Context, key string, at time.Time, windowStart time.Time) (*LoginAttempts, error) {
+	_, err := s.db.NewDelete().
+		Model((*schema.LoginAttempt)(nil)).
+		Where("last_failure_at < ?", windowStart).
+		Where("locked_until IS NULL OR locked_until < ?", at).
+		Where("key != ?", key).
+		Exec(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// increment in a single statement so the concurrent failures are all
+	// counted
+	model := schema.LoginAttempt{
+		Key:           key,
+		Failures:      1,
+		LastFailureAt: at,
+	}
+	err = s.db.NewInsert().
+		Model(&model).
+		On("CONFLICT (key) DO UPDATE").
+		Set("failures = CASE WHEN login_attempts.last_failure_at < ? THEN 1 ELSE login_attempts.failures + 1 END", windowStart).
+		Set("last_failure_at = EXCLUDED.last_failure_at").
+		Returning("*").
+		Scan(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	attempts := toLoginAttempts(model)
+	return &attempts, nil
+}
+
+// LockAttempts implements LoginAttemptStore.
+func (s *ormLoginAttemptStore) LockAttempts(ctx context.Context, key string, until time.Time) error {
+	_, err := s.db.NewUpdate().
+		Model((*schema.LoginAttempt)(nil)).
+		Set("failures = 0").
+		Set("locked_until = ?", until).
+		Where("key = ?", key).
+		Exec(ctx)
+	return err
+}
+
+// ResetAttempts implements LoginAttemptStore.
+func (s *ormLoginAttemptStore) ResetAttempts(ctx context.Context, key string) error {
+	result, err := s.db.NewDelete().
+		Model((*schema.LoginAttempt)(nil)).
+		Where("key = ?", key).
+		Exec(ctx)
+	if err != nil {
+		return err
+	}
+
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if deleted == 0 {
+		return ErrLoginAttemptsNotFound
+	}
+
+	return nil
+}
+
+// ListLocked implements LoginAttemptStore.
+func (s *ormLoginAttemptStore) ListLocked(ctx context.Context, at time.Time) ([]LoginAttempts, error) {
+	var models []schema.LoginAttempt
+	err := s.db.NewSelect().
+		Model(&models).
+		Where("locked_until > ?", at).
+		Order("locked_until DESC").
+		Scan(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]LoginAttempts, len(models))
+	for i, model := range models {
+		list[i] = toLoginAttempts(model)
+	}
+
+	return list, nil
+}
+
+// GetAttempts implements LoginAttemptStore.
+func (s *cacheLoginAttemptStore) GetAttempts(ctx context.Context, key string) (*LoginAttempts, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	entries, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+
+	attempts, ok := entries[key]
+	if !ok {
+		return nil, ErrLoginAttemptsNotFound
+	}
+
+	return &attempts, nil
+}
+
+// RecordFailure implements LoginAttemptStore.
+func (s *cacheLoginAttemptStore) RecordFailure(ctx context.Context, key string, at time.Time, windowStart time.Time) (*LoginAttempts, error) {
+	var recorded LoginAttempts
+	err := s.update(func(entries map[string]LoginAttempts) error {
+		for other, attempts := range entries {
+			if other != key && attempts.LastFailureAt.Before(windowStart) && !attempts.IsLocked(at) {
+				delete(entries, other)
+			}
+		}
+
+		recorded = entries[key]
+		if recorded.LastFailureAt.Before(windowStart) {
+			recorded.Failures = 0
+		}
+
+		recorded.Key = key
+		recorded.Failures++
+		recorded.LastFailureAt = at
+		entries[key] = recorded
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &recorded, nil
+}
+
+// LockAttempts implements LoginAttemptStore.
+func (s *cacheLoginAttemptStore) LockAttempts(ctx context.Context, key string, until time.Time) error {
+	return s.update(func(entries map[string]LoginAttempts) error {
+		attempts, ok := entries[key]
+		if !ok {
+			return nil
+		}
+
+		attempts.Failures = 0
+		attempts.LockedUntil = until
+		entries[key] = attempts
+		return nil
+	})
+}
+
+// ResetAttempts implements LoginAttemptStore.
+func (s *cacheLoginAttemptStore) ResetAttempts(ctx context.Context, key string) error {
+	return s.update(func(entries map[string]LoginAttempts) error {
+		if _, ok := entries[key]; !ok {
+			return ErrLoginAttemptsNotFound
+		}
+
+		delete(entries, key)
+		return nil
+	})
+}
+
+// ListLocked implements LoginAttemptStore.
+func (s *cacheLoginAttemptStore) ListLocked(ctx context.Context, at time.Time) ([]LoginAttempts, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	entries, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]LoginAttempts, 0)
+	for _, attempts := range entries {
+		if attempts.IsLocked(at) {
+			list = append(list, attempts)
+		}
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].LockedUntil.After(list[j].LockedUntil)
+	})
+
+	return list, nil
+}
+
+func (s *cacheLoginAttemptStore) update(fn func(map[string]LoginAttempts) error) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	entries, err := s.load()
+	if err != nil {
+		return err
+	}
+
+	if err := fn(entries); err != nil {
+		return err
+	}
+
+	return s.cache.Set(cacheKeyLoginAttempts, entries)
+}
+
+// load returns a copy of the stored attempts so it can be modified freely
+func (s *cacheLoginAttemptStore) load() (map[string]LoginAttempts, error) {
+	entries := make(map[string]LoginAttempts)
+
+	cached, err := s.cache.Get(cacheKeyLoginAttempts)
+	if errors.Is(err, cache.ErrKeyNotFound) {
+		return entries, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	stored, _ := cached.(map[string]LoginAttempts)
+	for key, attempts := range stored {
+		entries[key] = attempts
+	}
+
+	return entries, nil
+}
+
+func toLoginAttempts(model schema.LoginAttempt) LoginAttempts {
+	return LoginAttempts{
+		Key:           model.Key,
+		Failures:      model.Failures,
+		LastFailureAt: model.LastFailureAt,
+		LockedUntil:   model.LockedUntil,
+	}
+}
@@ -0,0 +1,148 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/euiko/webapp/db/cache"
+)
+
+// newLockoutTestServer serves the login and the unlock endpoints with the
+// failed logins tracked in a fresh cache store
+func newLockoutTestServer(t *testing.T, configure func(*LockoutSettings)) http.Handler {
+	t.Helper()
+
+	store := NewCacheLoginAttemptStore(cache.NewInMemory())
+	m, r := newLoginTestServer(t, func(s *Settings) {
+		s.Lockout.Enabled = true
+		configure(&s.Lockout)
+	}, WithLoginAttemptStoreFactory[*testUser](func(s *Settings) (LoginAttemptStore, error) {
+		return store, nil
+	}))
+
+	r.Get("/auth/lockouts", m.listLockoutsHandler)
+	r.Delete("/auth/lockouts/ip/{ip}", m.unlockIPHandler)
+	r.Delete("/auth/users/{id}/lockout", m.unlockUserHandler)
+	return r
+}
+
+func login(handler http.Handler, loginId string, password string) *httptest.ResponseRecorder {
+	return postJSON(handler, "/auth/login", LoginPayload{LoginId: loginId, Password: password})
+}
+
+func request(handler http.Handler, method string, target string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(method, target, nil))
+	return rec
+}
+
+func TestLockoutDelay(t *testing.T) {
+	const baseDelay = 100 * time.Millisecond
+	handler := newLockoutTestServer(t, func(s *LockoutSettings) {
+		s.MaxFailures = 0
+		s.DelayAfter = 2
+		s.BaseDelay = baseDelay
+	})
+
+	for range 2 {
+		if rec := login(handler, "dave", "wrong"); rec.Code == http.StatusTooManyRequests {
+			t.Fatal("expected the first failures not to be delayed")
+		}
+	}
+
+	rec := login(handler, "dave", "secret")
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "1" {
+		t.Fatalf("expected the delayed attempt, got %d", rec.Code)
+	}
+
+	// the login ids are delayed independently
+	if rec := login(handler, "erin", "secret"); rec.Code != http.StatusOK {
+		t.Fatalf("expected another login id to sign in, got %d", rec.Code)
+	}
+
+	time.Sleep(baseDelay)
+	login(handler, "dave", "wrong")
+
+	// the delay is doubled on the next failure
+	time.Sleep(baseDelay)
+	if rec := login(handler, "dave", "secret"); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected the doubled delay, got %d", rec.Code)
+	}
+
+	time.Sleep(baseDelay)
+	if rec := login(handler, "dave", "secret"); rec.Code != http.StatusOK {
+		t.Fatalf("expected the login after the delay, got %d", rec.Code)
+	}
+}
+
+func TestLockoutThreshold(t *testing.T) {
+	handler := newLockoutTestServer(t, func(s *LockoutSettings) {
+		s.MaxFailures = 3
+		s.BaseDelay = 0
+	})
+
+	for range 3 {
+		login(handler, "Dave", "wrong")
+	}
+
+	// the normalized login id is locked even with the right password
+	rec := login(handler, " dave", "secret")
+	if rec.Code != http.StatusLocked || rec.Header().Get("Retry-After") == "" {
+		t.Fatalf("expected the locked account, got %d", rec.Code)
+	}
+
+	rec = request(handler, http.MethodGet, "/auth/lockouts")
+	var lockouts []LockoutResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &lockouts); err != nil {
+		t.Fatal(err)
+	}
+	if len(lockouts) != 1 || lockouts[0].Type != lockoutTypeLoginID || lockouts[0].Value != "dave" {
+		t.Fatalf("expected the locked login id, got %+v", lockouts)
+	}
+
+	if rec := request(handler, http.MethodDelete, "/auth/users/dave/lockout"); rec.Code != http.StatusOK {
+		t.Fatalf("expected the account to be unlocked, got %d", rec.Code)
+	}
+	if rec := login(handler, "dave", "secret"); rec.Code != http.StatusOK {
+		t.Fatalf("expected the unlocked account to sign in, got %d", rec.Code)
+	}
+	if rec := request(handler, http.MethodDelete, "/auth/users/unknown/lockout"); rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404, got %d", rec.Code)
+	}
+}
+
+func TestLockoutIP(t *testing.T) {
+	handler := newLockoutTestServer(t, func(s *LockoutSettings) {
+		s.MaxFailures = 0
+		s.MaxIPFailures = 3
+		s.DelayAfter = 1
+	})
+
+	// the failures of the different login ids add up on the shared ip but
+	// the ip is never delayed
+	for _, loginId := range []string{"dave", "erin"} {
+		if rec := login(handler, loginId, "wrong"); rec.Code == http.StatusTooManyRequests {
+			t.Fatal("expected the ip not to be delayed")
+		}
+	}
+	if rec := login(handler, "frank", "secret"); rec.Code != http.StatusOK {
+		t.Fatalf("expected the ip not to be delayed, got %d", rec.Code)
+	}
+
+	login(handler, "frank", "wrong")
+	rec := login(handler, "grace", "secret")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected the locked ip, got %d", rec.Code)
+	}
+
+	// httptest requests come from the documentation address
+	if rec := request(handler, http.MethodDelete, "/auth/lockouts/ip/192.0.2.1"); rec.Code != http.StatusOK {
+		t.Fatalf("expected the ip to be unlocked, got %d", rec.Code)
+	}
+	if rec := login(handler, "grace", "secret"); rec.Code != http.StatusOK {
+		t.Fatalf("expected the unlocked ip to sign in, got %d", rec.Code)
+	}
+}
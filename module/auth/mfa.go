@@ -0,0 +1,592 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/euiko/webapp/core"
+	"github.com/euiko/webapp/db/cache"
+	"github.com/euiko/webapp/module/auth/lib"
+	rbaclib "github.com/euiko/webapp/module/rbac/lib"
+	"github.com/euiko/webapp/pkg/helper"
+	"github.com/euiko/webapp/pkg/password"
+	"github.com/euiko/webapp/pkg/totp"
+)
+
+type (
+	// MFAChallengeResponse replaces the login response of the users with
+	// mfa, the challenge token is exchanged for the tokens along with a code
+	MFAChallengeResponse struct {
+		MFARequired bool `json:"mfa_required"`
+		// EnrollmentRequired is set when the role of the user enforces mfa
+		// but the user is not enrolled yet
+		EnrollmentRequired bool   `json:"enrollment_required"`
+		ChallengeToken     string `json:"challenge_token"`
+		ExpiresIn          int64  `json:"expires_in"`
+	}
+
+	MFALoginPayload struct {
+		ChallengeToken string `json:"challenge_token" validate:"required"`
+		Code           string `json:"code" validate:"required_without=RecoveryCode"`
+		RecoveryCode   string `json:"recovery_code"`
+	}
+
+	MFAChallengeEnrollPayload struct {
+		ChallengeToken string `json:"challenge_token" validate:"required"`
+	}
+
+	MFACodePayload struct {
+		Code         string `json:"code" validate:"required_without=RecoveryCode"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+
+	// MFAEnrollResponse contains the secret and the plain recovery codes,
+	// they are only shown once
+	MFAEnrollResponse struct {
+		Secret string `json:"secret"`
+		// URI is the otpauth URI to be rendered as the QR code
+		URI           string   `json:"uri"`
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+
+	MFAStatusResponse struct {
+		Enabled                bool       `json:"enabled"`
+		Enforced               bool       `json:"enforced"`
+		RecoveryCodesRemaining int        `json:"recovery_codes_remaining"`
+		EnabledAt              *time.Time `json:"enabled_at,omitempty"`
+	}
+
+	mfaChallenge struct {
+		Subject string
+		// LoginID is the login id of the first step, the wrong codes are
+		// counted as its login failures
+		LoginID string
+		Enroll  bool
+		// Rehash is the new password hash collected on the first step
+		Rehash    string
+		Attempts  int
+		ExpiresAt time.Time
+	}
+)
+
+const (
+	cacheKeyMFAChallenge = "auth:mfa-challenge:"
+)
+
+var (
+	ErrInvalidMFACode       = errors.New("invalid mfa code")
+	ErrInvalidMFAChallenge  = errors.New("invalid or expired mfa challenge")
+	ErrMFAAlreadyEnabled    = errors.New("mfa is already enabled")
+	ErrMFAEnrollmentPending = errors.New("mfa enrollment required")
+)
+
+// mfaRoute registers the endpoints of the second login step and the
+// enrollment of the current user
+func (m *Module[U]) mfaRoute(r core.Router) {
+	if !m.settings.MFA.Enabled {
+		return
+	}
+
+	r.Post("/auth/login/mfa", m.mfaLoginHandler)
+	r.Post("/auth/login/mfa/enroll", m.mfaChallengeEnrollHandler)
+
+	r.Group(func(r core.Router) {
+		r.Use(m.Middleware())
+		r.Get("/auth/mfa", m.mfaStatusHandler)
+		r.Delete("/auth/mfa", m.disableMFAHandler)
+		r.Post("/auth/mfa/enroll", m.enrollMFAHandler)
+		r.Post("/auth/mfa/confirm", m.confirmMFAHandler)
+		r.Post("/auth/mfa/recovery-codes", m.regenerateRecoveryCodesHandler)
+	})
+}
+
+// mfaRequired returns whether the login of the user needs the second step,
+// and whether the user must enroll first since its role enforces mfa
+func (m *Module[U]) mfaRequired(ctx context.Context, user U) (bool, bool, error) {
+	if !m.settings.MFA.Enabled {
+		return false, false, nil
+	}
+
+	mfa, err := m.mfaStore.GetMFA(ctx, user.LoginID())
+	if err != nil && !errors.Is(err, ErrMFANotFound) {
+		return false, false, err
+	}
+
+	if mfa != nil && mfa.Enabled {
+		return true, false, nil
+	}
+
+	enforced := m.mfaEnforced(user)
+	return enforced, enforced, nil
+}
+
+// mfaEnforced returns true when the rbac role of the user is configured to
+// require mfa
+func (m *Module[U]) mfaEnforced(user lib.User) bool {
+	roleUser, ok := user.(rbaclib.User)
+	return ok && slices.Contains(m.settings.MFA.EnforcedRoles, roleUser.RoleName())
+}
+
+// writeMFAChallenge responds the challenge of the second login step instead
+// of the tokens
+func (m *Module[U]) writeMFAChallenge(w http.ResponseWriter, r *http.Request, user U, loginId string, enroll bool) {
+	rehash, _ := password.RehashFromContext(r.Context())
+	challengeToken := newRandomToken()
+	timeout := m.settings.MFA.ChallengeTimeout
+	err := m.mfaChallenges.Set(cacheKeyMFAChallenge+hashToken(challengeToken), mfaChallenge{
+		Subject:   user.LoginID(),
+		LoginID:   loginId,
+		Enroll:    enroll,
+		Rehash:    rehash,
+		ExpiresAt: time.Now().Add(timeout),
+	}, cache.SetWithTimeout(timeout))
+	if err != nil {
+		helper.WriteResponse(w, err)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	helper.WriteResponse(w, MFAChallengeResponse{
+		MFARequired:        true,
+		EnrollmentRequired: enroll,
+		ChallengeToken:     challengeToken,
+		ExpiresIn:          int64(timeout.Seconds()),
+	})
+}
+
+// takeMFAChallenge removes the challenge so the concurrent attempts can't
+// verify the same challenge, it is put back on a wrong code
+func (m *Module[U]) takeMFAChallenge(challengeToken string) (*mfaChallenge, error) {
+	key := cacheKeyMFAChallenge + hashToken(challengeToken)
+
+	m.mfaChallengesMutex.Lock()
+	defer m.mfaChallengesMutex.Unlock()
+
+	cached, err := m.mfaChallenges.Get(key)
+	if errors.Is(err, cache.ErrKeyNotFound) {
+		return nil, ErrInvalidMFAChallenge
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := m.mfaChallenges.Delete(key); err != nil {
+		return nil, err
+	}
+
+	challenge, ok := cached.(mfaChallenge)
+	if !ok || time.Now().After(challenge.ExpiresAt) {
+		return nil, ErrInvalidMFAChallenge
+	}
+
+	return &challenge, nil
+}
+
+// putMFAChallenge stores the challenge back until it expires or runs out of
+// the attempts
+func (m *Module[U]) putMFAChallenge(challengeToken string, challenge mfaChallenge) error {
+	timeout := time.Until(challenge.ExpiresAt)
+	if challenge.Attempts >= m.settings.MFA.MaxAttempts || timeout <= 0 {
+		return nil
+	}
+
+	return m.mfaChallenges.Set(cacheKeyMFAChallenge+hashToken(challengeToken), challenge, cache.SetWithTimeout(timeout))
+}
+
+// verifyMFA checks the totp code, or the recovery code of the enabled mfa,
+// each of them is only accepted once
+func (m *Module[U]) verifyMFA(ctx context.Context, mfa *MFA, code string, recoveryCode string) error {
+	if recoveryCode != "" {
+		if !mfa.Enabled {
+			return ErrInvalidMFACode
+		}
+
+		err := m.mfaStore.UseRecoveryCode(ctx, mfa.Subject, hashRecoveryCode(recoveryCode))
+		if errors.Is(err, ErrInvalidRecoveryCode) {
+			return ErrInvalidMFACode
+		}
+
+		return err
+	}
+
+	counter, ok, err := totp.Validate(mfa.Secret, strings.TrimSpace(code), time.Now(), m.settings.MFA.Skew)
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return ErrInvalidMFACode
+	}
+
+	err = m.mfaStore.UseCounter(ctx, mfa.Subject, counter)
+	if errors.Is(err, ErrTOTPReplayed) {
+		return ErrInvalidMFACode
+	}
+
+	return err
+}
+
+// enrollMFA starts a pending enrollment with a new secret and recovery
+// codes, it replaces the previous pending one
+func (m *Module[U]) enrollMFA(ctx context.Context, subject string) (*MFAEnrollResponse, error) {
+	current, err := m.mfaStore.GetMFA(ctx, subject)
+	if err != nil && !errors.Is(err, ErrMFANotFound) {
+		return nil, err
+	}
+
+	if current != nil && current.Enabled {
+		return nil, ErrMFAAlreadyEnabled
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	recoveryCodes, hashes := m.newRecoveryCodes()
+	err = m.mfaStore.SaveMFA(ctx, MFA{
+		Subject:       subject,
+		Secret:        secret,
+		RecoveryCodes: hashes,
+		CreatedAt:     time.Now(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &MFAEnrollResponse{
+		Secret:        secret,
+		URI:           totp.URI(m.settings.MFA.Issuer, subject, secret),
+		RecoveryCodes: recoveryCodes,
+	}, nil
+}
+
+// confirmMFA enables the pending enrollment verified by a totp code
+func (m *Module[U]) confirmMFA(ctx context.Context, subject string, code string) error {
+	mfa, err := m.mfaStore.GetMFA(ctx, subject)
+	if errors.Is(err, ErrMFANotFound) {
+		return ErrMFAEnrollmentPending
+	} else if err != nil {
+		return err
+	}
+
+	if mfa.Enabled {
+		return ErrMFAAlreadyEnabled
+	}
+
+	if err := m.verifyMFA(ctx, mfa, code, ""); err != nil {
+		return err
+	}
+
+	// reload to keep the counter recorded by the verification
+	mfa, err = m.mfaStore.GetMFA(ctx, subject)
+	if err != nil {
+		return err
+	}
+
+	mfa.Enabled = true
+	mfa.EnabledAt = time.Now()
+	return m.mfaStore.SaveMFA(ctx, *mfa)
+}
+
+// newRecoveryCodes returns the plain recovery codes and their hashes
+func (m *Module[U]) newRecoveryCodes() ([]string, []string) {
+	codes := make([]string, m.settings.MFA.RecoveryCodes)
+	hashes := make([]string, len(codes))
+	for i := range codes {
+		raw := make([]byte, 8)
+		_, _ = rand.Read(raw)
+		encoded := hex.EncodeToString(raw)
+
+		codes[i] = encoded[:4] + "-" + encoded[4:8] + "-" + encoded[8:12] + "-" + encoded[12:]
+		hashes[i] = hashRecoveryCode(codes[i])
+	}
+
+	return codes, hashes
+}
+
+// hashRecoveryCode normalizes the code typed by the user before hashing it
+func hashRecoveryCode(code string) string {
+	normalized := strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+
+		return r
+	}, strings.ToLower(code))
+	return hashToken(normalized)
+}
+
+func (m *Module[U]) mfaLoginHandler(w http.ResponseWriter, r *http.Request) {
+	var payload MFALoginPayload
+	if err := helper.DecodeRequestBody(r, &payload); err != nil {
+		helper.WriteResponse(w, err)
+		return
+	}
+
+	challenge, err := m.takeMFAChallenge(payload.ChallengeToken)
+	if errors.Is(err, ErrInvalidMFAChallenge) {
+		helper.WriteResponse(w, err, helper.ResponseWithStatus(http.StatusUnauthorized))
+		return
+	} else if err != nil {
+		helper.WriteResponse(w, err)
+		return
+	}
+
+	ip := clientIP(r)
+	if err := m.checkLockout(r.Context(), challenge.LoginID, ip); err != nil {
+		_ = m.putMFAChallenge(payload.ChallengeToken, *challenge)
+		if !writeLockoutError(w, err) {
+			helper.WriteResponse(w, err)
+		}
+		return
+	}
+
+	mfa, err := m.mfaStore.GetMFA(r.Context(), challenge.Subject)
+	if errors.Is(err, ErrMFANotFound) {
+		// keep the challenge so the user can still enroll
+		_ = m.putMFAChallenge(payload.ChallengeToken, *challenge)
+		helper.WriteResponse(w, ErrMFAEnrollmentPending, helper.ResponseWithStatus(http.StatusBadRequest))
+		return
+	} else if err != nil {
+		helper.WriteResponse(w, err)
+		return
+	}
+
+	if verifyErr := m.verifyMFA(r.Context(), mfa, payload.Code, payload.RecoveryCode); verifyErr != nil {
+		if errors.Is(verifyErr, ErrInvalidMFACode) {
+			m.recordLoginFailure(r.Context(), challenge.LoginID, ip)
+		}
+
+		challenge.Attempts++
+		if err := m.putMFAChallenge(payload.ChallengeToken, *challenge); err != nil {
+			helper.WriteResponse(w, err)
+			return
+		}
+
+		m.writeMFAError(w, verifyErr)
+		return
+	}
+
+	// the enforced enrollment is completed by its first code
+	if !mfa.Enabled {
+		if mfa, err = m.mfaStore.GetMFA(r.Context(), challenge.Subject); err != nil {
+			helper.WriteResponse(w, err)
+			return
+		}
+
+		mfa.Enabled = true
+		mfa.EnabledAt = time.Now()
+		if err := m.mfaStore.SaveMFA(r.Context(), *mfa); err != nil {
+			helper.WriteResponse(w, err)
+			return
+		}
+	}
+
+	user, err := m.userLoader.UserById(r.Context(), challenge.Subject)
+	if err != nil {
+		helper.WriteResponse(w, err)
+		return
+	}
+
+	m.resetLoginFailures(r.Context(), challenge.LoginID)
+	ctx := password.ContextWithRehash(r.Context(), challenge.Rehash)
+	m.completeLogin(w, r.WithContext(ctx), user)
+}
+
+func (m *Module[U]) mfaChallengeEnrollHandler(w http.ResponseWriter, r *http.Request) {
+	var payload MFAChallengeEnrollPayload
+	if err := helper.DecodeRequestBody(r, &payload); err != nil {
+		helper.WriteResponse(w, err)
+		return
+	}
+
+	challenge, err := m.takeMFAChallenge(payload.ChallengeToken)
+	if errors.Is(err, ErrInvalidMFAChallenge) {
+		helper.WriteResponse(w, err, helper.ResponseWithStatus(http.StatusUnauthorized))
+		return
+	} else if err != nil {
+		helper.WriteResponse(w, err)
+		return
+	}
+
+	// the challenge stays valid to verify the code of the new enrollment
+	if err := m.putMFAChallenge(payload.ChallengeToken, *challenge); err != nil {
+		helper.WriteResponse(w, err)
+		return
+	}
+
+	if !challenge.Enroll {
+		helper.WriteResponse(w, ErrMFAAlreadyEnabled, helper.ResponseWithStatus(http.StatusConflict))
+		return
+	}
+
+	m.writeMFAEnrollment(w, r, challenge.Subject)
+}
+
+func (m *Module[U]) mfaStatusHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := m.unscopedUser(w, r, "mfa")
+	if !ok {
+		return
+	}
+
+	response := MFAStatusResponse{
+		Enforced: m.mfaEnforced(user),
+	}
+
+	mfa, err := m.mfaStore.GetMFA(r.Context(), user.LoginID())
+	if err != nil && !errors.Is(err, ErrMFANotFound) {
+		helper.WriteResponse(w, err)
+		return
+	}
+
+	if mfa != nil && mfa.Enabled {
+		response.Enabled = true
+		response.RecoveryCodesRemaining = len(mfa.RecoveryCodes)
+		response.EnabledAt = &mfa.EnabledAt
+	}
+
+	helper.WriteResponse(w, response)
+}
+
+func (m *Module[U]) enrollMFAHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := m.unscopedUser(w, r, "mfa")
+	if !ok {
+		return
+	}
+
+	m.writeMFAEnrollment(w, r, user.LoginID())
+}
+
+func (m *Module[U]) writeMFAEnrollment(w http.ResponseWriter, r *http.Request, subject string) {
+	response, err := m.enrollMFA(r.Context(), subject)
+	if errors.Is(err, ErrMFAAlreadyEnabled) {
+		helper.WriteResponse(w, err, helper.ResponseWithStatus(http.StatusConflict))
+		return
+	} else if err != nil {
+		helper.WriteResponse(w, err)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	helper.WriteResponse(w, response, helper.ResponseWithStatus(http.StatusCreated))
+}
+
+func (m *Module[U]) confirmMFAHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := m.unscopedUser(w, r, "mfa")
+	if !ok {
+		return
+	}
+
+	var payload MFACodePayload
+	if err := helper.DecodeRequestBody(r, &payload); err != nil {
+		helper.WriteResponse(w, err)
+		return
+	}
+
+	err := m.confirmMFA(r.Context(), user.LoginID(), payload.Code)
+	if !m.writeMFAError(w, err) {
+		return
+	}
+
+	helper.WriteResponse(w, map[string]interface{}{
+		"message": "mfa enabled",
+	})
+}
+
+func (m *Module[U]) disableMFAHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := m.unscopedUser(w, r, "mfa")
+	if !ok {
+		return
+	}
+
+	var payload MFACodePayload
+	if err := helper.DecodeRequestBody(r, &payload); err != nil {
+		helper.WriteResponse(w, err)
+		return
+	}
+
+	// the pending enrollment can be discarded without a code
+	mfa, err := m.mfaStore.GetMFA(r.Context(), user.LoginID())
+	if err == nil && mfa.Enabled {
+		err = m.verifyMFA(r.Context(), mfa, payload.Code, payload.RecoveryCode)
+	}
+
+	if err == nil {
+		err = m.mfaStore.DeleteMFA(r.Context(), user.LoginID())
+	}
+
+	if !m.writeMFAError(w, err) {
+		return
+	}
+
+	helper.WriteResponse(w, map[string]interface{}{
+		"message": "mfa disabled",
+	})
+}
+
+func (m *Module[U]) regenerateRecoveryCodesHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := m.unscopedUser(w, r, "mfa")
+	if !ok {
+		return
+	}
+
+	var payload MFACodePayload
+	if err := helper.DecodeRequestBody(r, &payload); err != nil {
+		helper.WriteResponse(w, err)
+		return
+	}
+
+	mfa, err := m.mfaStore.GetMFA(r.Context(), user.LoginID())
+	if err == nil && !mfa.Enabled {
+		err = ErrMFAEnrollmentPending
+	}
+
+	// only the totp code is accepted, the recovery codes are replaced
+	if err == nil {
+		err = m.verifyMFA(r.Context(), mfa, payload.Code, "")
+	}
+
+	if err == nil {
+		mfa, err = m.mfaStore.GetMFA(r.Context(), user.LoginID())
+	}
+
+	var recoveryCodes []string
+	if err == nil {
+		recoveryCodes, mfa.RecoveryCodes = m.newRecoveryCodes()
+		err = m.mfaStore.SaveMFA(r.Context(), *mfa)
+	}
+
+	if !m.writeMFAError(w, err) {
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	helper.WriteResponse(w, map[string]interface{}{
+		"recovery_codes": recoveryCodes,
+	})
+}
+
+// writeMFAError writes the error response with its status, it returns true
+// when there is no error
+func (m *Module[U]) writeMFAError(w http.ResponseWriter, err error) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, ErrInvalidMFACode):
+		helper.WriteResponse(w, err, helper.ResponseWithStatus(http.StatusUnauthorized))
+	case errors.Is(err, ErrMFANotFound), errors.Is(err, ErrMFAEnrollmentPending):
+		helper.WriteResponse(w, err, helper.ResponseWithStatus(http.StatusNotFound))
+	case errors.Is(err, ErrMFAAlreadyEnabled):
+		helper.WriteResponse(w, err, helper.ResponseWithStatus(http.StatusConflict))
+	default:
+		helper.WriteResponse(w, err)
+	}
+
+	return false
+}
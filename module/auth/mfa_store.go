@@ -0,0 +1,315 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/euiko/webapp/db/cache"
+	"github.com/euiko/webapp/db/sqldb"
+	"github.com/euiko/webapp/module/auth/internal/schema"
+)
+
+type (
+	// MFA is the TOTP enrollment of the subject, it is pending until the
+	// first code is verified. Only the hashes of the recovery codes are
+	// stored.
+	MFA struct {
+		Subject       string
+		Secret        string
+		Enabled       bool
+		LastCounter   int64
+		RecoveryCodes []string
+		CreatedAt     time.Time
+		EnabledAt     time.Time
+	}
+
+	MFAStore interface {
+		SaveMFA(ctx context.Context, mfa MFA) error
+		GetMFA(ctx context.Context, subject string) (*MFA, error)
+		DeleteMFA(ctx context.Context, subject string) error
+		// UseCounter records the time step of the verified code, it fails
+		// with ErrTOTPReplayed unless the step is newer than the last one
+		UseCounter(ctx context.Context, subject string, counter int64) error
+		// UseRecoveryCode removes the hashed recovery code, it fails with
+		// ErrInvalidRecoveryCode when the code is not found
+		UseRecoveryCode(ctx context.Context, subject string, hash string) error
+	}
+
+	MFAStoreFactory func(s *Settings) (MFAStore, error)
+
+	ormMFAStore struct {
+		db sqldb.OrmDB
+	}
+
+	cacheMFAStore struct {
+		mutex sync.Mutex
+		cache cache.Cache
+	}
+)
+
+const (
+	cacheKeyMFA = "auth:mfa"
+)
+
+var (
+	ErrMFANotFound         = errors.New("mfa not found")
+	ErrTOTPReplayed        = errors.New("totp code already used")
+	ErrInvalidRecoveryCode = errors.New("invalid recovery code")
+)
+
+func NewOrmMFAStore(db sqldb.OrmDB) MFAStore {
+	return &ormMFAStore{
+		db: db,
+	}
+}
+
+// NewCacheMFAStore creates a mfa store backed by the cache, the enrollments
+// are lost on restart so it is only suitable for development
+func NewCacheMFAStore(c cache.Cache) MFAStore {
+	return &cacheMFAStore{
+		cache: c,
+	}
+}
+
+func defaultMFAStoreFactory(s *Settings) (MFAStore, error) {
+	switch s.MFA.Store {
+	case "cache":
+		return NewCacheMFAStore(cache.InMemory()), nil
+	case "sql":
+		return NewOrmMFAStore(sqldb.ORM()), nil
+	}
+
+	return nil, errors.New("invalid mfa store (valid stores: cache, sql)")
+}
+
+// SaveMFA implements MFAStore.
+func (s *ormMFAStore) SaveMFA(ctx context.Context, mfa MFA) error {
+	model := schema.MFA{
+		Subject:       mfa.Subject,
+		Secret:        mfa.Secret,
+		Enabled:       mfa.Enabled,
+		LastCounter:   mfa.LastCounter,
+		RecoveryCodes: mfa.RecoveryCodes,
+		CreatedAt:     mfa.CreatedAt,
+		EnabledAt:     mfa.EnabledAt,
+	}
+
+	_, err := s.db.NewInsert().
+		Model(&model).
+		On("CONFLICT (subject) DO UPDATE").
+		Set("secret = EXCLUDED.secret").
+		Set("enabled = EXCLUDED.enabled").
+		Set("last_counter = EXCLUDED.last_counter").
+		Set("recovery_codes = EXCLUDED.recovery_codes").
+		Set("created_at = EXCLUDED.created_at").
+		Set("enabled_at = EXCLUDED.enabled_at").
+		Exec(ctx)
+	return err
+}
+
+// GetMFA implements MFAStore.
+func (s *ormMFAStore) GetMFA(ctx context.Context, subject string) (*MFA, error) {
+	var model schema.MFA
+	err := s.db.NewSelect().
+		Model(&model).
+		Where("subject = ?", subject).
+		Limit(1).
+		Scan(ctx)
+	if sqldb.IsNoRows(err) {
+		return nil, ErrMFANotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &MFA{
+		Subject:       model.Subject,
+		Secret:        model.Secret,
+		Enabled:       model.Enabled,
+		LastCounter:   model.LastCounter,
+		RecoveryCodes: model.RecoveryCodes,
+		CreatedAt:     model.CreatedAt,
+		EnabledAt:     model.EnabledAt,
+	}, nil
+}
+
+// DeleteMFA implements MFAStore.
+func (s *ormMFAStore) DeleteMFA(ctx context.Context, subject string) error {
+	result, err := s.db.NewDelete().
+		Model((*schema.MFA)(nil)).
+		Where("subject = ?", subject).
+		Exec(ctx)
+	if err != nil {
+		return err
+	}
+
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if deleted == 0 {
+		return ErrMFANotFound
+	}
+
+	return nil
+}
+
+// UseCounter implements MFAStore.
+func (s *ormMFAStore) UseCounter(ctx context.Context, subject string, counter int64) error {
+	// the condition makes the check and the update atomic
+	result, err := s.db.NewUpdate().
+		Model((*schema.MFA)(nil)).
+		Set("last_counter = ?", counter).
+		Where("subject = ?", subject).
+		Where("last_counter < ?", counter).
+		Exec(ctx)
+	if err != nil {
+		return err
+	}
+
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if updated == 0 {
+		return ErrTOTPReplayed
+	}
+
+	return nil
+}
+
+// UseRecoveryCode implements MFAStore.
+func (s *ormMFAStore) UseRecoveryCode(ctx context.Context, subject string, hash string) error {
+	result, err := s.db.NewUpdate().
+		Model((*schema.MFA)(nil)).
+		Set("recovery_codes = recovery_codes - ?::text", hash).
+		Where("subject = ?", subject).
+		Where("recovery_codes @> jsonb_build_array(?::text)", hash).
+		Exec(ctx)
+	if err != nil {
+		return err
+	}
+
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if updated == 0 {
+		return ErrInvalidRecoveryCode
+	}
+
+	return nil
+}
+
+// SaveMFA implements MFAStore.
+func (s *cacheMFAStore) SaveMFA(ctx context.Context, mfa MFA) error {
+	return s.update(func(enrollments map[string]MFA) error {
+		enrollments[mfa.Subject] = mfa
+		return nil
+	})
+}
+
+// GetMFA implements MFAStore.
+func (s *cacheMFAStore) GetMFA(ctx context.Context, subject string) (*MFA, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	enrollments, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+
+	mfa, ok := enrollments[subject]
+	if !ok {
+		return nil, ErrMFANotFound
+	}
+
+	return &mfa, nil
+}
+
+// DeleteMFA implements MFAStore.
+func (s *cacheMFAStore) DeleteMFA(ctx context.Context, subject string) error {
+	return s.update(func(enrollments map[string]MFA) error {
+		if _, ok := enrollments[subject]; !ok {
+			return ErrMFANotFound
+		}
+
+		delete(enrollments, subject)
+		return nil
+	})
+}
+
+// UseCounter implements MFAStore.
+func (s *cacheMFAStore) UseCounter(ctx context.Context, subject string, counter int64) error {
+	return s.update(func(enrollments map[string]MFA) error {
+		mfa, ok := enrollments[subject]
+		if !ok || mfa.LastCounter >= counter {
+			return ErrTOTPReplayed
+		}
+
+		mfa.LastCounter = counter
+		enrollments[subject] = mfa
+		return nil
+	})
+}
+
+// UseRecoveryCode implements MFAStore.
+func (s *cacheMFAStore) UseRecoveryCode(ctx context.Context, subject string, hash string) error {
+	return s.update(func(enrollments map[string]MFA) error {
+		mfa, ok := enrollments[subject]
+		if !ok {
+			return ErrInvalidRecoveryCode
+		}
+
+		i := slices.Index(mfa.RecoveryCodes, hash)
+		if i < 0 {
+			return ErrInvalidRecoveryCode
+		}
+
+		// clone so the slice shared with the previous copies is untouched
+		mfa.RecoveryCodes = slices.Delete(slices.Clone(mfa.RecoveryCodes), i, i+1)
+		enrollments[subject] = mfa
+		return nil
+	})
+}
+
+func (s *cacheMFAStore) update(fn func(map[string]MFA) error) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	enrollments, err := s.load()
+	if err != nil {
+		return err
+	}
+
+	if err := fn(enrollments); err != nil {
+		return err
+	}
+
+	return s.cache.Set(cacheKeyMFA, enrollments)
+}
+
+// load returns a copy of the stored enrollments so it can be modified
+// freely
+func (s *cacheMFAStore) load() (map[string]MFA, error) {
+	enrollments := make(map[string]MFA)
+
+	cached, err := s.cache.Get(cacheKeyMFA)
+	if errors.Is(err, cache.ErrKeyNotFound) {
+		return enrollments, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	stored, _ := cached.(map[string]MFA)
+	for subject, mfa := range stored {
+		enrollments[subject] = mfa
+	}
+
+	return enrollments, nil
+}
@@ -0,0 +1,203 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/euiko/webapp/db/cache"
+	"github.com/euiko/webapp/pkg/totp"
+	"github.com/go-chi/chi/v5"
+)
+
+// newLoginTestServer serves the login endpoints of the module configured by
+// the given function
+func newLoginTestServer(t *testing.T, configure func(*Settings), opts ...ModuleOption[*testUser]) (*Module[*testUser], *chi.Mux) {
+	t.Helper()
+
+	m := NewModule[*testUser](nil, testUserLoader{}, opts...)
+	m.settings.Enabled = true
+	configure(&m.settings)
+
+	ctx := context.Background()
+	if err := m.Init(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.BeforeStart(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	r := chi.NewRouter()
+	r.Use(cookieSessionMiddleware)
+	r.Post("/auth/login", m.loginHandler)
+	r.Post("/auth/login/mfa", m.mfaLoginHandler)
+	r.Post("/auth/login/mfa/enroll", m.mfaChallengeEnrollHandler)
+	return m, r
+}
+
+func newMFATestServer(t *testing.T) (*Module[*testUser], *chi.Mux) {
+	return newLoginTestServer(t, func(s *Settings) {
+		s.MFA.Enabled = true
+		s.MFA.MaxAttempts = 3
+		s.MFA.EnforcedRoles = []string{"admin"}
+	}, WithMFAStoreFactory[*testUser](func(s *Settings) (MFAStore, error) {
+		return NewCacheMFAStore(cache.NewInMemory()), nil
+	}))
+}
+
+func postJSON(handler http.Handler, path string, body any) *httptest.ResponseRecorder {
+	encoded, _ := json.Marshal(body)
+	req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(encoded))
+	req.Header.Set("Content-Type", "application/json")
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+// loginChallenge signs in with the password and returns the mfa challenge
+func loginChallenge(t *testing.T, handler http.Handler, loginId string) MFAChallengeResponse {
+	t.Helper()
+
+	rec := postJSON(handler, "/auth/login", LoginPayload{LoginId: loginId, Password: "secret"})
+	var challenge MFAChallengeResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &challenge); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusOK || !challenge.MFARequired || challenge.ChallengeToken == "" {
+		t.Fatalf("expected the mfa challenge, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	return challenge
+}
+
+// currentCode returns the totp code of the current time step
+func currentCode(t *testing.T, secret string) string {
+	t.Helper()
+
+	code, err := totp.Code(secret, totp.Counter(time.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return code
+}
+
+func TestMFALogin(t *testing.T) {
+	m, handler := newMFATestServer(t)
+
+	ctx := context.Background()
+	enrollment, err := m.enrollMFA(ctx, "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mfa, _ := m.mfaStore.GetMFA(ctx, "bob")
+	mfa.Enabled = true
+	if err := m.mfaStore.SaveMFA(ctx, *mfa); err != nil {
+		t.Fatal(err)
+	}
+
+	challenge := loginChallenge(t, handler, "bob")
+	if challenge.EnrollmentRequired {
+		t.Fatal("expected the enrolled user not to enroll again")
+	}
+
+	// the challenge is put back on a wrong code
+	rec := postJSON(handler, "/auth/login/mfa", MFALoginPayload{ChallengeToken: challenge.ChallengeToken, Code: "abcdef"})
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	code := currentCode(t, enrollment.Secret)
+	rec = postJSON(handler, "/auth/login/mfa", MFALoginPayload{ChallengeToken: challenge.ChallengeToken, Code: code})
+	var response LoginResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusOK || response.Token == "" {
+		t.Fatalf("expected the tokens, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	t.Run("challenge replay", func(t *testing.T) {
+		rec := postJSON(handler, "/auth/login/mfa", MFALoginPayload{ChallengeToken: challenge.ChallengeToken, Code: code})
+		if rec.Code != http.StatusUnauthorized {
+			t.Fatalf("expected 401, got %d", rec.Code)
+		}
+	})
+
+	t.Run("code replay", func(t *testing.T) {
+		challenge := loginChallenge(t, handler, "bob")
+		rec := postJSON(handler, "/auth/login/mfa", MFALoginPayload{ChallengeToken: challenge.ChallengeToken, Code: code})
+		if rec.Code != http.StatusUnauthorized {
+			t.Fatalf("expected the used code to be rejected, got %d", rec.Code)
+		}
+	})
+
+	t.Run("recovery code", func(t *testing.T) {
+		challenge := loginChallenge(t, handler, "bob")
+		payload := MFALoginPayload{ChallengeToken: challenge.ChallengeToken, RecoveryCode: enrollment.RecoveryCodes[0]}
+		if rec := postJSON(handler, "/auth/login/mfa", payload); rec.Code != http.StatusOK {
+			t.Fatalf("expected the recovery code to be accepted, got %d: %s", rec.Code, rec.Body.String())
+		}
+
+		challenge = loginChallenge(t, handler, "bob")
+		payload.ChallengeToken = challenge.ChallengeToken
+		if rec := postJSON(handler, "/auth/login/mfa", payload); rec.Code != http.StatusUnauthorized {
+			t.Fatalf("expected the used recovery code to be rejected, got %d", rec.Code)
+		}
+	})
+
+	t.Run("max attempts", func(t *testing.T) {
+		challenge := loginChallenge(t, handler, "bob")
+		for range m.settings.MFA.MaxAttempts {
+			postJSON(handler, "/auth/login/mfa", MFALoginPayload{ChallengeToken: challenge.ChallengeToken, Code: "abcdef"})
+		}
+
+		// the recovery code is valid, the challenge is gone
+		payload := MFALoginPayload{ChallengeToken: challenge.ChallengeToken, RecoveryCode: enrollment.RecoveryCodes[1]}
+		if rec := postJSON(handler, "/auth/login/mfa", payload); rec.Code != http.StatusUnauthorized {
+			t.Fatalf("expected the exhausted challenge to be rejected, got %d", rec.Code)
+		}
+	})
+}
+
+func TestMFAEnforcedEnrollment(t *testing.T) {
+	m, handler := newMFATestServer(t)
+
+	if rec := postJSON(handler, "/auth/login", LoginPayload{LoginId: "carol", Password: "secret"}); bytes.Contains(rec.Body.Bytes(), []byte("challenge_token")) {
+		t.Fatalf("expected the user without mfa to sign in, got %s", rec.Body.String())
+	}
+
+	challenge := loginChallenge(t, handler, "admin")
+	if !challenge.EnrollmentRequired {
+		t.Fatal("expected the enforced role to enroll")
+	}
+
+	// the challenge is kept until the user enrolls
+	rec := postJSON(handler, "/auth/login/mfa", MFALoginPayload{ChallengeToken: challenge.ChallengeToken, Code: "123456"})
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected the pending enrollment, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	rec = postJSON(handler, "/auth/login/mfa/enroll", MFAChallengeEnrollPayload{ChallengeToken: challenge.ChallengeToken})
+	var enrollment MFAEnrollResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &enrollment); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusCreated || enrollment.Secret == "" {
+		t.Fatalf("expected the enrollment, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	payload := MFALoginPayload{ChallengeToken: challenge.ChallengeToken, Code: currentCode(t, enrollment.Secret)}
+	if rec := postJSON(handler, "/auth/login/mfa", payload); rec.Code != http.StatusOK {
+		t.Fatalf("expected the first code to complete the login, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	if mfa, err := m.mfaStore.GetMFA(context.Background(), "admin"); err != nil || !mfa.Enabled {
+		t.Fatalf("expected the enrollment to be enabled, got %v", err)
+	}
+}
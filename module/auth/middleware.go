@@ -8,47 +8,74 @@ import (
 	"github.com/euiko/webapp/module/auth/lib"
 	"github.com/euiko/webapp/pkg/helper"
 	"github.com/euiko/webapp/pkg/log"
-	"github.com/euiko/webapp/pkg/session"
 	"github.com/euiko/webapp/pkg/token"
 )
 
-func newMiddleware[U lib.User](module *Module[U], unauthorizedHandler http.Handler) func(http.Handler) http.Handler {
+func newMiddleware[U lib.User](module *Module[U], unauthorizedHandler http.Handler, methods lib.AuthMethod) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			var (
-				prohibited bool
-				token      *token.Token
-				err        error
+				prohibited    bool
+				token         *token.Token
+				apiKey        *APIKey
+				impersonation *lib.Impersonation
+				certUser      lib.User
+				err           error
 			)
 
-			authorization := r.Header.Get("Authorization")
-			if !strings.HasPrefix(authorization, "Bearer") {
-				prohibited = true
+			// the verified client certificate is kept even when the token
+			// authenticates the request, e.g. a service acting for a user
+			acceptsToken := methods&lib.AuthMethodToken != 0
+			cert := module.clientCertificate(r)
+			if methods&lib.AuthMethodClientCert == 0 {
+				cert = nil
 			}
 
-			// clean up the authorization header to obtain token
-			authorization = strings.TrimPrefix(authorization, "Bearer")
-			authorization = strings.TrimSpace(authorization)
+			// the api keys of the machine clients are accepted alongside
+			// the bearer tokens
+			if plain := module.apiKeyFromRequest(r); acceptsToken && plain != "" {
+				apiKey, err = module.authenticateAPIKey(r.Context(), plain)
+				if err != nil {
+					log.Error("failed to authenticate api key", log.WithError(err))
+					prohibited = true
+				} else {
+					token = apiKeyToken(apiKey)
+				}
+			} else if authorization := bearerToken(r); acceptsToken && len(authorization) > 0 {
+				token, err = module.decodeToken(r.Context(), []byte(authorization))
 
-			if len(authorization) == 0 {
+				if err != nil || token == nil {
+					log.Error("failed to decode token", log.WithError(err))
+					prohibited = true
+				} else if imp, ok, err := module.tokenImpersonation(token); ok && err != nil {
+					log.Warning("refused impersonation token", log.WithError(err))
+					prohibited = true
+				} else {
+					impersonation = imp
+				}
+			} else if cert != nil {
+				certUser, err = module.clientCertMapper.UserFromCertificate(r.Context(), cert)
+				if err != nil {
+					log.Warning("failed to map client certificate",
+						log.WithError(err),
+						log.WithField("subject", cert.Subject.String()),
+					)
+					prohibited = true
+				} else {
+					token = module.clientCertToken(cert, certUser)
+				}
+			} else {
 				prohibited = true
 			}
 
-			// only try to decode the token if it is not prohibited
-			if !prohibited {
-				// try use all available keys
-				keys := module.GetKeys()
-				for _, key := range keys {
-					token, err = module.TokenEncoding().Decode(key, []byte(authorization))
-					if err == nil {
-						break
-					}
-				}
-
+			// deny the revoked tokens, e.g. after logout
+			if !prohibited && token.ID != "" && module.tokenStore != nil {
+				revoked, err := module.tokenStore.IsTokenRevoked(r.Context(), token.ID)
 				if err != nil {
-					log.Error("failed to decode token", log.WithError(err))
-					prohibited = true
+					log.Error("failed to check token revocation", log.WithError(err))
 				}
+
+				prohibited = revoked || err != nil
 			}
 
 			if prohibited {
@@ -61,27 +88,59 @@ func newMiddleware[U lib.User](module *Module[U], unauthorizedHandler http.Handl
 			}
 
 			var user lib.User
-			err = session.Get(r.Context(), "user", &user)
-			if err == session.ErrKeyNotFound {
-				// load user from the user loader if not found in the session
-				user, err = module.UserLoader().UserById(r.Context(), token.Subject)
-				if err != nil {
-					helper.WriteResponse(w, errors.New("internal server error"))
-					return
-				}
+			if certUser != nil {
+				// like the api keys, the certificates are stateless
+				user = certUser
+			} else if apiKey != nil {
+				// the api keys are stateless, the user is loaded on every
+				// request so the changes of the user apply immediately
+				user, err = module.userLoader.UserById(r.Context(), apiKey.Subject)
+			} else {
+				// the user is never cached into the session, it would outlive
+				// the changes of the user and could belong to another subject
+				// than the token
+				user, err = module.userFromToken(r.Context(), token)
+			}
 
-				session.Add(r.Context(), "user", user)
-			} else if err != nil {
-				// other errors
+			if err != nil {
 				helper.WriteResponse(w, errors.New("internal server error"))
 				return
 			}
 
+			if impersonation != nil {
+				r = r.WithContext(withImpersonation(r, user, impersonation))
+			}
+
 			ctx := contextWithToken(r.Context(), token)
 			ctx = lib.WithCurrentUser(ctx, user)
+			if apiKey != nil {
+				ctx = contextWithAPIKey(ctx, apiKey)
+			}
+			if cert != nil {
+				ctx = lib.WithClientCertificate(ctx, cert)
+			}
+
+			// the api keys and the oauth clients are restricted to the
+			// permissions of their scopes
+			if scopes, ok := tokenScopes(token); ok {
+				ctx = lib.WithScopes(ctx, scopes)
+			}
 
 			r = r.WithContext(ctx)
 			next.ServeHTTP(w, r)
 		})
 	}
 }
+
+// bearerToken returns the token of the Bearer authorization header, it is
+// empty when the header uses another scheme
+func bearerToken(r *http.Request) string {
+	authorization := r.Header.Get("Authorization")
+	if !strings.HasPrefix(authorization, "Bearer") {
+		return ""
+	}
+
+	// clean up the authorization header to obtain token
+	authorization = strings.TrimPrefix(authorization, "Bearer")
+	return strings.TrimSpace(authorization)
+}
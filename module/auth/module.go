@@ -2,13 +2,20 @@ package auth
 
 import (
 	"context"
+	"embed"
+	"errors"
 	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/euiko/webapp/core"
 	"github.com/euiko/webapp/db/cache"
+	"github.com/euiko/webapp/db/sqldb"
 	"github.com/euiko/webapp/pkg/helper"
-	"github.com/euiko/webapp/pkg/log"
+	"github.com/euiko/webapp/pkg/password"
+	"github.com/euiko/webapp/pkg/session"
 	"github.com/euiko/webapp/pkg/token"
 	"github.com/euiko/webapp/settings"
 
@@ -22,7 +29,28 @@ type (
 		tokenEncoding       token.Encoding
 		userLoader          lib.UserLoader[U]
 		hooks               []lib.Hook[U]
-		keyStore            token.KeyStore
+		configKeys          []token.Key
+		keyring             atomic.Pointer[keyring]
+		keyStoreFactory     SigningKeyStoreFactory
+		keyStore            SigningKeyStore
+		remoteKeys          *token.RemoteKeySet
+		tokenStoreFactory   TokenStoreFactory
+		tokenStore          TokenStore
+		sessionStore        session.Store
+		apiKeyStoreFactory  APIKeyStoreFactory
+		apiKeyStore         APIKeyStore
+		oidcProviders       map[string]*oidcProvider
+		oauthStoreFactory   OAuthClientStoreFactory
+		oauthStore          OAuthClientStore
+		oauthCodes          cache.Cache
+		oauthCodesMutex     sync.Mutex
+		mfaStoreFactory     MFAStoreFactory
+		mfaStore            MFAStore
+		mfaChallenges       cache.Cache
+		mfaChallengesMutex  sync.Mutex
+		loginAttemptFactory LoginAttemptStoreFactory
+		loginAttemptStore   LoginAttemptStore
+		clientCertMapper    lib.ClientCertMapper[U]
 		middleware          func(http.Handler) http.Handler
 		unauthorizedHandler http.Handler
 	}
@@ -30,8 +58,9 @@ type (
 	ModuleOption[U lib.User] func(*Module[U])
 )
 
-const (
-	cacheKeyKeys = "auth:keys"
+var (
+	//go:embed internal/migrations
+	embededMigrationFS embed.FS
 )
 
 func WithUnauthorizedHandler[U lib.User](handler http.Handler) ModuleOption[U] {
@@ -40,6 +69,56 @@ func WithUnauthorizedHandler[U lib.User](handler http.Handler) ModuleOption[U] {
 	}
 }
 
+func WithHooks[U lib.User](hooks ...lib.Hook[U]) ModuleOption[U] {
+	return func(m *Module[U]) {
+		m.hooks = append(m.hooks, hooks...)
+	}
+}
+
+func WithTokenStoreFactory[U lib.User](factory TokenStoreFactory) ModuleOption[U] {
+	return func(m *Module[U]) {
+		m.tokenStoreFactory = factory
+	}
+}
+
+func WithSigningKeyStoreFactory[U lib.User](factory SigningKeyStoreFactory) ModuleOption[U] {
+	return func(m *Module[U]) {
+		m.keyStoreFactory = factory
+	}
+}
+
+func WithAPIKeyStoreFactory[U lib.User](factory APIKeyStoreFactory) ModuleOption[U] {
+	return func(m *Module[U]) {
+		m.apiKeyStoreFactory = factory
+	}
+}
+
+func WithOAuthClientStoreFactory[U lib.User](factory OAuthClientStoreFactory) ModuleOption[U] {
+	return func(m *Module[U]) {
+		m.oauthStoreFactory = factory
+	}
+}
+
+func WithMFAStoreFactory[U lib.User](factory MFAStoreFactory) ModuleOption[U] {
+	return func(m *Module[U]) {
+		m.mfaStoreFactory = factory
+	}
+}
+
+func WithLoginAttemptStoreFactory[U lib.User](factory LoginAttemptStoreFactory) ModuleOption[U] {
+	return func(m *Module[U]) {
+		m.loginAttemptFactory = factory
+	}
+}
+
+// WithClientCertMapper replaces the default mapper of the client
+// certificates identity to the users
+func WithClientCertMapper[U lib.User](mapper lib.ClientCertMapper[U]) ModuleOption[U] {
+	return func(m *Module[U]) {
+		m.clientCertMapper = mapper
+	}
+}
+
 func ModuleFactory[U lib.User](
 	userLoader lib.UserLoader[U],
 	options ...ModuleOption[U],
@@ -63,14 +142,96 @@ func NewModule[U lib.User](
 				JWTAlgorithm: "HS256",
 				JWTIssuer:    "webapp",
 				JWTAudience:  "webapp",
-				JWTTimeout:   24 * time.Hour,
+				JWTTimeout:   15 * time.Minute,
 				Keys: []string{
 					helper.EncodeBase64(helper.Hash([]byte("secret"), helper.HashSHA256)),
 				},
 			},
+			TokenStore:          "cache",
+			RefreshTokenTimeout: 30 * 24 * time.Hour,
+			KeyManagement: KeyManagementSettings{
+				Store:            "config",
+				RefreshInterval:  time.Minute,
+				RotationInterval: 0,
+				RetireAfter:      24 * time.Hour,
+			},
+			Introspection: IntrospectionSettings{
+				Enabled: false,
+				Clients: map[string]string{},
+			},
+			APIKeys: APIKeySettings{
+				Enabled:    false,
+				Store:      "sql",
+				HeaderName: "X-API-Key",
+				Prefix:     "wak",
+			},
+			OIDC: OIDCSettings{
+				Enabled:   false,
+				Providers: map[string]OIDCProviderSettings{},
+			},
+			OAuthServer: OAuthServerSettings{
+				Enabled:     false,
+				ClientStore: "sql",
+				CodeTimeout: time.Minute,
+			},
+			Password: PasswordSettings{
+				Algorithm: "argon2id",
+				Argon2id: Argon2idSettings{
+					Memory:      password.DefaultArgon2idParams.Memory,
+					Iterations:  password.DefaultArgon2idParams.Iterations,
+					Parallelism: password.DefaultArgon2idParams.Parallelism,
+				},
+				BcryptCost: password.DefaultBcryptCost,
+				Scrypt: ScryptSettings{
+					LogN:        password.DefaultScryptParams.LogN,
+					BlockSize:   password.DefaultScryptParams.BlockSize,
+					Parallelism: password.DefaultScryptParams.Parallelism,
+				},
+				Policy: PasswordPolicySettings{
+					MinLength:     8,
+					MaxLength:     64,
+					MaxSimilarity: 0.7,
+				},
+			},
+			MFA: MFASettings{
+				Enabled:          false,
+				Store:            "sql",
+				Issuer:           "webapp",
+				Skew:             1,
+				ChallengeTimeout: 5 * time.Minute,
+				MaxAttempts:      5,
+				RecoveryCodes:    10,
+				EnforcedRoles:    []string{},
+			},
+			Lockout: LockoutSettings{
+				Enabled:         false,
+				Store:           "cache",
+				Window:          15 * time.Minute,
+				MaxFailures:     5,
+				MaxIPFailures:   50,
+				LockoutDuration: 15 * time.Minute,
+				DelayAfter:      3,
+				BaseDelay:       time.Second,
+				MaxDelay:        30 * time.Second,
+			},
+			Impersonation: ImpersonationSettings{
+				Enabled: false,
+				Timeout: 15 * time.Minute,
+			},
+			ClientCert: ClientCertSettings{
+				Enabled:  false,
+				Identity: "common_name",
+				Scopes:   []string{},
+			},
 		},
-		tokenEncoding: nil,
-		userLoader:    userLoader,
+		tokenEncoding:       nil,
+		userLoader:          userLoader,
+		tokenStoreFactory:   defaultTokenStoreFactory,
+		keyStoreFactory:     defaultSigningKeyStoreFactory,
+		apiKeyStoreFactory:  defaultAPIKeyStoreFactory,
+		oauthStoreFactory:   defaultOAuthClientStoreFactory,
+		mfaStoreFactory:     defaultMFAStoreFactory,
+		loginAttemptFactory: defaultLoginAttemptStoreFactory,
 	}
 
 	for _, opt := range options {
@@ -95,32 +256,167 @@ func (m *Module[U]) Init(ctx context.Context, s *settings.Settings) error {
 		return err
 	}
 
-	// add keys in configurations
-	for _, key := range m.settings.TokenEncoding.Keys {
-		m.keyStore.Add(key, token.NewSymetricKey([]byte(key)))
+	// add keys in configurations, the managed keys are loaded on start
+	m.configKeys, err = loadKeys(&m.settings.TokenEncoding)
+	if err != nil {
+		return err
+	}
+
+	// the managed keys are reloaded periodically once started
+	managed := m.settings.KeyManagement.Store != "" && m.settings.KeyManagement.Store != "config"
+	if managed && m.settings.KeyManagement.RefreshInterval <= 0 {
+		return errors.New("key management refresh interval must be positive")
+	}
+
+	// the keys may only come from the key store or the remote key set
+	if m.usesKeys() && len(m.configKeys) == 0 && !managed && m.settings.TokenEncoding.JWKSURL == "" {
+		return errors.New("token encoding requires at least one key, a key store or a jwks url specified in config")
+	}
+
+	if err := m.reloadKeys(ctx); err != nil {
+		return err
+	}
+
+	if m.settings.TokenEncoding.JWKSURL != "" {
+		m.remoteKeys = token.NewRemoteKeySet(m.settings.TokenEncoding.JWKSURL)
+	}
+
+	if m.settings.OIDC.Enabled {
+		m.oidcProviders, err = newOIDCProviders(m.settings.OIDC.Providers)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := m.initPassword(); err != nil {
+		return err
+	}
+
+	if m.settings.ClientCert.Enabled && m.clientCertMapper == nil {
+		m.clientCertMapper, err = newIdentityCertMapper(m.settings.ClientCert.Identity, m.userLoader)
+		if err != nil {
+			return err
+		}
+	}
+
+	if m.settings.APIKeys.Enabled && strings.Contains(m.settings.APIKeys.Prefix, "_") {
+		return errors.New("api key prefix must not contain an underscore")
+	}
+
+	if m.settings.TokenStore == "sql" || m.settings.KeyManagement.Store == "sql" ||
+		(m.settings.APIKeys.Enabled && m.settings.APIKeys.Store == "sql") ||
+		(m.settings.OAuthServer.Enabled && m.settings.OAuthServer.ClientStore == "sql") ||
+		(m.settings.MFA.Enabled && m.settings.MFA.Store == "sql") ||
+		(m.settings.Lockout.Enabled && m.settings.Lockout.Store == "sql") {
+		sqldb.AddMigrationFS(embededMigrationFS)
 	}
 
 	return nil
 }
 
+// initPassword replaces the default password manager and registers the
+// password validation tag using the configured policy
+func (m *Module[U]) initPassword() error {
+	hasher, err := m.settings.Password.hasher()
+	if err != nil {
+		return err
+	}
+
+	policySettings := m.settings.Password.Policy
+	policy, err := password.NewPolicy(
+		password.WithMinLength(policySettings.MinLength),
+		password.WithMaxLength(policySettings.MaxLength),
+		password.WithMaxSimilarity(policySettings.MaxSimilarity),
+		password.WithBreachedListFile(policySettings.BreachedListFile),
+	)
+	if err != nil {
+		return err
+	}
+
+	password.SetDefault(password.NewManager(hasher))
+	return password.RegisterValidation(policy)
+}
+
 func (m *Module[U]) Close() error {
 	return nil
 }
 
-func (m *Module[U]) GetKeys() []token.Key {
-	cached, err := cache.InMemory().Get(cacheKeyKeys)
-	if err == cache.ErrKeyNotFound {
-		keys := m.keyStore.Keys()
-		cache.InMemory().Set(cacheKeyKeys, keys, cache.SetWithTimeout(10*time.Minute))
-		return keys
+func (m *Module[U]) BeforeStart(ctx context.Context) error {
+	if !m.settings.Enabled {
+		return nil
 	}
 
+	var err error
+	m.tokenStore, err = m.tokenStoreFactory(&m.settings)
 	if err != nil {
-		log.Error("failed when retrieving keys from cache", log.WithError(err))
+		return err
+	}
+
+	if encoding, ok := m.tokenEncoding.(*token.OpaqueEncoding); ok {
+		store, ok := m.tokenStore.(token.OpaqueStore)
+		if !ok {
+			return errors.New("opaque token encoding requires the token store to implement token.OpaqueStore")
+		}
+
+		encoding.SetStore(store)
+	}
+
+	if m.settings.APIKeys.Enabled {
+		m.apiKeyStore, err = m.apiKeyStoreFactory(&m.settings)
+		if err != nil {
+			return err
+		}
+	}
+
+	if m.settings.OAuthServer.Enabled {
+		m.oauthStore, err = m.oauthStoreFactory(&m.settings)
+		if err != nil {
+			return err
+		}
+
+		// the codes are short lived and kept in memory, so the token
+		// request must reach the instance issuing the code
+		m.oauthCodes = cache.InMemory()
+	}
+
+	if m.settings.MFA.Enabled {
+		m.mfaStore, err = m.mfaStoreFactory(&m.settings)
+		if err != nil {
+			return err
+		}
+
+		// like the oauth codes, the challenges are kept in memory
+		m.mfaChallenges = cache.InMemory()
+	}
+
+	if m.settings.Lockout.Enabled {
+		m.loginAttemptStore, err = m.loginAttemptFactory(&m.settings)
+		if err != nil {
+			return err
+		}
+	}
+
+	m.keyStore, err = m.keyStoreFactory(&m.settings)
+	if err != nil || m.keyStore == nil {
+		return err
+	}
+
+	if err := m.maintainKeys(ctx); err != nil {
+		return err
+	}
+
+	// pick up the keys changed by the other instances or the cli
+	go m.runKeyMaintenance(ctx)
+	return nil
+}
+
+func (m *Module[U]) GetKeys() []token.Key {
+	ring := m.keyring.Load()
+	if ring == nil {
 		return nil
 	}
 
-	return cached.([]token.Key)
+	return ring.keys
 }
 
 func (m *Module[U]) TokenEncoding() token.Encoding {
@@ -133,8 +429,65 @@ func (m *Module[U]) UserLoader() lib.UserLoader[lib.User] {
 
 func (m *Module[U]) Middleware() core.MiddlewareFunc {
 	if m.middleware == nil {
-		m.middleware = newMiddleware(m, nil)
+		m.middleware = newMiddleware(m, nil, lib.AuthMethodAll)
 	}
 
 	return m.middleware
 }
+
+// MethodsMiddleware returns the middleware only accepting the credentials of
+// the methods, the client certificates also require to be enabled
+func (m *Module[U]) MethodsMiddleware(methods lib.AuthMethod) core.MiddlewareFunc {
+	return newMiddleware(m, nil, methods)
+}
+
+// RevokeUser revokes the refresh tokens and the server-side sessions of the
+// user, the sessions are kept when they are stored in the cookies
+func (m *Module[U]) RevokeUser(ctx context.Context, subject string) error {
+	if !m.settings.Enabled {
+		return nil
+	}
+
+	// the stores are only opened on start, e.g. not in the commands
+	if m.tokenStore == nil {
+		if err := m.openRevocationStores(); err != nil {
+			return err
+		}
+	}
+
+	if err := m.tokenStore.RevokeSubject(ctx, subject); err != nil {
+		return err
+	}
+
+	if m.sessionStore != nil {
+		ctx = session.WithStore(ctx, m.sessionStore)
+	}
+
+	err := session.RevokeByUser(ctx, subject)
+	if errors.Is(err, session.ErrNoStore) {
+		return nil
+	}
+
+	return err
+}
+
+// openRevocationStores opens the stores of RevokeUser outside the server,
+// the cache stores only live within the server process
+func (m *Module[U]) openRevocationStores() error {
+	sessionStore := m.app.Settings().Server.Session.Store
+	if m.settings.TokenStore == "cache" || sessionStore == "cache" {
+		return lib.ErrRevocationUnavailable
+	}
+
+	tokenStore, err := m.tokenStoreFactory(&m.settings)
+	if err != nil {
+		return err
+	}
+
+	if sessionStore == "sql" {
+		m.sessionStore = session.NewOrmStore(sqldb.ORM())
+	}
+
+	m.tokenStore = tokenStore
+	return nil
+}
@@ -0,0 +1,130 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/euiko/webapp/core"
+	"github.com/euiko/webapp/db/cache"
+	"github.com/euiko/webapp/module/auth/lib"
+	"github.com/euiko/webapp/pkg/session"
+	"github.com/euiko/webapp/settings"
+)
+
+func TestRevokeUser(t *testing.T) {
+	m, r := newLoginTestServer(t, func(s *Settings) {}, WithTokenStoreFactory[*testUser](func(s *Settings) (TokenStore, error) {
+		return NewCacheTokenStore(cache.NewInMemory(), s.RefreshTokenTimeout), nil
+	}))
+	r.Post("/auth/refresh", m.refreshHandler)
+
+	refreshToken := func(loginId string) string {
+		var response LoginResponse
+		rec := login(r, loginId, "secret")
+		if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil || response.RefreshToken == "" {
+			t.Fatalf("expected the refresh token, got %d: %s", rec.Code, rec.Body.String())
+		}
+
+		return response.RefreshToken
+	}
+
+	alice, bob := refreshToken("alice"), refreshToken("bob")
+
+	store := session.NewCacheStore(cache.NewInMemory())
+	ctx := session.WithStore(context.Background(), store)
+	for _, record := range []session.Record{
+		{ID: "alice-laptop", UserID: "alice"},
+		{ID: "alice-phone", UserID: "alice"},
+		{ID: "bob-laptop", UserID: "bob"},
+	} {
+		record.ExpiresAt = time.Now().Add(time.Hour)
+		if err := store.Save(ctx, record); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := m.RevokeUser(ctx, "alice"); err != nil {
+		t.Fatal(err)
+	}
+
+	if rec := postJSON(r, "/auth/refresh", RefreshPayload{RefreshToken: alice}); rec.Code == http.StatusOK {
+		t.Fatal("expected the refresh token of the revoked user to be rejected")
+	}
+	if records, _ := store.ListByUser(ctx, "alice"); len(records) != 0 {
+		t.Fatalf("expected the sessions to be deleted, got %d", len(records))
+	}
+
+	// the other users and the next logins are not affected
+	if rec := postJSON(r, "/auth/refresh", RefreshPayload{RefreshToken: bob}); rec.Code != http.StatusOK {
+		t.Fatalf("expected the refresh token of another user to be valid, got %d", rec.Code)
+	}
+	if records, _ := store.ListByUser(ctx, "bob"); len(records) != 1 {
+		t.Fatalf("expected the sessions of another user to be kept, got %d", len(records))
+	}
+	if rec := postJSON(r, "/auth/refresh", RefreshPayload{RefreshToken: refreshToken("alice")}); rec.Code != http.StatusOK {
+		t.Fatalf("expected the refresh token of a new login to be valid, got %d", rec.Code)
+	}
+}
+
+func TestSessionCookieWithToken(t *testing.T) {
+	m, r := newLoginTestServer(t, func(s *Settings) {})
+	r.With(m.Middleware()).Get("/me", func(w http.ResponseWriter, r *http.Request) {
+		user, _ := lib.CurrentUser(r.Context())
+		w.Write([]byte(user.LoginID()))
+	})
+
+	rec := login(r, "alice", "secret")
+	var response LoginResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil || len(rec.Result().Cookies()) == 0 {
+		t.Fatalf("expected the tokens and the session cookie, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	me := func(bearer string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/me", nil)
+		req.Header.Set("Authorization", "Bearer "+bearer)
+		for _, cookie := range rec.Result().Cookies() {
+			req.AddCookie(cookie)
+		}
+
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := me(response.Token); rec.Code != http.StatusOK || rec.Body.String() != "alice" {
+		t.Fatalf("expected the user of the token, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// the session of alice never substitutes the subject of another token
+	var bob LoginResponse
+	json.Unmarshal(login(r, "bob", "secret").Body.Bytes(), &bob)
+	if rec := me(bob.Token); rec.Code != http.StatusOK || rec.Body.String() != "bob" {
+		t.Fatalf("expected the user of the token, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+type testApp struct {
+	core.App
+	settings settings.Settings
+}
+
+func (a *testApp) Settings() *settings.Settings {
+	return &a.settings
+}
+
+func TestRevokeUserOutsideServer(t *testing.T) {
+	m := NewModule[*testUser](&testApp{settings: settings.New()}, testUserLoader{})
+	m.settings.Enabled = true
+	if err := m.Init(context.Background(), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// the commands can't reach the memory of the server
+	if err := m.RevokeUser(context.Background(), "alice"); !errors.Is(err, lib.ErrRevocationUnavailable) {
+		t.Fatalf("expected the revocation to be unavailable, got %v", err)
+	}
+}
@@ -0,0 +1,500 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/euiko/webapp/core"
+	"github.com/euiko/webapp/db/cache"
+	"github.com/euiko/webapp/module/auth/lib"
+	"github.com/euiko/webapp/pkg/helper"
+	"github.com/euiko/webapp/pkg/log"
+	"github.com/euiko/webapp/pkg/session"
+	"github.com/euiko/webapp/pkg/token"
+)
+
+type (
+	// OAuthTokenResponse is the RFC 6749 access token response
+	OAuthTokenResponse struct {
+		AccessToken  string `json:"access_token"`
+		TokenType    string `json:"token_type"`
+		ExpiresIn    int64  `json:"expires_in"`
+		RefreshToken string `json:"refresh_token,omitempty"`
+		Scope        string `json:"scope"`
+	}
+
+	// oauthCode is the pending grant of an authorization code
+	oauthCode struct {
+		ClientID      string
+		RedirectURI   string
+		Subject       string
+		Scopes        []string
+		CodeChallenge string
+		ExpiresAt     time.Time
+	}
+
+	// oauthError is the RFC 6749 error, it is returned to the client either
+	// in the response body or the redirect query
+	oauthError struct {
+		status      int
+		code        string
+		description string
+	}
+)
+
+const (
+	cacheKeyOAuthCode = "auth:oauth-code:"
+)
+
+func newOAuthError(status int, code, description string) *oauthError {
+	return &oauthError{status: status, code: code, description: description}
+}
+
+func (e *oauthError) Error() string {
+	if e.description == "" {
+		return e.code
+	}
+
+	return e.code + ": " + e.description
+}
+
+// oauthRoute registers the authorization server endpoints, they live outside
+// of the api since the users and the clients are redirected to them
+func (m *Module[U]) oauthRoute(r core.Router) {
+	if !m.settings.OAuthServer.Enabled {
+		return
+	}
+
+	// the consent screen submits the decision back to the same URL
+	r.Get("/oauth/authorize", m.authorizeHandler)
+	r.Post("/oauth/authorize", m.authorizeHandler)
+	r.Post("/oauth/token", m.oauthTokenHandler)
+	if m.settings.OAuthServer.Issuer != "" {
+		r.Get("/.well-known/oauth-authorization-server", m.oauthMetadataHandler)
+	}
+}
+
+// authorizeHandler grants an authorization code to the client on behalf of
+// the signed in user, PKCE is required for every client
+func (m *Module[U]) authorizeHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	query := r.URL.Query()
+
+	// the errors are only redirected once the redirect URI is verified
+	client, err := m.oauthStore.GetClient(ctx, query.Get("client_id"))
+	if errors.Is(err, ErrOAuthClientNotFound) {
+		writeOAuthError(w, newOAuthError(http.StatusBadRequest, "invalid_request", "unknown client"))
+		return
+	} else if err != nil {
+		helper.WriteResponse(w, err)
+		return
+	}
+
+	redirectURI := query.Get("redirect_uri")
+	if redirectURI == "" && len(client.RedirectURIs) == 1 {
+		redirectURI = client.RedirectURIs[0]
+	}
+
+	if !slices.Contains(client.RedirectURIs, redirectURI) {
+		writeOAuthError(w, newOAuthError(http.StatusBadRequest, "invalid_request", "unregistered redirect_uri"))
+		return
+	}
+
+	redirectError := func(err *oauthError) {
+		redirectOAuth(w, r, redirectURI, url.Values{
+			"error":             {err.code},
+			"error_description": {err.description},
+			"state":             {query.Get("state")},
+		})
+	}
+
+	if query.Get("response_type") != "code" {
+		redirectError(newOAuthError(0, "unsupported_response_type", "only the code response type is supported"))
+		return
+	}
+
+	if !client.AllowsGrant(grantAuthorizationCode) {
+		redirectError(newOAuthError(0, "unauthorized_client", ""))
+		return
+	}
+
+	if query.Get("code_challenge") == "" || query.Get("code_challenge_method") != "S256" {
+		redirectError(newOAuthError(0, "invalid_request", "PKCE with the S256 method is required"))
+		return
+	}
+
+	scopes, ok := requestedScopes(query.Get("scope"), client.Scopes)
+	if !ok {
+		redirectError(newOAuthError(0, "invalid_scope", ""))
+		return
+	}
+
+	user, ok, err := m.sessionUser(ctx)
+	if err != nil {
+		log.Error("failed to load the session user", log.WithError(err))
+		redirectError(newOAuthError(0, "server_error", ""))
+		return
+	}
+
+	if !ok {
+		if loginURL := m.settings.OAuthServer.LoginURL; loginURL != "" && r.Method == http.MethodGet {
+			redirectOAuth(w, r, loginURL, url.Values{"return_to": {r.URL.RequestURI()}})
+			return
+		}
+
+		redirectError(newOAuthError(0, "access_denied", "the user is not signed in"))
+		return
+	}
+
+	granted := scopes
+	if !client.Trusted {
+		hook, ok := m.consentHook()
+		if !ok {
+			redirectError(newOAuthError(0, "access_denied", "the client requires the user consent"))
+			return
+		}
+
+		var handled bool
+		granted, handled, err = hook.Consent(w, r, user, lib.AuthorizationRequest{
+			ClientID:    client.ID,
+			ClientName:  client.Name,
+			RedirectURI: redirectURI,
+			Scopes:      scopes,
+		})
+		if errors.Is(err, lib.ErrAccessDenied) {
+			redirectError(newOAuthError(0, "access_denied", ""))
+			return
+		} else if err != nil {
+			log.Error("failed to ask the user consent", log.WithError(err))
+			redirectError(newOAuthError(0, "server_error", ""))
+			return
+		}
+
+		// the consent screen is being shown
+		if !handled {
+			return
+		}
+
+		// the user may only narrow the requested scopes
+		granted = slices.DeleteFunc(slices.Clone(granted), func(scope string) bool {
+			return !slices.Contains(scopes, scope)
+		})
+	}
+
+	code := newRandomToken()
+	err = m.oauthCodes.Set(cacheKeyOAuthCode+hashToken(code), oauthCode{
+		ClientID:      client.ID,
+		RedirectURI:   redirectURI,
+		Subject:       user.LoginID(),
+		Scopes:        granted,
+		CodeChallenge: query.Get("code_challenge"),
+		ExpiresAt:     time.Now().Add(m.settings.OAuthServer.CodeTimeout),
+	}, cache.SetWithTimeout(m.settings.OAuthServer.CodeTimeout))
+	if err != nil {
+		redirectError(newOAuthError(0, "server_error", ""))
+		return
+	}
+
+	redirectOAuth(w, r, redirectURI, url.Values{
+		"code":  {code},
+		"state": {query.Get("state")},
+	})
+}
+
+// oauthTokenHandler issues the tokens of the authorization code, client
+// credentials and refresh token grants
+func (m *Module[U]) oauthTokenHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	if err := r.ParseForm(); err != nil {
+		writeOAuthError(w, newOAuthError(http.StatusBadRequest, "invalid_request", ""))
+		return
+	}
+
+	ctx := r.Context()
+	client, err := m.authenticateClient(r)
+	if err != nil {
+		writeOAuthError(w, err)
+		return
+	}
+
+	var (
+		grantType = r.PostForm.Get("grant_type")
+		grant     tokenGrant
+		subject   string
+	)
+
+	switch grantType {
+	case grantAuthorizationCode, grantClientCredentials, grantRefreshToken:
+		if !client.AllowsGrant(grantType) {
+			writeOAuthError(w, newOAuthError(http.StatusBadRequest, "unauthorized_client", ""))
+			return
+		}
+	default:
+		writeOAuthError(w, newOAuthError(http.StatusBadRequest, "unsupported_grant_type", ""))
+		return
+	}
+
+	switch grantType {
+	case grantAuthorizationCode:
+		subject, grant, err = m.authorizationCodeGrant(client, r.PostForm)
+	case grantClientCredentials:
+		subject, grant, err = clientCredentialsGrant(client, r.PostForm)
+	case grantRefreshToken:
+		subject, grant, err = m.refreshTokenGrant(ctx, client, r.PostForm)
+	}
+
+	if err != nil {
+		writeOAuthError(w, err)
+		return
+	}
+
+	user, err := m.userLoader.UserById(ctx, subject)
+	if err != nil {
+		writeOAuthError(w, newOAuthError(http.StatusBadRequest, "invalid_grant", "unknown user"))
+		return
+	}
+
+	response, err := m.issueTokens(ctx, user, grant)
+	if err != nil {
+		log.Error("failed to issue the oauth tokens", log.WithError(err))
+		writeOAuthError(w, newOAuthError(http.StatusInternalServerError, "server_error", ""))
+		return
+	}
+
+	helper.WriteResponse(w, OAuthTokenResponse{
+		AccessToken:  response.Token,
+		TokenType:    "Bearer",
+		ExpiresIn:    response.ExpiresIn,
+		RefreshToken: response.RefreshToken,
+		Scope:        strings.Join(grant.Scopes, " "),
+	})
+}
+
+func (m *Module[U]) oauthMetadataHandler(w http.ResponseWriter, r *http.Request) {
+	issuer := strings.TrimSuffix(m.settings.OAuthServer.Issuer, "/")
+	helper.WriteResponse(w, map[string]any{
+		"issuer":                                issuer,
+		"authorization_endpoint":                issuer + "/oauth/authorize",
+		"token_endpoint":                        issuer + "/oauth/token",
+		"jwks_uri":                              issuer + "/.well-known/jwks.json",
+		"response_types_supported":              []string{"code"},
+		"grant_types_supported":                 []string{grantAuthorizationCode, grantClientCredentials, grantRefreshToken},
+		"code_challenge_methods_supported":      []string{"S256"},
+		"token_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post", "none"},
+	})
+}
+
+// authorizationCodeGrant redeems the code once, the verifier must match the
+// challenge of the authorization request
+func (m *Module[U]) authorizationCodeGrant(client *OAuthClient, form url.Values) (string, tokenGrant, error) {
+	key := cacheKeyOAuthCode + hashToken(form.Get("code"))
+
+	m.oauthCodesMutex.Lock()
+	cached, err := m.oauthCodes.Get(key)
+	if err == nil {
+		err = m.oauthCodes.Delete(key)
+	}
+	m.oauthCodesMutex.Unlock()
+
+	invalidGrant := newOAuthError(http.StatusBadRequest, "invalid_grant", "")
+	code, ok := cached.(oauthCode)
+	if err != nil || !ok || code.ClientID != client.ID || time.Now().After(code.ExpiresAt) {
+		return "", tokenGrant{}, invalidGrant
+	}
+
+	if redirectURI := form.Get("redirect_uri"); redirectURI != "" && redirectURI != code.RedirectURI {
+		return "", tokenGrant{}, invalidGrant
+	}
+
+	challenge := sha256.Sum256([]byte(form.Get("code_verifier")))
+	expected := base64.RawURLEncoding.EncodeToString(challenge[:])
+	if subtle.ConstantTimeCompare([]byte(expected), []byte(code.CodeChallenge)) != 1 {
+		return "", tokenGrant{}, invalidGrant
+	}
+
+	return code.Subject, tokenGrant{
+		ClientID:            client.ID,
+		Scopes:              code.Scopes,
+		WithoutRefreshToken: !client.AllowsGrant(grantRefreshToken),
+	}, nil
+}
+
+// clientCredentialsGrant lets the confidential clients act as their subject
+// without any user interaction
+func clientCredentialsGrant(client *OAuthClient, form url.Values) (string, tokenGrant, error) {
+	if client.IsPublic() || client.Subject == "" {
+		return "", tokenGrant{}, newOAuthError(http.StatusBadRequest, "unauthorized_client", "the client has no subject to act as")
+	}
+
+	scopes, ok := requestedScopes(form.Get("scope"), client.Scopes)
+	if !ok {
+		return "", tokenGrant{}, newOAuthError(http.StatusBadRequest, "invalid_scope", "")
+	}
+
+	return client.Subject, tokenGrant{
+		ClientID:            client.ID,
+		Scopes:              scopes,
+		WithoutRefreshToken: true,
+	}, nil
+}
+
+// refreshTokenGrant rotates the refresh token of the client, the scopes may
+// only be narrowed
+func (m *Module[U]) refreshTokenGrant(ctx context.Context, client *OAuthClient, form url.Values) (string, tokenGrant, error) {
+	stored, err := m.redeemRefreshToken(ctx, form.Get("refresh_token"))
+	if errors.Is(err, ErrInvalidRefreshToken) || (err == nil && stored.ClientID != client.ID) {
+		return "", tokenGrant{}, newOAuthError(http.StatusBadRequest, "invalid_grant", "")
+	} else if err != nil {
+		return "", tokenGrant{}, err
+	}
+
+	// the scopes removed from the client are dropped from the grant
+	allowed := slices.DeleteFunc(slices.Clone(stored.Scopes), func(scope string) bool {
+		return !slices.Contains(client.Scopes, scope)
+	})
+
+	scopes, ok := requestedScopes(form.Get("scope"), allowed)
+	if !ok {
+		return "", tokenGrant{}, newOAuthError(http.StatusBadRequest, "invalid_scope", "")
+	}
+
+	return stored.Subject, tokenGrant{
+		Family:   stored.Family,
+		ClientID: client.ID,
+		Scopes:   scopes,
+	}, nil
+}
+
+// authenticateClient verifies the client using either the HTTP basic or the
+// form credentials, the public clients only send their ID
+func (m *Module[U]) authenticateClient(r *http.Request) (*OAuthClient, error) {
+	id, secret, basic := r.BasicAuth()
+	if basic {
+		id, _ = url.QueryUnescape(id)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		id = r.PostForm.Get("client_id")
+		secret = r.PostForm.Get("client_secret")
+	}
+
+	invalidClient := newOAuthError(http.StatusUnauthorized, "invalid_client", "")
+	client, err := m.oauthStore.GetClient(r.Context(), id)
+	if errors.Is(err, ErrOAuthClientNotFound) {
+		return nil, invalidClient
+	} else if err != nil {
+		return nil, err
+	}
+
+	if client.IsPublic() {
+		if secret != "" {
+			return nil, invalidClient
+		}
+
+		return client, nil
+	}
+
+	if subtle.ConstantTimeCompare([]byte(hashToken(secret)), []byte(client.SecretHash)) != 1 {
+		return nil, invalidClient
+	}
+
+	return client, nil
+}
+
+// sessionUser loads the user signed in to the current session
+func (m *Module[U]) sessionUser(ctx context.Context) (U, bool, error) {
+	var empty U
+	id := session.UserID(ctx)
+	if id == "" {
+		return empty, false, nil
+	}
+
+	user, err := m.userLoader.UserById(ctx, id)
+	if err != nil {
+		return empty, false, err
+	}
+
+	return user, true, nil
+}
+
+func (m *Module[U]) consentHook() (lib.ConsentHook[U], bool) {
+	for _, hook := range m.hooks {
+		if consentHook, ok := hook.(lib.ConsentHook[U]); ok {
+			return consentHook, true
+		}
+	}
+
+	return nil, false
+}
+
+// requestedScopes parses the space separated scopes which must be a subset
+// of the allowed ones, all of the allowed scopes are requested when empty
+func requestedScopes(raw string, allowed []string) ([]string, bool) {
+	scopes := strings.Fields(raw)
+	if len(scopes) == 0 {
+		return slices.Clone(allowed), true
+	}
+
+	for _, scope := range scopes {
+		if !slices.Contains(allowed, scope) {
+			return nil, false
+		}
+	}
+
+	return scopes, true
+}
+
+// tokenScopes returns the scopes restricting the token, the tokens of the
+// login aren't restricted
+func tokenScopes(t *token.Token) ([]string, bool) {
+	scope, ok := token.Claim[string](t, claimScope)
+	if !ok {
+		return nil, false
+	}
+
+	return strings.Fields(scope), true
+}
+
+func redirectOAuth(w http.ResponseWriter, r *http.Request, target string, values url.Values) {
+	parsed, err := url.Parse(target)
+	if err != nil {
+		helper.WriteResponse(w, err)
+		return
+	}
+
+	query := parsed.Query()
+	for name, value := range values {
+		if len(value) > 0 && value[0] != "" {
+			query[name] = value
+		}
+	}
+
+	parsed.RawQuery = query.Encode()
+	http.Redirect(w, r, parsed.String(), http.StatusFound)
+}
+
+func writeOAuthError(w http.ResponseWriter, err error) {
+	var oauthErr *oauthError
+	if !errors.As(err, &oauthErr) {
+		log.Error("oauth request failed", log.WithError(err))
+		oauthErr = newOAuthError(http.StatusInternalServerError, "server_error", "")
+	}
+
+	if oauthErr.code == "invalid_client" {
+		w.Header().Set("WWW-Authenticate", `Basic realm="oauth"`)
+	}
+
+	body := map[string]string{"error": oauthErr.code}
+	if oauthErr.description != "" {
+		body["error_description"] = oauthErr.description
+	}
+
+	helper.WriteResponse(w, body, helper.ResponseWithStatus(oauthErr.status))
+}
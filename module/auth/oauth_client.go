@@ -0,0 +1,205 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/euiko/webapp/core"
+	"github.com/euiko/webapp/module/auth/lib"
+	"github.com/euiko/webapp/module/rbac/lib/role"
+	"github.com/euiko/webapp/pkg/helper"
+	"github.com/go-chi/chi/v5"
+)
+
+type (
+	CreateOAuthClientPayload struct {
+		Name         string   `json:"name" validate:"required"`
+		RedirectURIs []string `json:"redirect_uris"`
+		GrantTypes   []string `json:"grant_types" validate:"required"`
+		Scopes       []string `json:"scopes"`
+		Subject      string   `json:"subject"`
+		// Public clients, e.g. SPA, have no secret
+		Public  bool `json:"public"`
+		Trusted bool `json:"trusted"`
+	}
+
+	OAuthClientResponse struct {
+		ID           string    `json:"client_id"`
+		Name         string    `json:"name"`
+		RedirectURIs []string  `json:"redirect_uris"`
+		GrantTypes   []string  `json:"grant_types"`
+		Scopes       []string  `json:"scopes"`
+		Subject      string    `json:"subject,omitempty"`
+		Public       bool      `json:"public"`
+		Trusted      bool      `json:"trusted"`
+		CreatedAt    time.Time `json:"created_at"`
+	}
+
+	// CreateOAuthClientResponse contains the plain secret, it is only shown
+	// once
+	CreateOAuthClientResponse struct {
+		OAuthClientResponse
+		Secret string `json:"client_secret,omitempty"`
+	}
+)
+
+var (
+	ErrInvalidOAuthClient = errors.New("invalid oauth client")
+)
+
+// oauthClientRoute registers the endpoints to register the oauth clients
+func (m *Module[U]) oauthClientRoute(r core.Router) {
+	if !m.settings.OAuthServer.Enabled {
+		return
+	}
+
+	r.Group(func(r core.Router) {
+		r.Use(m.Middleware())
+		r.Method("POST", "/auth/oauth/clients", role.Handler(lib.PermissionManageOAuthClients, http.HandlerFunc(m.createOAuthClientHandler)))
+		r.Method("GET", "/auth/oauth/clients", role.Handler(lib.PermissionManageOAuthClients, http.HandlerFunc(m.listOAuthClientsHandler)))
+		r.Method("DELETE", "/auth/oauth/clients/{id}", role.Handler(lib.PermissionManageOAuthClients, http.HandlerFunc(m.deleteOAuthClientHandler)))
+	})
+}
+
+// CreateOAuthClient registers the client with a generated ID, the returned
+// plain secret is empty for the public clients and never stored
+func (m *Module[U]) CreateOAuthClient(ctx context.Context, client OAuthClient, public bool) (*OAuthClient, string, error) {
+	if m.oauthStore == nil {
+		return nil, "", errors.New("oauth server is not enabled")
+	}
+
+	if err := validateOAuthClient(client, public); err != nil {
+		return nil, "", err
+	}
+
+	id := make([]byte, 16)
+	_, _ = rand.Read(id)
+	client.ID = hex.EncodeToString(id)
+	client.CreatedAt = time.Now()
+	if client.Scopes == nil {
+		client.Scopes = []string{}
+	}
+
+	var secret string
+	if !public {
+		secret = newRandomToken()
+		client.SecretHash = hashToken(secret)
+	}
+
+	if err := m.oauthStore.SaveClient(ctx, client); err != nil {
+		return nil, "", err
+	}
+
+	return &client, secret, nil
+}
+
+func validateOAuthClient(client OAuthClient, public bool) error {
+	if len(client.GrantTypes) == 0 {
+		return fmt.Errorf("%w: at least one grant type is required", ErrInvalidOAuthClient)
+	}
+
+	for _, grantType := range client.GrantTypes {
+		switch grantType {
+		case grantAuthorizationCode, grantRefreshToken:
+		case grantClientCredentials:
+			if public || client.Subject == "" {
+				return fmt.Errorf("%w: client credentials requires a confidential client with a subject", ErrInvalidOAuthClient)
+			}
+		default:
+			return fmt.Errorf("%w: unsupported grant type %s", ErrInvalidOAuthClient, grantType)
+		}
+	}
+
+	if client.AllowsGrant(grantAuthorizationCode) && len(client.RedirectURIs) == 0 {
+		return fmt.Errorf("%w: authorization code requires a redirect uri", ErrInvalidOAuthClient)
+	}
+
+	for _, redirectURI := range client.RedirectURIs {
+		parsed, err := url.Parse(redirectURI)
+		if err != nil || !parsed.IsAbs() || parsed.Fragment != "" {
+			return fmt.Errorf("%w: redirect uri must be absolute without a fragment: %s", ErrInvalidOAuthClient, redirectURI)
+		}
+	}
+
+	return nil
+}
+
+func (m *Module[U]) createOAuthClientHandler(w http.ResponseWriter, r *http.Request) {
+	var payload CreateOAuthClientPayload
+	if err := helper.DecodeRequestBody(r, &payload); err != nil {
+		helper.WriteResponse(w, err)
+		return
+	}
+
+	client, secret, err := m.CreateOAuthClient(r.Context(), OAuthClient{
+		Name:         payload.Name,
+		RedirectURIs: payload.RedirectURIs,
+		GrantTypes:   payload.GrantTypes,
+		Scopes:       payload.Scopes,
+		Subject:      payload.Subject,
+		Trusted:      payload.Trusted,
+	}, payload.Public)
+	if errors.Is(err, ErrInvalidOAuthClient) {
+		helper.WriteResponse(w, err, helper.ResponseWithStatus(http.StatusBadRequest))
+		return
+	} else if err != nil {
+		helper.WriteResponse(w, err)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	helper.WriteResponse(w, CreateOAuthClientResponse{
+		OAuthClientResponse: toOAuthClientResponse(*client),
+		Secret:              secret,
+	}, helper.ResponseWithStatus(http.StatusCreated))
+}
+
+func (m *Module[U]) listOAuthClientsHandler(w http.ResponseWriter, r *http.Request) {
+	clients, err := m.oauthStore.ListClients(r.Context())
+	if err != nil {
+		helper.WriteResponse(w, err)
+		return
+	}
+
+	response := make([]OAuthClientResponse, len(clients))
+	for i, client := range clients {
+		response[i] = toOAuthClientResponse(client)
+	}
+
+	helper.WriteResponse(w, response)
+}
+
+func (m *Module[U]) deleteOAuthClientHandler(w http.ResponseWriter, r *http.Request) {
+	err := m.oauthStore.DeleteClient(r.Context(), chi.URLParam(r, "id"))
+	if errors.Is(err, ErrOAuthClientNotFound) {
+		helper.WriteResponse(w, err, helper.ResponseWithStatus(http.StatusNotFound))
+		return
+	} else if err != nil {
+		helper.WriteResponse(w, err)
+		return
+	}
+
+	helper.WriteResponse(w, map[string]interface{}{
+		"message": "oauth client deleted",
+	})
+}
+
+func toOAuthClientResponse(client OAuthClient) OAuthClientResponse {
+	return OAuthClientResponse{
+		ID:           client.ID,
+		Name:         client.Name,
+		RedirectURIs: client.RedirectURIs,
+		GrantTypes:   client.GrantTypes,
+		Scopes:       client.Scopes,
+		Subject:      client.Subject,
+		Public:       client.IsPublic(),
+		Trusted:      client.Trusted,
+		CreatedAt:    client.CreatedAt,
+	}
+}
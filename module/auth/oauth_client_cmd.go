@@ -0,0 +1,123 @@
+package auth
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/euiko/webapp/db"
+	"github.com/spf13/cobra"
+)
+
+func (m *Module[U]) oauthClientsCmd() *cobra.Command {
+	var dbOpened bool
+
+	cmd := &cobra.Command{
+		Use:   "oauth-clients",
+		Short: "Manage the clients of the oauth authorization server",
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			var err error
+			dbOpened, err = m.openCommandStore(m.settings.OAuthServer.ClientStore)
+			if err != nil {
+				return err
+			}
+
+			m.oauthStore, err = m.oauthStoreFactory(&m.settings)
+			return err
+		},
+		PersistentPostRunE: func(cmd *cobra.Command, args []string) error {
+			if dbOpened {
+				return db.Close()
+			}
+
+			return nil
+		},
+	}
+
+	cmd.AddCommand(m.createOAuthClientCmd())
+	cmd.AddCommand(m.listOAuthClientsCmd())
+	cmd.AddCommand(m.deleteOAuthClientCmd())
+	return cmd
+}
+
+func (m *Module[U]) createOAuthClientCmd() *cobra.Command {
+	var (
+		client OAuthClient
+		public bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "create",
+		Short: "Register a client, the secret is only printed once",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			created, secret, err := m.CreateOAuthClient(cmd.Context(), client, public)
+			if err != nil {
+				return err
+			}
+
+			fmt.Println("client id:", created.ID)
+			if secret != "" {
+				fmt.Println("client secret:", secret)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&client.Name, "name", "n", "", "Name of the client shown on the consent screen")
+	cmd.Flags().StringSliceVar(&client.RedirectURIs, "redirect-uri", nil, "Allowed redirect URIs of the authorization code grant")
+	cmd.Flags().StringSliceVar(&client.GrantTypes, "grant", []string{grantAuthorizationCode, grantRefreshToken}, "Allowed grant types")
+	cmd.Flags().StringSliceVar(&client.Scopes, "scope", nil, "Scopes the client may be granted, e.g. admin:manage-sessions, admin:* or *")
+	cmd.Flags().StringVarP(&client.Subject, "subject", "s", "", "Login ID of the user the client acts as on the client credentials grant")
+	cmd.Flags().BoolVar(&client.Trusted, "trusted", false, "Skip the user consent, only for the first party clients")
+	cmd.Flags().BoolVar(&public, "public", false, "Create a public client without secret, e.g. a SPA")
+	_ = cmd.MarkFlagRequired("name")
+	return cmd
+}
+
+func (m *Module[U]) listOAuthClientsCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "list",
+		Short: "List the registered clients",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			clients, err := m.oauthStore.ListClients(cmd.Context())
+			if err != nil {
+				return err
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "ID\tNAME\tGRANTS\tSCOPES\tPUBLIC\tTRUSTED\tCREATED AT")
+			for _, client := range clients {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%t\t%t\t%s\n",
+					client.ID,
+					client.Name,
+					strings.Join(client.GrantTypes, ","),
+					strings.Join(client.Scopes, ","),
+					client.IsPublic(),
+					client.Trusted,
+					formatKeyTime(client.CreatedAt),
+				)
+			}
+
+			return w.Flush()
+		},
+	}
+}
+
+func (m *Module[U]) deleteOAuthClientCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "delete [ID]",
+		Short: "Delete the client, its issued access tokens stay valid until expired",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := m.oauthStore.DeleteClient(cmd.Context(), args[0]); err != nil {
+				return err
+			}
+
+			fmt.Println("deleted oauth client", args[0])
+			return nil
+		},
+	}
+}
@@ -0,0 +1,296 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/euiko/webapp/db/cache"
+	"github.com/euiko/webapp/db/sqldb"
+	"github.com/euiko/webapp/module/auth/internal/schema"
+)
+
+type (
+	// OAuthClient is an application authorized to access the accounts of the
+	// users, the public clients have no secret and must use PKCE
+	OAuthClient struct {
+		ID           string
+		SecretHash   string
+		Name         string
+		RedirectURIs []string
+		GrantTypes   []string
+		// Scopes are the maximum scopes the client may be granted
+		Scopes []string
+		// Subject is the user the client acts as on the client credentials
+		// grant
+		Subject string
+		// Trusted clients, e.g. our own SPA, skip the consent
+		Trusted   bool
+		CreatedAt time.Time
+	}
+
+	OAuthClientStore interface {
+		SaveClient(ctx context.Context, client OAuthClient) error
+		GetClient(ctx context.Context, id string) (*OAuthClient, error)
+		ListClients(ctx context.Context) ([]OAuthClient, error)
+		DeleteClient(ctx context.Context, id string) error
+	}
+
+	OAuthClientStoreFactory func(s *Settings) (OAuthClientStore, error)
+
+	ormOAuthClientStore struct {
+		db sqldb.OrmDB
+	}
+
+	cacheOAuthClientStore struct {
+		mutex sync.Mutex
+		cache cache.Cache
+	}
+)
+
+const (
+	cacheKeyOAuthClients = "auth:oauth-clients"
+
+	grantAuthorizationCode = "authorization_code"
+	grantClientCredentials = "client_credentials"
+	grantRefreshToken      = "refresh_token"
+)
+
+var (
+	ErrOAuthClientNotFound = errors.New("oauth client not found")
+)
+
+func NewOrmOAuthClientStore(db sqldb.OrmDB) OAuthClientStore {
+	return &ormOAuthClientStore{
+		db: db,
+	}
+}
+
+// NewCacheOAuthClientStore creates a client store backed by the cache, the
+// clients are lost on restart so it is only suitable for development
+func NewCacheOAuthClientStore(c cache.Cache) OAuthClientStore {
+	return &cacheOAuthClientStore{
+		cache: c,
+	}
+}
+
+func defaultOAuthClientStoreFactory(s *Settings) (OAuthClientStore, error) {
+	switch s.OAuthServer.ClientStore {
+	case "cache":
+		return NewCacheOAuthClientStore(cache.InMemory()), nil
+	case "sql":
+		return NewOrmOAuthClientStore(sqldb.ORM()), nil
+	}
+
+	return nil, errors.New("invalid oauth client store (valid stores: cache, sql)")
+}
+
+// IsPublic returns true when the client can't keep a secret, e.g. a SPA or a
+// mobile application
+func (c OAuthClient) IsPublic() bool {
+	return c.SecretHash == ""
+}
+
+// AllowsGrant returns true when the client is registered for the grant type
+func (c OAuthClient) AllowsGrant(grantType string) bool {
+	return slices.Contains(c.GrantTypes, grantType)
+}
+
+// SaveClient implements OAuthClientStore.
+func (s *ormOAuthClientStore) SaveClient(ctx context.Context, client OAuthClient) error {
+	model := schema.OAuthClient{
+		ID:           client.ID,
+		SecretHash:   client.SecretHash,
+		Name:         client.Name,
+		RedirectURIs: client.RedirectURIs,
+		GrantTypes:   client.GrantTypes,
+		Scopes:       client.Scopes,
+		Subject:      client.Subject,
+		Trusted:      client.Trusted,
+		CreatedAt:    client.CreatedAt,
+	}
+
+	_, err := s.db.NewInsert().
+		Model(&model).
+		On("CONFLICT (id) DO UPDATE").
+		Set("secret_hash = EXCLUDED.secret_hash").
+		Set("name = EXCLUDED.name").
+		Set("redirect_uris = EXCLUDED.redirect_uris").
+		Set("grant_types = EXCLUDED.grant_types").
+		Set("scopes = EXCLUDED.scopes").
+		Set("subject = EXCLUDED.subject").
+		Set("trusted = EXCLUDED.trusted").
+		Exec(ctx)
+	return err
+}
+
+// GetClient implements OAuthClientStore.
+func (s *ormOAuthClientStore) GetClient(ctx context.Context, id string) (*OAuthClient, error) {
+	var model schema.OAuthClient
+	err := s.db.NewSelect().
+		Model(&model).
+		Where("id = ?", id).
+		Limit(1).
+		Scan(ctx)
+	if sqldb.IsNoRows(err) {
+		return nil, ErrOAuthClientNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	client := toOAuthClient(model)
+	return &client, nil
+}
+
+// ListClients implements OAuthClientStore.
+func (s *ormOAuthClientStore) ListClients(ctx context.Context) ([]OAuthClient, error) {
+	var models []schema.OAuthClient
+	err := s.db.NewSelect().
+		Model(&models).
+		Order("created_at DESC").
+		Scan(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	clients := make([]OAuthClient, len(models))
+	for i, model := range models {
+		clients[i] = toOAuthClient(model)
+	}
+
+	return clients, nil
+}
+
+// DeleteClient implements OAuthClientStore.
+func (s *ormOAuthClientStore) DeleteClient(ctx context.Context, id string) error {
+	result, err := s.db.NewDelete().
+		Model((*schema.OAuthClient)(nil)).
+		Where("id = ?", id).
+		Exec(ctx)
+	if err != nil {
+		return err
+	}
+
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if deleted == 0 {
+		return ErrOAuthClientNotFound
+	}
+
+	return nil
+}
+
+// SaveClient implements OAuthClientStore.
+func (s *cacheOAuthClientStore) SaveClient(ctx context.Context, client OAuthClient) error {
+	return s.update(func(clients map[string]OAuthClient) error {
+		clients[client.ID] = client
+		return nil
+	})
+}
+
+// GetClient implements OAuthClientStore.
+func (s *cacheOAuthClientStore) GetClient(ctx context.Context, id string) (*OAuthClient, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	clients, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+
+	client, ok := clients[id]
+	if !ok {
+		return nil, ErrOAuthClientNotFound
+	}
+
+	return &client, nil
+}
+
+// ListClients implements OAuthClientStore.
+func (s *cacheOAuthClientStore) ListClients(ctx context.Context) ([]OAuthClient, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	clients, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+
+	list := make([]OAuthClient, 0, len(clients))
+	for _, client := range clients {
+		list = append(list, client)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].CreatedAt.After(list[j].CreatedAt)
+	})
+
+	return list, nil
+}
+
+// DeleteClient implements OAuthClientStore.
+func (s *cacheOAuthClientStore) DeleteClient(ctx context.Context, id string) error {
+	return s.update(func(clients map[string]OAuthClient) error {
+		if _, ok := clients[id]; !ok {
+			return ErrOAuthClientNotFound
+		}
+
+		delete(clients, id)
+		return nil
+	})
+}
+
+func (s *cacheOAuthClientStore) update(fn func(map[string]OAuthClient) error) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	clients, err := s.load()
+	if err != nil {
+		return err
+	}
+
+	if err := fn(clients); err != nil {
+		return err
+	}
+
+	return s.cache.Set(cacheKeyOAuthClients, clients)
+}
+
+// load returns a copy of the stored clients so it can be modified freely
+func (s *cacheOAuthClientStore) load() (map[string]OAuthClient, error) {
+	clients := make(map[string]OAuthClient)
+
+	cached, err := s.cache.Get(cacheKeyOAuthClients)
+	if errors.Is(err, cache.ErrKeyNotFound) {
+		return clients, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	stored, _ := cached.(map[string]OAuthClient)
+	for id, client := range stored {
+		clients[id] = client
+	}
+
+	return clients, nil
+}
+
+func toOAuthClient(model schema.OAuthClient) OAuthClient {
+	return OAuthClient{
+		ID:           model.ID,
+		SecretHash:   model.SecretHash,
+		Name:         model.Name,
+		RedirectURIs: model.RedirectURIs,
+		GrantTypes:   model.GrantTypes,
+		Scopes:       model.Scopes,
+		Subject:      model.Subject,
+		Trusted:      model.Trusted,
+		CreatedAt:    model.CreatedAt,
+	}
+}
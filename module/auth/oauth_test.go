@@ -0,0 +1,248 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/euiko/webapp/pkg/session"
+	"github.com/go-chi/chi/v5"
+)
+
+const testRedirectURI = "https://client.example.com/callback"
+
+// cookieSessionMiddleware keeps the sessions in the cookie as the default
+// session settings
+var cookieSessionMiddleware = session.Middleware(func(w http.ResponseWriter, r *http.Request) session.Encoding {
+	return session.NewHTTPCookieEncoding(w, r)
+}, nil)
+
+// newOAuthTestServer serves the authorization server with the session cookie
+// of alice, the returned client is trusted so the consent is skipped
+func newOAuthTestServer(t *testing.T) (http.Handler, *OAuthClient, string) {
+	t.Helper()
+
+	m := NewModule[*testUser](nil, testUserLoader{})
+	m.settings.Enabled = true
+	m.settings.OAuthServer.Enabled = true
+	m.settings.OAuthServer.ClientStore = "cache"
+
+	ctx := context.Background()
+	if err := m.Init(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.BeforeStart(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	client, secret, err := m.CreateOAuthClient(ctx, OAuthClient{
+		Name:         "client",
+		RedirectURIs: []string{testRedirectURI},
+		GrantTypes:   []string{grantAuthorizationCode, grantRefreshToken},
+		Scopes:       []string{"profile", "orders"},
+		Trusted:      true,
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := chi.NewRouter()
+	r.Use(cookieSessionMiddleware)
+	r.Post("/auth/login", m.loginHandler)
+	r.Get("/oauth/authorize", m.authorizeHandler)
+	r.Post("/oauth/token", m.oauthTokenHandler)
+
+	cookies := login(r, "alice", "secret").Result().Cookies()
+	if len(cookies) == 0 {
+		t.Fatal("expected the session cookie")
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+
+		r.ServeHTTP(w, req)
+	}), client, secret
+}
+
+func codeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// authorize returns the redirect query of the authorization request
+func authorize(t *testing.T, handler http.Handler, clientID string, query url.Values) url.Values {
+	t.Helper()
+
+	query.Set("client_id", clientID)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/oauth/authorize?"+query.Encode(), nil))
+	if rec.Code != http.StatusFound {
+		t.Fatalf("expected redirect, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	location, err := url.Parse(rec.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return location.Query()
+}
+
+func exchange(handler http.Handler, client *OAuthClient, secret string, form url.Values) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/oauth/token", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(client.ID, secret)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestOAuthAuthorizationCode(t *testing.T) {
+	handler, client, secret := newOAuthTestServer(t)
+
+	values := authorize(t, handler, client.ID, url.Values{
+		"response_type":         {"code"},
+		"redirect_uri":          {testRedirectURI},
+		"scope":                 {"profile"},
+		"state":                 {"xyz"},
+		"code_challenge":        {codeChallenge("verifier")},
+		"code_challenge_method": {"S256"},
+	})
+	if values.Get("code") == "" || values.Get("state") != "xyz" {
+		t.Fatalf("expected the code and the state, got %v", values)
+	}
+
+	form := url.Values{
+		"grant_type":    {grantAuthorizationCode},
+		"code":          {values.Get("code")},
+		"redirect_uri":  {testRedirectURI},
+		"code_verifier": {"verifier"},
+	}
+	rec := exchange(handler, client, secret, form)
+	if rec.Code != http.StatusOK || rec.Header().Get("Cache-Control") != "no-store" {
+		t.Fatalf("expected the tokens, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var response OAuthTokenResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if response.AccessToken == "" || response.RefreshToken == "" || response.Scope != "profile" {
+		t.Fatalf("expected the tokens of the granted scope, got %+v", response)
+	}
+
+	t.Run("code reuse", func(t *testing.T) {
+		if rec := exchange(handler, client, secret, form); rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "invalid_grant") {
+			t.Fatalf("expected invalid_grant, got %d: %s", rec.Code, rec.Body.String())
+		}
+	})
+
+	t.Run("refresh", func(t *testing.T) {
+		refresh := url.Values{
+			"grant_type":    {grantRefreshToken},
+			"refresh_token": {response.RefreshToken},
+		}
+		if rec := exchange(handler, client, secret, refresh); rec.Code != http.StatusOK {
+			t.Fatalf("expected the rotated tokens, got %d: %s", rec.Code, rec.Body.String())
+		}
+
+		// the rotated refresh token can't be redeemed again
+		if rec := exchange(handler, client, secret, refresh); rec.Code != http.StatusBadRequest {
+			t.Fatalf("expected the reused refresh token to be rejected, got %d", rec.Code)
+		}
+	})
+}
+
+func TestOAuthAuthorizationCodeRejected(t *testing.T) {
+	handler, client, secret := newOAuthTestServer(t)
+
+	newCode := func(t *testing.T) string {
+		return authorize(t, handler, client.ID, url.Values{
+			"response_type":         {"code"},
+			"redirect_uri":          {testRedirectURI},
+			"code_challenge":        {codeChallenge("verifier")},
+			"code_challenge_method": {"S256"},
+		}).Get("code")
+	}
+
+	cases := map[string]struct {
+		form   func(code string) url.Values
+		secret string
+		status int
+	}{
+		"pkce mismatch": {
+			form: func(code string) url.Values {
+				return url.Values{"code": {code}, "code_verifier": {"forged"}}
+			},
+			secret: secret,
+			status: http.StatusBadRequest,
+		},
+		"pkce missing": {
+			form: func(code string) url.Values {
+				return url.Values{"code": {code}}
+			},
+			secret: secret,
+			status: http.StatusBadRequest,
+		},
+		"redirect uri mismatch": {
+			form: func(code string) url.Values {
+				return url.Values{"code": {code}, "code_verifier": {"verifier"}, "redirect_uri": {"https://evil.example.com/callback"}}
+			},
+			secret: secret,
+			status: http.StatusBadRequest,
+		},
+		"invalid client secret": {
+			form: func(code string) url.Values {
+				return url.Values{"code": {code}, "code_verifier": {"verifier"}}
+			},
+			secret: "forged",
+			status: http.StatusUnauthorized,
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			form := c.form(newCode(t))
+			form.Set("grant_type", grantAuthorizationCode)
+			if rec := exchange(handler, client, c.secret, form); rec.Code != c.status {
+				t.Fatalf("expected %d, got %d: %s", c.status, rec.Code, rec.Body.String())
+			}
+		})
+	}
+
+	t.Run("unregistered redirect uri", func(t *testing.T) {
+		query := url.Values{
+			"client_id":             {client.ID},
+			"response_type":         {"code"},
+			"redirect_uri":          {"https://evil.example.com/callback"},
+			"code_challenge":        {codeChallenge("verifier")},
+			"code_challenge_method": {"S256"},
+		}
+
+		// the error is never redirected to the unverified URI
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/oauth/authorize?"+query.Encode(), nil))
+		if rec.Code != http.StatusBadRequest || rec.Header().Get("Location") != "" {
+			t.Fatalf("expected 400 without redirect, got %d", rec.Code)
+		}
+	})
+
+	t.Run("pkce required", func(t *testing.T) {
+		values := authorize(t, handler, client.ID, url.Values{
+			"response_type": {"code"},
+			"redirect_uri":  {testRedirectURI},
+		})
+		if values.Get("code") != "" || values.Get("error") != "invalid_request" {
+			t.Fatalf("expected invalid_request, got %v", values)
+		}
+	})
+}
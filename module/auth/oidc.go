@@ -0,0 +1,419 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/euiko/webapp/core"
+	"github.com/euiko/webapp/module/auth/lib"
+	"github.com/euiko/webapp/pkg/helper"
+	"github.com/euiko/webapp/pkg/log"
+	"github.com/euiko/webapp/pkg/session"
+	"github.com/euiko/webapp/pkg/token"
+	"github.com/go-chi/chi/v5"
+	"github.com/lestrrat-go/jwx/v3/jwa"
+	"github.com/lestrrat-go/jwx/v3/jws"
+)
+
+type (
+	// oidcProvider is the client of an external identity provider, the
+	// discovery document is fetched on the first sign in
+	oidcProvider struct {
+		name     string
+		settings OIDCProviderSettings
+		client   *http.Client
+
+		mutex     sync.Mutex
+		discovery *oidcDiscovery
+		keys      *token.RemoteKeySet
+	}
+
+	oidcDiscovery struct {
+		Issuer                string `json:"issuer"`
+		AuthorizationEndpoint string `json:"authorization_endpoint"`
+		TokenEndpoint         string `json:"token_endpoint"`
+		JWKSURI               string `json:"jwks_uri"`
+	}
+
+	oidcTokenResponse struct {
+		AccessToken string `json:"access_token"`
+		IDToken     string `json:"id_token"`
+		TokenType   string `json:"token_type"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+
+	// oidcFlow is the pending authorization kept in the session between
+	// the login and the callback
+	oidcFlow struct {
+		State     string `mapstructure:"state"`
+		Nonce     string `mapstructure:"nonce"`
+		Verifier  string `mapstructure:"verifier"`
+		ExpiresAt int64  `mapstructure:"expires_at"`
+	}
+)
+
+const (
+	oidcFlowTimeout = 10 * time.Minute
+	oidcHTTPTimeout = 10 * time.Second
+)
+
+var (
+	ErrUnknownProvider   = errors.New("unknown identity provider")
+	ErrInvalidOIDCState  = errors.New("invalid or expired authorization state")
+	ErrInvalidIDToken    = errors.New("invalid id token")
+	ErrIdentityNotMapped = errors.New("the external identity is not allowed to sign in")
+)
+
+func newOIDCProviders(settings map[string]OIDCProviderSettings) (map[string]*oidcProvider, error) {
+	providers := make(map[string]*oidcProvider, len(settings))
+	for name, s := range settings {
+		if s.Issuer == "" || s.ClientID == "" || s.RedirectURL == "" {
+			return nil, fmt.Errorf("oidc provider %s requires the issuer, client_id and redirect_url", name)
+		}
+
+		if len(s.Scopes) == 0 {
+			s.Scopes = []string{"openid", "email", "profile"}
+		}
+
+		providers[name] = &oidcProvider{
+			name:     name,
+			settings: s,
+			client:   &http.Client{Timeout: oidcHTTPTimeout},
+		}
+	}
+
+	return providers, nil
+}
+
+// oidcRoute registers the authorization code flow endpoints of the
+// configured identity providers
+func (m *Module[U]) oidcRoute(r core.Router) {
+	if !m.settings.OIDC.Enabled {
+		return
+	}
+
+	r.Get("/auth/oidc/{provider}/login", m.oidcLoginHandler)
+	r.Get("/auth/oidc/{provider}/callback", m.oidcCallbackHandler)
+}
+
+// oidcLoginHandler redirects the user to the provider, the state, nonce and
+// PKCE verifier are kept in the session to be checked on the callback
+func (m *Module[U]) oidcLoginHandler(w http.ResponseWriter, r *http.Request) {
+	provider, ok := m.oidcProviders[chi.URLParam(r, "provider")]
+	if !ok {
+		helper.WriteResponse(w, ErrUnknownProvider, helper.ResponseWithStatus(http.StatusNotFound))
+		return
+	}
+
+	discovery, err := provider.discover(r.Context())
+	if err != nil {
+		log.Error("failed to discover the identity provider", log.WithField("provider", provider.name), log.WithError(err))
+		helper.WriteResponse(w, err, helper.ResponseWithStatus(http.StatusBadGateway))
+		return
+	}
+
+	flow := oidcFlow{
+		State:     newRandomToken(),
+		Nonce:     newRandomToken(),
+		Verifier:  newRandomToken(),
+		ExpiresAt: time.Now().Add(oidcFlowTimeout).Unix(),
+	}
+
+	// stored as a map so it survives the serialization of the session
+	err = session.Add(r.Context(), provider.sessionKey(), map[string]any{
+		"state":      flow.State,
+		"nonce":      flow.Nonce,
+		"verifier":   flow.Verifier,
+		"expires_at": flow.ExpiresAt,
+	})
+	if err != nil {
+		helper.WriteResponse(w, err)
+		return
+	}
+
+	http.Redirect(w, r, provider.authorizationURL(discovery, flow), http.StatusFound)
+}
+
+// oidcCallbackHandler exchanges the authorization code, verifies the ID
+// token and signs the mapped user in using our own tokens
+func (m *Module[U]) oidcCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	provider, ok := m.oidcProviders[chi.URLParam(r, "provider")]
+	if !ok {
+		helper.WriteResponse(w, ErrUnknownProvider, helper.ResponseWithStatus(http.StatusNotFound))
+		return
+	}
+
+	// the flow is only usable once
+	var flow oidcFlow
+	err := session.Get(ctx, provider.sessionKey(), &flow)
+	session.Delete(ctx, provider.sessionKey())
+
+	query := r.URL.Query()
+	if err != nil || time.Now().Unix() > flow.ExpiresAt ||
+		subtle.ConstantTimeCompare([]byte(query.Get("state")), []byte(flow.State)) != 1 {
+		helper.WriteResponse(w, ErrInvalidOIDCState, helper.ResponseWithStatus(http.StatusUnauthorized))
+		return
+	}
+
+	if errorCode := query.Get("error"); errorCode != "" {
+		helper.WriteResponse(w, fmt.Errorf("identity provider error: %s", errorCode), helper.ResponseWithStatus(http.StatusUnauthorized))
+		return
+	}
+
+	discovery, err := provider.discover(ctx)
+	if err != nil {
+		helper.WriteResponse(w, err, helper.ResponseWithStatus(http.StatusBadGateway))
+		return
+	}
+
+	tokens, err := provider.exchange(ctx, discovery, query.Get("code"), flow.Verifier)
+	if err != nil {
+		log.Error("failed to exchange the authorization code", log.WithField("provider", provider.name), log.WithError(err))
+		helper.WriteResponse(w, err, helper.ResponseWithStatus(http.StatusUnauthorized))
+		return
+	}
+
+	identity, err := provider.verifyIDToken(ctx, discovery, tokens.IDToken, flow.Nonce)
+	if err != nil {
+		log.Error("failed to verify the id token", log.WithField("provider", provider.name), log.WithError(err))
+		helper.WriteResponse(w, ErrInvalidIDToken, helper.ResponseWithStatus(http.StatusUnauthorized))
+		return
+	}
+
+	user, err := m.mapIdentity(ctx, *identity)
+	if errors.Is(err, ErrIdentityNotMapped) {
+		helper.WriteResponse(w, err, helper.ResponseWithStatus(http.StatusForbidden))
+		return
+	} else if err != nil {
+		helper.WriteResponse(w, err)
+		return
+	}
+
+	// the external identity only replaces the password, not the second step
+	if m.challengeMFA(w, r, user, user.LoginID()) {
+		return
+	}
+
+	m.completeLogin(w, r, user)
+}
+
+// mapIdentity asks the identity hooks for the local user of the identity
+func (m *Module[U]) mapIdentity(ctx context.Context, identity lib.ExternalIdentity) (U, error) {
+	var empty U
+	for _, hook := range m.hooks {
+		identityHook, ok := hook.(lib.IdentityHook[U])
+		if !ok {
+			continue
+		}
+
+		user, ok, err := identityHook.MapIdentity(ctx, identity)
+		if err != nil {
+			return empty, err
+		}
+
+		if ok {
+			return user, nil
+		}
+	}
+
+	return empty, ErrIdentityNotMapped
+}
+
+func (p *oidcProvider) sessionKey() string {
+	return "oidc:" + p.name
+}
+
+// discover fetches the discovery document once, a failed discovery is
+// retried on the next sign in
+func (p *oidcProvider) discover(ctx context.Context) (*oidcDiscovery, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+
+	issuer := strings.TrimSuffix(p.settings.Issuer, "/")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var discovery oidcDiscovery
+	if err := p.do(req, &discovery); err != nil {
+		return nil, err
+	}
+
+	// the issuer must match exactly to prevent the mix-up attacks
+	if strings.TrimSuffix(discovery.Issuer, "/") != issuer {
+		return nil, fmt.Errorf("discovered issuer %s doesn't match %s", discovery.Issuer, p.settings.Issuer)
+	}
+
+	if discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" || discovery.JWKSURI == "" {
+		return nil, errors.New("incomplete discovery document")
+	}
+
+	p.discovery = &discovery
+	p.keys = token.NewRemoteKeySet(discovery.JWKSURI, token.WithHTTPClient(p.client))
+	return p.discovery, nil
+}
+
+func (p *oidcProvider) authorizationURL(discovery *oidcDiscovery, flow oidcFlow) string {
+	challenge := sha256.Sum256([]byte(flow.Verifier))
+	values := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.settings.ClientID},
+		"redirect_uri":          {p.settings.RedirectURL},
+		"scope":                 {strings.Join(p.settings.Scopes, " ")},
+		"state":                 {flow.State},
+		"nonce":                 {flow.Nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+
+	separator := "?"
+	if strings.Contains(discovery.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+
+	return discovery.AuthorizationEndpoint + separator + values.Encode()
+}
+
+// exchange redeems the authorization code at the token endpoint, the
+// client authenticates using the HTTP basic credentials when it has a secret
+func (p *oidcProvider) exchange(ctx context.Context, discovery *oidcDiscovery, code, verifier string) (*oidcTokenResponse, error) {
+	if code == "" {
+		return nil, errors.New("missing authorization code")
+	}
+
+	values := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.settings.RedirectURL},
+		"client_id":     {p.settings.ClientID},
+		"code_verifier": {verifier},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, discovery.TokenEndpoint, strings.NewReader(values.Encode()))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if p.settings.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.settings.ClientID), url.QueryEscape(p.settings.ClientSecret))
+	}
+
+	var response oidcTokenResponse
+	if err := p.do(req, &response); err != nil {
+		return nil, err
+	}
+
+	if response.IDToken == "" {
+		return nil, errors.New("token response without id token")
+	}
+
+	return &response, nil
+}
+
+// verifyIDToken verifies the signature using the provider key set along
+// with the issuer, audience, expiry and nonce
+func (p *oidcProvider) verifyIDToken(ctx context.Context, discovery *oidcDiscovery, raw, nonce string) (*lib.ExternalIdentity, error) {
+	message, err := jws.Parse([]byte(raw))
+	if err != nil || len(message.Signatures()) != 1 {
+		return nil, ErrInvalidIDToken
+	}
+
+	headers := message.Signatures()[0].ProtectedHeaders()
+	algorithm, ok := headers.Algorithm()
+	// the symmetric algorithms would need the client secret as the key
+	if !ok || algorithm.IsSymmetric() || algorithm == jwa.NoSignature() {
+		return nil, fmt.Errorf("%w: unsupported algorithm", ErrInvalidIDToken)
+	}
+
+	var keys []token.Key
+	if kid, ok := headers.KeyID(); ok && kid != "" {
+		key, err := p.keys.Key(ctx, kid)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	} else if keys, err = p.keys.Keys(ctx); err != nil {
+		return nil, err
+	}
+
+	encoding := token.NewJwtEncoding(algorithm,
+		token.JwtWithIssuer(discovery.Issuer),
+		token.JwtWithAudience(p.settings.ClientID),
+	)
+
+	var decoded *token.Token
+	err = token.ErrKeyNotFound
+	for _, key := range keys {
+		if decoded, err = encoding.Decode(key, []byte(raw)); err == nil {
+			break
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	claimedNonce, _ := token.Claim[string](decoded, "nonce")
+	if subtle.ConstantTimeCompare([]byte(claimedNonce), []byte(nonce)) != 1 {
+		return nil, fmt.Errorf("%w: nonce mismatch", ErrInvalidIDToken)
+	}
+
+	identity := lib.ExternalIdentity{
+		Provider: p.name,
+		Subject:  decoded.Subject,
+		Claims:   decoded.Claims,
+	}
+	identity.Email, _ = token.Claim[string](decoded, "email")
+	identity.EmailVerified, _ = token.Claim[bool](decoded, "email_verified")
+	identity.Name, _ = token.Claim[string](decoded, "name")
+
+	return &identity, nil
+}
+
+// do sends the request and decodes the JSON response, the OAuth2 error
+// response is turned into an error
+func (p *oidcProvider) do(req *http.Request, target any) error {
+	req.Header.Set("Accept", "application/json")
+	res, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+
+	if res.StatusCode != http.StatusOK {
+		var oauthErr struct {
+			Error            string `json:"error"`
+			ErrorDescription string `json:"error_description"`
+		}
+		if json.Unmarshal(body, &oauthErr) == nil && oauthErr.Error != "" {
+			return fmt.Errorf("%s: %s", oauthErr.Error, oauthErr.ErrorDescription)
+		}
+
+		return fmt.Errorf("unexpected status %d from %s", res.StatusCode, req.URL.Redacted())
+	}
+
+	return json.Unmarshal(body, target)
+}
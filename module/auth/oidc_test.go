@@ -0,0 +1,305 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/euiko/webapp/module/auth/lib"
+	"github.com/euiko/webapp/pkg/session"
+	"github.com/euiko/webapp/pkg/token"
+	"github.com/go-chi/chi/v5"
+	"github.com/lestrrat-go/jwx/v3/jwa"
+)
+
+type (
+	testUser struct {
+		id string
+	}
+
+	testUserLoader struct{}
+
+	testIdentityHook struct {
+		lib.NopHook[*testUser]
+	}
+
+	// mockIdP is a minimal OpenID provider issuing the ID tokens of a
+	// single user for the authorization codes it hands out
+	mockIdP struct {
+		t      *testing.T
+		server *httptest.Server
+		key    token.Key
+		// nonce overrides the nonce of the ID token when set
+		nonce string
+		// pending authorization requests keyed by the issued code
+		codes map[string]url.Values
+	}
+)
+
+func (u *testUser) MarshalSession() (interface{}, error) { return u.id, nil }
+func (u *testUser) UnmarshalSession(v interface{}) error { u.id, _ = v.(string); return nil }
+func (u *testUser) LoginID() string                      { return u.id }
+func (u *testUser) Name() string                         { return u.id }
+
+// RoleName of the test user is its id, e.g. admin
+func (u *testUser) RoleName() string { return u.id }
+
+func (testUserLoader) UserById(ctx context.Context, id string) (*testUser, error) {
+	return &testUser{id: id}, nil
+}
+
+// LoadUser accepts any password but wrong
+func (testUserLoader) LoadUser(ctx context.Context, id, password string) (*testUser, error) {
+	if password == "wrong" {
+		return nil, errors.New("invalid password")
+	}
+
+	return &testUser{id: id}, nil
+}
+
+// MapIdentity only accepts the verified emails of the example.com domain
+func (testIdentityHook) MapIdentity(ctx context.Context, identity lib.ExternalIdentity) (*testUser, bool, error) {
+	if !identity.EmailVerified || identity.Email != "alice@example.com" {
+		return nil, false, nil
+	}
+
+	return &testUser{id: "alice"}, true, nil
+}
+
+func newMockIdP(t *testing.T) *mockIdP {
+	t.Helper()
+
+	signingKey, err := NewSigningKey("RS256", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := token.ParsePEMKey(signingKey.Material)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	idp := mockIdP{t: t, key: key, codes: make(map[string]url.Values)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 idp.server.URL,
+			"authorization_endpoint": idp.server.URL + "/authorize",
+			"token_endpoint":         idp.server.URL + "/token",
+			"jwks_uri":               idp.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		jwks, err := token.MarshalJWKS("RS256", idp.key)
+		if err != nil {
+			t.Error(err)
+		}
+		w.Write(jwks)
+	})
+	mux.HandleFunc("/token", idp.tokenHandler)
+	idp.server = httptest.NewServer(mux)
+	t.Cleanup(idp.server.Close)
+
+	return &idp
+}
+
+// authorize plays the user consenting at the authorization endpoint
+func (idp *mockIdP) authorize(location string) url.Values {
+	parsed, err := url.Parse(location)
+	if err != nil {
+		idp.t.Fatal(err)
+	}
+
+	query := parsed.Query()
+	code := newRandomToken()
+	idp.codes[code] = query
+	return url.Values{"code": {code}, "state": {query.Get("state")}}
+}
+
+func (idp *mockIdP) tokenHandler(w http.ResponseWriter, r *http.Request) {
+	clientID, secret, ok := r.BasicAuth()
+	if !ok || clientID != "webapp" || secret != "s3cret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	request, ok := idp.codes[r.PostFormValue("code")]
+	delete(idp.codes, r.PostFormValue("code"))
+
+	challenge := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+	if !ok || request.Get("code_challenge") != base64.RawURLEncoding.EncodeToString(challenge[:]) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	nonce := request.Get("nonce")
+	if idp.nonce != "" {
+		nonce = idp.nonce
+	}
+
+	encoding := token.NewJwtEncoding(jwa.RS256(), token.JwtWithIssuer(idp.server.URL), token.JwtWithExpiration(time.Minute))
+	idToken, err := encoding.EncodeToken(idp.key, token.Token{
+		Subject:  "external-alice",
+		Audience: []string{"webapp"},
+		Claims: map[string]any{
+			"nonce":          nonce,
+			"email":          "alice@example.com",
+			"email_verified": request.Get("login_hint") != "unverified",
+		},
+	})
+	if err != nil {
+		idp.t.Error(err)
+	}
+
+	json.NewEncoder(w).Encode(map[string]any{
+		"access_token": "idp-access-token",
+		"id_token":     string(idToken),
+		"token_type":   "Bearer",
+		"expires_in":   60,
+	})
+}
+
+func newOIDCTestModule(t *testing.T, idp *mockIdP, configure ...func(*Settings)) http.Handler {
+	t.Helper()
+
+	m := NewModule[*testUser](nil, testUserLoader{}, WithHooks[*testUser](testIdentityHook{}))
+	m.settings.Enabled = true
+	m.settings.OIDC = OIDCSettings{
+		Enabled: true,
+		Providers: map[string]OIDCProviderSettings{
+			"mock": {
+				Issuer:       idp.server.URL,
+				ClientID:     "webapp",
+				ClientSecret: "s3cret",
+				RedirectURL:  "http://localhost/api/auth/oidc/mock/callback",
+			},
+		},
+	}
+	for _, fn := range configure {
+		fn(&m.settings)
+	}
+
+	ctx := context.Background()
+	if err := m.Init(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.BeforeStart(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// a single session shared by the requests acts as the browser cookie
+	current := session.New()
+	r := chi.NewRouter()
+	r.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(session.WithContext(r.Context(), current)))
+		})
+	})
+	r.Get("/auth/oidc/{provider}/login", m.oidcLoginHandler)
+	r.Get("/auth/oidc/{provider}/callback", m.oidcCallbackHandler)
+	return r
+}
+
+func oidcLogin(t *testing.T, handler http.Handler, idp *mockIdP, loginHint string) url.Values {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/auth/oidc/mock/login", nil))
+	if rec.Code != http.StatusFound {
+		t.Fatalf("expected redirect, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	location := rec.Header().Get("Location")
+	if loginHint != "" {
+		location += "&login_hint=" + loginHint
+	}
+
+	return idp.authorize(location)
+}
+
+func oidcCallback(handler http.Handler, values url.Values) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/auth/oidc/mock/callback?"+values.Encode(), nil))
+	return rec
+}
+
+func TestOIDCLogin(t *testing.T) {
+	idp := newMockIdP(t)
+	handler := newOIDCTestModule(t, idp)
+
+	rec := oidcCallback(handler, oidcLogin(t, handler, idp, ""))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected login to succeed, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var response LoginResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if response.Token == "" || response.RefreshToken == "" {
+		t.Fatalf("expected tokens, got %+v", response)
+	}
+}
+
+func TestOIDCLoginMFA(t *testing.T) {
+	idp := newMockIdP(t)
+	handler := newOIDCTestModule(t, idp, func(s *Settings) {
+		s.MFA.Enabled = true
+		s.MFA.Store = "cache"
+		s.MFA.EnforcedRoles = []string{"alice"}
+	})
+
+	rec := oidcCallback(handler, oidcLogin(t, handler, idp, ""))
+	var challenge MFAChallengeResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &challenge); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusOK || !challenge.EnrollmentRequired || challenge.ChallengeToken == "" {
+		t.Fatalf("expected the mfa challenge instead of the tokens, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestOIDCLoginRejected(t *testing.T) {
+	idp := newMockIdP(t)
+	handler := newOIDCTestModule(t, idp)
+
+	t.Run("state mismatch", func(t *testing.T) {
+		values := oidcLogin(t, handler, idp, "")
+		values.Set("state", "forged")
+		if rec := oidcCallback(handler, values); rec.Code != http.StatusUnauthorized {
+			t.Fatalf("expected 401, got %d", rec.Code)
+		}
+	})
+
+	t.Run("replayed callback", func(t *testing.T) {
+		values := oidcLogin(t, handler, idp, "")
+		oidcCallback(handler, values)
+		if rec := oidcCallback(handler, values); rec.Code != http.StatusUnauthorized {
+			t.Fatalf("expected 401, got %d", rec.Code)
+		}
+	})
+
+	t.Run("nonce mismatch", func(t *testing.T) {
+		idp.nonce = "forged"
+		defer func() { idp.nonce = "" }()
+
+		if rec := oidcCallback(handler, oidcLogin(t, handler, idp, "")); rec.Code != http.StatusUnauthorized {
+			t.Fatalf("expected 401, got %d", rec.Code)
+		}
+	})
+
+	t.Run("identity not mapped", func(t *testing.T) {
+		if rec := oidcCallback(handler, oidcLogin(t, handler, idp, "unverified")); rec.Code != http.StatusForbidden {
+			t.Fatalf("expected 403, got %d", rec.Code)
+		}
+	})
+}
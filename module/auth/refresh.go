@@ -0,0 +1,276 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/euiko/webapp/module/auth/lib"
+	"github.com/euiko/webapp/pkg/helper"
+	"github.com/euiko/webapp/pkg/log"
+	"github.com/euiko/webapp/pkg/token"
+)
+
+type (
+	RefreshPayload struct {
+		RefreshToken string `in:"form=refresh_token" json:"refresh_token" validate:"required"`
+	}
+
+	// tokenGrant describes the tokens to issue, the tokens of the oauth
+	// clients carry the client and its granted scopes while the tokens of
+	// the impersonations carry the actor
+	tokenGrant struct {
+		Family              string
+		ClientID            string
+		Scopes              []string
+		Actor               string
+		WithoutRefreshToken bool
+	}
+)
+
+const (
+	claimClientID = "client_id"
+	claimScope    = "scope"
+	// claimActor is the actor of RFC 8693 acting as the subject
+	claimActor = "act"
+)
+
+var (
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+)
+
+func (m *Module[U]) refreshHandler(w http.ResponseWriter, r *http.Request) {
+	var (
+		payload RefreshPayload
+		ctx     = r.Context()
+	)
+
+	if err := helper.DecodeRequestBody(r, &payload); err != nil {
+		helper.WriteResponse(w, err)
+		return
+	}
+
+	stored, err := m.redeemRefreshToken(ctx, payload.RefreshToken)
+	if errors.Is(err, ErrInvalidRefreshToken) {
+		helper.WriteResponse(w, err, helper.ResponseWithStatus(http.StatusUnauthorized))
+		return
+	} else if err != nil {
+		helper.WriteResponse(w, err)
+		return
+	}
+
+	// the tokens of the oauth clients are only refreshed through the token
+	// endpoint so their scopes are kept
+	if stored.ClientID != "" {
+		helper.WriteResponse(w, ErrInvalidRefreshToken, helper.ResponseWithStatus(http.StatusUnauthorized))
+		return
+	}
+
+	// call before refresh hooks
+	for _, hook := range m.refreshHooks() {
+		if err := hook.BeforeRefresh(ctx, stored.Subject); err != nil {
+			helper.WriteResponse(w, err)
+			return
+		}
+	}
+
+	user, err := m.userLoader.UserById(ctx, stored.Subject)
+	if err != nil {
+		helper.WriteResponse(w, ErrInvalidRefreshToken, helper.ResponseWithStatus(http.StatusUnauthorized))
+		return
+	}
+
+	response, err := m.issueTokens(ctx, user, tokenGrant{Family: stored.Family})
+	if err != nil {
+		helper.WriteResponse(w, err)
+		return
+	}
+
+	// call after refresh hooks
+	for _, hook := range m.refreshHooks() {
+		if err := hook.AfterRefresh(ctx, user, &response.Token); err != nil {
+			helper.WriteResponse(w, err)
+			return
+		}
+	}
+
+	helper.WriteResponse(w, response)
+}
+
+// redeemRefreshToken marks the refresh token as used, the revoked, expired
+// and reused tokens are reported as ErrInvalidRefreshToken
+func (m *Module[U]) redeemRefreshToken(ctx context.Context, refreshToken string) (*RefreshToken, error) {
+	stored, err := m.tokenStore.UseRefreshToken(ctx, hashToken(refreshToken))
+	if errors.Is(err, ErrRefreshTokenNotFound) {
+		return nil, ErrInvalidRefreshToken
+	} else if err != nil {
+		return nil, err
+	}
+
+	if stored.Revoked {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	// a rotated refresh token being used again means either the client or
+	// an attacker holds a stolen token, so the whole family is revoked
+	if stored.Used {
+		log.Warning("refresh token reused, revoking the token family",
+			log.WithField("subject", stored.Subject),
+		)
+		if err := m.tokenStore.RevokeFamily(ctx, stored.Family); err != nil {
+			return nil, err
+		}
+
+		for _, hook := range m.refreshHooks() {
+			if err := hook.RefreshTokenReused(ctx, stored.Subject); err != nil {
+				log.Error("refresh token reused hook failed", log.WithError(err))
+			}
+		}
+
+		return nil, ErrInvalidRefreshToken
+	}
+
+	return stored, nil
+}
+
+// issueTokens creates the access token and the refresh token of the grant,
+// a new family is started when the grant has none
+func (m *Module[U]) issueTokens(ctx context.Context, user U, grant tokenGrant) (*LoginResponse, error) {
+	// the opaque tokens aren't signed
+	key, ok := m.signingKey()
+	if !ok && m.usesKeys() {
+		return nil, errors.New("invalid configuration")
+	}
+
+	subject := user.LoginID()
+	claims, err := m.userClaims(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	// the tokens of the oauth clients are restricted to the granted scopes
+	if grant.ClientID != "" {
+		if claims == nil {
+			claims = make(map[string]any, 2)
+		}
+		claims[claimClientID] = grant.ClientID
+		claims[claimScope] = strings.Join(grant.Scopes, " ")
+	}
+
+	if grant.Actor != "" {
+		if claims == nil {
+			claims = make(map[string]any, 1)
+		}
+		claims[claimActor] = map[string]any{"sub": grant.Actor}
+	}
+
+	accessToken, err := m.tokenEncoding.EncodeToken(key, token.Token{
+		Subject:  subject,
+		Audience: []string{"webapp"},
+		Claims:   claims,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	expiresIn := m.settings.TokenEncoding.JWTTimeout
+	if grant.Actor != "" && m.settings.Impersonation.Timeout < expiresIn {
+		expiresIn = m.settings.Impersonation.Timeout
+	}
+
+	response := LoginResponse{
+		Token:     string(accessToken),
+		ExpiresIn: int64(expiresIn / time.Second),
+	}
+	if grant.WithoutRefreshToken {
+		return &response, nil
+	}
+
+	family := grant.Family
+	if family == "" {
+		family = newRandomToken()
+	}
+
+	response.RefreshToken = newRandomToken()
+	err = m.tokenStore.SaveRefreshToken(ctx, RefreshToken{
+		ID:        hashToken(response.RefreshToken),
+		Family:    family,
+		Subject:   subject,
+		ClientID:  grant.ClientID,
+		Scopes:    grant.Scopes,
+		IssuedAt:  time.Now(),
+		ExpiresAt: time.Now().Add(m.settings.RefreshTokenTimeout),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &response, nil
+}
+
+// revokeTokens revokes the current access token and the family of the
+// refresh token if any
+func (m *Module[U]) revokeTokens(r *http.Request) error {
+	ctx := r.Context()
+	if current, ok := TokenFromContext(ctx); ok && current.ID != "" {
+		if err := m.tokenStore.RevokeToken(ctx, current.ID, current.ExpiresAt); err != nil {
+			return err
+		}
+	}
+
+	// the opaque token is removed instead of waiting to expire
+	if encoding, ok := m.tokenEncoding.(*token.OpaqueEncoding); ok {
+		if err := encoding.Revoke(ctx, []byte(bearerToken(r))); err != nil {
+			return err
+		}
+	}
+
+	// the refresh token is optional on logout
+	if r.ContentLength == 0 {
+		return nil
+	}
+
+	var payload RefreshPayload
+	if err := helper.DecodeRequestBody(r, &payload); err != nil {
+		return nil
+	}
+
+	stored, err := m.tokenStore.UseRefreshToken(ctx, hashToken(payload.RefreshToken))
+	if errors.Is(err, ErrRefreshTokenNotFound) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	return m.tokenStore.RevokeFamily(ctx, stored.Family)
+}
+
+func (m *Module[U]) refreshHooks() []lib.RefreshHook[U] {
+	hooks := make([]lib.RefreshHook[U], 0, len(m.hooks))
+	for _, hook := range m.hooks {
+		if refreshHook, ok := hook.(lib.RefreshHook[U]); ok {
+			hooks = append(hooks, refreshHook)
+		}
+	}
+
+	return hooks
+}
+
+// hashToken derives the stored ID from the refresh token
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func newRandomToken() string {
+	b := make([]byte, 32)
+	// crypto/rand.Read never returns an error on supported platforms
+	_, _ = rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -3,9 +3,12 @@ package auth
 import (
 	"errors"
 	"net/http"
+	"time"
 
 	"github.com/euiko/webapp/core"
 	"github.com/euiko/webapp/pkg/helper"
+	"github.com/euiko/webapp/pkg/httpcache"
+	"github.com/euiko/webapp/pkg/password"
 	"github.com/euiko/webapp/pkg/session"
 )
 
@@ -16,7 +19,9 @@ type (
 	}
 
 	LoginResponse struct {
-		Token string `json:"token"`
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token,omitempty"`
+		ExpiresIn    int64  `json:"expires_in"`
 	}
 )
 
@@ -30,24 +35,52 @@ func (m *Module[U]) APIRoute(r core.Router) {
 
 	// public accessible routes
 	r.Post("/auth/login", m.loginHandler)
-}
+	r.Post("/auth/refresh", m.refreshHandler)
+	if m.settings.Introspection.Enabled {
+		r.Post("/auth/introspect", m.introspectHandler)
+	}
 
-func (m *Module[U]) loginHandler(w http.ResponseWriter, r *http.Request) {
-	var (
-		payload LoginPayload
-		keys    = m.GetKeys()
-	)
+	m.sessionRoute(r)
+	m.apiKeyRoute(r)
+	m.oidcRoute(r)
+	m.oauthClientRoute(r)
+	m.mfaRoute(r)
+	m.lockoutRoute(r)
+	m.impersonationRoute(r)
+}
 
-	if len(keys) == 0 {
-		helper.WriteResponse(w, errors.New("invalid configuration"))
+func (m *Module[U]) Route(r core.Router) {
+	if !m.settings.Enabled {
 		return
 	}
 
+	// publish the public keys so other parties can verify the tokens
+	r.With(httpcache.Middleware(httpcache.WithPolicy(httpcache.PublicPolicy(5*time.Minute)))).
+		Get("/.well-known/jwks.json", m.jwksHandler)
+
+	m.oauthRoute(r)
+}
+
+func (m *Module[U]) loginHandler(w http.ResponseWriter, r *http.Request) {
+	var payload LoginPayload
+
 	if err := helper.DecodeRequestBody(r, &payload); err != nil {
 		helper.WriteResponse(w, err)
 		return
 	}
 
+	ip := clientIP(r)
+	if err := m.checkLockout(r.Context(), payload.LoginId, ip); err != nil {
+		if !writeLockoutError(w, err) {
+			helper.WriteResponse(w, err)
+		}
+		return
+	}
+
+	// collect the new hash when the user loader verifies an outdated
+	// password hash, it is available to the after login hooks
+	r = r.WithContext(password.WithRehash(r.Context()))
+
 	// call before login hooks
 	for _, hook := range m.hooks {
 		if err := hook.BeforeLogin(r.Context(), payload.LoginId, payload.Password); err != nil {
@@ -58,33 +91,60 @@ func (m *Module[U]) loginHandler(w http.ResponseWriter, r *http.Request) {
 
 	user, err := m.userLoader.LoadUser(r.Context(), payload.LoginId, payload.Password)
 	if err != nil {
+		m.recordLoginFailure(r.Context(), payload.LoginId, ip)
 		helper.WriteResponse(w, err)
 		return
 	}
 
+	if m.challengeMFA(w, r, user, payload.LoginId) {
+		return
+	}
+
+	m.resetLoginFailures(r.Context(), payload.LoginId)
+	m.completeLogin(w, r, user)
+}
+
+// challengeMFA responds the challenge of the second step to the users with
+// mfa instead of completing the login, it returns true when the response is
+// written. Every first step, e.g. the password or the oidc callback, must go
+// through it
+func (m *Module[U]) challengeMFA(w http.ResponseWriter, r *http.Request, user U, loginId string) bool {
+	required, enroll, err := m.mfaRequired(r.Context(), user)
+	if err != nil {
+		helper.WriteResponse(w, err)
+		return true
+	} else if required {
+		m.writeMFAChallenge(w, r, user, loginId, enroll)
+		return true
+	}
+
+	return false
+}
+
+// completeLogin issues the tokens of the authenticated user and binds the
+// subject to a renewed session
+func (m *Module[U]) completeLogin(w http.ResponseWriter, r *http.Request, user U) {
 	subject := user.LoginID()
-	key := keys[0] // use the first key to create token
-	token, err := m.tokenEncoding.Encode(key, subject, "webapp")
+	response, err := m.issueTokens(r.Context(), user, tokenGrant{})
 	if err != nil {
 		helper.WriteResponse(w, err)
 		return
 	}
 
-	// write into session
-	defer session.Add(r.Context(), "user", &user)
-
 	// call after login hooks
-	tokenStr := string(token)
 	for _, hook := range m.hooks {
-		if err := hook.AfterLogin(r.Context(), user, &tokenStr); err != nil {
+		if err := hook.AfterLogin(r.Context(), user, &response.Token); err != nil {
 			helper.WriteResponse(w, err)
 			return
 		}
 	}
 
-	response := LoginResponse{
-		Token: tokenStr,
-	}
+	// renew the session ID on login to prevent session fixation, and write
+	// into session before the response since the cookie is sent along with
+	// the header
+	session.Regenerate(r.Context())
+	session.SetUserID(r.Context(), subject)
+
 	helper.WriteResponse(w, response)
 }
 
@@ -102,7 +162,11 @@ func (m *Module[U]) logoutHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	// TODO: revoke token
+	if err := m.revokeTokens(r); err != nil {
+		helper.WriteResponse(w, err)
+		return
+	}
+	session.Destroy(r.Context())
 
 	// call after logout hooks
 	for _, hook := range m.hooks {
@@ -0,0 +1,110 @@
+package auth
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/euiko/webapp/core"
+	"github.com/euiko/webapp/module/auth/lib"
+	"github.com/euiko/webapp/module/rbac/lib/role"
+	"github.com/euiko/webapp/pkg/helper"
+	"github.com/euiko/webapp/pkg/session"
+	"github.com/go-chi/chi/v5"
+)
+
+type (
+	SessionResponse struct {
+		ID         string    `json:"id"`
+		UserAgent  string    `json:"user_agent"`
+		RemoteAddr string    `json:"remote_addr"`
+		Current    bool      `json:"current"`
+		CreatedAt  time.Time `json:"created_at"`
+		LastSeenAt time.Time `json:"last_seen_at"`
+		ExpiresAt  time.Time `json:"expires_at"`
+	}
+)
+
+// sessionRoute registers the session management endpoints, they are only
+// available when the sessions are stored server-side
+func (m *Module[U]) sessionRoute(r core.Router) {
+	if m.app.Settings().Server.Session.Store == "cookie" {
+		return
+	}
+
+	r.Group(func(r core.Router) {
+		r.Use(m.Middleware())
+		r.Get("/auth/sessions", m.listSessionsHandler)
+		r.Delete("/auth/sessions/{id}", m.revokeSessionHandler)
+		r.Method("GET", "/auth/users/{id}/sessions", role.Handler(lib.PermissionManageSessions, http.HandlerFunc(m.listUserSessionsHandler)))
+	})
+}
+
+func (m *Module[U]) listSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := m.unscopedUser(w, r, "sessions")
+	if !ok {
+		return
+	}
+
+	m.writeSessions(w, r, user.LoginID())
+}
+
+func (m *Module[U]) listUserSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	m.writeSessions(w, r, chi.URLParam(r, "id"))
+}
+
+func (m *Module[U]) revokeSessionHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := m.unscopedUser(w, r, "sessions")
+	if !ok {
+		return
+	}
+
+	records, err := session.ListByUser(r.Context(), user.LoginID())
+	if err != nil {
+		helper.WriteResponse(w, err)
+		return
+	}
+
+	// only allow revoking the sessions owned by the current user
+	id := chi.URLParam(r, "id")
+	for _, record := range records {
+		if record.ID != id {
+			continue
+		}
+
+		if err := session.Revoke(r.Context(), id); err != nil {
+			helper.WriteResponse(w, err)
+			return
+		}
+
+		helper.WriteResponse(w, map[string]interface{}{
+			"message": "session revoked",
+		})
+		return
+	}
+
+	helper.WriteResponse(w, session.ErrSessionNotFound, helper.ResponseWithStatus(http.StatusNotFound))
+}
+
+func (m *Module[U]) writeSessions(w http.ResponseWriter, r *http.Request, userID string) {
+	records, err := session.ListByUser(r.Context(), userID)
+	if err != nil {
+		helper.WriteResponse(w, err)
+		return
+	}
+
+	currentID := session.ID(r.Context())
+	response := make([]SessionResponse, len(records))
+	for i, record := range records {
+		response[i] = SessionResponse{
+			ID:         record.ID,
+			UserAgent:  record.UserAgent,
+			RemoteAddr: record.RemoteAddr,
+			Current:    record.ID == currentID,
+			CreatedAt:  record.CreatedAt,
+			LastSeenAt: record.UpdatedAt,
+			ExpiresAt:  record.ExpiresAt,
+		}
+	}
+
+	helper.WriteResponse(w, response)
+}
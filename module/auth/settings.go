@@ -1,11 +1,196 @@
 package auth
 
-import "time"
+import (
+	"errors"
+	"time"
+
+	"github.com/euiko/webapp/pkg/password"
+)
 
 type (
 	Settings struct {
-		Enabled       bool                  `mapstructure:"enabled"`
-		TokenEncoding TokenEncodingSettings `mapstructure:"token_encoding"`
+		Enabled             bool                  `mapstructure:"enabled"`
+		TokenEncoding       TokenEncodingSettings `mapstructure:"token_encoding"`
+		TokenStore          string                `mapstructure:"token_store"`
+		RefreshTokenTimeout time.Duration         `mapstructure:"refresh_token_timeout"`
+		KeyManagement       KeyManagementSettings `mapstructure:"key_management"`
+		Introspection       IntrospectionSettings `mapstructure:"introspection"`
+		APIKeys             APIKeySettings        `mapstructure:"api_keys"`
+		OIDC                OIDCSettings          `mapstructure:"oidc"`
+		OAuthServer         OAuthServerSettings   `mapstructure:"oauth_server"`
+		Password            PasswordSettings      `mapstructure:"password"`
+		MFA                 MFASettings           `mapstructure:"mfa"`
+		Lockout             LockoutSettings       `mapstructure:"lockout"`
+		Impersonation       ImpersonationSettings `mapstructure:"impersonation"`
+		ClientCert          ClientCertSettings    `mapstructure:"client_cert"`
+	}
+
+	// ClientCertSettings authenticates the requests by the client
+	// certificates verified by the tls settings of the server
+	ClientCertSettings struct {
+		Enabled bool `mapstructure:"enabled"`
+		// Identity is the field used as the login id by the default mapper
+		// (valid identities: common_name, dns_san, email_san, uri_san)
+		Identity string `mapstructure:"identity"`
+		// Scopes restricts the requests authenticated by the certificates,
+		// empty grants all the permissions of the user
+		Scopes []string `mapstructure:"scopes"`
+	}
+
+	ImpersonationSettings struct {
+		Enabled bool `mapstructure:"enabled"`
+		// Timeout ends the impersonation after the duration, the token also
+		// ends it when it expires earlier since it can't be refreshed
+		Timeout time.Duration `mapstructure:"timeout"`
+	}
+
+	LockoutSettings struct {
+		Enabled bool `mapstructure:"enabled"`
+		// Store tracks the failed logins (valid stores: cache, sql), use sql
+		// to share them between the instances
+		Store string `mapstructure:"store"`
+		// Window is how long the failures are counted since the last one
+		Window time.Duration `mapstructure:"window"`
+		// MaxFailures locks the login id after the failures, zero disables
+		// the lockout
+		MaxFailures int `mapstructure:"max_failures"`
+		// MaxIPFailures locks the client ip after the failures of any login
+		// id, zero disables the lockout
+		MaxIPFailures int `mapstructure:"max_ip_failures"`
+		// LockoutDuration is how long the login id or the ip is locked
+		LockoutDuration time.Duration `mapstructure:"lockout_duration"`
+		// DelayAfter is the number of the failures of a login id before the
+		// next attempts are delayed, starting from the base delay and
+		// doubled on every failure up to the max delay
+		DelayAfter int           `mapstructure:"delay_after"`
+		BaseDelay  time.Duration `mapstructure:"base_delay"`
+		MaxDelay   time.Duration `mapstructure:"max_delay"`
+	}
+
+	MFASettings struct {
+		Enabled bool `mapstructure:"enabled"`
+		// Store persists the totp enrollments (valid stores: cache, sql)
+		Store string `mapstructure:"store"`
+		// Issuer is the account issuer shown in the authenticator apps
+		Issuer string `mapstructure:"issuer"`
+		// Skew is the number of the time steps accepted before and after the
+		// current one to tolerate the clock drift
+		Skew int `mapstructure:"skew"`
+		// ChallengeTimeout is the lifetime of the second login step
+		ChallengeTimeout time.Duration `mapstructure:"challenge_timeout"`
+		// MaxAttempts is the number of the wrong codes before the challenge
+		// is invalidated
+		MaxAttempts int `mapstructure:"max_attempts"`
+		// RecoveryCodes is the number of the generated recovery codes
+		RecoveryCodes int `mapstructure:"recovery_codes"`
+		// EnforcedRoles are the rbac roles required to login with mfa, their
+		// users without mfa must enroll during the login
+		EnforcedRoles []string `mapstructure:"enforced_roles"`
+	}
+
+	PasswordSettings struct {
+		// Algorithm hashes the new passwords (valid algorithms: argon2id,
+		// bcrypt, scrypt), the hashes of other algorithms or parameters are
+		// rehashed on login
+		Algorithm  string                 `mapstructure:"algorithm"`
+		Argon2id   Argon2idSettings       `mapstructure:"argon2id"`
+		BcryptCost int                    `mapstructure:"bcrypt_cost"`
+		Scrypt     ScryptSettings         `mapstructure:"scrypt"`
+		Policy     PasswordPolicySettings `mapstructure:"policy"`
+	}
+
+	Argon2idSettings struct {
+		// Memory is the memory cost in KiB
+		Memory      uint32 `mapstructure:"memory"`
+		Iterations  uint32 `mapstructure:"iterations"`
+		Parallelism uint8  `mapstructure:"parallelism"`
+	}
+
+	ScryptSettings struct {
+		// LogN is the log2 of the CPU/memory cost
+		LogN        uint8 `mapstructure:"log_n"`
+		BlockSize   int   `mapstructure:"block_size"`
+		Parallelism int   `mapstructure:"parallelism"`
+	}
+
+	// PasswordPolicySettings configures the policy of the password
+	// validation tag
+	PasswordPolicySettings struct {
+		MinLength int `mapstructure:"min_length"`
+		MaxLength int `mapstructure:"max_length"`
+		// MaxSimilarity is the maximum similarity ratio to the login id from
+		// 0 to 1, zero disables the check
+		MaxSimilarity float64 `mapstructure:"max_similarity"`
+		// BreachedListFile lists the refused passwords, one per line either
+		// plain or SHA-1 hashed
+		BreachedListFile string `mapstructure:"breached_list_file"`
+	}
+
+	OAuthServerSettings struct {
+		Enabled bool `mapstructure:"enabled"`
+		// ClientStore persists the registered clients (valid stores: cache,
+		// sql)
+		ClientStore string `mapstructure:"client_store"`
+		// Issuer is the base URL of the application published in the
+		// authorization server metadata
+		Issuer string `mapstructure:"issuer"`
+		// LoginURL is where the users without a session are sent to sign
+		// in, the authorization URL is passed as the return_to query
+		LoginURL string `mapstructure:"login_url"`
+		// CodeTimeout is the lifetime of the authorization codes
+		CodeTimeout time.Duration `mapstructure:"code_timeout"`
+	}
+
+	OIDCSettings struct {
+		Enabled bool `mapstructure:"enabled"`
+		// Providers are the external identity providers keyed by the name
+		// used in the login and callback paths
+		Providers map[string]OIDCProviderSettings `mapstructure:"providers"`
+	}
+
+	OIDCProviderSettings struct {
+		// Issuer is the URL serving the discovery document under
+		// /.well-known/openid-configuration
+		Issuer       string `mapstructure:"issuer"`
+		ClientID     string `mapstructure:"client_id"`
+		ClientSecret string `mapstructure:"client_secret"`
+		// RedirectURL is the absolute URL of the callback endpoint
+		// registered at the provider
+		RedirectURL string   `mapstructure:"redirect_url"`
+		Scopes      []string `mapstructure:"scopes"`
+	}
+
+	APIKeySettings struct {
+		Enabled bool `mapstructure:"enabled"`
+		// Store persists the api keys (valid stores: cache, sql)
+		Store string `mapstructure:"store"`
+		// HeaderName is the header carrying the key, the key may also be
+		// sent using the ApiKey authorization scheme
+		HeaderName string `mapstructure:"header_name"`
+		// Prefix identifies the keys issued by the application, e.g. in the
+		// secret scanners, it must not contain an underscore
+		Prefix string `mapstructure:"prefix"`
+	}
+
+	IntrospectionSettings struct {
+		Enabled bool `mapstructure:"enabled"`
+		// Clients are the credentials of the resource servers allowed to
+		// introspect the tokens, keyed by the client id
+		Clients map[string]string `mapstructure:"clients"`
+	}
+
+	KeyManagementSettings struct {
+		// Store persists the managed keys (valid stores: config, cache,
+		// sql), config only uses the keys of the token encoding
+		Store string `mapstructure:"store"`
+		// RefreshInterval is how often the keys are reloaded from the store
+		RefreshInterval time.Duration `mapstructure:"refresh_interval"`
+		// RotationInterval rotates the signing key periodically, zero
+		// disables the scheduled rotation
+		RotationInterval time.Duration `mapstructure:"rotation_interval"`
+		// RetireAfter keeps the rotated keys verifying, it must be longer
+		// than the jwt timeout
+		RetireAfter time.Duration `mapstructure:"retire_after"`
 	}
 
 	TokenEncodingSettings struct {
@@ -14,6 +199,52 @@ type (
 		JWTIssuer    string        `mapstructure:"jwt_issuer"`
 		JWTAudience  string        `mapstructure:"jwt_audience"`
 		JWTTimeout   time.Duration `mapstructure:"jwt_timeout"`
-		Keys         []string      `mapstructure:"keys"`
+		// Keys are the secrets of the symmetric algorithms, or the PEM
+		// encoded keys of the asymmetric ones
+		Keys []string `mapstructure:"keys"`
+		// KeyFiles are the paths of the PEM encoded keys
+		KeyFiles []string `mapstructure:"key_files"`
+		// JWKSURL verifies the tokens issued by a remote party using its
+		// published key set
+		JWKSURL string `mapstructure:"jwks_url"`
 	}
 )
+
+const (
+	pasetoV4Local  = "v4.local"
+	pasetoV4Public = "v4.public"
+)
+
+// hasher returns the password hasher of the configured algorithm
+func (s *PasswordSettings) hasher() (password.Hasher, error) {
+	switch s.Algorithm {
+	case "argon2id":
+		params := password.DefaultArgon2idParams
+		params.Memory = s.Argon2id.Memory
+		params.Iterations = s.Argon2id.Iterations
+		params.Parallelism = s.Argon2id.Parallelism
+		return password.NewArgon2id(params), nil
+	case "bcrypt":
+		return password.NewBcrypt(s.BcryptCost), nil
+	case "scrypt":
+		params := password.DefaultScryptParams
+		params.LogN = s.Scrypt.LogN
+		params.BlockSize = s.Scrypt.BlockSize
+		params.Parallelism = s.Scrypt.Parallelism
+		return password.NewScrypt(params), nil
+	}
+
+	return nil, errors.New("invalid password algorithm (valid algorithms: argon2id, bcrypt, scrypt)")
+}
+
+// keyAlgorithm returns the algorithm of the keys used by the encoding type
+func (s *TokenEncodingSettings) keyAlgorithm() string {
+	switch s.Type {
+	case "paseto-v4-local":
+		return pasetoV4Local
+	case "paseto-v4-public":
+		return pasetoV4Public
+	}
+
+	return s.JWTAlgorithm
+}
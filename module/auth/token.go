@@ -1,9 +1,16 @@
 package auth
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
 	"errors"
 	"fmt"
+	"net/http"
+	"os"
 
+	"github.com/euiko/webapp/pkg/helper"
+	"github.com/euiko/webapp/pkg/log"
 	"github.com/euiko/webapp/pkg/token"
 	"github.com/lestrrat-go/jwx/v3/jwa"
 )
@@ -16,13 +23,17 @@ var (
 	tokenEncodingRegistry = map[string]TokenEncodingFactory{
 		"jwt":          jwtEncodingFactory,
 		"headless-jwt": headlessJwtEncodingFactory,
+		"opaque":       opaqueEncodingFactory,
+
+		"paseto-v4-local":  pasetoV4LocalEncodingFactory,
+		"paseto-v4-public": pasetoV4PublicEncodingFactory,
 	}
 )
 
 func NewTokenEncoding(s *Settings) (token.Encoding, error) {
 	fn, ok := tokenEncodingRegistry[s.TokenEncoding.Type]
 	if !ok {
-		return nil, errors.New("invalid token encoding type (valid types: jwt, headless-jwt)")
+		return nil, errors.New("invalid token encoding type (valid types: jwt, headless-jwt, opaque, paseto-v4-local, paseto-v4-public)")
 	}
 
 	return fn(&s.TokenEncoding)
@@ -40,35 +51,192 @@ func headlessJwtEncodingFactory(s *TokenEncodingSettings) (token.Encoding, error
 	})
 }
 
+// opaqueEncodingFactory creates the encoding without the store, it is set
+// on start using the token store since the database isn't opened yet
+func opaqueEncodingFactory(s *TokenEncodingSettings) (token.Encoding, error) {
+	return token.NewOpaqueEncoding(nil,
+		token.OpaqueWithIssuer(s.JWTIssuer),
+		token.OpaqueWithAudience(s.JWTAudience),
+		token.OpaqueWithExpiration(s.JWTTimeout),
+	), nil
+}
+
+func pasetoV4LocalEncodingFactory(s *TokenEncodingSettings) (token.Encoding, error) {
+	return token.NewPasetoV4LocalEncoding(pasetoOptions(s)...), nil
+}
+
+func pasetoV4PublicEncodingFactory(s *TokenEncodingSettings) (token.Encoding, error) {
+	return token.NewPasetoV4PublicEncoding(pasetoOptions(s)...), nil
+}
+
+// pasetoOptions shares the jwt settings for the same validations
+func pasetoOptions(s *TokenEncodingSettings) []token.PasetoOption {
+	return []token.PasetoOption{
+		token.PasetoWithIssuer(s.JWTIssuer),
+		token.PasetoWithAudience(s.JWTAudience),
+		token.PasetoWithExpiration(s.JWTTimeout),
+	}
+}
+
 func newJwtEncoding(
 	s *TokenEncodingSettings,
 	fn func(jwa.SignatureAlgorithm, ...token.JwtOption) token.Encoding,
 ) (token.Encoding, error) {
-	var (
-		keyProvider token.Key
-	)
-
 	algorithm, ok := jwa.LookupSignatureAlgorithm(s.JWTAlgorithm)
 	if !ok {
 		return nil, fmt.Errorf("invalid jwt algorithm: %s", s.JWTAlgorithm)
 	}
 
-	if algorithm.IsSymmetric() {
-		if len(s.Keys) == 0 {
-			return nil, errors.New("symmetric jwt algorithm requires at least one keys specified in config")
+	opts := []token.JwtOption{
+		token.JwtWithIssuer(s.JWTIssuer),
+		token.JwtWithAudience(s.JWTAudience),
+		token.JwtWithExpiration(s.JWTTimeout),
+	}
+	return fn(algorithm, opts...), nil
+}
+
+// loadKeys parses the configured keys, the symmetric keys are identified by
+// their hash while the asymmetric keys by their thumbprint
+func loadKeys(s *TokenEncodingSettings) ([]token.Key, error) {
+	symmetric, err := isSymmetricAlgorithm(s.keyAlgorithm())
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []token.Key
+	if symmetric {
+		for _, secret := range s.Keys {
+			// the id is public so it must not reveal the secret
+			sum := sha256.Sum256([]byte(secret))
+			id := base64.RawURLEncoding.EncodeToString(sum[:12])
+			keys = append(keys, token.WithKeyID(id, token.NewSymetricKey([]byte(secret))))
 		}
 
-		keyProvider = token.NewSymetricKey([]byte(s.Keys[0]))
+		return keys, nil
 	}
 
-	if keyProvider == nil {
-		return nil, errors.New("currently only support symetric keys")
+	pems := make([][]byte, 0, len(s.Keys)+len(s.KeyFiles))
+	for _, key := range s.Keys {
+		pems = append(pems, []byte(key))
 	}
 
-	opts := []token.JwtOption{
-		token.JwtWithIssuer(s.JWTIssuer),
-		token.JwtWithAudience(s.JWTAudience),
-		token.JwtWithExpiration(s.JWTTimeout),
+	for _, path := range s.KeyFiles {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		pems = append(pems, data)
 	}
-	return fn(algorithm, opts...), nil
+
+	for _, data := range pems {
+		key, err := token.ParsePEMKey(data)
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+// decodeToken verifies the token using the key referred by its kid header,
+// falling back to try all keys when the token doesn't carry one
+func (m *Module[U]) decodeToken(ctx context.Context, b []byte) (*token.Token, error) {
+	if !m.usesKeys() {
+		return m.tokenEncoding.Decode(nil, b)
+	}
+
+	if reader, ok := m.tokenEncoding.(token.KeyIDReader); ok {
+		if kid, ok := reader.KeyID(b); ok {
+			key, found := m.lookupKey(ctx, kid)
+			if !found && m.remoteKeys != nil {
+				var err error
+				key, err = m.remoteKeys.Key(ctx, kid)
+				found = err == nil
+			}
+
+			if !found {
+				return nil, fmt.Errorf("%w: %s", token.ErrKeyNotFound, kid)
+			}
+
+			return m.tokenEncoding.Decode(key, b)
+		}
+	}
+
+	keys := m.GetKeys()
+	if m.remoteKeys != nil {
+		remoteKeys, err := m.remoteKeys.Keys(ctx)
+		if err != nil {
+			log.Error("failed to fetch remote keys", log.WithError(err))
+		}
+
+		keys = append(keys, remoteKeys...)
+	}
+
+	var (
+		decoded *token.Token
+		err     = token.ErrKeyNotFound
+	)
+	for _, key := range keys {
+		decoded, err = m.tokenEncoding.Decode(key, b)
+		if err == nil {
+			break
+		}
+	}
+
+	return decoded, err
+}
+
+// usesKeys returns false when the token encoding keeps the tokens state
+// instead of signing them
+func (m *Module[U]) usesKeys() bool {
+	_, opaque := m.tokenEncoding.(*token.OpaqueEncoding)
+	return !opaque
+}
+
+// signingKey returns the key creating the tokens
+func (m *Module[U]) signingKey() (token.Key, bool) {
+	ring := m.keyring.Load()
+	if ring == nil || ring.signing == nil {
+		return nil, false
+	}
+
+	return ring.signing, true
+}
+
+func (m *Module[U]) jwksHandler(w http.ResponseWriter, r *http.Request) {
+	// the alg is only meaningful for the jwt verifiers
+	var algorithm string
+	if _, ok := m.tokenEncoding.(*token.PasetoEncoding); !ok {
+		algorithm = m.settings.TokenEncoding.JWTAlgorithm
+	}
+
+	jwks, err := token.MarshalJWKS(algorithm, m.GetKeys()...)
+	if err != nil {
+		helper.WriteResponse(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jwks)
+}
+
+// isSymmetricAlgorithm returns whether the keys of the algorithm are secrets
+// instead of PEM encoded keys
+func isSymmetricAlgorithm(algorithm string) (bool, error) {
+	switch algorithm {
+	case pasetoV4Local:
+		return true, nil
+	case pasetoV4Public:
+		return false, nil
+	}
+
+	alg, ok := jwa.LookupSignatureAlgorithm(algorithm)
+	if !ok {
+		return false, fmt.Errorf("invalid jwt algorithm: %s", algorithm)
+	}
+
+	return alg.IsSymmetric(), nil
 }
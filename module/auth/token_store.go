@@ -0,0 +1,392 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/euiko/webapp/db/cache"
+	"github.com/euiko/webapp/db/sqldb"
+	"github.com/euiko/webapp/module/auth/internal/schema"
+	"github.com/euiko/webapp/pkg/token"
+)
+
+type (
+	// RefreshToken is the persisted state of a refresh token, the ID is the
+	// hash of the token so a leaked store never exposes usable tokens
+	RefreshToken struct {
+		ID      string
+		Family  string
+		Subject string
+		// ClientID is the oauth client the token is issued to, it is empty
+		// for the tokens of the login
+		ClientID  string
+		Scopes    []string
+		Used      bool
+		Revoked   bool
+		IssuedAt  time.Time
+		ExpiresAt time.Time
+	}
+
+	TokenStore interface {
+		// SaveRefreshToken stores the newly issued refresh token
+		SaveRefreshToken(ctx context.Context, token RefreshToken) error
+		// UseRefreshToken marks the refresh token as used and returns its
+		// state before being used, so the reuse can be detected
+		UseRefreshToken(ctx context.Context, id string) (*RefreshToken, error)
+		// RevokeFamily revokes all of the refresh tokens rotated from the
+		// same login
+		RevokeFamily(ctx context.Context, family string) error
+		// RevokeSubject revokes all of the refresh tokens issued to the
+		// subject so far
+		RevokeSubject(ctx context.Context, subject string) error
+		// RevokeToken denies the access token of the jti until it expires
+		RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error
+		// IsTokenRevoked checks whether the access token of the jti is denied
+		IsTokenRevoked(ctx context.Context, jti string) (bool, error)
+	}
+
+	TokenStoreFactory func(s *Settings) (TokenStore, error)
+
+	ormTokenStore struct {
+		db sqldb.OrmDB
+	}
+
+	cacheTokenStore struct {
+		mutex     sync.Mutex
+		cache     cache.Cache
+		familyTTL time.Duration
+	}
+)
+
+const (
+	cacheKeyRefreshToken   = "auth:refresh:"
+	cacheKeyRevokedFamily  = "auth:revoked-family:"
+	cacheKeyRevokedSubject = "auth:revoked-subject:"
+	cacheKeyRevokedToken   = "auth:revoked:"
+	cacheKeyAccessToken    = "auth:access:"
+)
+
+var (
+	ErrRefreshTokenNotFound = errors.New("refresh token not found")
+)
+
+func NewOrmTokenStore(db sqldb.OrmDB) TokenStore {
+	return &ormTokenStore{
+		db: db,
+	}
+}
+
+// NewCacheTokenStore creates a token store backed by the cache, the family
+// revocation is kept for the familyTTL which must be at least the refresh
+// token timeout
+func NewCacheTokenStore(c cache.Cache, familyTTL time.Duration) TokenStore {
+	return &cacheTokenStore{
+		cache:     c,
+		familyTTL: familyTTL,
+	}
+}
+
+func defaultTokenStoreFactory(s *Settings) (TokenStore, error) {
+	switch s.TokenStore {
+	case "cache":
+		return NewCacheTokenStore(cache.InMemory(), s.RefreshTokenTimeout), nil
+	case "sql":
+		return NewOrmTokenStore(sqldb.ORM()), nil
+	}
+
+	return nil, errors.New("invalid token store (valid stores: cache, sql)")
+}
+
+// SaveRefreshToken implements TokenStore.
+func (s *ormTokenStore) SaveRefreshToken(ctx context.Context, token RefreshToken) error {
+	model := schema.RefreshToken{
+		ID:        token.ID,
+		Family:    token.Family,
+		Subject:   token.Subject,
+		ClientID:  token.ClientID,
+		Scopes:    token.Scopes,
+		ExpiresAt: token.ExpiresAt,
+	}
+
+	_, err := s.db.NewInsert().
+		Model(&model).
+		Exec(ctx)
+	return err
+}
+
+// UseRefreshToken implements TokenStore.
+func (s *ormTokenStore) UseRefreshToken(ctx context.Context, id string) (*RefreshToken, error) {
+	var model schema.RefreshToken
+	err := s.db.NewSelect().
+		Model(&model).
+		Where("id = ?", id).
+		Where("expires_at > ?", time.Now()).
+		Limit(1).
+		Scan(ctx)
+	if sqldb.IsNoRows(err) {
+		return nil, ErrRefreshTokenNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	token := toRefreshToken(model)
+	if token.Used {
+		return token, nil
+	}
+
+	// only one of the concurrent requests wins the update
+	result, err := s.db.NewUpdate().
+		Model((*schema.RefreshToken)(nil)).
+		Set("used_at = ?", time.Now()).
+		Where("id = ?", id).
+		Where("used_at IS NULL").
+		Exec(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+
+	token.Used = updated == 0
+	return token, nil
+}
+
+// RevokeFamily implements TokenStore.
+func (s *ormTokenStore) RevokeFamily(ctx context.Context, family string) error {
+	_, err := s.db.NewUpdate().
+		Model((*schema.RefreshToken)(nil)).
+		Set("revoked_at = ?", time.Now()).
+		Where("family = ?", family).
+		Where("revoked_at IS NULL").
+		Exec(ctx)
+	return err
+}
+
+// RevokeSubject implements TokenStore.
+func (s *ormTokenStore) RevokeSubject(ctx context.Context, subject string) error {
+	_, err := s.db.NewUpdate().
+		Model((*schema.RefreshToken)(nil)).
+		Set("revoked_at = ?", time.Now()).
+		Where("subject = ?", subject).
+		Where("revoked_at IS NULL").
+		Exec(ctx)
+	return err
+}
+
+// RevokeToken implements TokenStore.
+func (s *ormTokenStore) RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	model := schema.RevokedToken{
+		JTI:       jti,
+		ExpiresAt: expiresAt,
+	}
+
+	_, err := s.db.NewInsert().
+		Model(&model).
+		On("CONFLICT (jti) DO NOTHING").
+		Exec(ctx)
+	return err
+}
+
+// IsTokenRevoked implements TokenStore.
+func (s *ormTokenStore) IsTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	return s.db.NewSelect().
+		Model((*schema.RevokedToken)(nil)).
+		Where("jti = ?", jti).
+		Where("expires_at > ?", time.Now()).
+		Exists(ctx)
+}
+
+// SaveAccessToken implements token.OpaqueStore.
+func (s *ormTokenStore) SaveAccessToken(ctx context.Context, id string, t token.Token) error {
+	model := schema.AccessToken{
+		ID:        id,
+		JTI:       t.ID,
+		Issuer:    t.Issuer,
+		Subject:   t.Subject,
+		Audience:  t.Audience,
+		Claims:    t.Claims,
+		IssuedAt:  t.IssuedAt,
+		ExpiresAt: t.ExpiresAt,
+	}
+
+	_, err := s.db.NewInsert().
+		Model(&model).
+		Exec(ctx)
+	return err
+}
+
+// LoadAccessToken implements token.OpaqueStore.
+func (s *ormTokenStore) LoadAccessToken(ctx context.Context, id string) (*token.Token, error) {
+	var model schema.AccessToken
+	err := s.db.NewSelect().
+		Model(&model).
+		Where("id = ?", id).
+		Where("expires_at IS NULL OR expires_at > ?", time.Now()).
+		Limit(1).
+		Scan(ctx)
+	if sqldb.IsNoRows(err) {
+		return nil, token.ErrTokenNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &token.Token{
+		ID:        model.JTI,
+		Issuer:    model.Issuer,
+		Subject:   model.Subject,
+		Audience:  model.Audience,
+		Claims:    model.Claims,
+		IssuedAt:  model.IssuedAt,
+		ExpiresAt: model.ExpiresAt,
+	}, nil
+}
+
+// DeleteAccessToken implements token.OpaqueStore.
+func (s *ormTokenStore) DeleteAccessToken(ctx context.Context, id string) error {
+	_, err := s.db.NewDelete().
+		Model((*schema.AccessToken)(nil)).
+		Where("id = ?", id).
+		Exec(ctx)
+	return err
+}
+
+// SaveRefreshToken implements TokenStore.
+func (s *cacheTokenStore) SaveRefreshToken(ctx context.Context, token RefreshToken) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.setRefreshToken(token)
+}
+
+// UseRefreshToken implements TokenStore.
+func (s *cacheTokenStore) UseRefreshToken(ctx context.Context, id string) (*RefreshToken, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	cached, err := s.cache.Get(cacheKeyRefreshToken + id)
+	if errors.Is(err, cache.ErrKeyNotFound) {
+		return nil, ErrRefreshTokenNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	token, ok := cached.(RefreshToken)
+	if !ok || !token.ExpiresAt.After(time.Now()) {
+		return nil, ErrRefreshTokenNotFound
+	}
+
+	// the family revocation is stored separately to avoid tracking the
+	// members of the family
+	if _, err := s.cache.Get(cacheKeyRevokedFamily + token.Family); err == nil {
+		token.Revoked = true
+	}
+
+	// the tokens issued after the revocation of the subject stay valid
+	if revokedAt, err := s.cache.Get(cacheKeyRevokedSubject + token.Subject); err == nil {
+		if at, ok := revokedAt.(time.Time); ok && !token.IssuedAt.After(at) {
+			token.Revoked = true
+		}
+	}
+
+	result := token
+	if !token.Used {
+		token.Used = true
+		if err := s.setRefreshToken(token); err != nil {
+			return nil, err
+		}
+	}
+
+	return &result, nil
+}
+
+// RevokeFamily implements TokenStore.
+func (s *cacheTokenStore) RevokeFamily(ctx context.Context, family string) error {
+	// any token of the family expires within the ttl since the revocation
+	return s.cache.Set(cacheKeyRevokedFamily+family, true, cache.SetWithTimeout(s.familyTTL))
+}
+
+// RevokeSubject implements TokenStore.
+func (s *cacheTokenStore) RevokeSubject(ctx context.Context, subject string) error {
+	return s.cache.Set(cacheKeyRevokedSubject+subject, time.Now(), cache.SetWithTimeout(s.familyTTL))
+}
+
+// RevokeToken implements TokenStore.
+func (s *cacheTokenStore) RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	return s.cache.Set(cacheKeyRevokedToken+jti, true, cache.SetWithTimeout(time.Until(expiresAt)))
+}
+
+// IsTokenRevoked implements TokenStore.
+func (s *cacheTokenStore) IsTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	_, err := s.cache.Get(cacheKeyRevokedToken + jti)
+	if errors.Is(err, cache.ErrKeyNotFound) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// SaveAccessToken implements token.OpaqueStore.
+func (s *cacheTokenStore) SaveAccessToken(ctx context.Context, id string, t token.Token) error {
+	var opts []cache.SetOption
+	if !t.ExpiresAt.IsZero() {
+		opts = append(opts, cache.SetWithTimeout(time.Until(t.ExpiresAt)))
+	}
+
+	return s.cache.Set(cacheKeyAccessToken+id, t, opts...)
+}
+
+// LoadAccessToken implements token.OpaqueStore.
+func (s *cacheTokenStore) LoadAccessToken(ctx context.Context, id string) (*token.Token, error) {
+	cached, err := s.cache.Get(cacheKeyAccessToken + id)
+	if errors.Is(err, cache.ErrKeyNotFound) {
+		return nil, token.ErrTokenNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	t, ok := cached.(token.Token)
+	if !ok {
+		return nil, token.ErrTokenNotFound
+	}
+
+	return &t, nil
+}
+
+// DeleteAccessToken implements token.OpaqueStore.
+func (s *cacheTokenStore) DeleteAccessToken(ctx context.Context, id string) error {
+	err := s.cache.Delete(cacheKeyAccessToken + id)
+	if errors.Is(err, cache.ErrKeyNotFound) {
+		return nil
+	}
+
+	return err
+}
+
+func (s *cacheTokenStore) setRefreshToken(token RefreshToken) error {
+	return s.cache.Set(
+		cacheKeyRefreshToken+token.ID,
+		token,
+		cache.SetWithTimeout(time.Until(token.ExpiresAt)),
+	)
+}
+
+func toRefreshToken(model schema.RefreshToken) *RefreshToken {
+	return &RefreshToken{
+		ID:        model.ID,
+		Family:    model.Family,
+		Subject:   model.Subject,
+		ClientID:  model.ClientID,
+		Scopes:    model.Scopes,
+		Used:      !model.UsedAt.IsZero(),
+		Revoked:   !model.RevokedAt.IsZero(),
+		IssuedAt:  model.CreatedAt,
+		ExpiresAt: model.ExpiresAt,
+	}
+}
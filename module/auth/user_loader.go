@@ -4,6 +4,7 @@ import (
 	"context"
 
 	"github.com/euiko/webapp/module/auth/lib"
+	"github.com/euiko/webapp/pkg/token"
 )
 
 type (
@@ -23,3 +24,31 @@ func (l *userLoaderWrapped[U]) UserById(ctx context.Context, loginId string) (li
 func (l *userLoaderWrapped[U]) LoadUser(ctx context.Context, loginId string, password string) (lib.User, error) {
 	return l.UserLoader.LoadUser(ctx, loginId, password)
 }
+
+// userClaims builds the private claims of the user token when the user
+// loader supports it
+func (m *Module[U]) userClaims(ctx context.Context, user U) (map[string]any, error) {
+	loader, ok := m.userLoader.(lib.ClaimsUserLoader[U])
+	if !ok {
+		return nil, nil
+	}
+
+	return loader.UserClaims(ctx, user)
+}
+
+// userFromToken rebuilds the user from the token claims when the user
+// loader supports it, otherwise the user is loaded by the subject
+func (m *Module[U]) userFromToken(ctx context.Context, t *token.Token) (lib.User, error) {
+	if loader, ok := m.userLoader.(lib.ClaimsUserLoader[U]); ok {
+		user, ok, err := loader.UserFromClaims(ctx, t.Subject, t.Claims)
+		if err != nil {
+			return nil, err
+		}
+
+		if ok {
+			return user, nil
+		}
+	}
+
+	return m.userLoader.UserById(ctx, t.Subject)
+}
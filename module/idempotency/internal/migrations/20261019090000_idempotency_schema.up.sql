@@ -0,0 +1,23 @@
+SET statement_timeout = 0;
+
+--bun:split
+
+CREATE SCHEMA IF NOT EXISTS idempotency;
+
+--bun:split
+
+CREATE TABLE IF NOT EXISTS idempotency.keys (
+    key VARCHAR(64) PRIMARY KEY,
+    fingerprint VARCHAR(64) NOT NULL,
+    completed BOOLEAN NOT NULL DEFAULT FALSE,
+    status INTEGER NOT NULL DEFAULT 0,
+    header JSONB,
+    body BYTEA,
+    expires_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+--bun:split
+
+CREATE INDEX IF NOT EXISTS keys_expires_at_idx ON idempotency.keys (expires_at);
@@ -0,0 +1,24 @@
+package schema
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+type (
+	Key struct {
+		bun.BaseModel `bun:"table:idempotency.keys"`
+
+		Key         string      `bun:"key,pk"`
+		Fingerprint string      `bun:"fingerprint,notnull"`
+		Completed   bool        `bun:"completed,notnull"`
+		Status      int         `bun:"status,notnull"`
+		Header      http.Header `bun:"header,type:jsonb"`
+		Body        []byte      `bun:"body,type:bytea"`
+		ExpiresAt   time.Time   `bun:"expires_at,notnull"`
+		CreatedAt   time.Time   `bun:"created_at,notnull,nullzero,default:current_timestamp"`
+		UpdatedAt   time.Time   `bun:"updated_at,notnull,nullzero,default:current_timestamp"`
+	}
+)
@@ -0,0 +1,20 @@
+package lib
+
+import (
+	"net/http"
+
+	"github.com/euiko/webapp/core"
+)
+
+// IdempotencyMiddleware returns the idempotency middleware when the module is
+// registered, otherwise the requests are passed through as is
+func IdempotencyMiddleware(app core.App) core.MiddlewareFunc {
+	module, ok := core.GetModule[Module](app)
+	if !ok {
+		return func(next http.Handler) http.Handler {
+			return next
+		}
+	}
+
+	return module.Middleware()
+}
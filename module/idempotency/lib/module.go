@@ -0,0 +1,13 @@
+package lib
+
+import (
+	"github.com/euiko/webapp/core"
+)
+
+type (
+	Module interface {
+		// Middleware returns the idempotency middleware, it must be placed
+		// after the authentication middleware to scope the keys per user
+		Middleware() core.MiddlewareFunc
+	}
+)
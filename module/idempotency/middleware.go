@@ -0,0 +1,255 @@
+package idempotency
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	authlib "github.com/euiko/webapp/module/auth/lib"
+	"github.com/euiko/webapp/pkg/helper"
+	"github.com/euiko/webapp/pkg/log"
+)
+
+type (
+	recordingWriter struct {
+		http.ResponseWriter
+
+		status int
+		buf    bytes.Buffer
+		// before is the header set by the outer middlewares, e.g. the csrf
+		// token, and header is the one written by the handler
+		before http.Header
+		header http.Header
+	}
+)
+
+const (
+	maxKeyLength = 255
+
+	replayedHeader = "Idempotent-Replayed"
+)
+
+func (m *Module) handle(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !m.settings.Enabled || m.store == nil || isSafeMethod(r.Method) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		idempotencyKey := r.Header.Get(m.settings.HeaderName)
+		if idempotencyKey == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if len(idempotencyKey) > maxKeyLength {
+			detail := fmt.Sprintf("%s must not exceed %d characters", m.settings.HeaderName, maxKeyLength)
+			helper.WriteProblem(w, helper.NewProblem(http.StatusBadRequest, detail))
+			return
+		}
+
+		fingerprint, err := fingerprint(r)
+		if err != nil {
+			helper.WriteResponse(w, err)
+			return
+		}
+
+		ctx := r.Context()
+		record := Record{
+			Key:         scopedKey(r, idempotencyKey),
+			Fingerprint: fingerprint,
+			ExpiresAt:   time.Now().Add(m.settings.TTL),
+		}
+
+		existing, err := m.store.Begin(ctx, record)
+		if err != nil {
+			log.Error("failed to reserve idempotency key", log.WithError(err))
+			helper.WriteResponse(w, err)
+			return
+		}
+
+		if existing != nil {
+			m.replay(w, existing, &record)
+			return
+		}
+
+		// snapshot the header to only store the ones set by the handler
+		rw := &recordingWriter{ResponseWriter: w, before: w.Header().Clone()}
+		completed := false
+		defer func() {
+			// release the key so the client can retry after a failure
+			if !completed {
+				if err := m.store.Release(ctx, record.Key); err != nil {
+					log.Error("failed to release idempotency key", log.WithError(err))
+				}
+			}
+		}()
+
+		next.ServeHTTP(rw, r)
+
+		status := rw.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+
+		if status >= http.StatusInternalServerError {
+			return
+		}
+
+		record.Completed = true
+		record.Status = status
+		record.Header = storableHeader(rw.before, rw.writtenHeader())
+		record.Body = rw.buf.Bytes()
+		if err := m.store.Complete(ctx, record); err != nil {
+			log.Error("failed to store idempotent response", log.WithError(err))
+			return
+		}
+
+		completed = true
+	})
+}
+
+func (m *Module) replay(w http.ResponseWriter, existing, current *Record) {
+	if existing.Fingerprint != current.Fingerprint {
+		detail := fmt.Sprintf("%s was already used with a different request payload", m.settings.HeaderName)
+		helper.WriteProblem(w, helper.NewProblem(http.StatusUnprocessableEntity, detail))
+		return
+	}
+
+	if !existing.Completed {
+		detail := fmt.Sprintf("a request with the same %s is still being processed", m.settings.HeaderName)
+		helper.WriteProblem(w, helper.NewProblem(http.StatusConflict, detail))
+		return
+	}
+
+	header := w.Header()
+	for key, values := range existing.Header {
+		header[key] = append([]string(nil), values...)
+	}
+	header.Set(replayedHeader, "true")
+
+	w.WriteHeader(existing.Status)
+	if _, err := w.Write(existing.Body); err != nil {
+		log.Debug("failed to write replayed response", log.WithError(err))
+	}
+}
+
+// scopedKey scopes the idempotency key to the current user and route, so
+// the same key from different users or routes never collide
+func scopedKey(r *http.Request, key string) string {
+	var userID string
+	if user, ok := authlib.CurrentUser(r.Context()); ok {
+		userID = user.LoginID()
+	}
+
+	route := r.URL.Path
+	if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+		route = rctx.RoutePattern()
+	}
+
+	h := sha256.New()
+	for _, part := range []string{userID, r.Method, route, key} {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// fingerprint hashes the request payload and restores the body so it can
+// still be read by the handler
+func fingerprint(r *http.Request) (string, error) {
+	h := sha256.New()
+	h.Write([]byte(r.Method))
+	h.Write([]byte{0})
+	h.Write([]byte(r.URL.RequestURI()))
+	h.Write([]byte{0})
+
+	if r.Body != nil && r.Body != http.NoBody {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			return "", err
+		}
+		r.Body.Close()
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		h.Write(body)
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// storableHeader returns the header set by the handler excluding the cookies,
+// which are specific to the client that triggers the response
+func storableHeader(before, after http.Header) http.Header {
+	stored := make(http.Header, len(after))
+	for key, values := range after {
+		if key == "Set-Cookie" {
+			continue
+		}
+
+		if previous, ok := before[key]; ok && slices.Equal(previous, values) {
+			continue
+		}
+
+		stored[key] = slices.Clone(values)
+	}
+
+	return stored
+}
+
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+
+	return false
+}
+
+// WriteHeader implements http.ResponseWriter
+func (w *recordingWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+		w.snapshot()
+	}
+
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Write implements http.ResponseWriter
+func (w *recordingWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+		w.snapshot()
+	}
+
+	w.buf.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+// snapshot keeps the header written by the handler, before the outer
+// writers change it, e.g. the Content-Encoding of the compression
+func (w *recordingWriter) snapshot() {
+	w.header = w.ResponseWriter.Header().Clone()
+}
+
+func (w *recordingWriter) writtenHeader() http.Header {
+	if w.header == nil {
+		// the handler completed without writing anything
+		return w.ResponseWriter.Header()
+	}
+
+	return w.header
+}
+
+// Unwrap returns the underlying writer to support http.ResponseController
+func (w *recordingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
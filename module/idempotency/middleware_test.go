@@ -0,0 +1,140 @@
+package idempotency
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/euiko/webapp/core"
+	"github.com/euiko/webapp/db/cache"
+)
+
+// newTestHandler guards the handler behind the idempotency middleware, an
+// outer middleware sets a header specific to every request like the csrf
+// token
+func newTestHandler(t *testing.T, handler http.Handler) http.Handler {
+	t.Helper()
+
+	m := NewModule(nil, WithStoreFactory(func(app core.App, s *Settings) (Store, error) {
+		return NewCacheStore(cache.NewInMemory()), nil
+	}))
+	if err := m.BeforeStart(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	var requests atomic.Int64
+	guarded := m.Middleware()(handler)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Request-Token", strconv.FormatInt(requests.Add(1), 10))
+		guarded.ServeHTTP(w, r)
+	})
+}
+
+func post(handler http.Handler, key, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/orders", strings.NewReader(body))
+	req.Header.Set("Idempotency-Key", key)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestReplay(t *testing.T) {
+	var calls atomic.Int64
+	handler := newTestHandler(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Location", "/orders/1")
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "secret"})
+		w.WriteHeader(http.StatusCreated)
+		w.Write(body)
+	}))
+
+	first := post(handler, "key-1", `{"item":"book"}`)
+	replayed := post(handler, "key-1", `{"item":"book"}`)
+
+	if calls.Load() != 1 {
+		t.Fatalf("expected the handler to run once, got %d", calls.Load())
+	}
+	if replayed.Code != http.StatusCreated || replayed.Body.String() != first.Body.String() {
+		t.Fatalf("expected the replayed response, got %d: %s", replayed.Code, replayed.Body.String())
+	}
+	if replayed.Header().Get(replayedHeader) != "true" || replayed.Header().Get("Location") != "/orders/1" {
+		t.Fatalf("expected the handler header to be replayed, got %v", replayed.Header())
+	}
+	if replayed.Header().Get("Set-Cookie") != "" {
+		t.Fatal("expected the cookies not to be replayed")
+	}
+
+	// the headers of the outer middlewares belong to the current request
+	if replayed.Header().Get("X-Request-Token") != "2" {
+		t.Fatalf("expected the fresh request header, got %q", replayed.Header().Get("X-Request-Token"))
+	}
+
+	if rec := post(handler, "key-2", `{"item":"book"}`); calls.Load() != 2 || rec.Code != http.StatusCreated {
+		t.Fatalf("expected another key to reach the handler, got %d", rec.Code)
+	}
+}
+
+func TestFingerprintMismatch(t *testing.T) {
+	handler := newTestHandler(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	post(handler, "key-1", `{"item":"book"}`)
+	if rec := post(handler, "key-1", `{"item":"pen"}`); rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422, got %d", rec.Code)
+	}
+}
+
+func TestInFlight(t *testing.T) {
+	var (
+		started = make(chan struct{})
+		release = make(chan struct{})
+	)
+	handler := newTestHandler(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		done <- post(handler, "key-1", `{}`)
+	}()
+
+	<-started
+	if rec := post(handler, "key-1", `{}`); rec.Code != http.StatusConflict {
+		t.Fatalf("expected 409 while in flight, got %d", rec.Code)
+	}
+
+	close(release)
+	if rec := <-done; rec.Code != http.StatusCreated {
+		t.Fatalf("expected the first request to complete, got %d", rec.Code)
+	}
+}
+
+func TestServerErrorNotStored(t *testing.T) {
+	var calls atomic.Int64
+	handler := newTestHandler(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	if rec := post(handler, "key-1", `{}`); rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503, got %d", rec.Code)
+	}
+
+	// the key is released so the client can retry
+	if rec := post(handler, "key-1", `{}`); rec.Code != http.StatusCreated || calls.Load() != 2 {
+		t.Fatalf("expected the retry to reach the handler, got %d", rec.Code)
+	}
+}
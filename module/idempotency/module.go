@@ -0,0 +1,110 @@
+package idempotency
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"time"
+
+	"github.com/euiko/webapp/core"
+	"github.com/euiko/webapp/db/cache"
+	"github.com/euiko/webapp/db/sqldb"
+	"github.com/euiko/webapp/settings"
+)
+
+type (
+	Module struct {
+		app          core.App
+		settings     Settings
+		storeFactory StoreFactory
+		store        Store
+		middleware   core.MiddlewareFunc
+	}
+
+	ModuleOption func(*Module)
+
+	StoreFactory func(app core.App, s *Settings) (Store, error)
+)
+
+var (
+	//go:embed internal/migrations
+	embededMigrationFS embed.FS
+)
+
+func ModuleFactory(options ...ModuleOption) core.ModuleFactory {
+	return func(app core.App) core.Module {
+		return NewModule(app, options...)
+	}
+}
+
+func WithStoreFactory(factory StoreFactory) ModuleOption {
+	return func(m *Module) {
+		m.storeFactory = factory
+	}
+}
+
+func NewModule(app core.App, options ...ModuleOption) *Module {
+	m := Module{
+		app: app,
+		settings: Settings{
+			Enabled:    true,
+			Store:      "cache",
+			TTL:        24 * time.Hour,
+			HeaderName: "Idempotency-Key",
+		},
+		storeFactory: defaultStoreFactory,
+	}
+
+	for _, opt := range options {
+		opt(&m)
+	}
+
+	return &m
+}
+
+func (m *Module) DefaultSettings(s *settings.Settings) {
+	s.SetExtra("idempotency", &m.settings)
+}
+
+func (m *Module) Init(ctx context.Context, s *settings.Settings) error {
+	if m.settings.Store == "sql" {
+		sqldb.AddMigrationFS(embededMigrationFS)
+	}
+
+	return nil
+}
+
+func (m *Module) Close() error {
+	return nil
+}
+
+func (m *Module) BeforeStart(ctx context.Context) error {
+	if !m.settings.Enabled {
+		return nil
+	}
+
+	var err error
+	m.store, err = m.storeFactory(m.app, &m.settings)
+	return err
+}
+
+// Middleware returns the idempotency middleware, it passes the requests
+// through when the module is disabled
+func (m *Module) Middleware() core.MiddlewareFunc {
+	if m.middleware == nil {
+		m.middleware = m.handle
+	}
+
+	return m.middleware
+}
+
+func defaultStoreFactory(app core.App, s *Settings) (Store, error) {
+	switch s.Store {
+	case "cache":
+		return NewCacheStore(cache.InMemory()), nil
+	case "sql":
+		return NewOrmStore(sqldb.ORM()), nil
+	}
+
+	return nil, fmt.Errorf("invalid idempotency store: %s (valid stores: cache, sql)", s.Store)
+}
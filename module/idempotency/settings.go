@@ -0,0 +1,12 @@
+package idempotency
+
+import "time"
+
+type (
+	Settings struct {
+		Enabled    bool          `mapstructure:"enabled"`
+		Store      string        `mapstructure:"store"`
+		TTL        time.Duration `mapstructure:"ttl"`
+		HeaderName string        `mapstructure:"header_name"`
+	}
+)
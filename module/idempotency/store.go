@@ -0,0 +1,192 @@
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/euiko/webapp/db/cache"
+	"github.com/euiko/webapp/db/sqldb"
+	"github.com/euiko/webapp/module/idempotency/internal/schema"
+)
+
+type (
+	// Record holds the state of an idempotency key
+	Record struct {
+		Key         string
+		Fingerprint string
+		Completed   bool
+		Status      int
+		Header      http.Header
+		Body        []byte
+		ExpiresAt   time.Time
+	}
+
+	Store interface {
+		// Begin reserves the key for the in-flight request, it returns the
+		// existing record instead when the key already reserved or completed
+		Begin(ctx context.Context, record Record) (*Record, error)
+		// Complete stores the response of the reserved key
+		Complete(ctx context.Context, record Record) error
+		// Release removes the key so the request can be retried
+		Release(ctx context.Context, key string) error
+	}
+
+	ormStore struct {
+		db sqldb.OrmDB
+	}
+
+	cacheStore struct {
+		mutex sync.Mutex
+		cache cache.Cache
+	}
+)
+
+const cacheKeyPrefix = "idempotency:"
+
+func NewOrmStore(db sqldb.OrmDB) Store {
+	return &ormStore{
+		db: db,
+	}
+}
+
+func NewCacheStore(c cache.Cache) Store {
+	return &cacheStore{
+		cache: c,
+	}
+}
+
+// Begin implements Store.
+func (s *ormStore) Begin(ctx context.Context, record Record) (*Record, error) {
+	newKey := schema.Key{
+		Key:         record.Key,
+		Fingerprint: record.Fingerprint,
+		ExpiresAt:   record.ExpiresAt,
+	}
+
+	// remove the expired key first so it can be reserved again
+	_, err := s.db.NewDelete().
+		Model((*schema.Key)(nil)).
+		Where("key = ?", record.Key).
+		Where("expires_at < ?", time.Now()).
+		Exec(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := s.db.NewInsert().
+		Model(&newKey).
+		On("CONFLICT (key) DO NOTHING").
+		Exec(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	inserted, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+
+	if inserted > 0 {
+		return nil, nil
+	}
+
+	var existing schema.Key
+	err = s.db.NewSelect().
+		Model(&existing).
+		Where("key = ?", record.Key).
+		Limit(1).
+		Scan(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return toRecord(existing), nil
+}
+
+// Complete implements Store.
+func (s *ormStore) Complete(ctx context.Context, record Record) error {
+	_, err := s.db.NewUpdate().
+		Model((*schema.Key)(nil)).
+		Set("completed = ?", true).
+		Set("status = ?", record.Status).
+		Set("header = ?", record.Header).
+		Set("body = ?", record.Body).
+		Set("expires_at = ?", record.ExpiresAt).
+		Set("updated_at = ?", time.Now()).
+		Where("key = ?", record.Key).
+		Exec(ctx)
+	return err
+}
+
+// Release implements Store.
+func (s *ormStore) Release(ctx context.Context, key string) error {
+	_, err := s.db.NewDelete().
+		Model((*schema.Key)(nil)).
+		Where("key = ?", key).
+		Exec(ctx)
+	return err
+}
+
+// Begin implements Store.
+func (s *cacheStore) Begin(ctx context.Context, record Record) (*Record, error) {
+	// the cache doesn't provide an atomic set if not exists
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	cached, err := s.cache.Get(cacheKeyPrefix + record.Key)
+	if err == nil {
+		existing, ok := cached.(Record)
+		if ok && existing.ExpiresAt.After(time.Now()) {
+			return &existing, nil
+		}
+	} else if !errors.Is(err, cache.ErrKeyNotFound) {
+		return nil, err
+	}
+
+	return nil, s.set(record)
+}
+
+// Complete implements Store.
+func (s *cacheStore) Complete(ctx context.Context, record Record) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	record.Completed = true
+	return s.set(record)
+}
+
+// Release implements Store.
+func (s *cacheStore) Release(ctx context.Context, key string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	err := s.cache.Delete(cacheKeyPrefix + key)
+	if errors.Is(err, cache.ErrKeyNotFound) {
+		return nil
+	}
+
+	return err
+}
+
+func (s *cacheStore) set(record Record) error {
+	return s.cache.Set(
+		cacheKeyPrefix+record.Key,
+		record,
+		cache.SetWithTimeout(time.Until(record.ExpiresAt)),
+	)
+}
+
+func toRecord(k schema.Key) *Record {
+	return &Record{
+		Key:         k.Key,
+		Fingerprint: k.Fingerprint,
+		Completed:   k.Completed,
+		Status:      k.Status,
+		Header:      k.Header,
+		Body:        k.Body,
+		ExpiresAt:   k.ExpiresAt,
+	}
+}
@@ -6,4 +6,5 @@ import (
 
 var (
 	PermissionManageRoles = role.Group("admin").NewPermission("manage-roles", "Manage roles")
+	PermissionManageUsers = role.Group("admin").NewPermission("manage-users", "Manage users and their roles")
 )
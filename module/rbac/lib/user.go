@@ -1,7 +1,29 @@
 package lib
 
+import (
+	"net/http"
+
+	authlib "github.com/euiko/webapp/module/auth/lib"
+)
+
 type (
 	User interface {
 		RoleName() string
 	}
 )
+
+// RoleFromRequest returns the role name of the current user, it can be used
+// as httpcache.VaryFunc for responses that vary by the user role
+func RoleFromRequest(r *http.Request) string {
+	user, ok := authlib.CurrentUser(r.Context())
+	if !ok {
+		return ""
+	}
+
+	roleUser, ok := user.(User)
+	if !ok {
+		return ""
+	}
+
+	return roleUser.RoleName()
+}
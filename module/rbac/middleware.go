@@ -48,6 +48,20 @@ func newMiddleware(m *Module) func(http.Handler) http.Handler {
 			}
 
 			permissionManager := m.buildPermissionManager(userRole.Permissions...)
+
+			// the scoped credentials, e.g. api keys, only get the subset of
+			// the role permissions granted by their scopes
+			if scopes, ok := authlib.ScopesFromContext(r.Context()); ok {
+				permissions := make([]*role.Permission, 0, len(scopes))
+				for _, p := range permissionManager.All() {
+					if authlib.ScopesAllow(scopes, p) {
+						permissions = append(permissions, p)
+					}
+				}
+
+				permissionManager = role.NewPermissionManager(permissions)
+			}
+
 			ctx := lib.ContextWithPermissions(r.Context(), permissionManager)
 			r = r.WithContext(ctx)
 
@@ -6,11 +6,13 @@ import (
 
 	"github.com/euiko/webapp/core"
 	authlib "github.com/euiko/webapp/module/auth/lib"
+	idempotencylib "github.com/euiko/webapp/module/idempotency/lib"
 	api "github.com/euiko/webapp/module/rbac/internal/api"
 	"github.com/euiko/webapp/module/rbac/lib"
 	"github.com/euiko/webapp/module/rbac/lib/role"
 	"github.com/euiko/webapp/pkg/common/httpapi"
 	"github.com/euiko/webapp/pkg/helper"
+	"github.com/euiko/webapp/pkg/httpcache"
 	"github.com/euiko/webapp/pkg/log"
 	"github.com/go-chi/chi/v5"
 )
@@ -36,12 +38,15 @@ func (m *Module) PostRoute(r core.Router) {
 func (m *Module) APIRoute(r core.Router) {
 	r.Group(func(r core.Router) {
 		r.Use(authlib.AuthRequiredMiddleware(m.app))
-		r.Get("/permissions", m.listAllPermissionsHandler)
+		// permissions only change on restart, let the clients revalidate using etag
+		r.With(httpcache.Middleware(httpcache.WithPolicy(httpcache.RevalidatePolicy()))).
+			Get("/permissions", m.listAllPermissionsHandler)
 		r.Method("GET", "/users/me/role", m.getRoleHandler(m.userFromSession))
 
 		// endpoint that requires manage roles permission
 		r.Get("/roles", m.listAllRolesHandler)
-		r.Method("POST", "/roles", role.Handler(lib.PermissionManageRoles, http.HandlerFunc(m.addRoleHandler)))
+		r.With(idempotencylib.IdempotencyMiddleware(m.app)).
+			Method("POST", "/roles", role.Handler(lib.PermissionManageRoles, http.HandlerFunc(m.addRoleHandler)))
 		r.Method("DELETE", "/roles/{name}", role.Handler(lib.PermissionManageRoles, http.HandlerFunc(m.removeRoleHandler)))
 		r.Method("PUT", "/roles/{name}", role.Handler(lib.PermissionManageRoles, http.HandlerFunc(m.updateRoleHandler)))
 		r.Method("GET", "/users/{id}/role", role.Handler(lib.PermissionManageRoles, m.getRoleHandler(m.userFromIDParams)))
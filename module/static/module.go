@@ -31,8 +31,9 @@ func NewModule() *Module {
 		settings: Settings{
 			Enabled: true,
 			Embed: EmbedSettings{
-				IndexPath: "index.html",
-				UseMPA:    false,
+				IndexPath:     "index.html",
+				UseMPA:        false,
+				Precompressed: true,
 			},
 			Proxy: ProxySettings{
 				Upstream: "http://localhost:5173",
@@ -0,0 +1,140 @@
+package static
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"os"
+	"path/filepath"
+
+	"github.com/euiko/webapp/pkg/compress"
+	"github.com/spf13/cobra"
+)
+
+var (
+	// precompressedExtensions maps the encoding to the sibling file extension
+	precompressedExtensions = map[string]string{
+		compress.EncodingBrotli: ".br",
+		compress.EncodingZstd:   ".zst",
+		compress.EncodingGzip:   ".gz",
+	}
+)
+
+func (m *Module) Command(cmd *cobra.Command) {
+	staticCmd := cobra.Command{
+		Use:   "static",
+		Short: "Static files related commands",
+	}
+	staticCmd.AddCommand(precompressCmd())
+	cmd.AddCommand(&staticCmd)
+}
+
+func precompressCmd() *cobra.Command {
+	var (
+		encodings []string
+		minSize   int
+	)
+
+	cmd := &cobra.Command{
+		Use:   "precompress [DIR]",
+		Short: "Generate the compressed siblings of the static files",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dir := "./ui/dist"
+			if len(args) > 0 {
+				dir = args[0]
+			}
+
+			generated, err := precompressDir(dir, encodings, int64(minSize))
+			for _, name := range generated {
+				fmt.Println("generated", name)
+			}
+
+			return err
+		},
+	}
+
+	cmd.Flags().StringSliceVarP(&encodings, "encodings", "e", []string{compress.EncodingBrotli, compress.EncodingGzip}, "Encodings to generate")
+	cmd.Flags().IntVarP(&minSize, "min-size", "m", 1024, "Minimum file size in bytes to be compressed")
+	return cmd
+}
+
+// precompressDir walks the dir and writes the compressed siblings of every
+// compressible file, returning the generated file names
+func precompressDir(dir string, encodings []string, minSize int64) ([]string, error) {
+	var (
+		compressor = compress.New()
+		generated  = []string{}
+		compressed = make(map[string]struct{}, len(precompressedExtensions))
+	)
+
+	for _, ext := range precompressedExtensions {
+		compressed[ext] = struct{}{}
+	}
+
+	err := filepath.WalkDir(dir, func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+
+		ext := filepath.Ext(name)
+		if _, ok := compressed[ext]; ok {
+			return nil
+		}
+
+		if !compressor.IsCompressible(mime.TypeByExtension(ext)) {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		if info.Size() < minSize {
+			return nil
+		}
+
+		for _, encoding := range encodings {
+			siblingExt, ok := precompressedExtensions[encoding]
+			if !ok {
+				return fmt.Errorf("%w: %s", compress.ErrUnsupportedEncoding, encoding)
+			}
+
+			if err := compressFile(name, name+siblingExt, encoding); err != nil {
+				return err
+			}
+			generated = append(generated, name+siblingExt)
+		}
+
+		return nil
+	})
+
+	return generated, err
+}
+
+func compressFile(src, dst, encoding string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	encoder, err := compress.NewEncoder(encoding, out)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(encoder, in); err != nil {
+		return err
+	}
+
+	return encoder.Close()
+}
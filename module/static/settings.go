@@ -10,6 +10,9 @@ type (
 	EmbedSettings struct {
 		IndexPath string `mapstructure:"index_path"`
 		UseMPA    bool   `mapstructure:"use_mpa"`
+		// Precompressed serves the .br/.gz siblings of a file generated at
+		// build time (see `static precompress`) when the client accepts it
+		Precompressed bool `mapstructure:"precompressed"`
 	}
 
 	ProxySettings struct {
@@ -4,11 +4,18 @@ package static
 
 import (
 	"embed"
+	"io"
 	"io/fs"
+	"mime"
 	"net/http"
+	"path"
 	"strings"
 
-	"github.com/euiko/webapp/api"
+	"github.com/euiko/webapp/core"
+	"github.com/euiko/webapp/pkg/compress"
+	"github.com/euiko/webapp/pkg/helper"
+	"github.com/euiko/webapp/pkg/log"
+	"github.com/euiko/webapp/pkg/secure"
 )
 
 var EmbedFS embed.FS
@@ -23,7 +30,7 @@ func (s subFS) Open(name string) (fs.File, error) {
 	return s.fs.Open(s.path + "/" + name)
 }
 
-func createStaticRoutes(r api.Router, s *Settings) {
+func createStaticRoutes(r core.Router, s *Settings) {
 	embedFs := newSubFS(EmbedFS, "ui/dist")
 
 	// serve other files from the embedded EmbedFS
@@ -46,7 +53,7 @@ func createStaticRoutes(r api.Router, s *Settings) {
 
 		// register route
 		r.Get(route, func(w http.ResponseWriter, r *http.Request) {
-			httpFs := http.FileServer(http.FS(fs))
+			httpFs := newFileServer(fs, s)
 
 			// trim the directory prefix for directories
 			if entry.IsDir() {
@@ -62,6 +69,16 @@ func createStaticRoutes(r api.Router, s *Settings) {
 	// serve index.html from embedded static
 	if !s.Embed.UseMPA {
 		r.Get("/*", func(w http.ResponseWriter, r *http.Request) {
+			// the index need to be rendered for every request when using csp nonce
+			if nonce := secure.Nonce(r.Context()); nonce != "" {
+				serveIndexWithNonce(w, r, embedFs, s.Embed.IndexPath, nonce)
+				return
+			}
+
+			if s.Embed.Precompressed && servePrecompressed(w, r, embedFs, s.Embed.IndexPath) {
+				return
+			}
+
 			http.ServeFileFS(w, r, embedFs, s.Embed.IndexPath)
 		})
 	}
@@ -73,3 +90,93 @@ func newSubFS(fs fs.FS, path string) fs.FS {
 		fs:   fs,
 	}
 }
+
+func newFileServer(fsys fs.FS, s *Settings) http.Handler {
+	fileServer := http.FileServer(http.FS(fsys))
+	if !s.Embed.Precompressed {
+		return fileServer
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+		if name != "" && servePrecompressed(w, r, fsys, name) {
+			return
+		}
+
+		fileServer.ServeHTTP(w, r)
+	})
+}
+
+// serveIndexWithNonce serves the index with the nonce injected into its
+// script and style tags
+func serveIndexWithNonce(w http.ResponseWriter, r *http.Request, fsys fs.FS, name string, nonce string) {
+	content, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		log.Error("failed to read index file", log.WithField("name", name), log.WithError(err))
+		http.NotFound(w, r)
+		return
+	}
+
+	content = secure.InjectNonce(content, nonce)
+
+	// the nonce changes for every request, thus it mustn't be cached
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(content)
+}
+
+// servePrecompressed serves the precompressed sibling of the named file
+// that is acceptable by the client, it returns false when none found
+func servePrecompressed(w http.ResponseWriter, r *http.Request, fsys fs.FS, name string) bool {
+	var (
+		available = make([]string, 0, len(precompressedExtensions))
+		extension = path.Ext(name)
+	)
+
+	for _, encoding := range compress.DefaultEncodings {
+		ext, ok := precompressedExtensions[encoding]
+		if !ok {
+			continue
+		}
+
+		if _, err := fs.Stat(fsys, name+ext); err == nil {
+			available = append(available, encoding)
+		}
+	}
+
+	encoding := compress.Negotiate(r.Header.Get("Accept-Encoding"), available...)
+	if encoding == "" {
+		// still vary the response when there are any precompressed files
+		if len(available) > 0 {
+			helper.AddVary(w.Header(), "Accept-Encoding")
+		}
+		return false
+	}
+
+	f, err := fsys.Open(name + precompressedExtensions[encoding])
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		return false
+	}
+
+	content, ok := f.(io.ReadSeeker)
+	if !ok {
+		return false
+	}
+
+	// use the original file type instead of the compressed one
+	if contentType := mime.TypeByExtension(extension); contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	w.Header().Set("Content-Encoding", encoding)
+	helper.AddVary(w.Header(), "Accept-Encoding")
+
+	http.ServeContent(w, r, name, stat.ModTime(), content)
+	return true
+}
@@ -0,0 +1,204 @@
+package compress
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"errors"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+type (
+	// Encoder is a resettable compressing writer
+	Encoder interface {
+		io.WriteCloser
+		Reset(io.Writer)
+		Flush() error
+	}
+
+	EncoderFactory func() Encoder
+
+	acceptedEncoding struct {
+		name  string
+		q     float64
+		order int
+	}
+)
+
+const (
+	EncodingBrotli  = "br"
+	EncodingZstd    = "zstd"
+	EncodingGzip    = "gzip"
+	EncodingDeflate = "deflate"
+)
+
+var (
+	ErrUnsupportedEncoding = errors.New("unsupported encoding")
+
+	// DefaultEncodings lists supported encodings ordered by server preference
+	DefaultEncodings = []string{
+		EncodingBrotli,
+		EncodingZstd,
+		EncodingGzip,
+		EncodingDeflate,
+	}
+
+	encoderFactories = map[string]EncoderFactory{
+		EncodingBrotli: func() Encoder {
+			return brotli.NewWriterLevel(io.Discard, brotli.DefaultCompression)
+		},
+		EncodingZstd: func() Encoder {
+			// error only returned on invalid options
+			encoder, _ := zstd.NewWriter(io.Discard, zstd.WithEncoderLevel(zstd.SpeedDefault))
+			return encoder
+		},
+		EncodingGzip: func() Encoder {
+			return gzip.NewWriter(io.Discard)
+		},
+		EncodingDeflate: func() Encoder {
+			// error only returned on invalid level
+			encoder, _ := flate.NewWriter(io.Discard, flate.DefaultCompression)
+			return encoder
+		},
+	}
+
+	encoderPools sync.Map
+)
+
+// RegisterEncoder adds or replaces the encoder factory of the given encoding
+func RegisterEncoder(name string, factory EncoderFactory) {
+	encoderFactories[name] = factory
+	encoderPools.Delete(name)
+}
+
+// NewEncoder creates a new encoder of the given encoding that writes into w
+func NewEncoder(name string, w io.Writer) (Encoder, error) {
+	factory, ok := encoderFactories[name]
+	if !ok {
+		return nil, ErrUnsupportedEncoding
+	}
+
+	encoder := factory()
+	encoder.Reset(w)
+	return encoder, nil
+}
+
+// Negotiate picks the best encoding from the Accept-Encoding header value,
+// ties on quality are resolved using the order of the supported encodings.
+// Returns an empty string when none acceptable.
+func Negotiate(acceptEncoding string, supported ...string) string {
+	if acceptEncoding == "" || len(supported) == 0 {
+		return ""
+	}
+
+	var (
+		accepted = parseAcceptEncoding(acceptEncoding)
+		wildcard = -1.0
+	)
+
+	if q, ok := accepted["*"]; ok {
+		wildcard = q
+	}
+
+	candidates := make([]acceptedEncoding, 0, len(supported))
+	for i, name := range supported {
+		q, ok := accepted[name]
+		if !ok {
+			q = wildcard
+		}
+
+		if q <= 0 {
+			continue
+		}
+
+		candidates = append(candidates, acceptedEncoding{name: name, q: q, order: i})
+	}
+
+	if len(candidates) == 0 {
+		return ""
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].q != candidates[j].q {
+			return candidates[i].q > candidates[j].q
+		}
+		return candidates[i].order < candidates[j].order
+	})
+
+	return candidates[0].name
+}
+
+// registeredEncodings filters the names having an encoder factory, keeping
+// their order
+func registeredEncodings(names []string) []string {
+	registered := make([]string, 0, len(names))
+	for _, name := range names {
+		if _, ok := encoderFactories[name]; ok {
+			registered = append(registered, name)
+		}
+	}
+
+	return registered
+}
+
+func getEncoder(name string, w io.Writer) Encoder {
+	factory, ok := encoderFactories[name]
+	if !ok {
+		return nil
+	}
+
+	pool, _ := encoderPools.LoadOrStore(name, &sync.Pool{
+		New: func() any {
+			return factory()
+		},
+	})
+
+	encoder := pool.(*sync.Pool).Get().(Encoder)
+	encoder.Reset(w)
+	return encoder
+}
+
+func putEncoder(name string, encoder Encoder) {
+	pool, ok := encoderPools.Load(name)
+	if !ok {
+		return
+	}
+
+	// detach from the previous writer before putting it back
+	encoder.Reset(io.Discard)
+	pool.(*sync.Pool).Put(encoder)
+}
+
+func parseAcceptEncoding(header string) map[string]float64 {
+	accepted := make(map[string]float64)
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+
+		q := 1.0
+		for _, param := range strings.Split(params, ";") {
+			key, value, ok := strings.Cut(strings.TrimSpace(param), "=")
+			if !ok || strings.TrimSpace(key) != "q" {
+				continue
+			}
+
+			parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			if err == nil {
+				q = parsed
+			}
+		}
+
+		accepted[name] = q
+	}
+
+	return accepted
+}
@@ -0,0 +1,305 @@
+package compress
+
+import (
+	"bufio"
+	"errors"
+	"mime"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/euiko/webapp/pkg/helper"
+)
+
+type (
+	Compressor struct {
+		encodings    []string
+		minSize      int
+		contentTypes []string
+	}
+
+	Option func(*Compressor)
+
+	responseWriter struct {
+		http.ResponseWriter
+
+		compressor  *Compressor
+		encoding    string
+		status      int
+		buf         []byte
+		encoder     Encoder
+		decided     bool
+		wroteHeader bool
+	}
+)
+
+var (
+	// DefaultContentTypes lists the media types that are worth to be compressed,
+	// a trailing "*" matches any subtype
+	DefaultContentTypes = []string{
+		"text/*",
+		"application/json",
+		"application/problem+json",
+		"application/javascript",
+		"application/xml",
+		"application/xhtml+xml",
+		"application/rss+xml",
+		"application/atom+xml",
+		"application/wasm",
+		"application/manifest+json",
+		"image/svg+xml",
+		"font/ttf",
+		"font/otf",
+	}
+)
+
+// WithEncodings sets the enabled encodings ordered by preference
+func WithEncodings(encodings ...string) Option {
+	return func(c *Compressor) {
+		c.encodings = encodings
+	}
+}
+
+// WithMinSize sets the minimum response size in bytes to be compressed
+func WithMinSize(size int) Option {
+	return func(c *Compressor) {
+		c.minSize = size
+	}
+}
+
+// WithContentTypes sets the allowed content types to be compressed
+func WithContentTypes(contentTypes ...string) Option {
+	return func(c *Compressor) {
+		c.contentTypes = contentTypes
+	}
+}
+
+func New(opts ...Option) *Compressor {
+	c := Compressor{
+		encodings:    DefaultEncodings,
+		minSize:      1024,
+		contentTypes: DefaultContentTypes,
+	}
+
+	for _, opt := range opts {
+		opt(&c)
+	}
+
+	return &c
+}
+
+// Middleware creates a new compression middleware using the given options
+func Middleware(opts ...Option) func(http.Handler) http.Handler {
+	return New(opts...).Middleware
+}
+
+// Middleware implements response compression as an http middleware, the
+// encodings without a registered encoder are never negotiated
+func (c *Compressor) Middleware(next http.Handler) http.Handler {
+	encodings := registeredEncodings(c.encodings)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// keep wrapping even when nothing acceptable, so the Vary header
+		// still being set for compressible responses
+		encoding := Negotiate(r.Header.Get("Accept-Encoding"), encodings...)
+		cw := &responseWriter{
+			ResponseWriter: w,
+			compressor:     c,
+			encoding:       encoding,
+		}
+		defer cw.close()
+
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// IsCompressible reports whether the content type is in the allow list
+func (c *Compressor) IsCompressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	for _, allowed := range c.contentTypes {
+		if prefix, ok := strings.CutSuffix(allowed, "*"); ok {
+			if strings.HasPrefix(mediaType, prefix) {
+				return true
+			}
+		} else if mediaType == allowed {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (w *responseWriter) WriteHeader(status int) {
+	// forward informational responses directly
+	if status >= 100 && status < 200 && status != http.StatusSwitchingProtocols {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *responseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+
+	if !w.decided {
+		w.buf = append(w.buf, b...)
+		if w.encoding != "" && len(w.buf) < w.compressor.minSize && w.eligible() {
+			return len(b), nil
+		}
+
+		if err := w.decide(true); err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+
+	if w.encoder != nil {
+		return w.encoder.Write(b)
+	}
+
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush implements http.Flusher, flushing means the response is being
+// streamed thus it will be compressed regardless of the minimum size
+func (w *responseWriter) Flush() {
+	if !w.decided {
+		if w.status == 0 {
+			w.status = http.StatusOK
+		}
+
+		if err := w.decide(len(w.buf) > 0); err != nil {
+			return
+		}
+	}
+
+	if w.encoder != nil {
+		_ = w.encoder.Flush()
+	}
+
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack implements http.Hijacker
+func (w *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("the underlying response writer doesn't support hijack")
+	}
+
+	return hijacker.Hijack()
+}
+
+// Unwrap returns the underlying writer to support http.ResponseController
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *responseWriter) eligible() bool {
+	if w.status < 200 || w.status == http.StatusNoContent || w.status == http.StatusNotModified {
+		return false
+	}
+
+	// compressing a byte range breaks its Content-Range, e.g. the resumed
+	// downloads of the static files
+	header := w.Header()
+	if w.status == http.StatusPartialContent || header.Get("Content-Range") != "" {
+		return false
+	}
+
+	if header.Get("Content-Encoding") != "" {
+		return false
+	}
+
+	if strings.Contains(header.Get("Cache-Control"), "no-transform") {
+		return false
+	}
+
+	// sniff the content type the same way net/http does
+	contentType := header.Get("Content-Type")
+	if contentType == "" && len(w.buf) > 0 {
+		contentType = http.DetectContentType(w.buf)
+		header.Set("Content-Type", contentType)
+	}
+
+	return w.compressor.IsCompressible(contentType)
+}
+
+func (w *responseWriter) decide(compress bool) error {
+	w.decided = true
+	header := w.Header()
+
+	eligible := w.eligible()
+	if eligible {
+		helper.AddVary(header, "Accept-Encoding")
+	}
+
+	if compress && eligible && w.encoding != "" {
+		header.Set("Content-Encoding", w.encoding)
+		header.Del("Content-Length")
+		header.Del("Accept-Ranges")
+
+		// the representation differs from the identity one
+		if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			header.Set("ETag", "W/"+etag)
+		}
+
+		w.encoder = getEncoder(w.encoding, w.ResponseWriter)
+	}
+
+	w.writeHeader()
+	if len(w.buf) == 0 {
+		return nil
+	}
+
+	var err error
+	if w.encoder != nil {
+		_, err = w.encoder.Write(w.buf)
+	} else {
+		_, err = w.ResponseWriter.Write(w.buf)
+	}
+	w.buf = nil
+
+	return err
+}
+
+func (w *responseWriter) writeHeader() {
+	if w.wroteHeader {
+		return
+	}
+
+	w.wroteHeader = true
+	if w.status == 0 {
+		return
+	}
+
+	w.ResponseWriter.WriteHeader(w.status)
+}
+
+func (w *responseWriter) close() {
+	if !w.decided {
+		// the response is smaller than the threshold
+		_ = w.decide(false)
+	}
+
+	if w.encoder != nil {
+		_ = w.encoder.Close()
+		putEncoder(w.encoding, w.encoder)
+		w.encoder = nil
+	}
+}
@@ -0,0 +1,242 @@
+package compress
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testBody = strings.Repeat("compressible text ", 200)
+
+func serve(handler http.Handler, req *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	Middleware()(handler).ServeHTTP(rec, req)
+	return rec
+}
+
+func gzipRequest(target string) *http.Request {
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	return req
+}
+
+func textHandler(body string, header http.Header) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for key, values := range header {
+			w.Header()[key] = values
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		io.WriteString(w, body)
+	})
+}
+
+func TestNegotiate(t *testing.T) {
+	cases := map[string]string{
+		"":                        "",
+		"gzip":                    EncodingGzip,
+		"gzip, br":                EncodingBrotli,
+		"gzip;q=1, br;q=0.5":      EncodingGzip,
+		"br;q=0, gzip":            EncodingGzip,
+		"*":                       EncodingBrotli,
+		"*, br;q=0":               EncodingZstd,
+		"identity":                "",
+		"GZIP;q=0.8, deflate;q=1": EncodingDeflate,
+	}
+
+	for header, expected := range cases {
+		if got := Negotiate(header, DefaultEncodings...); got != expected {
+			t.Errorf("Negotiate(%q) = %q, expected %q", header, got, expected)
+		}
+	}
+}
+
+func TestCompression(t *testing.T) {
+	rec := serve(textHandler(testBody, http.Header{"Etag": {`"v1"`}}), gzipRequest("/"))
+
+	if rec.Header().Get("Content-Encoding") != EncodingGzip {
+		t.Fatalf("expected gzip, got %q", rec.Header().Get("Content-Encoding"))
+	}
+	if rec.Header().Get("Vary") != "Accept-Encoding" {
+		t.Fatalf("expected Vary: Accept-Encoding, got %q", rec.Header().Get("Vary"))
+	}
+	if rec.Header().Get("Etag") != `W/"v1"` {
+		t.Fatalf("expected a weak etag, got %q", rec.Header().Get("Etag"))
+	}
+
+	reader, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(reader)
+	if err != nil || string(body) != testBody {
+		t.Fatalf("expected the decompressed body, got %d bytes: %v", len(body), err)
+	}
+}
+
+func TestUnknownEncoding(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept-Encoding", "xz, gzip;q=0.5")
+
+	rec := httptest.NewRecorder()
+	Middleware(WithEncodings("xz", EncodingGzip))(textHandler(testBody, nil)).ServeHTTP(rec, req)
+
+	// the encoding without an encoder is skipped instead of mislabeling the
+	// identity body
+	if encoding := rec.Header().Get("Content-Encoding"); encoding != EncodingGzip {
+		t.Fatalf("expected gzip, got %q", encoding)
+	}
+	if _, err := gzip.NewReader(rec.Body); err != nil {
+		t.Fatalf("expected the gzip body, got %v", err)
+	}
+}
+
+func TestSkipped(t *testing.T) {
+	content := strings.NewReader(testBody)
+	cases := map[string]struct {
+		handler http.Handler
+		req     *http.Request
+	}{
+		"not accepted": {
+			handler: textHandler(testBody, nil),
+			req:     httptest.NewRequest(http.MethodGet, "/", nil),
+		},
+		"below min size": {
+			handler: textHandler("small", nil),
+			req:     gzipRequest("/"),
+		},
+		"not compressible": {
+			handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "image/png")
+				io.WriteString(w, testBody)
+			}),
+			req: gzipRequest("/"),
+		},
+		"already encoded": {
+			handler: textHandler(testBody, http.Header{"Content-Encoding": {"br"}}),
+			req:     gzipRequest("/"),
+		},
+		"no transform": {
+			handler: textHandler(testBody, http.Header{"Cache-Control": {"no-transform"}}),
+			req:     gzipRequest("/"),
+		},
+		"head": {
+			handler: textHandler(testBody, nil),
+			req: func() *http.Request {
+				req := gzipRequest("/")
+				req.Method = http.MethodHead
+				return req
+			}(),
+		},
+		"partial content": {
+			handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				http.ServeContent(w, r, "file.txt", time.Time{}, content)
+			}),
+			req: func() *http.Request {
+				req := gzipRequest("/file.txt")
+				req.Header.Set("Range", "bytes=0-1999")
+				return req
+			}(),
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			rec := serve(c.handler, c.req)
+			if encoding := rec.Header().Get("Content-Encoding"); encoding == EncodingGzip {
+				t.Fatal("expected the response not to be compressed")
+			}
+		})
+	}
+
+	t.Run("range body", func(t *testing.T) {
+		req := gzipRequest("/file.txt")
+		req.Header.Set("Range", "bytes=10-19")
+		rec := serve(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.ServeContent(w, r, "file.txt", time.Time{}, strings.NewReader(testBody))
+		}), req)
+
+		if rec.Code != http.StatusPartialContent || rec.Body.String() != testBody[10:20] {
+			t.Fatalf("expected the identity range, got %d: %q", rec.Code, rec.Body.String())
+		}
+	})
+}
+
+// flushRecorder records the flushes of the response
+type flushRecorder struct {
+	*httptest.ResponseRecorder
+	flushed []int
+}
+
+func (r *flushRecorder) Flush() {
+	r.flushed = append(r.flushed, r.Body.Len())
+	r.ResponseRecorder.Flush()
+}
+
+func TestFlush(t *testing.T) {
+	rec := &flushRecorder{ResponseRecorder: httptest.NewRecorder()}
+	handler := Middleware()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, "data: first\n\n")
+		w.(http.Flusher).Flush()
+		io.WriteString(w, "data: second\n\n")
+	}))
+	handler.ServeHTTP(rec, gzipRequest("/events"))
+
+	// the streamed response is compressed below the min size, and the
+	// first event reaches the client before the handler returns
+	if rec.Header().Get("Content-Encoding") != EncodingGzip {
+		t.Fatalf("expected the stream to be compressed, got %q", rec.Header().Get("Content-Encoding"))
+	}
+	if len(rec.flushed) == 0 || rec.flushed[0] == 0 {
+		t.Fatalf("expected the first event to be flushed, got %v", rec.flushed)
+	}
+
+	reader, err := gzip.NewReader(bytes.NewReader(rec.Body.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(reader)
+	if string(body) != "data: first\n\ndata: second\n\n" {
+		t.Fatalf("unexpected stream %q", body)
+	}
+}
+
+func TestHijack(t *testing.T) {
+	server := httptest.NewServer(Middleware()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+
+		rw.WriteString("HTTP/1.1 200 OK\r\nContent-Length: 8\r\nConnection: close\r\n\r\nhijacked")
+		rw.Flush()
+	})))
+	defer server.Close()
+
+	conn, err := net.Dial("tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	io.WriteString(conn, "GET / HTTP/1.1\r\nHost: example.com\r\nAccept-Encoding: gzip\r\n\r\n")
+	res, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	body, _ := io.ReadAll(res.Body)
+	if string(body) != "hijacked" {
+		t.Fatalf("expected the hijacked response, got %q", body)
+	}
+}
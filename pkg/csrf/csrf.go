@@ -0,0 +1,336 @@
+package csrf
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/euiko/webapp/pkg/helper"
+	"github.com/euiko/webapp/pkg/log"
+	"github.com/euiko/webapp/pkg/session"
+)
+
+type (
+	// Mode determines where the expected token is stored
+	Mode int
+
+	Protection struct {
+		mode           Mode
+		sessionKey     string
+		cookieName     string
+		cookiePath     string
+		cookieSecure   bool
+		headerName     string
+		fieldName      string
+		trustedOrigins []string
+		exemptBearer   bool
+		errorHandler   func(http.ResponseWriter, *http.Request, error)
+	}
+
+	Option func(*Protection)
+
+	contextKeyType struct{}
+)
+
+const (
+	// ModeSynchronizer stores the token in the session.Session and
+	// compares it against the submitted one
+	ModeSynchronizer Mode = iota
+	// ModeDoubleSubmit stores the token only in a cookie and compares it
+	// against the submitted one
+	ModeDoubleSubmit
+)
+
+const tokenLength = 32
+
+var (
+	ErrMissingToken   = errors.New("csrf token missing")
+	ErrInvalidToken   = errors.New("csrf token invalid")
+	ErrInvalidOrigin  = errors.New("csrf origin check failed")
+	ErrInvalidReferer = errors.New("csrf referer check failed")
+
+	contextKey = contextKeyType{}
+
+	safeMethods = map[string]struct{}{
+		http.MethodGet:     {},
+		http.MethodHead:    {},
+		http.MethodOptions: {},
+		http.MethodTrace:   {},
+	}
+)
+
+// WithMode sets where the expected token is stored, default to ModeSynchronizer
+func WithMode(mode Mode) Option {
+	return func(p *Protection) {
+		p.mode = mode
+	}
+}
+
+// WithSessionKey sets the session key used to store the token in ModeSynchronizer
+func WithSessionKey(key string) Option {
+	return func(p *Protection) {
+		p.sessionKey = key
+	}
+}
+
+// WithCookie sets the cookie used to expose the token to the client
+func WithCookie(name string, path string, secure bool) Option {
+	return func(p *Protection) {
+		p.cookieName = name
+		p.cookiePath = path
+		p.cookieSecure = secure
+	}
+}
+
+// WithHeaderName sets the header used to expose and to submit the token
+func WithHeaderName(name string) Option {
+	return func(p *Protection) {
+		p.headerName = name
+	}
+}
+
+// WithFieldName sets the form field name used to submit the token
+func WithFieldName(name string) Option {
+	return func(p *Protection) {
+		p.fieldName = name
+	}
+}
+
+// WithTrustedOrigins adds origins (scheme://host[:port]) that are allowed
+// to send unsafe requests besides the request's own origin, e.g. the public
+// https origin when the tls is terminated by a proxy
+func WithTrustedOrigins(origins ...string) Option {
+	return func(p *Protection) {
+		p.trustedOrigins = append(p.trustedOrigins, origins...)
+	}
+}
+
+// WithBearerExemption toggles skipping the check for requests that
+// are authenticated using a bearer token, enabled by default
+func WithBearerExemption(exempt bool) Option {
+	return func(p *Protection) {
+		p.exemptBearer = exempt
+	}
+}
+
+// WithErrorHandler overrides the default forbidden response
+func WithErrorHandler(handler func(http.ResponseWriter, *http.Request, error)) Option {
+	return func(p *Protection) {
+		p.errorHandler = handler
+	}
+}
+
+func New(opts ...Option) *Protection {
+	p := Protection{
+		mode:           ModeSynchronizer,
+		sessionKey:     "_csrf",
+		cookieName:     "csrf_token",
+		cookiePath:     "/",
+		cookieSecure:   false,
+		headerName:     "X-CSRF-Token",
+		fieldName:      "csrf_token",
+		trustedOrigins: []string{},
+		exemptBearer:   true,
+		errorHandler:   defaultErrorHandler,
+	}
+
+	for _, opt := range opts {
+		opt(&p)
+	}
+
+	return &p
+}
+
+// Middleware creates a new csrf protection middleware using the given options
+func Middleware(opts ...Option) func(http.Handler) http.Handler {
+	return New(opts...).Middleware
+}
+
+// Token returns the csrf token of the current request
+func Token(ctx context.Context) string {
+	token, _ := ctx.Value(contextKey).(string)
+	return token
+}
+
+// Middleware implements the csrf protection as an http middleware
+func (p *Protection) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if p.exemptBearer && isBearerRequest(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		token, err := p.expectedToken(r)
+		if err != nil {
+			log.Debug("failed to load csrf token, generating new one", log.WithError(err))
+		}
+
+		// always expose the token so the client can submit it
+		if token == "" {
+			token = generateToken()
+			p.storeToken(r, token)
+		}
+		p.exposeToken(w, token)
+
+		ctx := context.WithValue(r.Context(), contextKey, token)
+		r = r.WithContext(ctx)
+
+		if _, ok := safeMethods[r.Method]; ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if err := p.verifyOrigin(r); err != nil {
+			p.errorHandler(w, r, err)
+			return
+		}
+
+		if err := p.verifyToken(r, token); err != nil {
+			p.errorHandler(w, r, err)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (p *Protection) expectedToken(r *http.Request) (string, error) {
+	var token string
+	switch p.mode {
+	case ModeDoubleSubmit:
+		cookie, err := r.Cookie(p.cookieName)
+		if err != nil {
+			return "", err
+		}
+		token = cookie.Value
+	default:
+		err := session.Get(r.Context(), p.sessionKey, &token)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return token, nil
+}
+
+func (p *Protection) storeToken(r *http.Request, token string) {
+	// cookie based token are stored through exposeToken
+	if p.mode == ModeDoubleSubmit {
+		return
+	}
+
+	if err := session.Add(r.Context(), p.sessionKey, token); err != nil {
+		log.Error("failed to store csrf token into session", log.WithError(err))
+	}
+}
+
+func (p *Protection) exposeToken(w http.ResponseWriter, token string) {
+	if p.headerName != "" {
+		w.Header().Set(p.headerName, token)
+	}
+
+	if p.cookieName != "" {
+		// readable by javascript so the SPA can send it back through the header
+		http.SetCookie(w, &http.Cookie{
+			Name:     p.cookieName,
+			Value:    token,
+			Path:     p.cookiePath,
+			HttpOnly: false,
+			Secure:   p.cookieSecure,
+			SameSite: http.SameSiteLaxMode,
+		})
+	}
+}
+
+func (p *Protection) verifyOrigin(r *http.Request) error {
+	if origin := r.Header.Get("Origin"); origin != "" {
+		if !p.isTrustedOrigin(r, origin) {
+			return ErrInvalidOrigin
+		}
+		return nil
+	}
+
+	referer := r.Header.Get("Referer")
+	if referer == "" {
+		// referer is mandatory for https as it can't be stripped by a mitm
+		if r.TLS != nil {
+			return ErrInvalidReferer
+		}
+		return nil
+	}
+
+	refererURL, err := url.Parse(referer)
+	if err != nil || refererURL.Host == "" {
+		return ErrInvalidReferer
+	}
+
+	if !p.isTrustedOrigin(r, refererURL.Scheme+"://"+refererURL.Host) {
+		return ErrInvalidReferer
+	}
+
+	return nil
+}
+
+func (p *Protection) isTrustedOrigin(r *http.Request, origin string) bool {
+	originURL, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+
+	// the scheme is compared too so an http page can't post to https
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+
+	if strings.EqualFold(originURL.Scheme, scheme) && strings.EqualFold(originURL.Host, r.Host) {
+		return true
+	}
+
+	for _, trusted := range p.trustedOrigins {
+		if strings.EqualFold(strings.TrimSuffix(trusted, "/"), origin) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (p *Protection) verifyToken(r *http.Request, expected string) error {
+	submitted := r.Header.Get(p.headerName)
+	if submitted == "" && p.fieldName != "" {
+		submitted = r.PostFormValue(p.fieldName)
+	}
+
+	if submitted == "" {
+		return ErrMissingToken
+	}
+
+	if subtle.ConstantTimeCompare([]byte(submitted), []byte(expected)) != 1 {
+		return ErrInvalidToken
+	}
+
+	return nil
+}
+
+func isBearerRequest(r *http.Request) bool {
+	authorization := r.Header.Get("Authorization")
+	return len(authorization) > len("Bearer ") &&
+		strings.EqualFold(authorization[:len("Bearer ")], "Bearer ")
+}
+
+func generateToken() string {
+	b := make([]byte, tokenLength)
+	// crypto/rand.Read never returns an error on supported platforms
+	_, _ = rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func defaultErrorHandler(w http.ResponseWriter, _ *http.Request, err error) {
+	helper.WriteResponse(w, err, helper.ResponseWithStatus(http.StatusForbidden))
+}
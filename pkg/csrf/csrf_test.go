@@ -0,0 +1,142 @@
+package csrf
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/euiko/webapp/pkg/session"
+)
+
+// newTestHandler serves the requests behind the protection, all of them
+// share the same session as if it were kept by the cookie
+func newTestHandler(opts ...Option) http.Handler {
+	current := session.New()
+	protected := Middleware(opts...)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		protected.ServeHTTP(w, r.WithContext(session.WithContext(r.Context(), current)))
+	})
+}
+
+// fetchToken performs a safe request and returns the exposed token
+func fetchToken(t *testing.T, handler http.Handler) (string, *http.Cookie) {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://example.com/", nil))
+
+	token := rec.Header().Get("X-CSRF-Token")
+	if token == "" {
+		t.Fatal("expected the token to be exposed")
+	}
+
+	for _, cookie := range rec.Result().Cookies() {
+		if cookie.Name == "csrf_token" {
+			return token, cookie
+		}
+	}
+
+	t.Fatal("expected the token cookie")
+	return "", nil
+}
+
+func post(handler http.Handler, token string, cookie *http.Cookie, header http.Header) int {
+	req := httptest.NewRequest(http.MethodPost, "http://example.com/", strings.NewReader(""))
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	if token != "" {
+		req.Header.Set("X-CSRF-Token", token)
+	}
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec.Code
+}
+
+func TestModes(t *testing.T) {
+	for name, mode := range map[string]Mode{
+		"synchronizer":  ModeSynchronizer,
+		"double submit": ModeDoubleSubmit,
+	} {
+		t.Run(name, func(t *testing.T) {
+			handler := newTestHandler(WithMode(mode))
+			token, cookie := fetchToken(t, handler)
+
+			if code := post(handler, token, cookie, nil); code != http.StatusNoContent {
+				t.Fatalf("expected the token to be accepted, got %d", code)
+			}
+
+			if code := post(handler, "", cookie, nil); code != http.StatusForbidden {
+				t.Fatalf("expected a missing token to be refused, got %d", code)
+			}
+
+			if code := post(handler, generateToken(), cookie, nil); code != http.StatusForbidden {
+				t.Fatalf("expected a forged token to be refused, got %d", code)
+			}
+		})
+	}
+
+	t.Run("double submit without cookie", func(t *testing.T) {
+		handler := newTestHandler(WithMode(ModeDoubleSubmit))
+		token, _ := fetchToken(t, handler)
+		if code := post(handler, token, nil, nil); code != http.StatusForbidden {
+			t.Fatalf("expected the token without its cookie to be refused, got %d", code)
+		}
+	})
+}
+
+func TestOrigin(t *testing.T) {
+	handler := newTestHandler(WithTrustedOrigins("https://app.example.com"))
+	token, cookie := fetchToken(t, handler)
+
+	cases := map[string]struct {
+		origin string
+		code   int
+	}{
+		"same origin":                {"http://example.com", http.StatusNoContent},
+		"trusted origin":             {"https://app.example.com", http.StatusNoContent},
+		"other host":                 {"http://evil.com", http.StatusForbidden},
+		"other scheme":               {"https://example.com", http.StatusForbidden},
+		"untrusted port":             {"http://example.com:8080", http.StatusForbidden},
+		"trusted host, other scheme": {"http://app.example.com", http.StatusForbidden},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			header := http.Header{"Origin": {c.origin}}
+			if code := post(handler, token, cookie, header); code != c.code {
+				t.Fatalf("expected %d, got %d", c.code, code)
+			}
+		})
+	}
+
+	t.Run("plain http origin on https", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "https://example.com/", nil)
+		req.TLS = &tls.ConnectionState{}
+		req.Header.Set("Origin", "http://example.com")
+		req.Header.Set("X-CSRF-Token", token)
+		req.AddCookie(cookie)
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != http.StatusForbidden {
+			t.Fatalf("expected 403, got %d", rec.Code)
+		}
+	})
+
+	t.Run("bearer exemption", func(t *testing.T) {
+		header := http.Header{"Origin": {"http://evil.com"}, "Authorization": {"Bearer token"}}
+		if code := post(handler, "", nil, header); code != http.StatusNoContent {
+			t.Fatalf("expected the bearer request to skip the check, got %d", code)
+		}
+	})
+}
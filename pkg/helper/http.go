@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"net/http"
 	"reflect"
+	"strings"
 
 	"github.com/elnormous/contenttype"
 	"github.com/euiko/webapp/pkg/log"
@@ -38,6 +39,15 @@ type (
 		Error       string                `json:"error"`
 		FieldErrors map[string]FieldError `json:"field_errors,omitempty"`
 	}
+
+	// Problem is an RFC 7807 problem details response
+	Problem struct {
+		Type     string `json:"type"`
+		Title    string `json:"title"`
+		Status   int    `json:"status"`
+		Detail   string `json:"detail,omitempty"`
+		Instance string `json:"instance,omitempty"`
+	}
 )
 
 var (
@@ -121,6 +131,13 @@ func WriteResponse(w http.ResponseWriter, data interface{}, opts ...WriteRespons
 
 	// check for errors
 	if err, ok := data.(error); ok {
+		// body overflow is reported as a problem details
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			detail := fmt.Sprintf("request body must not be larger than %d bytes", maxBytesErr.Limit)
+			return WriteProblem(w, NewProblem(http.StatusRequestEntityTooLarge, detail))
+		}
+
 		errStatus := http.StatusInternalServerError
 		body := ErrorResponse{
 			Error:       err.Error(),
@@ -165,6 +182,41 @@ func WriteResponse(w http.ResponseWriter, data interface{}, opts ...WriteRespons
 	return err
 }
 
+// AddVary appends the value to the Vary header when not exists yet
+func AddVary(h http.Header, value string) {
+	for _, v := range h.Values("Vary") {
+		for _, existing := range strings.Split(v, ",") {
+			existing = strings.TrimSpace(existing)
+			if existing == "*" || strings.EqualFold(existing, value) {
+				return
+			}
+		}
+	}
+
+	h.Add("Vary", value)
+}
+
+// NewProblem creates a problem details using the status text as its title
+func NewProblem(status int, detail string) Problem {
+	return Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+	}
+}
+
+// WriteProblem writes the problem details as application/problem+json
+func WriteProblem(w http.ResponseWriter, problem Problem) error {
+	if problem.Status <= 0 {
+		problem.Status = http.StatusInternalServerError
+	}
+
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(problem.Status)
+	return json.NewEncoder(w).Encode(problem)
+}
+
 func writeJSON(w http.ResponseWriter, data interface{}, status int) error {
 	// set default status code
 	if status <= 0 {
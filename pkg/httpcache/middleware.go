@@ -0,0 +1,332 @@
+package httpcache
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/euiko/webapp/db/cache"
+	"github.com/euiko/webapp/pkg/helper"
+	"github.com/euiko/webapp/pkg/log"
+)
+
+type (
+	// VaryFunc returns the value of a request property that the response
+	// varies on, e.g. the role of the current user
+	VaryFunc func(*http.Request) string
+
+	Cacher struct {
+		policy      *Policy
+		weakETag    bool
+		store       cache.Cache
+		ttl         time.Duration
+		varyHeaders []string
+		varyFuncs   []namedVaryFunc
+	}
+
+	Option func(*Cacher)
+
+	namedVaryFunc struct {
+		name string
+		fn   VaryFunc
+	}
+
+	// entry is the stored full response
+	entry struct {
+		status int
+		header http.Header
+		body   []byte
+	}
+
+	bufferedWriter struct {
+		http.ResponseWriter
+
+		status int
+		buf    bytes.Buffer
+	}
+)
+
+const keyPrefix = "httpcache:"
+
+// WithPolicy sets the Cache-Control of the responses
+func WithPolicy(policy Policy) Option {
+	return func(c *Cacher) {
+		c.policy = &policy
+	}
+}
+
+// WithWeakETag generates weak ETags instead of strong ones, useful when
+// the response is semantically equivalent but not byte identical
+func WithWeakETag(weak bool) Option {
+	return func(c *Cacher) {
+		c.weakETag = weak
+	}
+}
+
+// WithStore caches the full responses in the store for the given ttl
+func WithStore(store cache.Cache, ttl time.Duration) Option {
+	return func(c *Cacher) {
+		c.store = store
+		c.ttl = ttl
+	}
+}
+
+// WithVaryHeaders adds request headers the response varies on, they
+// are included in the store key and the Vary header
+func WithVaryHeaders(headers ...string) Option {
+	return func(c *Cacher) {
+		c.varyHeaders = append(c.varyHeaders, headers...)
+	}
+}
+
+// WithVary adds a request property the response varies on, it is
+// included in the store key only
+func WithVary(name string, fn VaryFunc) Option {
+	return func(c *Cacher) {
+		c.varyFuncs = append(c.varyFuncs, namedVaryFunc{name: name, fn: fn})
+	}
+}
+
+func New(opts ...Option) *Cacher {
+	c := Cacher{
+		policy:      nil,
+		weakETag:    false,
+		store:       nil,
+		ttl:         time.Minute,
+		varyHeaders: []string{},
+		varyFuncs:   []namedVaryFunc{},
+	}
+
+	for _, opt := range opts {
+		opt(&c)
+	}
+
+	return &c
+}
+
+// Middleware creates a new response caching middleware using the given options
+func Middleware(opts ...Option) func(http.Handler) http.Handler {
+	return New(opts...).Middleware
+}
+
+// Middleware implements the ETag, conditional requests and response caching,
+// HEAD is handled like GET so both get the same validators
+func (c *Cacher) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		var key string
+		if c.store != nil {
+			key = c.key(r)
+			if cached, err := c.store.Get(key); err == nil {
+				if e, ok := cached.(*entry); ok {
+					c.serve(w, r, e)
+					return
+				}
+			}
+		}
+
+		// snapshot the header to only store the ones set by the handler
+		before := w.Header().Clone()
+		bw := &bufferedWriter{ResponseWriter: w}
+		next.ServeHTTP(bw, r)
+
+		e := &entry{
+			status: bw.status,
+			header: w.Header(),
+			body:   bw.buf.Bytes(),
+		}
+		if e.status == 0 {
+			e.status = http.StatusOK
+		}
+
+		if e.status == http.StatusOK {
+			c.decorate(e.header, e.body)
+			// the handlers may skip the body of HEAD, only GET is stored
+			if c.store != nil && r.Method == http.MethodGet && !strings.Contains(e.header.Get("Cache-Control"), "no-store") {
+				stored := &entry{status: e.status, header: storableHeader(before, e.header), body: e.body}
+				if err := c.store.Set(key, stored, cache.SetWithTimeout(c.ttl)); err != nil {
+					log.Error("failed to store response into cache", log.WithError(err))
+				}
+			}
+		}
+
+		c.write(w, r, e)
+	})
+}
+
+// Invalidate removes the stored response of the request
+func (c *Cacher) Invalidate(r *http.Request) error {
+	if c.store == nil {
+		return nil
+	}
+
+	return c.store.Delete(c.key(r))
+}
+
+func (c *Cacher) serve(w http.ResponseWriter, r *http.Request, e *entry) {
+	header := w.Header()
+	for key, values := range e.header {
+		header[key] = slices.Clone(values)
+	}
+
+	c.write(w, r, e)
+}
+
+func (c *Cacher) write(w http.ResponseWriter, r *http.Request, e *entry) {
+	if e.status == http.StatusOK && notModified(r, w.Header()) {
+		header := w.Header()
+		header.Del("Content-Type")
+		header.Del("Content-Length")
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.WriteHeader(e.status)
+	if _, err := w.Write(e.body); err != nil {
+		log.Debug("failed to write cached response", log.WithError(err))
+	}
+}
+
+// decorate sets the ETag, Cache-Control and Vary headers when not set yet
+func (c *Cacher) decorate(header http.Header, body []byte) {
+	if header.Get("ETag") == "" {
+		header.Set("ETag", ETag(body, c.weakETag))
+	}
+
+	if c.policy != nil && header.Get("Cache-Control") == "" {
+		header.Set("Cache-Control", c.policy.CacheControl())
+	}
+
+	for _, name := range c.varyHeaders {
+		helper.AddVary(header, name)
+	}
+}
+
+func (c *Cacher) key(r *http.Request) string {
+	var b strings.Builder
+	b.WriteString(keyPrefix)
+	// HEAD is served from the stored GET response
+	b.WriteString(http.MethodGet)
+	b.WriteByte(' ')
+	b.WriteString(r.URL.Path)
+	b.WriteByte('?')
+	// encode sorts the query by its key
+	b.WriteString(r.URL.Query().Encode())
+
+	for _, name := range c.varyHeaders {
+		b.WriteByte(0)
+		b.WriteString(name)
+		b.WriteByte('=')
+		b.WriteString(r.Header.Get(name))
+	}
+
+	for _, vary := range c.varyFuncs {
+		b.WriteByte(0)
+		b.WriteString(vary.name)
+		b.WriteByte('=')
+		b.WriteString(vary.fn(r))
+	}
+
+	return b.String()
+}
+
+// storableHeader returns the header set by the handler excluding the cookies,
+// which are specific to the client that triggers the response
+func storableHeader(before, after http.Header) http.Header {
+	stored := make(http.Header, len(after))
+	for key, values := range after {
+		if key == "Set-Cookie" {
+			continue
+		}
+
+		if previous, ok := before[key]; ok && slices.Equal(previous, values) {
+			continue
+		}
+
+		stored[key] = slices.Clone(values)
+	}
+
+	return stored
+}
+
+// ETag computes the entity tag of the body
+func ETag(body []byte, weak bool) string {
+	sum := sha256.Sum256(body)
+	tag := `"` + hex.EncodeToString(sum[:16]) + `"`
+	if weak {
+		return "W/" + tag
+	}
+
+	return tag
+}
+
+// notModified evaluates If-None-Match and If-Modified-Since (RFC 9110 13.2.2)
+func notModified(r *http.Request, header http.Header) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		etag := header.Get("ETag")
+		if etag == "" {
+			return false
+		}
+
+		for _, candidate := range strings.Split(inm, ",") {
+			candidate = strings.TrimSpace(candidate)
+			if candidate == "*" || weakMatch(candidate, etag) {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	ims := r.Header.Get("If-Modified-Since")
+	lastModified := header.Get("Last-Modified")
+	if ims == "" || lastModified == "" {
+		return false
+	}
+
+	since, err := http.ParseTime(ims)
+	if err != nil {
+		return false
+	}
+
+	modified, err := http.ParseTime(lastModified)
+	if err != nil {
+		return false
+	}
+
+	return !modified.Truncate(time.Second).After(since)
+}
+
+// weakMatch compares entity tags ignoring the weak indicator
+func weakMatch(a, b string) bool {
+	return strings.TrimPrefix(a, "W/") == strings.TrimPrefix(b, "W/")
+}
+
+// WriteHeader implements http.ResponseWriter
+func (w *bufferedWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+// Write implements http.ResponseWriter
+func (w *bufferedWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+
+	return w.buf.Write(b)
+}
+
+// Unwrap returns the underlying writer to support http.ResponseController
+func (w *bufferedWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,177 @@
+package httpcache
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/euiko/webapp/db/cache"
+)
+
+// countingHandler serves the body and counts the calls reaching it
+type countingHandler struct {
+	body   string
+	header http.Header
+	calls  int
+}
+
+func (h *countingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.calls++
+	for key, values := range h.header {
+		w.Header()[key] = values
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	io.WriteString(w, h.body)
+}
+
+func request(handler http.Handler, method string, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, "/resource?b=2&a=1", nil)
+	for key, values := range header {
+		req.Header[key] = values
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestIfNoneMatch(t *testing.T) {
+	handler := Middleware(WithPolicy(RevalidatePolicy()))(&countingHandler{body: "hello"})
+
+	first := request(handler, http.MethodGet, nil)
+	etag := first.Header().Get("ETag")
+	if etag == "" || first.Header().Get("Cache-Control") != "private, no-cache" {
+		t.Fatalf("expected the validators, got %v", first.Header())
+	}
+
+	cases := map[string]struct {
+		inm  string
+		code int
+	}{
+		"matching":      {etag, http.StatusNotModified},
+		"weak matching": {"W/" + etag, http.StatusNotModified},
+		"in a list":     {`"other", ` + etag, http.StatusNotModified},
+		"wildcard":      {"*", http.StatusNotModified},
+		"stale":         {`"other"`, http.StatusOK},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			rec := request(handler, http.MethodGet, http.Header{"If-None-Match": {c.inm}})
+			if rec.Code != c.code {
+				t.Fatalf("expected %d, got %d", c.code, rec.Code)
+			}
+			if c.code == http.StatusNotModified && (rec.Body.Len() > 0 || rec.Header().Get("Content-Type") != "") {
+				t.Fatalf("expected an empty 304, got %q", rec.Body.String())
+			}
+		})
+	}
+
+	t.Run("head", func(t *testing.T) {
+		rec := request(handler, http.MethodHead, nil)
+		if rec.Header().Get("ETag") != etag {
+			t.Fatalf("expected the etag of GET, got %q", rec.Header().Get("ETag"))
+		}
+
+		rec = request(handler, http.MethodHead, http.Header{"If-None-Match": {etag}})
+		if rec.Code != http.StatusNotModified {
+			t.Fatalf("expected 304, got %d", rec.Code)
+		}
+	})
+}
+
+func TestIfModifiedSince(t *testing.T) {
+	modified := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	handler := Middleware()(&countingHandler{
+		body:   "hello",
+		header: http.Header{"Last-Modified": {modified.Format(http.TimeFormat)}},
+	})
+
+	cases := map[string]struct {
+		since time.Time
+		code  int
+	}{
+		"unchanged": {modified, http.StatusNotModified},
+		"later":     {modified.Add(time.Hour), http.StatusNotModified},
+		"earlier":   {modified.Add(-time.Second), http.StatusOK},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			rec := request(handler, http.MethodGet, http.Header{"If-Modified-Since": {c.since.Format(http.TimeFormat)}})
+			if rec.Code != c.code {
+				t.Fatalf("expected %d, got %d", c.code, rec.Code)
+			}
+		})
+	}
+
+	t.Run("if none match takes precedence", func(t *testing.T) {
+		rec := request(handler, http.MethodGet, http.Header{
+			"If-None-Match":     {`"other"`},
+			"If-Modified-Since": {modified.Format(http.TimeFormat)},
+		})
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d", rec.Code)
+		}
+	})
+}
+
+func TestStore(t *testing.T) {
+	origin := &countingHandler{body: "hello", header: http.Header{"Set-Cookie": {"session=secret"}}}
+	cacher := New(WithStore(cache.NewInMemory(), time.Minute), WithVaryHeaders("Accept-Language"))
+	handler := cacher.Middleware(origin)
+
+	first := request(handler, http.MethodGet, nil)
+	second := request(handler, http.MethodGet, nil)
+	if origin.calls != 1 || second.Body.String() != "hello" || second.Header().Get("ETag") != first.Header().Get("ETag") {
+		t.Fatalf("expected the second response from the store, got %d calls", origin.calls)
+	}
+	if second.Header().Get("Set-Cookie") != "" {
+		t.Fatal("expected the cookies not to be stored")
+	}
+
+	// HEAD is served from the stored GET response
+	if rec := request(handler, http.MethodHead, nil); origin.calls != 1 || rec.Header().Get("ETag") != first.Header().Get("ETag") {
+		t.Fatalf("expected HEAD from the store, got %d calls", origin.calls)
+	}
+
+	request(handler, http.MethodGet, http.Header{"Accept-Language": {"id"}})
+	if origin.calls != 2 {
+		t.Fatalf("expected the vary header to be part of the key, got %d calls", origin.calls)
+	}
+
+	if err := cacher.Invalidate(httptest.NewRequest(http.MethodGet, "/resource?a=1&b=2", nil)); err != nil {
+		t.Fatal(err)
+	}
+	request(handler, http.MethodGet, nil)
+	if origin.calls != 3 {
+		t.Fatalf("expected the invalidated response to reach the handler, got %d calls", origin.calls)
+	}
+}
+
+func TestStoreSkipped(t *testing.T) {
+	cases := map[string]http.Handler{
+		"no store": &countingHandler{body: "hello", header: http.Header{"Cache-Control": {"no-store"}}},
+		"error": http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		}),
+	}
+
+	for name, origin := range cases {
+		t.Run(name, func(t *testing.T) {
+			calls := 0
+			handler := Middleware(WithStore(cache.NewInMemory(), time.Minute))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls++
+				origin.ServeHTTP(w, r)
+			}))
+
+			request(handler, http.MethodGet, nil)
+			request(handler, http.MethodGet, nil)
+			if calls != 2 {
+				t.Fatalf("expected the response not to be stored, got %d calls", calls)
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package httpcache
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+type (
+	// Policy describes the Cache-Control directives of a route
+	Policy struct {
+		MaxAge               time.Duration
+		SharedMaxAge         time.Duration
+		StaleWhileRevalidate time.Duration
+		Private              bool
+		Public               bool
+		NoCache              bool
+		NoStore              bool
+		MustRevalidate       bool
+		Immutable            bool
+	}
+)
+
+// PrivatePolicy allows only the client to cache the response for maxAge
+// and requires revalidation afterwards
+func PrivatePolicy(maxAge time.Duration) Policy {
+	return Policy{
+		MaxAge:         maxAge,
+		Private:        true,
+		MustRevalidate: true,
+	}
+}
+
+// PublicPolicy allows any cache to store the response for maxAge
+func PublicPolicy(maxAge time.Duration) Policy {
+	return Policy{
+		MaxAge: maxAge,
+		Public: true,
+	}
+}
+
+// RevalidatePolicy allows caching but requires revalidation on every use,
+// best combined with ETag to benefit from the 304 responses
+func RevalidatePolicy() Policy {
+	return Policy{
+		Private: true,
+		NoCache: true,
+	}
+}
+
+// CacheControl renders the policy as a Cache-Control header value
+func (p Policy) CacheControl() string {
+	if p.NoStore {
+		return "no-store"
+	}
+
+	directives := make([]string, 0, 8)
+	if p.Public {
+		directives = append(directives, "public")
+	}
+
+	if p.Private {
+		directives = append(directives, "private")
+	}
+
+	if p.NoCache {
+		directives = append(directives, "no-cache")
+	}
+
+	if p.MaxAge > 0 || (!p.NoCache && len(directives) > 0) {
+		directives = append(directives, "max-age="+seconds(p.MaxAge))
+	}
+
+	if p.SharedMaxAge > 0 {
+		directives = append(directives, "s-maxage="+seconds(p.SharedMaxAge))
+	}
+
+	if p.StaleWhileRevalidate > 0 {
+		directives = append(directives, "stale-while-revalidate="+seconds(p.StaleWhileRevalidate))
+	}
+
+	if p.MustRevalidate {
+		directives = append(directives, "must-revalidate")
+	}
+
+	if p.Immutable {
+		directives = append(directives, "immutable")
+	}
+
+	return strings.Join(directives, ", ")
+}
+
+func seconds(d time.Duration) string {
+	return strconv.FormatInt(int64(d/time.Second), 10)
+}
@@ -0,0 +1,97 @@
+package limit
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+)
+
+type (
+	// limitedBody applies the limit lazily on the first read, so the limit
+	// can still be overridden by the inner middlewares
+	limitedBody struct {
+		mutex         sync.Mutex
+		w             http.ResponseWriter
+		original      io.ReadCloser
+		reader        io.ReadCloser
+		limit         int64
+		contentLength int64
+	}
+
+	bodyContextKeyType struct{}
+)
+
+var (
+	bodyContextKey = bodyContextKeyType{}
+)
+
+// MaxBodySize limits the request body to the given size in bytes, it can be
+// used globally and again per route to override the global limit (either
+// smaller or larger), zero or negative size means unlimited. Reading beyond
+// the limit returns an *http.MaxBytesError which is written as a 413 problem
+// response by helper.WriteResponse.
+func MaxBodySize(size int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Body == nil || r.Body == http.NoBody {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			// override the outer limit
+			if body, ok := r.Context().Value(bodyContextKey).(*limitedBody); ok {
+				body.setLimit(size)
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			body := &limitedBody{
+				w:             w,
+				original:      r.Body,
+				limit:         size,
+				contentLength: r.ContentLength,
+			}
+
+			r = r.WithContext(context.WithValue(r.Context(), bodyContextKey, body))
+			r.Body = body
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Read implements io.Reader
+func (b *limitedBody) Read(p []byte) (int, error) {
+	b.mutex.Lock()
+	if b.reader == nil {
+		switch {
+		case b.limit <= 0:
+			b.reader = b.original
+		case b.contentLength > b.limit:
+			// reject early when the declared length already exceeds the limit
+			b.mutex.Unlock()
+			return 0, &http.MaxBytesError{Limit: b.limit}
+		default:
+			b.reader = http.MaxBytesReader(b.w, b.original, b.limit)
+		}
+	}
+	reader := b.reader
+	b.mutex.Unlock()
+
+	return reader.Read(p)
+}
+
+// Close implements io.Closer
+func (b *limitedBody) Close() error {
+	return b.original.Close()
+}
+
+func (b *limitedBody) setLimit(size int64) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	// the limit can't be changed once the body being read
+	if b.reader == nil {
+		b.limit = size
+	}
+}
@@ -0,0 +1,58 @@
+package limit
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/euiko/webapp/pkg/log"
+)
+
+// WriteDeadline overrides the server WriteTimeout for the route, useful for
+// streaming endpoints that outlive the global timeout. Zero duration clears
+// the deadline entirely.
+func WriteDeadline(timeout time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if err := ExtendWriteDeadline(w, timeout); err != nil {
+				log.Warning("failed to set write deadline", log.WithError(err))
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// ReadDeadline overrides the server ReadTimeout for the route, useful for
+// large uploads. Zero duration clears the deadline entirely.
+func ReadDeadline(timeout time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if err := ExtendReadDeadline(w, timeout); err != nil {
+				log.Warning("failed to set read deadline", log.WithError(err))
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// ExtendWriteDeadline moves the write deadline of the current request to
+// now plus the timeout, streaming handlers may call it periodically to keep
+// the connection alive. Zero timeout clears the deadline.
+func ExtendWriteDeadline(w http.ResponseWriter, timeout time.Duration) error {
+	return http.NewResponseController(w).SetWriteDeadline(deadline(timeout))
+}
+
+// ExtendReadDeadline moves the read deadline of the current request to
+// now plus the timeout. Zero timeout clears the deadline.
+func ExtendReadDeadline(w http.ResponseWriter, timeout time.Duration) error {
+	return http.NewResponseController(w).SetReadDeadline(deadline(timeout))
+}
+
+func deadline(timeout time.Duration) time.Time {
+	if timeout <= 0 {
+		return time.Time{}
+	}
+
+	return time.Now().Add(timeout)
+}
@@ -0,0 +1,242 @@
+package limit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/euiko/webapp/pkg/helper"
+	"github.com/euiko/webapp/pkg/log"
+)
+
+type (
+	TimeoutOption func(*timeoutConfig)
+
+	timeoutConfig struct {
+		status int
+	}
+
+	timeoutWriter struct {
+		http.ResponseWriter
+
+		// the handler header is kept separately to avoid racing with
+		// the timeout response
+		header      http.Header
+		mutex       sync.Mutex
+		wroteHeader bool
+		timedOut    bool
+	}
+)
+
+// TimeoutWithStatus overrides the status written when the handler timed out
+// before writing anything, default to 503 Service Unavailable
+func TimeoutWithStatus(status int) TimeoutOption {
+	return func(c *timeoutConfig) {
+		c.status = status
+	}
+}
+
+// Timeout sets the handler deadline through the request context. When the
+// deadline is exceeded before the handler writes its header, a problem
+// response is written and any later writes from the handler are discarded.
+// Zero or negative duration disables the deadline. Since the deadline is
+// propagated through the context, nested timeouts can only shorten it.
+func Timeout(timeout time.Duration, opts ...TimeoutOption) func(http.Handler) http.Handler {
+	config := timeoutConfig{
+		status: http.StatusServiceUnavailable,
+	}
+
+	for _, opt := range opts {
+		opt(&config)
+	}
+
+	return func(next http.Handler) http.Handler {
+		if timeout <= 0 {
+			return next
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, cancel := context.WithTimeout(r.Context(), timeout)
+			defer cancel()
+
+			var (
+				tw = &timeoutWriter{ResponseWriter: w, header: w.Header().Clone()}
+				// buffered so the handler goroutine never blocks
+				done   = make(chan struct{}, 1)
+				panics = make(chan any, 1)
+			)
+
+			r = r.WithContext(ctx)
+			go func() {
+				// a panic escaping this goroutine would crash the server, it
+				// is re-raised on the serving goroutine instead so net/http
+				// recovers it like for any other handler
+				defer func() {
+					p := recover()
+					if p == nil {
+						return
+					}
+
+					if p != http.ErrAbortHandler {
+						// keep the stack of the handler goroutine
+						p = fmt.Sprintf("%v\n\n%s", p, debug.Stack())
+					}
+
+					if tw.isTimedOut() {
+						// nobody waits for the handler anymore
+						log.Error("handler panicked after timing out",
+							log.WithField("method", r.Method),
+							log.WithField("path", r.URL.Path),
+							log.WithField("panic", p),
+						)
+						return
+					}
+
+					panics <- p
+				}()
+
+				next.ServeHTTP(tw, r)
+				done <- struct{}{}
+			}()
+
+			select {
+			case p := <-panics:
+				panic(p)
+			case <-done:
+				tw.finish()
+				return
+			case <-ctx.Done():
+				if errors.Is(ctx.Err(), context.DeadlineExceeded) && tw.timeout() {
+					log.Debug("handler timed out",
+						log.WithField("method", r.Method),
+						log.WithField("path", r.URL.Path),
+					)
+
+					detail := fmt.Sprintf("handler didn't complete within %s", timeout)
+					helper.WriteProblem(w, helper.NewProblem(config.status, detail))
+					return
+				}
+
+				// the response already being written or the client went away,
+				// wait for the handler to observe the cancellation
+				select {
+				case p := <-panics:
+					panic(p)
+				case <-done:
+					tw.finish()
+				}
+			}
+		})
+	}
+}
+
+// Header implements http.ResponseWriter
+func (w *timeoutWriter) Header() http.Header {
+	return w.header
+}
+
+// WriteHeader implements http.ResponseWriter
+func (w *timeoutWriter) WriteHeader(status int) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.timedOut || w.wroteHeader {
+		return
+	}
+
+	w.writeHeader(status)
+}
+
+// Write implements http.ResponseWriter
+func (w *timeoutWriter) Write(b []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+
+	if !w.wroteHeader {
+		w.writeHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush implements http.Flusher
+func (w *timeoutWriter) Flush() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.timedOut {
+		return
+	}
+
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		if !w.wroteHeader {
+			w.writeHeader(http.StatusOK)
+		}
+		flusher.Flush()
+	}
+}
+
+// Unwrap returns the underlying writer to support http.ResponseController
+func (w *timeoutWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *timeoutWriter) writeHeader(status int) {
+	w.syncHeader()
+	w.wroteHeader = true
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// syncHeader replaces the underlying header with the handler's one
+func (w *timeoutWriter) syncHeader() {
+	header := w.ResponseWriter.Header()
+	for key := range header {
+		if _, ok := w.header[key]; !ok {
+			delete(header, key)
+		}
+	}
+
+	for key, values := range w.header {
+		header[key] = values
+	}
+}
+
+// finish propagates the header when the handler completed without writing
+func (w *timeoutWriter) finish() {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.wroteHeader || w.timedOut {
+		return
+	}
+
+	w.syncHeader()
+}
+
+func (w *timeoutWriter) isTimedOut() bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	return w.timedOut
+}
+
+// timeout marks the writer as timed out, it returns false when the handler
+// already started writing the response thus the timeout can't be reported
+func (w *timeoutWriter) timeout() bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.wroteHeader {
+		return false
+	}
+
+	w.timedOut = true
+	return true
+}
@@ -0,0 +1,105 @@
+package limit
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTimeout(t *testing.T) {
+	var (
+		release = make(chan struct{})
+		late    = make(chan error, 1)
+	)
+	handler := Timeout(10 * time.Millisecond)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.Header().Set("X-Late", "true")
+		_, err := io.WriteString(w, "late")
+		late <- err
+	}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503, got %d", rec.Code)
+	}
+	close(release)
+
+	// the handler writes after the deadline are discarded
+	if err := <-late; !errors.Is(err, http.ErrHandlerTimeout) {
+		t.Fatalf("expected the late write to fail, got %v", err)
+	}
+	if strings.Contains(rec.Body.String(), "late") || rec.Header().Get("X-Late") != "" {
+		t.Fatalf("expected the late response to be discarded, got %q", rec.Body.String())
+	}
+}
+
+func TestTimeoutCompleted(t *testing.T) {
+	handler := Timeout(time.Second, TimeoutWithStatus(http.StatusGatewayTimeout))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Handler", "true")
+		w.WriteHeader(http.StatusCreated)
+		io.WriteString(w, "created")
+	}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if rec.Code != http.StatusCreated || rec.Body.String() != "created" || rec.Header().Get("X-Handler") != "true" {
+		t.Fatalf("expected the handler response, got %d: %q", rec.Code, rec.Body.String())
+	}
+}
+
+func TestTimeoutPanic(t *testing.T) {
+	handler := Timeout(time.Second)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	}))
+
+	t.Run("re-raised on the serving goroutine", func(t *testing.T) {
+		defer func() {
+			p := recover()
+			if p == nil || !strings.Contains(p.(string), "boom") {
+				t.Fatalf("expected the handler panic, got %v", p)
+			}
+		}()
+
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	})
+
+	t.Run("recovered by the server", func(t *testing.T) {
+		server := httptest.NewServer(handler)
+		defer server.Close()
+
+		// the connection is dropped but the server keeps serving
+		for i := 0; i < 2; i++ {
+			if _, err := http.Get(server.URL); err == nil {
+				t.Fatal("expected the connection to be aborted")
+			}
+		}
+	})
+
+	t.Run("after timing out", func(t *testing.T) {
+		var (
+			release  = make(chan struct{})
+			panicked = make(chan struct{})
+		)
+		handler := Timeout(10 * time.Millisecond)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-release
+			defer close(panicked)
+			panic("late boom")
+		}))
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		close(release)
+		<-panicked
+
+		if rec.Code != http.StatusServiceUnavailable {
+			t.Fatalf("expected 503, got %d", rec.Code)
+		}
+	})
+}
@@ -0,0 +1,60 @@
+package password
+
+import (
+	"errors"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+type (
+	bcryptHasher struct {
+		cost int
+	}
+)
+
+const (
+	// DefaultBcryptCost follows the OWASP recommendation
+	DefaultBcryptCost = 12
+)
+
+// NewBcrypt creates a hasher encoding in the modular crypt format of
+// bcrypt, e.g. $2a$12$<salt and hash>. The passwords longer than 72 bytes
+// are refused since bcrypt silently truncates them
+func NewBcrypt(cost int) Hasher {
+	return &bcryptHasher{
+		cost: cost,
+	}
+}
+
+// IDs implements Hasher.
+func (h *bcryptHasher) IDs() []string {
+	return []string{"2a", "2b", "2y"}
+}
+
+// Hash implements Hasher.
+func (h *bcryptHasher) Hash(password string) (string, error) {
+	hashed, err := bcrypt.GenerateFromPassword([]byte(password), h.cost)
+	if err != nil {
+		return "", err
+	}
+
+	return string(hashed), nil
+}
+
+// Verify implements Hasher.
+func (h *bcryptHasher) Verify(password string, encoded string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) || errors.Is(err, bcrypt.ErrPasswordTooLong) {
+		return false, nil
+	} else if err != nil {
+		return false, ErrInvalidHash
+	}
+
+	return true, nil
+}
+
+// NeedsRehash implements Hasher.
+func (h *bcryptHasher) NeedsRehash(encoded string) bool {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return err != nil || cost != h.cost
+}
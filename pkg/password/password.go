@@ -0,0 +1,216 @@
+package password
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"sync"
+)
+
+type (
+	// Hasher hashes the passwords into the PHC string format, e.g.
+	// $argon2id$v=19$m=65536,t=3,p=2$salt$hash
+	Hasher interface {
+		// IDs are the identifiers of the encoded hashes the hasher verifies,
+		// the first one is used to encode
+		IDs() []string
+		Hash(password string) (string, error)
+		Verify(password string, encoded string) (bool, error)
+		// NeedsRehash returns true when the hash is encoded with other
+		// parameters than the hasher uses
+		NeedsRehash(encoded string) bool
+	}
+
+	// Manager hashes the passwords using the current hasher and verifies the
+	// hashes of every registered hasher, so the algorithm or its parameters
+	// can be changed without invalidating the stored hashes
+	Manager struct {
+		current Hasher
+		hashers map[string]Hasher
+	}
+
+	rehashContextKey struct{}
+
+	// rehash holds the new hash of the verified password, it is set by
+	// Verify down the call chain so it is a pointer stored in the context
+	rehash struct {
+		mutex   sync.Mutex
+		encoded string
+	}
+)
+
+var (
+	ErrMismatch        = errors.New("password mismatch")
+	ErrInvalidHash     = errors.New("invalid password hash")
+	ErrUnsupportedHash = errors.New("unsupported password hash")
+
+	defaultManager      *Manager
+	defaultManagerMutex sync.RWMutex
+)
+
+// NewManager creates a manager hashing using the current hasher, the hashes
+// of argon2id, bcrypt and scrypt with default parameters are always
+// verifiable and the other hashers override them
+func NewManager(current Hasher, others ...Hasher) *Manager {
+	m := Manager{
+		current: current,
+		hashers: make(map[string]Hasher),
+	}
+
+	defaults := []Hasher{NewArgon2id(DefaultArgon2idParams), NewBcrypt(DefaultBcryptCost), NewScrypt(DefaultScryptParams)}
+	for _, hasher := range append(append(defaults, others...), current) {
+		for _, id := range hasher.IDs() {
+			m.hashers[id] = hasher
+		}
+	}
+
+	return &m
+}
+
+// Default returns the manager used by the package level functions, it
+// hashes using argon2id unless replaced by SetDefault
+func Default() *Manager {
+	defaultManagerMutex.RLock()
+	m := defaultManager
+	defaultManagerMutex.RUnlock()
+	if m != nil {
+		return m
+	}
+
+	defaultManagerMutex.Lock()
+	defer defaultManagerMutex.Unlock()
+	if defaultManager == nil {
+		defaultManager = NewManager(NewArgon2id(DefaultArgon2idParams))
+	}
+
+	return defaultManager
+}
+
+// SetDefault replaces the manager used by the package level functions
+func SetDefault(m *Manager) {
+	defaultManagerMutex.Lock()
+	defer defaultManagerMutex.Unlock()
+	defaultManager = m
+}
+
+// Hash hashes the password using the default manager
+func Hash(password string) (string, error) {
+	return Default().Hash(password)
+}
+
+// Verify verifies the password using the default manager
+func Verify(ctx context.Context, password string, encoded string) error {
+	return Default().Verify(ctx, password, encoded)
+}
+
+// Hash hashes the password using the current hasher
+func (m *Manager) Hash(password string) (string, error) {
+	return m.current.Hash(password)
+}
+
+// Verify returns ErrMismatch when the password doesn't match the hash. On
+// match the password is rehashed when the hash isn't encoded by the current
+// hasher, the new hash is available from RehashFromContext
+func (m *Manager) Verify(ctx context.Context, password string, encoded string) error {
+	hasher, err := m.hasher(encoded)
+	if err != nil {
+		return err
+	}
+
+	ok, err := hasher.Verify(password, encoded)
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return ErrMismatch
+	}
+
+	holder, found := ctx.Value(rehashContextKey{}).(*rehash)
+	if !found || !m.NeedsRehash(encoded) {
+		return nil
+	}
+
+	rehashed, err := m.current.Hash(password)
+	if err != nil {
+		return err
+	}
+
+	holder.mutex.Lock()
+	holder.encoded = rehashed
+	holder.mutex.Unlock()
+	return nil
+}
+
+// NeedsRehash returns true when the hash is encoded by other algorithm or
+// parameters than the current hasher
+func (m *Manager) NeedsRehash(encoded string) bool {
+	id := hashID(encoded)
+	for _, currentID := range m.current.IDs() {
+		if id == currentID {
+			return m.current.NeedsRehash(encoded)
+		}
+	}
+
+	return true
+}
+
+func (m *Manager) hasher(encoded string) (Hasher, error) {
+	id := hashID(encoded)
+	if id == "" {
+		return nil, ErrInvalidHash
+	}
+
+	hasher, ok := m.hashers[id]
+	if !ok {
+		return nil, ErrUnsupportedHash
+	}
+
+	return hasher, nil
+}
+
+// WithRehash returns a context collecting the new hash of the password
+// verified using it, e.g. it is set by the login before loading the user
+func WithRehash(ctx context.Context) context.Context {
+	return context.WithValue(ctx, rehashContextKey{}, &rehash{})
+}
+
+// ContextWithRehash returns a context carrying the new hash collected by an
+// earlier request, e.g. before the second step of a multi-factor login
+func ContextWithRehash(ctx context.Context, encoded string) context.Context {
+	return context.WithValue(ctx, rehashContextKey{}, &rehash{encoded: encoded})
+}
+
+// RehashFromContext returns the new hash of the verified password when its
+// stored hash is outdated, the caller is responsible to store it
+func RehashFromContext(ctx context.Context) (string, bool) {
+	holder, ok := ctx.Value(rehashContextKey{}).(*rehash)
+	if !ok {
+		return "", false
+	}
+
+	holder.mutex.Lock()
+	defer holder.mutex.Unlock()
+	return holder.encoded, holder.encoded != ""
+}
+
+// hashID returns the identifier of the PHC string, e.g. argon2id
+func hashID(encoded string) string {
+	if !strings.HasPrefix(encoded, "$") {
+		return ""
+	}
+
+	id, _, _ := strings.Cut(encoded[1:], "$")
+	return id
+}
+
+// encodeBase64 encodes using the standard alphabet without padding as the
+// PHC string format specifies
+func encodeBase64(data []byte) string {
+	return base64.RawStdEncoding.EncodeToString(data)
+}
+
+func decodeBase64(encoded string) ([]byte, error) {
+	return base64.RawStdEncoding.DecodeString(encoded)
+}
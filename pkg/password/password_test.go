@@ -0,0 +1,153 @@
+package password
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/euiko/webapp/pkg/validator"
+)
+
+// cheap parameters so the tests run fast
+var (
+	testArgon2idParams = Argon2idParams{Memory: 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+	testScryptParams   = ScryptParams{LogN: 10, BlockSize: 8, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+)
+
+func TestHashers(t *testing.T) {
+	hashers := map[string]Hasher{
+		"$argon2id$v=19$m=1024,t=1,p=1$": NewArgon2id(testArgon2idParams),
+		"$2a$04$":                        NewBcrypt(4),
+		"$scrypt$ln=10,r=8,p=1$":         NewScrypt(testScryptParams),
+	}
+
+	for prefix, hasher := range hashers {
+		encoded, err := hasher.Hash("correct horse")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !strings.HasPrefix(encoded, prefix) {
+			t.Fatalf("expected %s prefix, got %s", prefix, encoded)
+		}
+
+		if ok, err := hasher.Verify("correct horse", encoded); err != nil || !ok {
+			t.Fatalf("expected %s to verify, got %v %v", prefix, ok, err)
+		}
+
+		if ok, _ := hasher.Verify("wrong horse", encoded); ok {
+			t.Fatalf("expected %s to refuse the wrong password", prefix)
+		}
+
+		if hasher.NeedsRehash(encoded) {
+			t.Fatalf("expected %s to not need rehash", prefix)
+		}
+	}
+}
+
+func TestManagerRehash(t *testing.T) {
+	old, err := NewBcrypt(4).Hash("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := NewManager(NewArgon2id(testArgon2idParams), NewBcrypt(4))
+	ctx := WithRehash(context.Background())
+	if err := m.Verify(ctx, "wrong horse", old); !errors.Is(err, ErrMismatch) {
+		t.Fatalf("expected ErrMismatch, got %v", err)
+	}
+
+	if _, ok := RehashFromContext(ctx); ok {
+		t.Fatal("expected no rehash of a mismatch")
+	}
+
+	if err := m.Verify(ctx, "correct horse", old); err != nil {
+		t.Fatal(err)
+	}
+
+	rehashed, ok := RehashFromContext(ctx)
+	if !ok || !strings.HasPrefix(rehashed, "$argon2id$") {
+		t.Fatalf("expected an argon2id rehash, got %q", rehashed)
+	}
+
+	ctx = WithRehash(context.Background())
+	if err := m.Verify(ctx, "correct horse", rehashed); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := RehashFromContext(ctx); ok {
+		t.Fatal("expected no rehash of the current hash")
+	}
+
+	// the parameter changes are rehashed as well
+	stronger := NewManager(NewArgon2id(Argon2idParams{Memory: 2048, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}))
+	if !stronger.NeedsRehash(rehashed) {
+		t.Fatal("expected rehash on the parameter changes")
+	}
+
+	if err := m.Verify(ctx, "correct horse", "$md5$abc"); !errors.Is(err, ErrUnsupportedHash) {
+		t.Fatalf("expected ErrUnsupportedHash, got %v", err)
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "breached.txt")
+	// the SHA-1 of "password123" as listed by Have I Been Pwned
+	content := "qwertyuiop\nCBFDAC6008F9CAB4083784CBD1874F76618D2A97:2412\n"
+	if err := os.WriteFile(file, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	policy, err := NewPolicy(WithBreachedListFile(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		password string
+		loginID  string
+		expected error
+	}{
+		{"short", "", ErrTooShort},
+		{strings.Repeat("a", 65), "", ErrTooLong},
+		{"qwertyuiop", "", ErrBreached},
+		{"password123", "", ErrBreached},
+		{"johndoe2024", "JohnDoe", ErrTooSimilar},
+		{"johndo3x", "johndoex", ErrTooSimilar},
+		{"correct horse battery", "johndoe", nil},
+	}
+
+	for _, c := range cases {
+		if err := policy.Validate(c.password, c.loginID); !errors.Is(err, c.expected) {
+			t.Fatalf("expected %v of %q, got %v", c.expected, c.password, err)
+		}
+	}
+}
+
+func TestValidationTag(t *testing.T) {
+	policy, err := NewPolicy()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := RegisterValidation(policy); err != nil {
+		t.Fatal(err)
+	}
+
+	type payload struct {
+		LoginId  string `json:"login_id"`
+		Password string `json:"password" validate:"password=LoginId"`
+	}
+
+	if err := validator.Validate(payload{LoginId: "johndoe", Password: "correct horse"}); err != nil {
+		t.Fatal(err)
+	}
+
+	err = validator.Validate(payload{LoginId: "johndoe", Password: "johndoe1"})
+	if _, ok := validator.GetValidationErrors(err); !ok {
+		t.Fatalf("expected validation errors, got %v", err)
+	}
+}
@@ -0,0 +1,235 @@
+package password
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/euiko/webapp/pkg/validator"
+	playground "github.com/go-playground/validator/v10"
+)
+
+type (
+	// Policy validates the strength of the new passwords
+	Policy struct {
+		minLength     int
+		maxLength     int
+		maxSimilarity float64
+		breachedFile  string
+		breached      map[string]struct{}
+	}
+
+	PolicyOption func(*Policy)
+)
+
+const (
+	// ValidationTag validates the field using the policy registered by
+	// RegisterValidation, the param is the name of the sibling field holding
+	// the login ID, e.g. `validate:"password=LoginId"`
+	ValidationTag = "password"
+
+	sha1Prefix = "sha1:"
+)
+
+var (
+	ErrTooShort    = errors.New("password is too short")
+	ErrTooLong     = errors.New("password is too long")
+	ErrBreached    = errors.New("password is known to be breached")
+	ErrTooSimilar  = errors.New("password is too similar to the login id")
+	ErrPolicyField = errors.New("invalid password validation field")
+)
+
+// WithMinLength sets the minimum number of characters, default to 8
+func WithMinLength(length int) PolicyOption {
+	return func(p *Policy) {
+		p.minLength = length
+	}
+}
+
+// WithMaxLength sets the maximum number of characters, default to 64, zero
+// disables the check
+func WithMaxLength(length int) PolicyOption {
+	return func(p *Policy) {
+		p.maxLength = length
+	}
+}
+
+// WithMaxSimilarity sets the maximum similarity ratio between the password
+// and the login id from 0 to 1, default to 0.7, zero disables the check
+func WithMaxSimilarity(ratio float64) PolicyOption {
+	return func(p *Policy) {
+		p.maxSimilarity = ratio
+	}
+}
+
+// WithBreachedListFile refuses the passwords listed in the file, one per
+// line. The lines of 40 hex characters are the SHA-1 hashes of the
+// passwords, optionally followed by :<count> as published by Have I Been
+// Pwned
+func WithBreachedListFile(path string) PolicyOption {
+	return func(p *Policy) {
+		p.breachedFile = path
+	}
+}
+
+// WithBreachedList refuses the listed passwords
+func WithBreachedList(passwords ...string) PolicyOption {
+	return func(p *Policy) {
+		for _, password := range passwords {
+			p.addBreached(password)
+		}
+	}
+}
+
+// NewPolicy creates the policy, the breached list file is loaded at once
+func NewPolicy(opts ...PolicyOption) (*Policy, error) {
+	p := Policy{
+		minLength:     8,
+		maxLength:     64,
+		maxSimilarity: 0.7,
+		breached:      make(map[string]struct{}),
+	}
+
+	for _, opt := range opts {
+		opt(&p)
+	}
+
+	if p.breachedFile != "" {
+		if err := p.loadBreachedFile(p.breachedFile); err != nil {
+			return nil, err
+		}
+	}
+
+	return &p, nil
+}
+
+// Validate returns the first policy violation of the password, the login
+// id may be empty to skip the similarity check
+func (p *Policy) Validate(password string, loginID string) error {
+	length := utf8.RuneCountInString(password)
+	if length < p.minLength {
+		return fmt.Errorf("%w: at least %d characters are required", ErrTooShort, p.minLength)
+	}
+
+	if p.maxLength > 0 && length > p.maxLength {
+		return fmt.Errorf("%w: at most %d characters are allowed", ErrTooLong, p.maxLength)
+	}
+
+	if p.isBreached(password) {
+		return ErrBreached
+	}
+
+	if p.maxSimilarity > 0 && loginID != "" && similarity(password, loginID) > p.maxSimilarity {
+		return ErrTooSimilar
+	}
+
+	return nil
+}
+
+// RegisterValidation registers the policy as the password validation tag of
+// the payload structs
+func RegisterValidation(p *Policy) error {
+	return validator.RegisterValidation(ValidationTag, func(fl playground.FieldLevel) bool {
+		var loginID string
+		if param := fl.Param(); param != "" {
+			parent := reflect.Indirect(fl.Parent())
+			field := parent.FieldByName(param)
+			if !field.IsValid() || field.Kind() != reflect.String {
+				panic(fmt.Errorf("%w: %s", ErrPolicyField, param))
+			}
+
+			loginID = field.String()
+		}
+
+		return p.Validate(fl.Field().String(), loginID) == nil
+	})
+}
+
+func (p *Policy) isBreached(password string) bool {
+	if len(p.breached) == 0 {
+		return false
+	}
+
+	if _, ok := p.breached[password]; ok {
+		return true
+	}
+
+	digest := sha1.Sum([]byte(password))
+	_, ok := p.breached[sha1Prefix+hex.EncodeToString(digest[:])]
+	return ok
+}
+
+func (p *Policy) addBreached(entry string) {
+	hash, _, _ := strings.Cut(entry, ":")
+	if _, err := hex.DecodeString(hash); err == nil && len(hash) == 2*sha1.Size {
+		p.breached[sha1Prefix+strings.ToLower(hash)] = struct{}{}
+		return
+	}
+
+	p.breached[entry] = struct{}{}
+}
+
+func (p *Policy) loadBreachedFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			p.addBreached(line)
+		}
+	}
+
+	return scanner.Err()
+}
+
+// similarity returns the ratio from 0 to 1 of how similar the password is
+// to the login id, containing the login id is treated as identical
+func similarity(password string, loginID string) float64 {
+	a := []rune(strings.ToLower(password))
+	b := []rune(strings.ToLower(loginID))
+	if len(b) >= 3 && strings.Contains(string(a), string(b)) {
+		return 1
+	}
+
+	longest := max(len(a), len(b))
+	if longest == 0 {
+		return 1
+	}
+
+	return 1 - float64(levenshtein(a, b))/float64(longest)
+}
+
+// levenshtein returns the edit distance between a and b
+func levenshtein(a, b []rune) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+
+		previous, current = current, previous
+	}
+
+	return previous[len(b)]
+}
@@ -0,0 +1,124 @@
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+type (
+	ScryptParams struct {
+		// LogN is the log2 of the CPU/memory cost
+		LogN        uint8
+		BlockSize   int
+		Parallelism int
+		SaltLength  int
+		KeyLength   int
+	}
+
+	scryptHasher struct {
+		params ScryptParams
+	}
+)
+
+var (
+	// DefaultScryptParams follows the OWASP recommendation
+	DefaultScryptParams = ScryptParams{
+		LogN:        17,
+		BlockSize:   8,
+		Parallelism: 1,
+		SaltLength:  16,
+		KeyLength:   32,
+	}
+)
+
+// NewScrypt creates a hasher encoding as
+// $scrypt$ln=<log2 cost>,r=<block size>,p=<parallelism>$<salt>$<hash>
+func NewScrypt(params ScryptParams) Hasher {
+	return &scryptHasher{
+		params: params,
+	}
+}
+
+// IDs implements Hasher.
+func (h *scryptHasher) IDs() []string {
+	return []string{"scrypt"}
+}
+
+// Hash implements Hasher.
+func (h *scryptHasher) Hash(password string) (string, error) {
+	salt := make([]byte, h.params.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key, err := scrypt.Key([]byte(password), salt, 1<<h.params.LogN, h.params.BlockSize, h.params.Parallelism, h.params.KeyLength)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("$scrypt$ln=%d,r=%d,p=%d$%s$%s",
+		h.params.LogN,
+		h.params.BlockSize,
+		h.params.Parallelism,
+		encodeBase64(salt),
+		encodeBase64(key),
+	), nil
+}
+
+// Verify implements Hasher.
+func (h *scryptHasher) Verify(password string, encoded string) (bool, error) {
+	params, salt, key, err := decodeScrypt(encoded)
+	if err != nil {
+		return false, err
+	}
+
+	computed, err := scrypt.Key([]byte(password), salt, 1<<params.LogN, params.BlockSize, params.Parallelism, params.KeyLength)
+	if err != nil {
+		return false, err
+	}
+
+	return subtle.ConstantTimeCompare(computed, key) == 1, nil
+}
+
+// NeedsRehash implements Hasher.
+func (h *scryptHasher) NeedsRehash(encoded string) bool {
+	params, _, _, err := decodeScrypt(encoded)
+	return err != nil || params != h.params
+}
+
+func decodeScrypt(encoded string) (ScryptParams, []byte, []byte, error) {
+	var params ScryptParams
+
+	// "", "scrypt", "ln=..,r=..,p=..", salt, hash
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 5 || parts[1] != "scrypt" {
+		return params, nil, nil, ErrInvalidHash
+	}
+
+	if _, err := fmt.Sscanf(parts[2], "ln=%d,r=%d,p=%d", &params.LogN, &params.BlockSize, &params.Parallelism); err != nil {
+		return params, nil, nil, ErrInvalidHash
+	}
+
+	// refuse the costs overflowing or exhausting the memory
+	if params.LogN == 0 || params.LogN > 31 {
+		return params, nil, nil, ErrInvalidHash
+	}
+
+	salt, err := decodeBase64(parts[3])
+	if err != nil {
+		return params, nil, nil, ErrInvalidHash
+	}
+
+	key, err := decodeBase64(parts[4])
+	if err != nil || len(key) == 0 {
+		return params, nil, nil, ErrInvalidHash
+	}
+
+	params.SaltLength = len(salt)
+	params.KeyLength = len(key)
+	return params, salt, key, nil
+}
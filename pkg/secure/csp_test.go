@@ -0,0 +1,25 @@
+package secure
+
+import "testing"
+
+func TestInjectNonce(t *testing.T) {
+	cases := map[string]string{
+		`<script>a()</script>`:                      `<script nonce="n0nce">a()</script>`,
+		`<script src="/app.js" defer></script>`:     `<script nonce="n0nce" src="/app.js" defer></script>`,
+		"<STYLE\n  media=\"all\">a{}</STYLE>":       "<STYLE nonce=\"n0nce\"\n  media=\"all\">a{}</STYLE>",
+		`<script nonce="other">a()</script>`:        `<script nonce="other">a()</script>`,
+		`<style media="all" NONCE='other'></style>`: `<style media="all" NONCE='other'></style>`,
+		`<scripts><stylesheet>`:                     `<scripts><stylesheet>`,
+		`<script data-nonce-id="1"></script>`:       `<script nonce="n0nce" data-nonce-id="1"></script>`,
+	}
+
+	for html, expected := range cases {
+		if got := string(InjectNonce([]byte(html), "n0nce")); got != expected {
+			t.Errorf("InjectNonce(%q) = %q, expected %q", html, got, expected)
+		}
+	}
+
+	if got := string(InjectNonce([]byte(`<script></script>`), "")); got != `<script></script>` {
+		t.Errorf("expected no nonce to leave the html untouched, got %q", got)
+	}
+}
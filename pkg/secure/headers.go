@@ -0,0 +1,241 @@
+package secure
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type (
+	Headers struct {
+		hstsMaxAge                time.Duration
+		hstsIncludeSubdomains     bool
+		hstsPreload               bool
+		contentTypeNosniff        bool
+		referrerPolicy            string
+		permissionsPolicy         string
+		frameAncestors            []string
+		crossOriginOpenerPolicy   string
+		crossOriginEmbedderPolicy string
+		csp                       *CSP
+		cspReportOnly             bool
+	}
+
+	Option func(*Headers)
+)
+
+// WithHSTS sets the Strict-Transport-Security header, zero maxAge disables it
+func WithHSTS(maxAge time.Duration, includeSubdomains bool, preload bool) Option {
+	return func(h *Headers) {
+		h.hstsMaxAge = maxAge
+		h.hstsIncludeSubdomains = includeSubdomains
+		h.hstsPreload = preload
+	}
+}
+
+// WithContentTypeNosniff toggles the X-Content-Type-Options header
+func WithContentTypeNosniff(enabled bool) Option {
+	return func(h *Headers) {
+		h.contentTypeNosniff = enabled
+	}
+}
+
+// WithReferrerPolicy sets the Referrer-Policy header
+func WithReferrerPolicy(policy string) Option {
+	return func(h *Headers) {
+		h.referrerPolicy = policy
+	}
+}
+
+// WithPermissionsPolicy sets the Permissions-Policy header
+func WithPermissionsPolicy(policy string) Option {
+	return func(h *Headers) {
+		h.permissionsPolicy = policy
+	}
+}
+
+// WithFrameAncestors sets the allowed frame ancestors, it is written as
+// CSP frame-ancestors directive and the legacy X-Frame-Options
+func WithFrameAncestors(sources ...string) Option {
+	return func(h *Headers) {
+		h.frameAncestors = sources
+	}
+}
+
+// WithCrossOriginPolicies sets the Cross-Origin-Opener-Policy and
+// Cross-Origin-Embedder-Policy headers
+func WithCrossOriginPolicies(opener string, embedder string) Option {
+	return func(h *Headers) {
+		h.crossOriginOpenerPolicy = opener
+		h.crossOriginEmbedderPolicy = embedder
+	}
+}
+
+// WithCSP sets the Content-Security-Policy, reportOnly uses the
+// Content-Security-Policy-Report-Only header instead but frame-ancestors
+// stays enforced
+func WithCSP(csp *CSP, reportOnly bool) Option {
+	return func(h *Headers) {
+		h.csp = csp
+		h.cspReportOnly = reportOnly
+	}
+}
+
+func New(opts ...Option) *Headers {
+	h := Headers{
+		hstsMaxAge:                365 * 24 * time.Hour,
+		hstsIncludeSubdomains:     true,
+		hstsPreload:               false,
+		contentTypeNosniff:        true,
+		referrerPolicy:            "strict-origin-when-cross-origin",
+		permissionsPolicy:         "",
+		frameAncestors:            []string{"'self'"},
+		crossOriginOpenerPolicy:   "same-origin",
+		crossOriginEmbedderPolicy: "",
+		csp:                       nil,
+		cspReportOnly:             false,
+	}
+
+	for _, opt := range opts {
+		opt(&h)
+	}
+
+	return &h
+}
+
+// Middleware creates a new security headers middleware using the given options
+func Middleware(opts ...Option) func(http.Handler) http.Handler {
+	return New(opts...).Middleware
+}
+
+// Middleware writes the security headers and generates the CSP nonce
+func (h *Headers) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := w.Header()
+
+		if h.hstsMaxAge > 0 && isHTTPS(r) {
+			header.Set("Strict-Transport-Security", h.hsts())
+		}
+
+		if h.contentTypeNosniff {
+			header.Set("X-Content-Type-Options", "nosniff")
+		}
+
+		if h.referrerPolicy != "" {
+			header.Set("Referrer-Policy", h.referrerPolicy)
+		}
+
+		if h.permissionsPolicy != "" {
+			header.Set("Permissions-Policy", h.permissionsPolicy)
+		}
+
+		if frameOptions := h.frameOptions(); frameOptions != "" {
+			header.Set("X-Frame-Options", frameOptions)
+		}
+
+		if h.crossOriginOpenerPolicy != "" {
+			header.Set("Cross-Origin-Opener-Policy", h.crossOriginOpenerPolicy)
+		}
+
+		if h.crossOriginEmbedderPolicy != "" {
+			header.Set("Cross-Origin-Embedder-Policy", h.crossOriginEmbedderPolicy)
+		}
+
+		policy, nonce := h.contentSecurityPolicy()
+		if h.cspReportOnly {
+			// browsers ignore frame-ancestors in a report-only policy, so it
+			// is still enforced on its own
+			if sources := h.frameAncestorSources(); len(sources) > 0 {
+				header.Set("Content-Security-Policy", "frame-ancestors "+strings.Join(sources, " "))
+			}
+
+			if policy != "" {
+				header.Set("Content-Security-Policy-Report-Only", policy)
+			}
+		} else if policy != "" {
+			header.Set("Content-Security-Policy", policy)
+		}
+
+		if nonce != "" {
+			r = r.WithContext(contextWithNonce(r.Context(), nonce))
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (h *Headers) hsts() string {
+	value := "max-age=" + strconv.FormatInt(int64(h.hstsMaxAge.Seconds()), 10)
+	if h.hstsIncludeSubdomains {
+		value += "; includeSubDomains"
+	}
+
+	if h.hstsPreload {
+		value += "; preload"
+	}
+
+	return value
+}
+
+func (h *Headers) frameOptions() string {
+	// X-Frame-Options can only express a single none or self source
+	if len(h.frameAncestors) != 1 {
+		return ""
+	}
+
+	switch h.frameAncestors[0] {
+	case "'none'":
+		return "DENY"
+	case "'self'":
+		return "SAMEORIGIN"
+	}
+
+	return ""
+}
+
+// frameAncestorSources returns the frame-ancestors of the CSP, falling back
+// to the configured frame ancestors
+func (h *Headers) frameAncestorSources() []string {
+	if h.csp != nil {
+		if sources, ok := h.csp.directives["frame-ancestors"]; ok {
+			return sources
+		}
+	}
+
+	return h.frameAncestors
+}
+
+func (h *Headers) contentSecurityPolicy() (string, string) {
+	if h.csp == nil && (h.cspReportOnly || len(h.frameAncestors) == 0) {
+		return "", ""
+	}
+
+	csp := NewCSP()
+	if h.csp != nil {
+		csp = h.csp
+	}
+
+	var nonce string
+	if csp.UsesNonce() {
+		nonce = generateNonce()
+	}
+
+	policy := csp.Build(nonce)
+	if _, ok := csp.directives["frame-ancestors"]; !ok && !h.cspReportOnly && len(h.frameAncestors) > 0 {
+		if policy != "" {
+			policy += "; "
+		}
+		policy += "frame-ancestors " + strings.Join(h.frameAncestors, " ")
+	}
+
+	return policy, nonce
+}
+
+func isHTTPS(r *http.Request) bool {
+	if r.TLS != nil {
+		return true
+	}
+
+	return strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https")
+}
@@ -0,0 +1,42 @@
+package secure
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestReportOnlyFrameAncestors(t *testing.T) {
+	cases := map[string]struct {
+		csp        *CSP
+		enforced   string
+		reportOnly string
+	}{
+		"frame ancestors option": {
+			csp:        NewCSP().Set("default-src", "'self'"),
+			enforced:   "frame-ancestors 'self'",
+			reportOnly: "default-src 'self'",
+		},
+		"frame ancestors directive": {
+			csp:        NewCSP().Set("default-src", "'self'").Set("frame-ancestors", "'none'"),
+			enforced:   "frame-ancestors 'none'",
+			reportOnly: "default-src 'self'; frame-ancestors 'none'",
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handler := Middleware(WithCSP(c.csp, true))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			// browsers ignore frame-ancestors of a report-only policy
+			if got := rec.Header().Get("Content-Security-Policy"); got != c.enforced {
+				t.Fatalf("expected the enforced %q, got %q", c.enforced, got)
+			}
+			if got := rec.Header().Get("Content-Security-Policy-Report-Only"); got != c.reportOnly {
+				t.Fatalf("expected the report-only %q, got %q", c.reportOnly, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,237 @@
+package session
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/euiko/webapp/db/cache"
+)
+
+type (
+	cacheStore struct {
+		mutex sync.Mutex
+		cache cache.Cache
+	}
+)
+
+const (
+	cacheKeyPrefix     = "session:"
+	cacheUserKeyPrefix = "session-user:"
+)
+
+// NewCacheStore creates a store backed by the cache, the records are stored
+// as JSON so it behaves the same for in-memory and remote caches
+func NewCacheStore(c cache.Cache) Store {
+	return &cacheStore{
+		cache: c,
+	}
+}
+
+// Load implements Store.
+func (s *cacheStore) Load(ctx context.Context, id string) (*Record, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.load(id)
+}
+
+// Save implements Store.
+func (s *cacheStore) Save(ctx context.Context, record Record) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// the session may be re-associated with another user
+	if previous, err := s.load(record.ID); err == nil && previous.UserID != record.UserID {
+		if err := s.removeUserIndex(previous.UserID, previous.ID); err != nil {
+			return err
+		}
+	}
+
+	encoded, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	ttl := time.Until(record.ExpiresAt)
+	if err := s.cache.Set(cacheKeyPrefix+record.ID, encoded, cache.SetWithTimeout(ttl)); err != nil {
+		return err
+	}
+
+	if record.UserID == "" {
+		return nil
+	}
+
+	ids, err := s.userIndex(record.UserID)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		if id == record.ID {
+			return nil
+		}
+	}
+
+	return s.setUserIndex(record.UserID, append(ids, record.ID))
+}
+
+// Delete implements Store.
+func (s *cacheStore) Delete(ctx context.Context, id string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	record, err := s.load(id)
+	if errors.Is(err, ErrSessionNotFound) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if err := s.cache.Delete(cacheKeyPrefix + id); err != nil && !errors.Is(err, cache.ErrKeyNotFound) {
+		return err
+	}
+
+	return s.removeUserIndex(record.UserID, id)
+}
+
+// ListByUser implements Store.
+func (s *cacheStore) ListByUser(ctx context.Context, userID string) ([]Record, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	ids, err := s.userIndex(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		records = make([]Record, 0, len(ids))
+		active  = make([]string, 0, len(ids))
+	)
+	for _, id := range ids {
+		record, err := s.load(id)
+		if errors.Is(err, ErrSessionNotFound) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		records = append(records, *record)
+		active = append(active, id)
+	}
+
+	// prune the expired sessions from the index
+	if len(active) != len(ids) {
+		if err := s.setUserIndex(userID, active); err != nil {
+			return nil, err
+		}
+	}
+
+	return records, nil
+}
+
+// DeleteByUser implements Store.
+func (s *cacheStore) DeleteByUser(ctx context.Context, userID string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	ids, err := s.userIndex(userID)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		if err := s.cache.Delete(cacheKeyPrefix + id); err != nil && !errors.Is(err, cache.ErrKeyNotFound) {
+			return err
+		}
+	}
+
+	return s.setUserIndex(userID, nil)
+}
+
+func (s *cacheStore) load(id string) (*Record, error) {
+	cached, err := s.cache.Get(cacheKeyPrefix + id)
+	if errors.Is(err, cache.ErrKeyNotFound) {
+		return nil, ErrSessionNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	encoded, ok := cached.([]byte)
+	if !ok {
+		return nil, ErrSessionNotFound
+	}
+
+	var record Record
+	if err := json.Unmarshal(encoded, &record); err != nil {
+		return nil, err
+	}
+
+	if !record.ExpiresAt.After(time.Now()) {
+		return nil, ErrSessionNotFound
+	}
+
+	return &record, nil
+}
+
+func (s *cacheStore) userIndex(userID string) ([]string, error) {
+	cached, err := s.cache.Get(cacheUserKeyPrefix + userID)
+	if errors.Is(err, cache.ErrKeyNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	encoded, ok := cached.([]byte)
+	if !ok {
+		return nil, nil
+	}
+
+	var ids []string
+	if err := json.Unmarshal(encoded, &ids); err != nil {
+		return nil, err
+	}
+
+	return ids, nil
+}
+
+func (s *cacheStore) setUserIndex(userID string, ids []string) error {
+	if len(ids) == 0 {
+		err := s.cache.Delete(cacheUserKeyPrefix + userID)
+		if errors.Is(err, cache.ErrKeyNotFound) {
+			return nil
+		}
+
+		return err
+	}
+
+	encoded, err := json.Marshal(ids)
+	if err != nil {
+		return err
+	}
+
+	return s.cache.Set(cacheUserKeyPrefix+userID, encoded)
+}
+
+func (s *cacheStore) removeUserIndex(userID string, id string) error {
+	if userID == "" {
+		return nil
+	}
+
+	ids, err := s.userIndex(userID)
+	if err != nil {
+		return err
+	}
+
+	remaining := make([]string, 0, len(ids))
+	for _, existing := range ids {
+		if existing != id {
+			remaining = append(remaining, existing)
+		}
+	}
+
+	return s.setUserIndex(userID, remaining)
+}
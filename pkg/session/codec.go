@@ -0,0 +1,214 @@
+package session
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	// Codec protects the cookie values, the name is bound to the value so
+	// it can't be moved into another cookie
+	Codec interface {
+		Encode(name string, payload []byte) (string, error)
+		// Decode returns the payload and the time it is encoded
+		Decode(name string, value string) ([]byte, time.Time, error)
+	}
+
+	signedCodec struct {
+		keys [][]byte
+	}
+
+	encryptedCodec struct {
+		aeads []cipher.AEAD
+	}
+)
+
+const (
+	minKeyLength = 16
+	timestampLen = 8
+)
+
+var (
+	ErrInvalidCookie = errors.New("invalid session cookie")
+	ErrNoKeys        = errors.New("at least one key is required")
+
+	ephemeralCodec     Codec
+	ephemeralCodecOnce sync.Once
+)
+
+// NewSignedCodec creates a codec that signs the values using HMAC-SHA256,
+// the values are readable by the clients but can't be tampered. The first
+// key is used to sign while all of the keys are tried to verify, so the
+// keys can be rotated by prepending a new key.
+func NewSignedCodec(keys ...[]byte) (Codec, error) {
+	if len(keys) == 0 {
+		return nil, ErrNoKeys
+	}
+
+	derived := make([][]byte, len(keys))
+	for i, key := range keys {
+		if len(key) < minKeyLength {
+			return nil, fmt.Errorf("session key must be at least %d bytes", minKeyLength)
+		}
+
+		derived[i] = deriveKey(key, "session-sign")
+	}
+
+	return &signedCodec{keys: derived}, nil
+}
+
+// NewEncryptedCodec creates a codec that encrypts the values using
+// AES-256-GCM, the values are neither readable nor tampered by the clients.
+// The first key is used to encrypt while all of the keys are tried to
+// decrypt, so the keys can be rotated by prepending a new key.
+func NewEncryptedCodec(keys ...[]byte) (Codec, error) {
+	if len(keys) == 0 {
+		return nil, ErrNoKeys
+	}
+
+	aeads := make([]cipher.AEAD, len(keys))
+	for i, key := range keys {
+		if len(key) < minKeyLength {
+			return nil, fmt.Errorf("session key must be at least %d bytes", minKeyLength)
+		}
+
+		block, err := aes.NewCipher(deriveKey(key, "session-encrypt"))
+		if err != nil {
+			return nil, err
+		}
+
+		aeads[i], err = cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &encryptedCodec{aeads: aeads}, nil
+}
+
+// EphemeralCodec returns a signed codec using a random key generated once
+// per process, the sessions are invalidated on restart and not shared
+// across instances
+func EphemeralCodec() Codec {
+	ephemeralCodecOnce.Do(func() {
+		key := make([]byte, 32)
+		// crypto/rand.Read never returns an error on supported platforms
+		_, _ = rand.Read(key)
+		ephemeralCodec, _ = NewSignedCodec(key)
+	})
+
+	return ephemeralCodec
+}
+
+// Encode implements Codec.
+func (c *signedCodec) Encode(name string, payload []byte) (string, error) {
+	value := base64.RawURLEncoding.EncodeToString(withTimestamp(payload))
+	mac := sign(c.keys[0], name, value)
+	return value + "." + base64.RawURLEncoding.EncodeToString(mac), nil
+}
+
+// Decode implements Codec.
+func (c *signedCodec) Decode(name string, value string) ([]byte, time.Time, error) {
+	i := strings.LastIndexByte(value, '.')
+	if i < 0 {
+		return nil, time.Time{}, ErrInvalidCookie
+	}
+
+	mac, err := base64.RawURLEncoding.DecodeString(value[i+1:])
+	if err != nil {
+		return nil, time.Time{}, ErrInvalidCookie
+	}
+
+	value = value[:i]
+	for _, key := range c.keys {
+		if !hmac.Equal(mac, sign(key, name, value)) {
+			continue
+		}
+
+		decoded, err := base64.RawURLEncoding.DecodeString(value)
+		if err != nil {
+			return nil, time.Time{}, ErrInvalidCookie
+		}
+
+		return splitTimestamp(decoded)
+	}
+
+	return nil, time.Time{}, ErrInvalidCookie
+}
+
+// Encode implements Codec.
+func (c *encryptedCodec) Encode(name string, payload []byte) (string, error) {
+	aead := c.aeads[0]
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(payload)+timestampLen+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	sealed := aead.Seal(nonce, nonce, withTimestamp(payload), []byte(name))
+	return base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+// Decode implements Codec.
+func (c *encryptedCodec) Decode(name string, value string) ([]byte, time.Time, error) {
+	sealed, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, time.Time{}, ErrInvalidCookie
+	}
+
+	for _, aead := range c.aeads {
+		if len(sealed) < aead.NonceSize() {
+			continue
+		}
+
+		nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+		decrypted, err := aead.Open(nil, nonce, ciphertext, []byte(name))
+		if err != nil {
+			continue
+		}
+
+		return splitTimestamp(decrypted)
+	}
+
+	return nil, time.Time{}, ErrInvalidCookie
+}
+
+func sign(key []byte, name, value string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(name))
+	h.Write([]byte{0})
+	h.Write([]byte(value))
+	return h.Sum(nil)
+}
+
+// deriveKey derives a purpose specific 32 bytes key, so the same key can
+// be used for both signing and encryption
+func deriveKey(key []byte, purpose string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(purpose))
+	return h.Sum(nil)
+}
+
+func withTimestamp(payload []byte) []byte {
+	b := make([]byte, timestampLen, timestampLen+len(payload))
+	binary.BigEndian.PutUint64(b, uint64(time.Now().Unix()))
+	return append(b, payload...)
+}
+
+func splitTimestamp(b []byte) ([]byte, time.Time, error) {
+	if len(b) < timestampLen {
+		return nil, time.Time{}, ErrInvalidCookie
+	}
+
+	timestamp := time.Unix(int64(binary.BigEndian.Uint64(b[:timestampLen])), 0)
+	return b[timestampLen:], timestamp, nil
+}
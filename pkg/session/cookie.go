@@ -0,0 +1,76 @@
+package session
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+type (
+	// Cookie describes the attributes of the session cookies
+	Cookie struct {
+		Name     string
+		Path     string
+		Domain   string
+		MaxAge   time.Duration
+		Secure   bool
+		HTTPOnly bool
+		SameSite http.SameSite
+	}
+)
+
+// DefaultCookie returns the cookie attributes used when none is configured
+func DefaultCookie() Cookie {
+	return Cookie{
+		Name:     "session",
+		Path:     "/",
+		Domain:   "",
+		MaxAge:   0,
+		Secure:   false,
+		HTTPOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// ParseSameSite converts lax, strict or none into http.SameSite, any other
+// value uses the browser default
+func ParseSameSite(sameSite string) http.SameSite {
+	switch strings.ToLower(sameSite) {
+	case "lax":
+		return http.SameSiteLaxMode
+	case "strict":
+		return http.SameSiteStrictMode
+	case "none":
+		return http.SameSiteNoneMode
+	}
+
+	return http.SameSiteDefaultMode
+}
+
+// build creates the cookie with the given name and value, zero expires
+// uses the MaxAge instead
+func (c Cookie) build(name string, value string, expires time.Time) *http.Cookie {
+	cookie := http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     c.Path,
+		Domain:   c.Domain,
+		Expires:  expires,
+		Secure:   c.Secure,
+		HttpOnly: c.HTTPOnly,
+		SameSite: c.SameSite,
+	}
+
+	if expires.IsZero() && c.MaxAge > 0 {
+		cookie.MaxAge = int(c.MaxAge / time.Second)
+	}
+
+	return &cookie
+}
+
+// expire creates the cookie that removes the given cookie from the client
+func (c Cookie) expire(name string) *http.Cookie {
+	cookie := c.build(name, "", time.Time{})
+	cookie.MaxAge = -1
+	return cookie
+}
@@ -0,0 +1,76 @@
+package session
+
+import (
+	"context"
+)
+
+const flashKey = "_flash"
+
+// AddFlash adds a read-once message to the category, the messages must be
+// JSON serializable since they outlive the current request
+func AddFlash(ctx context.Context, category string, message any) error {
+	session, ok := fromContext(ctx)
+	if !ok {
+		return ErrNotInitialized
+	}
+
+	session.AddFlash(category, message)
+	return nil
+}
+
+// Flashes returns and removes the messages of the category
+func Flashes(ctx context.Context, category string) ([]any, error) {
+	session, ok := fromContext(ctx)
+	if !ok {
+		return nil, ErrNotInitialized
+	}
+
+	return session.Flashes(category), nil
+}
+
+func (s *Session) AddFlash(category string, message any) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	flashes := s.flashes()
+	messages, _ := flashes[category].([]any)
+	flashes[category] = append(messages, message)
+	s.Store(flashKey, flashes)
+}
+
+func (s *Session) Flashes(category string) []any {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	flashes := s.flashes()
+	messages, ok := flashes[category].([]any)
+	if !ok {
+		return nil
+	}
+
+	delete(flashes, category)
+	if len(flashes) == 0 {
+		s.Delete(flashKey)
+	} else {
+		s.Store(flashKey, flashes)
+	}
+
+	return messages
+}
+
+// flashes returns a copy of the flash messages, it has the same shape
+// before and after being decoded from JSON
+func (s *Session) flashes() map[string]any {
+	flashes := make(map[string]any)
+	value, ok := s.Load(flashKey)
+	if !ok {
+		return flashes
+	}
+
+	stored, _ := value.(map[string]any)
+	for category, messages := range stored {
+		flashes[category] = messages
+	}
+
+	return flashes
+}
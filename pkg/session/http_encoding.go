@@ -3,102 +3,191 @@ package session
 import (
 	"encoding/json"
 	"net/http"
-
-	"github.com/euiko/webapp/pkg/helper"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type (
 	HTTPCookieEncoding struct {
-		path     string
-		httpOnly bool
-		secure   bool
-		key      string
-		r        *http.Request
-		w        http.ResponseWriter
+		cookie Cookie
+		codec  Codec
+		ttl    time.Duration
+		r      *http.Request
+		w      http.ResponseWriter
+
+		// the number of cookie chunks received in the request
+		chunks    int
+		encodedAt time.Time
+		invalid   bool
 	}
 
 	HTTPCookieEncodingOption func(*HTTPCookieEncoding)
+
+	// cookiePayload is the encoded content of the session cookie
+	cookiePayload struct {
+		UserID string         `json:"user_id,omitempty"`
+		Values map[string]any `json:"values,omitempty"`
+	}
+)
+
+const (
+	// maxChunkSize keeps each cookie below the 4096 bytes limit of the
+	// browsers including its name and attributes
+	maxChunkSize = 3800
+	maxChunks    = 10
+
+	// touchInterval throttles re-encoding the unmodified sessions only to
+	// extend their expiration
+	touchInterval = time.Minute
 )
 
+// WithCookie sets the attributes of the session cookie
+func WithCookie(cookie Cookie) HTTPCookieEncodingOption {
+	return func(e *HTTPCookieEncoding) {
+		e.cookie = cookie
+	}
+}
+
+// WithCodec sets the codec that protects the session cookie, it uses the
+// EphemeralCodec by default
+func WithCodec(codec Codec) HTTPCookieEncodingOption {
+	return func(e *HTTPCookieEncoding) {
+		e.codec = codec
+	}
+}
+
+// WithTTL rejects the session cookie encoded longer than the ttl ago, the
+// cookie is re-encoded on every request so it acts as an idle timeout
+func WithTTL(ttl time.Duration) HTTPCookieEncodingOption {
+	return func(e *HTTPCookieEncoding) {
+		e.ttl = ttl
+	}
+}
+
 func NewHTTPCookieEncoding(w http.ResponseWriter, r *http.Request, opts ...HTTPCookieEncodingOption) *HTTPCookieEncoding {
 	e := HTTPCookieEncoding{
-		path:     "/",
-		httpOnly: false,
-		secure:   false,
-		key:      "session",
-		r:        r,
-		w:        w,
+		cookie: DefaultCookie(),
+		codec:  nil,
+		ttl:    0,
+		r:      r,
+		w:      w,
 	}
 
 	for _, opt := range opts {
 		opt(&e)
 	}
 
+	if e.codec == nil {
+		e.codec = EphemeralCodec()
+	}
+
 	return &e
 }
 
 func (e *HTTPCookieEncoding) Decode() (*Session, error) {
-	var (
-		cookie       *http.Cookie
-		cookieHeader = e.r.Header.Get("Cookie")
-		session      = New()
-	)
+	session := New()
 
-	cookies, err := http.ParseCookie(cookieHeader)
-	if err != nil {
-		return session, err
-	}
-
-	for _, c := range cookies {
-		if c.Name == e.key {
-			cookie = c
+	// join the chunks of the cookie in order
+	var value strings.Builder
+	for ; e.chunks < maxChunks; e.chunks++ {
+		cookie, err := e.r.Cookie(chunkName(e.cookie.Name, e.chunks))
+		if err != nil {
 			break
 		}
+
+		value.WriteString(cookie.Value)
 	}
+
 	// create new session if none cookie found
-	if cookie == nil {
+	if e.chunks == 0 {
 		return session, nil
 	}
 
-	jsoned, err := helper.DecodeBase64(cookie.Value)
+	// the invalid cookie will be removed on encode
+	e.invalid = true
+	jsoned, encodedAt, err := e.codec.Decode(e.cookie.Name, value.String())
 	if err != nil {
 		return session, err
 	}
 
-	values := make(map[string]any)
-	err = json.Unmarshal(jsoned, &values)
+	if e.ttl > 0 && time.Since(encodedAt) > e.ttl {
+		return session, ErrInvalidCookie
+	}
+
+	var payload cookiePayload
+	err = json.Unmarshal(jsoned, &payload)
 	if err != nil {
 		return session, err
 	}
 
-	for key, value := range values {
-		session.Store(key, value)
+	e.invalid = false
+	e.encodedAt = encodedAt
+
+	session.userID = payload.UserID
+	for key, value := range payload.Values {
+		session.Map.Store(key, value)
 	}
 
 	return session, nil
 }
 
 func (e *HTTPCookieEncoding) Encode(session *Session) error {
-	sessionMap := make(map[string]interface{})
-	session.Range(func(key, value any) bool {
-		sessionMap[key.(string)] = value
-		return true
-	})
+	var chunks []string
 
-	encoded, err := json.Marshal(sessionMap)
-	if err != nil {
-		return err
+	// the unmodified session only re-encoded to extend its expiration
+	touch := e.chunks > 0 && e.ttl > 0 && time.Since(e.encodedAt) > touchInterval
+	if !session.IsDirty() && !e.invalid && !touch {
+		return nil
+	}
+
+	// the cookie is removed when the session is destroyed
+	if !session.IsEmpty() {
+		encoded, err := json.Marshal(cookiePayload{
+			UserID: session.UserID(),
+			Values: session.Values(),
+		})
+		if err != nil {
+			return err
+		}
+
+		value, err := e.codec.Encode(e.cookie.Name, encoded)
+		if err != nil {
+			return err
+		}
+
+		chunks = splitChunks(value)
+		if len(chunks) > maxChunks {
+			return ErrSessionTooLarge
+		}
+	}
+
+	for i, chunk := range chunks {
+		http.SetCookie(e.w, e.cookie.build(chunkName(e.cookie.Name, i), chunk, time.Time{}))
 	}
 
-	base64 := helper.EncodeBase64(encoded)
-	cookie := http.Cookie{
-		Name:     e.key,
-		Value:    base64,
-		Path:     "/",
-		HttpOnly: true,
-		Secure:   true,
+	// expire the stale chunks of the previous cookie
+	for i := len(chunks); i < e.chunks; i++ {
+		http.SetCookie(e.w, e.cookie.expire(chunkName(e.cookie.Name, i)))
 	}
 
-	http.SetCookie(e.w, &cookie)
 	return nil
 }
+
+func chunkName(name string, i int) string {
+	if i == 0 {
+		return name
+	}
+
+	return name + "_" + strconv.Itoa(i)
+}
+
+func splitChunks(value string) []string {
+	chunks := make([]string, 0, len(value)/maxChunkSize+1)
+	for len(value) > maxChunkSize {
+		chunks = append(chunks, value[:maxChunkSize])
+		value = value[maxChunkSize:]
+	}
+
+	return append(chunks, value)
+}
@@ -0,0 +1,122 @@
+package session
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHTTPCookieEncoding(t *testing.T) {
+	var (
+		oldKey = []byte("old-session-key-0123456789")
+		newKey = []byte("new-session-key-0123456789")
+	)
+
+	encode := func(codec Codec, values map[string]any) []*http.Cookie {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		w := httptest.NewRecorder()
+
+		s := New()
+		for key, value := range values {
+			s.Store(key, value)
+		}
+
+		if err := NewHTTPCookieEncoding(w, r, WithCodec(codec)).Encode(s); err != nil {
+			t.Fatalf("failed to encode session: %v", err)
+		}
+
+		return w.Result().Cookies()
+	}
+
+	decode := func(codec Codec, cookies []*http.Cookie) (*Session, error) {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		for _, cookie := range cookies {
+			r.AddCookie(cookie)
+		}
+
+		return NewHTTPCookieEncoding(httptest.NewRecorder(), r, WithCodec(codec)).Decode()
+	}
+
+	for name, factory := range map[string]func(...[]byte) (Codec, error){
+		"signed":    NewSignedCodec,
+		"encrypted": NewEncryptedCodec,
+	} {
+		t.Run(name, func(t *testing.T) {
+			oldCodec, err := factory(oldKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			rotatedCodec, err := factory(newKey, oldKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			cookies := encode(oldCodec, map[string]any{"role": "user"})
+
+			// the rotated codec must still accept the old key
+			s, err := decode(rotatedCodec, cookies)
+			if err != nil {
+				t.Fatalf("failed to decode with rotated keys: %v", err)
+			}
+
+			if v, _ := s.Load("role"); v != "user" {
+				t.Fatalf("expected role user, got %v", v)
+			}
+
+			// tampering must be rejected
+			tampered := *cookies[0]
+			value := []byte(tampered.Value)
+			value[len(value)/2] ^= 1
+			tampered.Value = string(value)
+			if _, err := decode(rotatedCodec, []*http.Cookie{&tampered}); err == nil {
+				t.Fatal("expected tampered cookie to be rejected")
+			}
+
+			// the new key must not be accepted by the old codec
+			if _, err := decode(oldCodec, encode(rotatedCodec, map[string]any{"role": "admin"})); err == nil {
+				t.Fatal("expected cookie signed by unknown key to be rejected")
+			}
+
+			// large sessions are split into multiple cookies
+			cookies = encode(rotatedCodec, map[string]any{"data": strings.Repeat("x", 3*maxChunkSize)})
+			if len(cookies) < 3 {
+				t.Fatalf("expected the session to be chunked, got %d cookies", len(cookies))
+			}
+
+			s, err = decode(rotatedCodec, cookies)
+			if err != nil {
+				t.Fatalf("failed to decode chunked session: %v", err)
+			}
+
+			if v, _ := s.Load("data"); v != strings.Repeat("x", 3*maxChunkSize) {
+				t.Fatal("expected chunked value to be restored")
+			}
+		})
+	}
+}
+
+func TestHTTPCookieEncodingUserID(t *testing.T) {
+	s := New()
+	s.SetUserID("alice")
+
+	w := httptest.NewRecorder()
+	if err := NewHTTPCookieEncoding(w, httptest.NewRequest(http.MethodGet, "/", nil)).Encode(s); err != nil {
+		t.Fatal(err)
+	}
+
+	// the session holding only the user is still sent
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	for _, cookie := range w.Result().Cookies() {
+		r.AddCookie(cookie)
+	}
+
+	decoded, err := NewHTTPCookieEncoding(httptest.NewRecorder(), r).Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.UserID() != "alice" {
+		t.Fatalf("expected the user ID to be restored, got %q", decoded.UserID())
+	}
+}
@@ -0,0 +1,88 @@
+package session
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/euiko/webapp/pkg/log"
+)
+
+type (
+	// EncodingFactory creates the encoding of the session of the request
+	EncodingFactory func(w http.ResponseWriter, r *http.Request) Encoding
+
+	// writer encodes the session right before the header is written
+	writer struct {
+		http.ResponseWriter
+
+		once   sync.Once
+		encode func()
+	}
+)
+
+// Middleware decodes the session of every request and encodes it before the
+// header is written, the store is nil when the sessions are kept in the
+// cookie
+func Middleware(newEncoding EncodingFactory, store Store) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			e := newEncoding(w, r)
+			sessionValue, err := e.Decode()
+			if err != nil {
+				// just log the error
+				log.Debug("failed to decode session, falling back to empty session", log.WithError(err))
+				sessionValue = New()
+			}
+
+			ctx := WithContext(r.Context(), sessionValue)
+			if store != nil {
+				ctx = WithStore(ctx, store)
+			}
+			r = r.WithContext(ctx)
+
+			// the cookies must be written before the header is sent
+			sw := &writer{ResponseWriter: w}
+			sw.encode = func() {
+				if err := e.Encode(sessionValue); err != nil {
+					log.Error("failed to encode session", log.WithError(err))
+				}
+			}
+
+			h.ServeHTTP(sw, r)
+			sw.once.Do(sw.encode)
+		})
+	}
+}
+
+// WriteHeader implements http.ResponseWriter
+func (w *writer) WriteHeader(status int) {
+	w.once.Do(w.encode)
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Write implements http.ResponseWriter
+func (w *writer) Write(b []byte) (int, error) {
+	w.once.Do(w.encode)
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush implements http.Flusher
+func (w *writer) Flush() {
+	w.once.Do(w.encode)
+	if err := http.NewResponseController(w.ResponseWriter).Flush(); err != nil {
+		log.Debug("failed to flush response", log.WithError(err))
+	}
+}
+
+// Hijack implements http.Hijacker
+func (w *writer) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	w.once.Do(w.encode)
+	return http.NewResponseController(w.ResponseWriter).Hijack()
+}
+
+// Unwrap returns the underlying writer to support http.ResponseController
+func (w *writer) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,26 @@
+SET statement_timeout = 0;
+
+--bun:split
+
+CREATE SCHEMA IF NOT EXISTS sessions;
+
+--bun:split
+
+CREATE TABLE IF NOT EXISTS sessions.sessions (
+    id VARCHAR(64) PRIMARY KEY,
+    user_id VARCHAR(255),
+    data JSONB NOT NULL DEFAULT '{}'::JSONB,
+    user_agent TEXT,
+    remote_addr VARCHAR(255),
+    expires_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+--bun:split
+
+CREATE INDEX IF NOT EXISTS sessions_user_id_idx ON sessions.sessions (user_id);
+
+--bun:split
+
+CREATE INDEX IF NOT EXISTS sessions_expires_at_idx ON sessions.sessions (expires_at);
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"sync"
+	"sync/atomic"
 
 	"github.com/go-viper/mapstructure/v2"
 )
@@ -11,6 +12,12 @@ import (
 type (
 	Session struct {
 		sync.Map
+
+		mutex      sync.Mutex
+		id         string
+		userID     string
+		regenerate bool
+		dirty      atomic.Bool
 	}
 
 	Marshaller interface {
@@ -81,6 +88,211 @@ func Get(ctx context.Context, key string, output interface{}) error {
 	return mapstructure.Decode(value, output)
 }
 
+// ID returns the identifier of the current session, it is empty when the
+// session is not persisted in a Store yet
+func ID(ctx context.Context) string {
+	session, ok := fromContext(ctx)
+	if !ok {
+		return ""
+	}
+
+	return session.ID()
+}
+
+// UserID returns the user associated with the current session, it is empty
+// when the session is anonymous
+func UserID(ctx context.Context) string {
+	session, ok := fromContext(ctx)
+	if !ok {
+		return ""
+	}
+
+	return session.UserID()
+}
+
+// SetUserID associates the current session with the user, so it can be
+// listed per user by the Store
+func SetUserID(ctx context.Context, userID string) error {
+	session, ok := fromContext(ctx)
+	if !ok {
+		return ErrNotInitialized
+	}
+
+	session.SetUserID(userID)
+	return nil
+}
+
+// Regenerate replaces the session identifier while keeping its values, it
+// must be called on privilege changes such as login to prevent fixation
+func Regenerate(ctx context.Context) error {
+	session, ok := fromContext(ctx)
+	if !ok {
+		return ErrNotInitialized
+	}
+
+	session.Regenerate()
+	return nil
+}
+
+// Destroy removes all of the session values and invalidates the session
+func Destroy(ctx context.Context) error {
+	session, ok := fromContext(ctx)
+	if !ok {
+		return ErrNotInitialized
+	}
+
+	session.Destroy()
+	return nil
+}
+
+func (s *Session) ID() string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.id
+}
+
+func (s *Session) UserID() string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.userID
+}
+
+func (s *Session) SetUserID(userID string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.userID != userID {
+		s.userID = userID
+		s.dirty.Store(true)
+	}
+}
+
+func (s *Session) Regenerate() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.regenerate = true
+	s.dirty.Store(true)
+}
+
+func (s *Session) Destroy() {
+	s.Clear()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.userID = ""
+	s.regenerate = true
+}
+
+// IsDirty returns true when the session is modified since it is decoded
+func (s *Session) IsDirty() bool {
+	return s.dirty.Load()
+}
+
+// Store implements sync.Map and marks the session as modified
+func (s *Session) Store(key, value any) {
+	s.dirty.Store(true)
+	s.Map.Store(key, value)
+}
+
+// Delete implements sync.Map and marks the session as modified
+func (s *Session) Delete(key any) {
+	s.LoadAndDelete(key)
+}
+
+// Clear implements sync.Map and marks the session as modified
+func (s *Session) Clear() {
+	s.dirty.Store(true)
+	s.Map.Clear()
+}
+
+// Swap implements sync.Map and marks the session as modified
+func (s *Session) Swap(key, value any) (any, bool) {
+	s.dirty.Store(true)
+	return s.Map.Swap(key, value)
+}
+
+// LoadOrStore implements sync.Map and marks the session as modified when
+// the value is stored
+func (s *Session) LoadOrStore(key, value any) (any, bool) {
+	actual, loaded := s.Map.LoadOrStore(key, value)
+	if !loaded {
+		s.dirty.Store(true)
+	}
+
+	return actual, loaded
+}
+
+// LoadAndDelete implements sync.Map and marks the session as modified when
+// the value exists
+func (s *Session) LoadAndDelete(key any) (any, bool) {
+	value, loaded := s.Map.LoadAndDelete(key)
+	if loaded {
+		s.dirty.Store(true)
+	}
+
+	return value, loaded
+}
+
+// CompareAndSwap implements sync.Map and marks the session as modified when
+// the value is swapped
+func (s *Session) CompareAndSwap(key, old, new any) bool {
+	swapped := s.Map.CompareAndSwap(key, old, new)
+	if swapped {
+		s.dirty.Store(true)
+	}
+
+	return swapped
+}
+
+// CompareAndDelete implements sync.Map and marks the session as modified
+// when the value is deleted
+func (s *Session) CompareAndDelete(key, old any) bool {
+	deleted := s.Map.CompareAndDelete(key, old)
+	if deleted {
+		s.dirty.Store(true)
+	}
+
+	return deleted
+}
+
+// Values returns a copy of the session values
+func (s *Session) Values() map[string]any {
+	values := make(map[string]any)
+	s.Range(func(key, value any) bool {
+		values[key.(string)] = value
+		return true
+	})
+
+	return values
+}
+
+// IsEmpty returns true when the session doesn't have any value nor user
+func (s *Session) IsEmpty() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.isEmpty()
+}
+
+// isEmpty is IsEmpty for the callers holding the mutex
+func (s *Session) isEmpty() bool {
+	if s.userID != "" {
+		return false
+	}
+
+	empty := true
+	s.Range(func(_, _ any) bool {
+		empty = false
+		return false
+	})
+
+	return empty
+}
+
 func WithContext(ctx context.Context, session *Session) context.Context {
 	return context.WithValue(ctx, sessionContextKey, session)
 }
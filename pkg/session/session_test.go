@@ -0,0 +1,49 @@
+package session
+
+import (
+	"context"
+	"testing"
+)
+
+func TestDirtyTracking(t *testing.T) {
+	s := New()
+	s.Map.Store("key", "value")
+	if s.IsDirty() {
+		t.Fatal("expected decoded values not to mark the session as dirty")
+	}
+
+	if _, ok := s.Load("key"); !ok || s.IsDirty() {
+		t.Fatal("expected reads not to mark the session as dirty")
+	}
+
+	s.Delete("missing")
+	if s.IsDirty() {
+		t.Fatal("expected deleting a missing key not to mark the session as dirty")
+	}
+
+	s.Store("key", "changed")
+	if !s.IsDirty() {
+		t.Fatal("expected writes to mark the session as dirty")
+	}
+}
+
+func TestFlashes(t *testing.T) {
+	ctx := WithContext(context.Background(), New())
+
+	_ = AddFlash(ctx, "info", "first")
+	_ = AddFlash(ctx, "info", "second")
+	_ = AddFlash(ctx, "error", "failed")
+
+	messages, err := Flashes(ctx, "info")
+	if err != nil || len(messages) != 2 || messages[0] != "first" || messages[1] != "second" {
+		t.Fatalf("expected both info messages in order, got %v %v", messages, err)
+	}
+
+	if messages, _ := Flashes(ctx, "info"); len(messages) != 0 {
+		t.Fatalf("expected flashes to be read once, got %v", messages)
+	}
+
+	if messages, _ := Flashes(ctx, "error"); len(messages) != 1 {
+		t.Fatalf("expected other categories to be kept, got %v", messages)
+	}
+}
@@ -0,0 +1,155 @@
+package session
+
+import (
+	"context"
+	"embed"
+	"time"
+
+	"github.com/euiko/webapp/db/sqldb"
+	"github.com/uptrace/bun"
+)
+
+type (
+	ormStore struct {
+		db sqldb.OrmDB
+	}
+
+	sessionModel struct {
+		bun.BaseModel `bun:"table:sessions.sessions"`
+
+		ID         string         `bun:"id,pk"`
+		UserID     string         `bun:"user_id,nullzero"`
+		Data       map[string]any `bun:"data,type:jsonb,notnull"`
+		UserAgent  string         `bun:"user_agent"`
+		RemoteAddr string         `bun:"remote_addr"`
+		ExpiresAt  time.Time      `bun:"expires_at,notnull"`
+		CreatedAt  time.Time      `bun:"created_at,notnull,nullzero,default:current_timestamp"`
+		UpdatedAt  time.Time      `bun:"updated_at,notnull,nullzero,default:current_timestamp"`
+	}
+)
+
+var (
+	// MigrationFS contains the schema of the sql store, it must be
+	// registered using sqldb.AddMigrationFS when the sql store is used
+	//go:embed migrations
+	MigrationFS embed.FS
+)
+
+// NewOrmStore creates a store backed by the sessions.sessions table
+func NewOrmStore(db sqldb.OrmDB) Store {
+	return &ormStore{
+		db: db,
+	}
+}
+
+// Load implements Store.
+func (s *ormStore) Load(ctx context.Context, id string) (*Record, error) {
+	var model sessionModel
+	err := s.db.NewSelect().
+		Model(&model).
+		Where("id = ?", id).
+		Where("expires_at > ?", time.Now()).
+		Limit(1).
+		Scan(ctx)
+	if sqldb.IsNoRows(err) {
+		return nil, ErrSessionNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	return model.toRecord(), nil
+}
+
+// Save implements Store.
+func (s *ormStore) Save(ctx context.Context, record Record) error {
+	now := time.Now()
+	if record.CreatedAt.IsZero() {
+		record.CreatedAt = now
+	}
+
+	model := sessionModel{
+		ID:         record.ID,
+		UserID:     record.UserID,
+		Data:       record.Values,
+		UserAgent:  record.UserAgent,
+		RemoteAddr: record.RemoteAddr,
+		ExpiresAt:  record.ExpiresAt,
+		CreatedAt:  record.CreatedAt,
+		UpdatedAt:  now,
+	}
+	if model.Data == nil {
+		model.Data = map[string]any{}
+	}
+
+	_, err := s.db.NewInsert().
+		Model(&model).
+		On("CONFLICT (id) DO UPDATE").
+		Set("user_id = EXCLUDED.user_id").
+		Set("data = EXCLUDED.data").
+		Set("expires_at = EXCLUDED.expires_at").
+		Set("updated_at = EXCLUDED.updated_at").
+		Exec(ctx)
+	if err != nil || record.UserID == "" {
+		return err
+	}
+
+	// opportunistically cleanup the expired sessions of the user
+	_, err = s.db.NewDelete().
+		Model((*sessionModel)(nil)).
+		Where("user_id = ?", record.UserID).
+		Where("expires_at <= ?", now).
+		Exec(ctx)
+	return err
+}
+
+// Delete implements Store.
+func (s *ormStore) Delete(ctx context.Context, id string) error {
+	_, err := s.db.NewDelete().
+		Model((*sessionModel)(nil)).
+		Where("id = ?", id).
+		Exec(ctx)
+	return err
+}
+
+// DeleteByUser implements Store.
+func (s *ormStore) DeleteByUser(ctx context.Context, userID string) error {
+	_, err := s.db.NewDelete().
+		Model((*sessionModel)(nil)).
+		Where("user_id = ?", userID).
+		Exec(ctx)
+	return err
+}
+
+// ListByUser implements Store.
+func (s *ormStore) ListByUser(ctx context.Context, userID string) ([]Record, error) {
+	var models []sessionModel
+	err := s.db.NewSelect().
+		Model(&models).
+		Where("user_id = ?", userID).
+		Where("expires_at > ?", time.Now()).
+		Order("updated_at DESC").
+		Scan(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]Record, len(models))
+	for i, model := range models {
+		records[i] = *model.toRecord()
+	}
+
+	return records, nil
+}
+
+func (m *sessionModel) toRecord() *Record {
+	return &Record{
+		ID:         m.ID,
+		UserID:     m.UserID,
+		Values:     m.Data,
+		UserAgent:  m.UserAgent,
+		RemoteAddr: m.RemoteAddr,
+		ExpiresAt:  m.ExpiresAt,
+		CreatedAt:  m.CreatedAt,
+		UpdatedAt:  m.UpdatedAt,
+	}
+}
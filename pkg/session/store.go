@@ -0,0 +1,90 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+type (
+	// Record is the persisted state of a session, the ID is the hash of the
+	// cookie value so a leaked store never exposes usable session IDs
+	Record struct {
+		ID         string         `json:"id"`
+		UserID     string         `json:"user_id"`
+		Values     map[string]any `json:"values"`
+		UserAgent  string         `json:"user_agent"`
+		RemoteAddr string         `json:"remote_addr"`
+		ExpiresAt  time.Time      `json:"expires_at"`
+		CreatedAt  time.Time      `json:"created_at"`
+		UpdatedAt  time.Time      `json:"updated_at"`
+	}
+
+	// Store persists the sessions server-side
+	Store interface {
+		// Load returns the unexpired session, ErrSessionNotFound is returned
+		// when the session doesn't exist or already expired
+		Load(ctx context.Context, id string) (*Record, error)
+		// Save creates or replaces the session
+		Save(ctx context.Context, record Record) error
+		// Delete removes the session, deleting a missing session is not an error
+		Delete(ctx context.Context, id string) error
+		// ListByUser returns the active sessions of the user
+		ListByUser(ctx context.Context, userID string) ([]Record, error)
+		// DeleteByUser removes all of the sessions of the user
+		DeleteByUser(ctx context.Context, userID string) error
+	}
+
+	storeContextKey struct{}
+)
+
+var (
+	ErrSessionNotFound = errors.New("session not found")
+	ErrNoStore         = errors.New("session store is not configured")
+	ErrSessionTooLarge = errors.New("session is too large to be stored in cookies")
+)
+
+// WithStore injects the store into the context, so the handlers can
+// manage the sessions other than the current one
+func WithStore(ctx context.Context, store Store) context.Context {
+	return context.WithValue(ctx, storeContextKey{}, store)
+}
+
+// StoreFromContext returns the store of the current request
+func StoreFromContext(ctx context.Context) (Store, bool) {
+	store, ok := ctx.Value(storeContextKey{}).(Store)
+	return store, ok
+}
+
+// ListByUser returns the active sessions of the user from the store of
+// the current request
+func ListByUser(ctx context.Context, userID string) ([]Record, error) {
+	store, ok := StoreFromContext(ctx)
+	if !ok {
+		return nil, ErrNoStore
+	}
+
+	return store.ListByUser(ctx, userID)
+}
+
+// Revoke removes the session of the given ID from the store of the
+// current request
+func Revoke(ctx context.Context, id string) error {
+	store, ok := StoreFromContext(ctx)
+	if !ok {
+		return ErrNoStore
+	}
+
+	return store.Delete(ctx, id)
+}
+
+// RevokeByUser removes all of the sessions of the user from the store of
+// the current request, e.g. after the password of the user is changed
+func RevokeByUser(ctx context.Context, userID string) error {
+	store, ok := StoreFromContext(ctx)
+	if !ok {
+		return ErrNoStore
+	}
+
+	return store.DeleteByUser(ctx, userID)
+}
@@ -0,0 +1,177 @@
+package session
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"time"
+)
+
+type (
+	// StoreEncoding keeps only a random session ID in the cookie and
+	// persists the session values in the Store
+	StoreEncoding struct {
+		store   Store
+		cookie  Cookie
+		ttl     time.Duration
+		sliding bool
+		r       *http.Request
+		w       http.ResponseWriter
+
+		token  string
+		record *Record
+	}
+
+	StoreEncodingOption func(*StoreEncoding)
+)
+
+// WithStoreCookie sets the attributes of the session ID cookie, the MaxAge
+// is ignored since the cookie follows the session expiration
+func WithStoreCookie(cookie Cookie) StoreEncodingOption {
+	return func(e *StoreEncoding) {
+		e.cookie = cookie
+	}
+}
+
+// WithStoreTTL sets the session lifetime, when sliding is true the
+// expiration is extended on every request, otherwise it is fixed since
+// the session is created
+func WithStoreTTL(ttl time.Duration, sliding bool) StoreEncodingOption {
+	return func(e *StoreEncoding) {
+		e.ttl = ttl
+		e.sliding = sliding
+	}
+}
+
+func NewStoreEncoding(store Store, w http.ResponseWriter, r *http.Request, opts ...StoreEncodingOption) *StoreEncoding {
+	e := StoreEncoding{
+		store:   store,
+		cookie:  DefaultCookie(),
+		ttl:     24 * time.Hour,
+		sliding: true,
+		r:       r,
+		w:       w,
+	}
+
+	for _, opt := range opts {
+		opt(&e)
+	}
+
+	return &e
+}
+
+func (e *StoreEncoding) Decode() (*Session, error) {
+	session := New()
+
+	cookie, err := e.r.Cookie(e.cookie.Name)
+	if err != nil {
+		// create new session if none cookie found
+		return session, nil
+	}
+
+	e.token = cookie.Value
+	record, err := e.store.Load(e.r.Context(), hashToken(cookie.Value))
+	if errors.Is(err, ErrSessionNotFound) {
+		return session, nil
+	} else if err != nil {
+		return session, err
+	}
+
+	e.record = record
+	session.id = record.ID
+	session.userID = record.UserID
+	for key, value := range record.Values {
+		session.Map.Store(key, value)
+	}
+
+	return session, nil
+}
+
+func (e *StoreEncoding) Encode(session *Session) error {
+	var (
+		ctx = e.r.Context()
+		now = time.Now()
+	)
+
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+
+	// the unmodified session only saved to extend its expiration, while the
+	// unknown session ID is removed from the cookie
+	stale := e.token != "" && e.record == nil
+	touch := e.record != nil && e.sliding && now.Sub(e.record.UpdatedAt) > touchInterval
+	if !session.IsDirty() && !stale && !touch {
+		return nil
+	}
+
+	if session.regenerate && e.record != nil {
+		if err := e.store.Delete(ctx, e.record.ID); err != nil {
+			return err
+		}
+
+		e.record = nil
+	}
+	session.regenerate = false
+
+	// don't persist empty sessions, and remove the stale cookie if any
+	if session.isEmpty() {
+		if e.record != nil {
+			if err := e.store.Delete(ctx, e.record.ID); err != nil {
+				return err
+			}
+		}
+
+		if e.token != "" {
+			http.SetCookie(e.w, e.cookie.expire(e.cookie.Name))
+		}
+
+		session.id = ""
+		return nil
+	}
+
+	record := e.record
+	if record == nil {
+		e.token = generateToken()
+		record = &Record{
+			ID:         hashToken(e.token),
+			UserAgent:  e.r.UserAgent(),
+			RemoteAddr: e.r.RemoteAddr,
+			ExpiresAt:  now.Add(e.ttl),
+			CreatedAt:  now,
+		}
+	} else if e.sliding {
+		record.ExpiresAt = now.Add(e.ttl)
+	}
+
+	record.UserID = session.userID
+	record.Values = session.Values()
+	record.UpdatedAt = now
+	if err := e.store.Save(ctx, *record); err != nil {
+		return err
+	}
+
+	// the cookie only needs to be written when the ID or expiration changes
+	if e.record == nil || e.sliding {
+		http.SetCookie(e.w, e.cookie.build(e.cookie.Name, e.token, record.ExpiresAt))
+	}
+
+	e.record = record
+	session.id = record.ID
+	return nil
+}
+
+// hashToken derives the store ID from the cookie value
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func generateToken() string {
+	b := make([]byte, 32)
+	// crypto/rand.Read never returns an error on supported platforms
+	_, _ = rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
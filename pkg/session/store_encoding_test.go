@@ -0,0 +1,92 @@
+package session
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/euiko/webapp/db/cache"
+)
+
+func TestStoreEncoding(t *testing.T) {
+	store := NewCacheStore(cache.NewInMemory())
+
+	// roundTrip decodes the session from the cookie, applies the change
+	// and encodes it back, returning the written cookie if any
+	roundTrip := func(cookie *http.Cookie, change func(*Session)) (*Session, *http.Cookie) {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if cookie != nil {
+			r.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+
+		e := NewStoreEncoding(store, w, r)
+		s, err := e.Decode()
+		if err != nil {
+			t.Fatalf("failed to decode session: %v", err)
+		}
+
+		change(s)
+		if err := e.Encode(s); err != nil {
+			t.Fatalf("failed to encode session: %v", err)
+		}
+
+		cookies := w.Result().Cookies()
+		if len(cookies) == 0 {
+			return s, nil
+		}
+
+		return s, cookies[0]
+	}
+
+	// empty sessions are not persisted
+	_, cookie := roundTrip(nil, func(s *Session) {})
+	if cookie != nil {
+		t.Fatalf("expected no cookie for empty session, got %v", cookie)
+	}
+
+	s, cookie := roundTrip(nil, func(s *Session) {
+		s.Store("key", "value")
+		s.SetUserID("demo")
+	})
+	if cookie == nil || cookie.Value == "" {
+		t.Fatal("expected session cookie to be set")
+	}
+	firstID := s.ID()
+
+	s, _ = roundTrip(cookie, func(s *Session) {
+		if v, ok := s.Load("key"); !ok || v != "value" {
+			t.Fatalf("expected stored value, got %v", v)
+		}
+	})
+	if s.ID() != firstID {
+		t.Fatalf("expected the same session ID, got %s and %s", firstID, s.ID())
+	}
+
+	// regenerate must issue a new ID and remove the old one
+	s, regenerated := roundTrip(cookie, func(s *Session) { s.Regenerate() })
+	if regenerated == nil || regenerated.Value == cookie.Value || s.ID() == firstID {
+		t.Fatal("expected a new session ID after regenerate")
+	}
+
+	if _, err := store.Load(context.Background(), firstID); err != ErrSessionNotFound {
+		t.Fatalf("expected old session to be removed, got %v", err)
+	}
+
+	records, err := store.ListByUser(context.Background(), "demo")
+	if err != nil || len(records) != 1 || records[0].ID != s.ID() {
+		t.Fatalf("expected exactly the regenerated session listed, got %v %v", records, err)
+	}
+
+	// destroy must remove the session and expire the cookie
+	_, destroyed := roundTrip(regenerated, func(s *Session) { s.Destroy() })
+	if destroyed == nil || destroyed.MaxAge >= 0 {
+		t.Fatalf("expected the cookie to be expired, got %v", destroyed)
+	}
+
+	records, err = store.ListByUser(context.Background(), "demo")
+	if err != nil || len(records) != 0 {
+		t.Fatalf("expected no sessions after destroy, got %v %v", records, err)
+	}
+}
@@ -3,6 +3,15 @@ package token
 type (
 	Encoding interface {
 		Encode(key Key, subject string, audiences ...string) ([]byte, error)
+		// EncodeToken encodes the subject, audience and claims of the
+		// token, the other fields are set by the encoding
+		EncodeToken(key Key, t Token) ([]byte, error)
 		Decode(key Key, b []byte) (*Token, error)
 	}
+
+	// KeyIDReader is implemented by the encodings carrying the key ID in
+	// the token, so the verification key can be picked without trying all
+	KeyIDReader interface {
+		KeyID(b []byte) (string, bool)
+	}
 )
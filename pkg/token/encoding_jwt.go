@@ -1,13 +1,16 @@
 package token
 
 import (
+	"crypto/rand"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"strings"
 	"time"
 
 	"github.com/lestrrat-go/jwx/v3/jwa"
+	"github.com/lestrrat-go/jwx/v3/jws"
 	"github.com/lestrrat-go/jwx/v3/jwt"
 )
 
@@ -15,11 +18,13 @@ type (
 	JwtEncoding struct {
 		algorithm jwa.SignatureAlgorithm
 
-		useNBF   bool
-		now      func() time.Time
-		issuer   string
-		audience string
-		ttl      time.Duration
+		useNBF bool
+		// headless tokens can't carry the kid header
+		withoutKeyID bool
+		now          func() time.Time
+		issuer       string
+		audience     string
+		ttl          time.Duration
 	}
 
 	HeadlessJwtEncoding struct {
@@ -86,6 +91,7 @@ func NewJwtEncoding(algorithm jwa.SignatureAlgorithm, opts ...JwtOption) *JwtEnc
 // NewHeadlessJwtEncoding .
 func NewHeadlessJwtEncoding(algorithm jwa.SignatureAlgorithm, opts ...JwtOption) *HeadlessJwtEncoding {
 	jwtEncoding := NewJwtEncoding(algorithm, opts...)
+	jwtEncoding.withoutKeyID = true
 
 	headerData := map[string]interface{}{
 		"alg": algorithm.String(),
@@ -102,13 +108,29 @@ func NewHeadlessJwtEncoding(algorithm jwa.SignatureAlgorithm, opts ...JwtOption)
 }
 
 func (e *JwtEncoding) Encode(key Key, subject string, audiences ...string) ([]byte, error) {
+	return e.EncodeToken(key, Token{
+		Subject:  subject,
+		Audience: audiences,
+	})
+}
+
+func (e *JwtEncoding) EncodeToken(key Key, t Token) ([]byte, error) {
+	if err := validateClaims(t.Claims); err != nil {
+		return nil, err
+	}
+
 	now := e.now()
 	builder := jwt.NewBuilder().
-		Subject(subject).
-		Audience(audiences).
+		JwtID(newID()).
+		Subject(t.Subject).
+		Audience(t.Audience).
 		IssuedAt(now).
 		Issuer(e.issuer)
 
+	for name, value := range t.Claims {
+		builder.Claim(name, value)
+	}
+
 	if e.ttl > 0 {
 		builder.Expiration(now.Add(e.ttl))
 	}
@@ -122,12 +144,33 @@ func (e *JwtEncoding) Encode(key Key, subject string, audiences ...string) ([]by
 		return nil, err
 	}
 
-	return jwt.Sign(jwtToken, jwt.WithKey(e.algorithm, key.Private()))
+	var suboptions []jwt.Option
+	if kid := KeyID(key); kid != "" && !e.withoutKeyID {
+		headers := jws.NewHeaders()
+		if err := headers.Set(jws.KeyIDKey, kid); err != nil {
+			return nil, err
+		}
+
+		suboptions = append(suboptions, jws.WithProtectedHeaders(headers))
+	}
+
+	return jwt.Sign(jwtToken, jwt.WithKey(e.algorithm, signingKey(key), suboptions...))
+}
+
+// KeyID implements KeyIDReader.
+func (e *JwtEncoding) KeyID(b []byte) (string, bool) {
+	message, err := jws.Parse(b)
+	if err != nil || len(message.Signatures()) == 0 {
+		return "", false
+	}
+
+	kid, ok := message.Signatures()[0].ProtectedHeaders().KeyID()
+	return kid, ok && kid != ""
 }
 
 func (e *JwtEncoding) Decode(key Key, b []byte) (*Token, error) {
 	options := []jwt.ParseOption{
-		jwt.WithKey(e.algorithm, key.Public()),
+		jwt.WithKey(e.algorithm, verificationKey(key)),
 	}
 
 	if e.issuer != "" {
@@ -144,6 +187,10 @@ func (e *JwtEncoding) Decode(key Key, b []byte) (*Token, error) {
 	}
 
 	token := new(Token)
+	if id, ok := verified.JwtID(); ok {
+		token.ID = id
+	}
+
 	if issuer, ok := verified.Issuer(); ok {
 		token.Issuer = issuer
 	}
@@ -164,11 +211,42 @@ func (e *JwtEncoding) Decode(key Key, b []byte) (*Token, error) {
 		token.IssuedAt = issuedAt
 	}
 
+	for _, name := range verified.Keys() {
+		if _, ok := registeredClaims[name]; ok {
+			continue
+		}
+
+		var value any
+		if err := verified.Get(name, &value); err != nil {
+			return nil, err
+		}
+
+		if token.Claims == nil {
+			token.Claims = make(map[string]any)
+		}
+		token.Claims[name] = value
+	}
+
 	return token, nil
 }
 
+// newID generates a random identifier used as the jti claim
+func newID() string {
+	b := make([]byte, 16)
+	// crypto/rand.Read never returns an error on supported platforms
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
 func (e *HeadlessJwtEncoding) Encode(key Key, subject string, audiences ...string) ([]byte, error) {
-	encoded, err := e.encoding.Encode(key, subject, audiences...)
+	return e.EncodeToken(key, Token{
+		Subject:  subject,
+		Audience: audiences,
+	})
+}
+
+func (e *HeadlessJwtEncoding) EncodeToken(key Key, t Token) ([]byte, error) {
+	encoded, err := e.encoding.EncodeToken(key, t)
 	if err != nil {
 		return nil, err
 	}
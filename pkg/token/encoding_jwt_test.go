@@ -0,0 +1,48 @@
+package token
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/lestrrat-go/jwx/v3/jwa"
+)
+
+func TestJwtEncodingClaims(t *testing.T) {
+	key := NewSymetricKey([]byte("secret"))
+	encoding := NewHeadlessJwtEncoding(jwa.HS256())
+
+	encoded, err := encoding.EncodeToken(key, Token{
+		Subject:  "user",
+		Audience: []string{"webapp"},
+		Claims: map[string]any{
+			"tenant": "acme",
+			"scopes": []string{"read", "write"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded, err := encoding.Decode(key, encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if tenant, ok := Claim[string](decoded, "tenant"); !ok || tenant != "acme" {
+		t.Fatalf("expected tenant acme, got %q", tenant)
+	}
+
+	if scopes, ok := Claim[[]string](decoded, "scopes"); !ok || !reflect.DeepEqual(scopes, []string{"read", "write"}) {
+		t.Fatalf("expected scopes [read write], got %v", scopes)
+	}
+
+	if _, ok := Claim[string](decoded, "role"); ok {
+		t.Fatal("expected missing claim")
+	}
+
+	_, err = encoding.EncodeToken(key, Token{Subject: "user", Claims: map[string]any{"sub": "admin"}})
+	if !errors.Is(err, ErrReservedClaim) {
+		t.Fatalf("expected ErrReservedClaim, got %v", err)
+	}
+}
@@ -0,0 +1,188 @@
+package token
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"slices"
+	"sync/atomic"
+	"time"
+)
+
+type (
+	// OpaqueStore keeps the state of the opaque tokens, the ID is the hash
+	// of the token so a leaked store never exposes usable tokens
+	OpaqueStore interface {
+		SaveAccessToken(ctx context.Context, id string, t Token) error
+		// LoadAccessToken returns ErrTokenNotFound when the token doesn't
+		// exist or already expired
+		LoadAccessToken(ctx context.Context, id string) (*Token, error)
+		DeleteAccessToken(ctx context.Context, id string) error
+	}
+
+	// OpaqueEncoding issues random tokens carrying no information, the
+	// token is looked up from the store on decode so it can be revoked
+	// anytime. The keys are not used.
+	OpaqueEncoding struct {
+		store    atomic.Pointer[OpaqueStore]
+		now      func() time.Time
+		issuer   string
+		audience string
+		ttl      time.Duration
+	}
+
+	OpaqueOption func(*OpaqueEncoding)
+)
+
+var (
+	ErrTokenNotFound = errors.New("token not found")
+	ErrTokenExpired  = errors.New("token expired")
+	ErrInvalidToken  = errors.New("invalid token")
+	ErrNoStore       = errors.New("opaque encoding requires a store")
+)
+
+// OpaqueWithExpiration sets the expiration time of the token to the current time plus the given duration.
+func OpaqueWithExpiration(ttl time.Duration) OpaqueOption {
+	return func(e *OpaqueEncoding) {
+		e.ttl = ttl
+	}
+}
+
+// OpaqueWithIssuer sets the issuer of the token to the given string.
+func OpaqueWithIssuer(issuer string) OpaqueOption {
+	return func(e *OpaqueEncoding) {
+		e.issuer = issuer
+	}
+}
+
+// OpaqueWithAudience only accepts the tokens having the given audience.
+func OpaqueWithAudience(audience string) OpaqueOption {
+	return func(e *OpaqueEncoding) {
+		e.audience = audience
+	}
+}
+
+// OpaqueWithTimeProvider sets the time provider for the token to the given function.
+func OpaqueWithTimeProvider(now func() time.Time) OpaqueOption {
+	return func(e *OpaqueEncoding) {
+		e.now = now
+	}
+}
+
+// NewOpaqueEncoding creates a new OpaqueEncoding, the store may be nil and
+// set later using SetStore, e.g. when the database is opened afterward
+func NewOpaqueEncoding(store OpaqueStore, opts ...OpaqueOption) *OpaqueEncoding {
+	e := OpaqueEncoding{
+		now:      time.Now,
+		issuer:   "",
+		audience: "",
+		ttl:      24 * time.Hour, // default only valid for 24 hour
+	}
+
+	for _, opt := range opts {
+		opt(&e)
+	}
+
+	if store != nil {
+		e.SetStore(store)
+	}
+
+	return &e
+}
+
+// SetStore sets the store of the tokens
+func (e *OpaqueEncoding) SetStore(store OpaqueStore) {
+	e.store.Store(&store)
+}
+
+func (e *OpaqueEncoding) Encode(key Key, subject string, audiences ...string) ([]byte, error) {
+	return e.EncodeToken(key, Token{
+		Subject:  subject,
+		Audience: audiences,
+	})
+}
+
+func (e *OpaqueEncoding) EncodeToken(key Key, t Token) ([]byte, error) {
+	store, err := e.getStore()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := validateClaims(t.Claims); err != nil {
+		return nil, err
+	}
+
+	now := e.now()
+	t.ID = newID()
+	t.Issuer = e.issuer
+	t.IssuedAt = now
+	t.ExpiresAt = time.Time{}
+	if e.ttl > 0 {
+		t.ExpiresAt = now.Add(e.ttl)
+	}
+
+	b := make([]byte, 32)
+	// crypto/rand.Read never returns an error on supported platforms
+	_, _ = rand.Read(b)
+	encoded := base64.RawURLEncoding.EncodeToString(b)
+
+	// the encoding interface doesn't carry the request context
+	if err := store.SaveAccessToken(context.Background(), hashOpaque(encoded), t); err != nil {
+		return nil, err
+	}
+
+	return []byte(encoded), nil
+}
+
+func (e *OpaqueEncoding) Decode(key Key, b []byte) (*Token, error) {
+	store, err := e.getStore()
+	if err != nil {
+		return nil, err
+	}
+
+	t, err := store.LoadAccessToken(context.Background(), hashOpaque(string(b)))
+	if err != nil {
+		return nil, err
+	}
+
+	if !t.ExpiresAt.IsZero() && !t.ExpiresAt.After(e.now()) {
+		return nil, ErrTokenExpired
+	}
+
+	if e.issuer != "" && t.Issuer != e.issuer {
+		return nil, ErrInvalidToken
+	}
+
+	if e.audience != "" && !slices.Contains(t.Audience, e.audience) {
+		return nil, ErrInvalidToken
+	}
+
+	return t, nil
+}
+
+// Revoke removes the token from the store so it can't be used anymore
+func (e *OpaqueEncoding) Revoke(ctx context.Context, b []byte) error {
+	store, err := e.getStore()
+	if err != nil {
+		return err
+	}
+
+	return store.DeleteAccessToken(ctx, hashOpaque(string(b)))
+}
+
+func (e *OpaqueEncoding) getStore() (OpaqueStore, error) {
+	store := e.store.Load()
+	if store == nil {
+		return nil, ErrNoStore
+	}
+
+	return *store, nil
+}
+
+func hashOpaque(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,79 @@
+package token
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+type mapOpaqueStore struct {
+	tokens sync.Map
+}
+
+func (s *mapOpaqueStore) SaveAccessToken(ctx context.Context, id string, t Token) error {
+	s.tokens.Store(id, t)
+	return nil
+}
+
+func (s *mapOpaqueStore) LoadAccessToken(ctx context.Context, id string) (*Token, error) {
+	value, ok := s.tokens.Load(id)
+	if !ok {
+		return nil, ErrTokenNotFound
+	}
+
+	t := value.(Token)
+	return &t, nil
+}
+
+func (s *mapOpaqueStore) DeleteAccessToken(ctx context.Context, id string) error {
+	s.tokens.Delete(id)
+	return nil
+}
+
+func TestOpaqueEncoding(t *testing.T) {
+	now := time.Now()
+	encoding := NewOpaqueEncoding(nil,
+		OpaqueWithAudience("webapp"),
+		OpaqueWithTimeProvider(func() time.Time { return now }),
+		OpaqueWithExpiration(time.Minute),
+	)
+
+	if _, err := encoding.Encode(nil, "user", "webapp"); !errors.Is(err, ErrNoStore) {
+		t.Fatalf("expected ErrNoStore, got %v", err)
+	}
+
+	encoding.SetStore(&mapOpaqueStore{})
+	encoded, err := encoding.EncodeToken(nil, Token{
+		Subject:  "user",
+		Audience: []string{"webapp"},
+		Claims:   map[string]any{"tenant": "acme"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded, err := encoding.Decode(nil, encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if tenant, _ := Claim[string](decoded, "tenant"); decoded.Subject != "user" || tenant != "acme" {
+		t.Fatalf("unexpected token %+v", decoded)
+	}
+
+	now = now.Add(2 * time.Minute)
+	if _, err := encoding.Decode(nil, encoded); !errors.Is(err, ErrTokenExpired) {
+		t.Fatalf("expected ErrTokenExpired, got %v", err)
+	}
+
+	now = now.Add(-2 * time.Minute)
+	if err := encoding.Revoke(context.Background(), encoded); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := encoding.Decode(nil, encoded); !errors.Is(err, ErrTokenNotFound) {
+		t.Fatalf("expected ErrTokenNotFound, got %v", err)
+	}
+}
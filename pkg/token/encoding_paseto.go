@@ -0,0 +1,525 @@
+package token
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/chacha20"
+)
+
+type (
+	// PasetoEncoding implements the PASETO v4 tokens, the local purpose
+	// encrypts the claims using a 32 bytes symmetric key while the public
+	// purpose signs them using an Ed25519 key
+	PasetoEncoding struct {
+		header   string
+		useNBF   bool
+		now      func() time.Time
+		issuer   string
+		audience string
+		ttl      time.Duration
+		implicit []byte
+	}
+
+	PasetoOption func(*PasetoEncoding)
+
+	pasetoFooter struct {
+		KeyID string `json:"kid,omitempty"`
+	}
+)
+
+const (
+	pasetoV4LocalHeader  = "v4.local."
+	pasetoV4PublicHeader = "v4.public."
+
+	pasetoNonceSize = 32
+	pasetoMacSize   = 32
+)
+
+var (
+	ErrInvalidKeySize = errors.New("invalid key size")
+)
+
+// PasetoWithExpiration sets the expiration time of the token to the current time plus the given duration.
+func PasetoWithExpiration(ttl time.Duration) PasetoOption {
+	return func(e *PasetoEncoding) {
+		e.ttl = ttl
+	}
+}
+
+// PasetoWithIssuer sets the issuer of the token to the given string.
+func PasetoWithIssuer(issuer string) PasetoOption {
+	return func(e *PasetoEncoding) {
+		e.issuer = issuer
+	}
+}
+
+// PasetoWithNotBefore sets the nbf claim of the token to the current time.
+func PasetoWithNotBefore() PasetoOption {
+	return func(e *PasetoEncoding) {
+		e.useNBF = true
+	}
+}
+
+// PasetoWithAudience sets the audience of the token to the given string.
+func PasetoWithAudience(audience string) PasetoOption {
+	return func(e *PasetoEncoding) {
+		e.audience = audience
+	}
+}
+
+// PasetoWithTimeProvider sets the time provider for the token to the given function.
+func PasetoWithTimeProvider(now func() time.Time) PasetoOption {
+	return func(e *PasetoEncoding) {
+		e.now = now
+	}
+}
+
+// PasetoWithImplicitAssertion binds the token to the data not included in
+// the token, e.g. the tenant, it must be the same on encode and decode
+func PasetoWithImplicitAssertion(implicit []byte) PasetoOption {
+	return func(e *PasetoEncoding) {
+		e.implicit = implicit
+	}
+}
+
+// NewPasetoV4LocalEncoding creates the v4.local encoding, the key must be
+// 32 bytes either raw or base64 encoded
+func NewPasetoV4LocalEncoding(opts ...PasetoOption) *PasetoEncoding {
+	return newPasetoEncoding(pasetoV4LocalHeader, opts...)
+}
+
+// NewPasetoV4PublicEncoding creates the v4.public encoding, the key must be
+// a parsed Ed25519 key
+func NewPasetoV4PublicEncoding(opts ...PasetoOption) *PasetoEncoding {
+	return newPasetoEncoding(pasetoV4PublicHeader, opts...)
+}
+
+func newPasetoEncoding(header string, opts ...PasetoOption) *PasetoEncoding {
+	e := PasetoEncoding{
+		header:   header,
+		useNBF:   false,
+		now:      time.Now,
+		issuer:   "",
+		audience: "",
+		ttl:      24 * time.Hour, // default only valid for 24 hour
+	}
+
+	for _, opt := range opts {
+		opt(&e)
+	}
+
+	return &e
+}
+
+func (e *PasetoEncoding) Encode(key Key, subject string, audiences ...string) ([]byte, error) {
+	return e.EncodeToken(key, Token{
+		Subject:  subject,
+		Audience: audiences,
+	})
+}
+
+func (e *PasetoEncoding) EncodeToken(key Key, t Token) ([]byte, error) {
+	if err := validateClaims(t.Claims); err != nil {
+		return nil, err
+	}
+
+	now := e.now()
+	claims := make(map[string]any, len(t.Claims)+7)
+	for name, value := range t.Claims {
+		claims[name] = value
+	}
+
+	claims["jti"] = newID()
+	claims["sub"] = t.Subject
+	claims["iat"] = now.Format(time.RFC3339)
+	if e.issuer != "" {
+		claims["iss"] = e.issuer
+	}
+
+	// the registered aud claim of PASETO is a string
+	switch len(t.Audience) {
+	case 0:
+	case 1:
+		claims["aud"] = t.Audience[0]
+	default:
+		claims["aud"] = t.Audience
+	}
+
+	if e.ttl > 0 {
+		claims["exp"] = now.Add(e.ttl).Format(time.RFC3339)
+	}
+
+	if e.useNBF {
+		claims["nbf"] = now.Format(time.RFC3339)
+	}
+
+	message, err := json.Marshal(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	var footer []byte
+	if kid := KeyID(key); kid != "" {
+		footer, err = json.Marshal(pasetoFooter{KeyID: kid})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var body []byte
+	if e.header == pasetoV4LocalHeader {
+		body, err = e.encrypt(key, message, footer)
+	} else {
+		body, err = e.sign(key, message, footer)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	token := e.header + base64.RawURLEncoding.EncodeToString(body)
+	if len(footer) > 0 {
+		token += "." + base64.RawURLEncoding.EncodeToString(footer)
+	}
+
+	return []byte(token), nil
+}
+
+// KeyID implements KeyIDReader.
+func (e *PasetoEncoding) KeyID(b []byte) (string, bool) {
+	_, footer, err := e.split(b)
+	if err != nil || len(footer) == 0 {
+		return "", false
+	}
+
+	var decoded pasetoFooter
+	if err := json.Unmarshal(footer, &decoded); err != nil {
+		return "", false
+	}
+
+	return decoded.KeyID, decoded.KeyID != ""
+}
+
+func (e *PasetoEncoding) Decode(key Key, b []byte) (*Token, error) {
+	body, footer, err := e.split(b)
+	if err != nil {
+		return nil, err
+	}
+
+	var message []byte
+	if e.header == pasetoV4LocalHeader {
+		message, err = e.decrypt(key, body, footer)
+	} else {
+		message, err = e.verify(key, body, footer)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	claims := make(map[string]any)
+	if err := json.Unmarshal(message, &claims); err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	token := new(Token)
+	if err := e.validate(token, claims); err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
+
+// validate moves the registered claims into the token fields and checks
+// them the same way as the JwtEncoding
+func (e *PasetoEncoding) validate(token *Token, claims map[string]any) error {
+	now := e.now()
+	for name, value := range claims {
+		var err error
+		switch name {
+		case "jti":
+			token.ID, _ = value.(string)
+		case "iss":
+			token.Issuer, _ = value.(string)
+		case "sub":
+			token.Subject, _ = value.(string)
+		case "aud":
+			token.Audience, err = parseAudience(value)
+		case "exp":
+			token.ExpiresAt, err = parseTime(value)
+		case "iat":
+			token.IssuedAt, err = parseTime(value)
+		case "nbf":
+			var notBefore time.Time
+			notBefore, err = parseTime(value)
+			if err == nil && notBefore.After(now) {
+				return ErrInvalidToken
+			}
+		default:
+			if token.Claims == nil {
+				token.Claims = make(map[string]any)
+			}
+			token.Claims[name] = value
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	if !token.ExpiresAt.IsZero() && !token.ExpiresAt.After(now) {
+		return ErrTokenExpired
+	}
+
+	if e.issuer != "" && token.Issuer != e.issuer {
+		return ErrInvalidToken
+	}
+
+	if e.audience != "" && !slices.Contains(token.Audience, e.audience) {
+		return ErrInvalidToken
+	}
+
+	return nil
+}
+
+func (e *PasetoEncoding) split(b []byte) ([]byte, []byte, error) {
+	if !bytes.HasPrefix(b, []byte(e.header)) {
+		return nil, nil, ErrInvalidToken
+	}
+
+	parts := strings.Split(string(b[len(e.header):]), ".")
+	if len(parts) > 2 {
+		return nil, nil, ErrInvalidToken
+	}
+
+	body, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, nil, ErrInvalidToken
+	}
+
+	var footer []byte
+	if len(parts) == 2 {
+		footer, err = base64.RawURLEncoding.DecodeString(parts[1])
+		if err != nil {
+			return nil, nil, ErrInvalidToken
+		}
+	}
+
+	return body, footer, nil
+}
+
+func (e *PasetoEncoding) encrypt(key Key, message, footer []byte) ([]byte, error) {
+	secret, err := pasetoLocalKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, pasetoNonceSize)
+	// crypto/rand.Read never returns an error on supported platforms
+	_, _ = rand.Read(nonce)
+
+	encryptionKey, counterNonce, authKey, err := pasetoSplitKey(secret, nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	cipher, err := chacha20.NewUnauthenticatedCipher(encryptionKey, counterNonce)
+	if err != nil {
+		return nil, err
+	}
+
+	ciphertext := make([]byte, len(message))
+	cipher.XORKeyStream(ciphertext, message)
+
+	mac, err := pasetoMac(authKey, pae([]byte(e.header), nonce, ciphertext, footer, e.implicit))
+	if err != nil {
+		return nil, err
+	}
+
+	body := make([]byte, 0, len(nonce)+len(ciphertext)+len(mac))
+	body = append(body, nonce...)
+	body = append(body, ciphertext...)
+	return append(body, mac...), nil
+}
+
+func (e *PasetoEncoding) decrypt(key Key, body, footer []byte) ([]byte, error) {
+	secret, err := pasetoLocalKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(body) < pasetoNonceSize+pasetoMacSize {
+		return nil, ErrInvalidToken
+	}
+
+	var (
+		nonce      = body[:pasetoNonceSize]
+		ciphertext = body[pasetoNonceSize : len(body)-pasetoMacSize]
+		mac        = body[len(body)-pasetoMacSize:]
+	)
+
+	encryptionKey, counterNonce, authKey, err := pasetoSplitKey(secret, nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	expected, err := pasetoMac(authKey, pae([]byte(e.header), nonce, ciphertext, footer, e.implicit))
+	if err != nil {
+		return nil, err
+	}
+
+	if subtle.ConstantTimeCompare(mac, expected) != 1 {
+		return nil, ErrInvalidToken
+	}
+
+	cipher, err := chacha20.NewUnauthenticatedCipher(encryptionKey, counterNonce)
+	if err != nil {
+		return nil, err
+	}
+
+	message := make([]byte, len(ciphertext))
+	cipher.XORKeyStream(message, ciphertext)
+	return message, nil
+}
+
+func (e *PasetoEncoding) sign(key Key, message, footer []byte) ([]byte, error) {
+	private, ok := signingKey(key).(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%w: v4.public requires an Ed25519 private key", ErrUnsupportedKeyType)
+	}
+
+	signature := ed25519.Sign(private, pae([]byte(e.header), message, footer, e.implicit))
+	return append(append([]byte{}, message...), signature...), nil
+}
+
+func (e *PasetoEncoding) verify(key Key, body, footer []byte) ([]byte, error) {
+	public, ok := verificationKey(key).(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%w: v4.public requires an Ed25519 key", ErrUnsupportedKeyType)
+	}
+
+	if len(body) < ed25519.SignatureSize {
+		return nil, ErrInvalidToken
+	}
+
+	var (
+		message   = body[:len(body)-ed25519.SignatureSize]
+		signature = body[len(body)-ed25519.SignatureSize:]
+	)
+
+	if !ed25519.Verify(public, pae([]byte(e.header), message, footer, e.implicit), signature) {
+		return nil, ErrInvalidToken
+	}
+
+	return message, nil
+}
+
+// pasetoLocalKey returns the 32 bytes key of the v4.local, the key given in
+// the settings is commonly base64 encoded
+func pasetoLocalKey(key Key) ([]byte, error) {
+	secret := key.Private()
+	if len(secret) == 32 {
+		return secret, nil
+	}
+
+	for _, encoding := range []*base64.Encoding{base64.StdEncoding, base64.URLEncoding, base64.RawURLEncoding} {
+		decoded, err := encoding.DecodeString(string(secret))
+		if err == nil && len(decoded) == 32 {
+			return decoded, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%w: v4.local requires a 32 bytes key", ErrInvalidKeySize)
+}
+
+// pasetoSplitKey derives the encryption key, the XChaCha20 nonce and the
+// authentication key from the key and the random nonce
+func pasetoSplitKey(key, nonce []byte) ([]byte, []byte, []byte, error) {
+	hash, err := blake2b.New(56, key)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	hash.Write([]byte("paseto-encryption-key"))
+	hash.Write(nonce)
+	derived := hash.Sum(nil)
+
+	authKey, err := pasetoMac(key, append([]byte("paseto-auth-key-for-aead"), nonce...))
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	return derived[:32], derived[32:], authKey, nil
+}
+
+func pasetoMac(key, message []byte) ([]byte, error) {
+	hash, err := blake2b.New(pasetoMacSize, key)
+	if err != nil {
+		return nil, err
+	}
+
+	hash.Write(message)
+	return hash.Sum(nil), nil
+}
+
+// pae is the pre-authentication encoding of the pieces
+func pae(pieces ...[]byte) []byte {
+	var buffer bytes.Buffer
+	writeLE64 := func(n int) {
+		var b [8]byte
+		// the most significant bit is cleared for the interoperability
+		binary.LittleEndian.PutUint64(b[:], uint64(n)&^(1<<63))
+		buffer.Write(b[:])
+	}
+
+	writeLE64(len(pieces))
+	for _, piece := range pieces {
+		writeLE64(len(piece))
+		buffer.Write(piece)
+	}
+
+	return buffer.Bytes()
+}
+
+func parseAudience(value any) ([]string, error) {
+	switch v := value.(type) {
+	case string:
+		return []string{v}, nil
+	case []any:
+		audience := make([]string, 0, len(v))
+		for _, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				return nil, ErrInvalidToken
+			}
+			audience = append(audience, s)
+		}
+		return audience, nil
+	}
+
+	return nil, ErrInvalidToken
+}
+
+func parseTime(value any) (time.Time, error) {
+	s, ok := value.(string)
+	if !ok {
+		return time.Time{}, ErrInvalidToken
+	}
+
+	parsed, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, ErrInvalidToken
+	}
+
+	return parsed, nil
+}
@@ -0,0 +1,77 @@
+package token
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestPasetoEncoding(t *testing.T) {
+	secret := make([]byte, 32)
+	_, _ = rand.Read(secret)
+
+	public, err := ParsePEMKey(generatePEMKey(t, "EdDSA"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[string]struct {
+		encoding func(...PasetoOption) *PasetoEncoding
+		key      Key
+	}{
+		"v4.local":  {NewPasetoV4LocalEncoding, WithKeyID("local", NewSymetricKey([]byte(base64.StdEncoding.EncodeToString(secret))))},
+		"v4.public": {NewPasetoV4PublicEncoding, public},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			now := time.Now()
+			encoding := c.encoding(
+				PasetoWithIssuer("webapp"),
+				PasetoWithAudience("webapp"),
+				PasetoWithExpiration(time.Minute),
+				PasetoWithTimeProvider(func() time.Time { return now }),
+			)
+
+			encoded, err := encoding.EncodeToken(c.key, Token{
+				Subject:  "user",
+				Audience: []string{"webapp"},
+				Claims:   map[string]any{"tenant": "acme"},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if kid, ok := encoding.KeyID(encoded); !ok || kid != KeyID(c.key) {
+				t.Fatalf("expected kid %q, got %q", KeyID(c.key), kid)
+			}
+
+			decoded, err := encoding.Decode(c.key, encoded)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tenant, _ := Claim[string](decoded, "tenant"); decoded.Subject != "user" || decoded.Issuer != "webapp" || tenant != "acme" {
+				t.Fatalf("unexpected token %+v", decoded)
+			}
+
+			tampered := []byte(string(encoded))
+			tampered[len(encoding.header)+10] ^= 'A' ^ 'B'
+			if _, err := encoding.Decode(c.key, tampered); err == nil {
+				t.Fatal("expected tampered token to be rejected")
+			}
+
+			other := c.encoding(PasetoWithAudience("other"), PasetoWithTimeProvider(func() time.Time { return now }))
+			if _, err := other.Decode(c.key, encoded); !errors.Is(err, ErrInvalidToken) {
+				t.Fatalf("expected ErrInvalidToken, got %v", err)
+			}
+
+			now = now.Add(2 * time.Minute)
+			if _, err := encoding.Decode(c.key, encoded); !errors.Is(err, ErrTokenExpired) {
+				t.Fatalf("expected ErrTokenExpired, got %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,209 @@
+package token
+
+import (
+	"context"
+	"crypto"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v3/jwk"
+)
+
+type (
+	// RemoteKeySet verifies the tokens using the keys published by a JWKS
+	// endpoint, the keys are cached and refreshed periodically or when an
+	// unknown key ID is requested
+	RemoteKeySet struct {
+		url                string
+		client             *http.Client
+		refreshInterval    time.Duration
+		minRefreshInterval time.Duration
+
+		mutex     sync.Mutex
+		keys      map[string]Key
+		fetchedAt time.Time
+	}
+
+	RemoteKeySetOption func(*RemoteKeySet)
+)
+
+var (
+	ErrKeyNotFound = errors.New("key not found")
+)
+
+// WithHTTPClient sets the client used to fetch the key set
+func WithHTTPClient(client *http.Client) RemoteKeySetOption {
+	return func(s *RemoteKeySet) {
+		s.client = client
+	}
+}
+
+// WithRefreshInterval sets how long the fetched keys are used before
+// fetching again
+func WithRefreshInterval(interval time.Duration) RemoteKeySetOption {
+	return func(s *RemoteKeySet) {
+		s.refreshInterval = interval
+	}
+}
+
+func NewRemoteKeySet(url string, opts ...RemoteKeySetOption) *RemoteKeySet {
+	s := RemoteKeySet{
+		url:                url,
+		client:             http.DefaultClient,
+		refreshInterval:    time.Hour,
+		minRefreshInterval: 10 * time.Second,
+	}
+
+	for _, opt := range opts {
+		opt(&s)
+	}
+
+	return &s
+}
+
+// Key returns the key by its ID, the key set is fetched again when the ID
+// is unknown to support the key rotation on the remote side
+func (s *RemoteKeySet) Key(ctx context.Context, id string) (Key, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	key, ok := s.keys[id]
+	stale := time.Since(s.fetchedAt) > s.refreshInterval
+	// throttle the fetch of unknown keys to avoid flooding the endpoint
+	// using random key IDs
+	if stale || (!ok && time.Since(s.fetchedAt) > s.minRefreshInterval) {
+		if err := s.fetch(ctx); err != nil {
+			return nil, err
+		}
+
+		key, ok = s.keys[id]
+	}
+
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+
+	return key, nil
+}
+
+// Keys returns all keys of the key set
+func (s *RemoteKeySet) Keys(ctx context.Context) ([]Key, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if time.Since(s.fetchedAt) > s.refreshInterval {
+		if err := s.fetch(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	keys := make([]Key, 0, len(s.keys))
+	for _, key := range s.keys {
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+func (s *RemoteKeySet) fetch(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url, nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to fetch key set: unexpected status %d", res.StatusCode)
+	}
+
+	set, err := jwk.ParseReader(res.Body)
+	if err != nil {
+		return err
+	}
+
+	keys := make(map[string]Key, set.Len())
+	for i := 0; i < set.Len(); i++ {
+		jwkKey, ok := set.Key(i)
+		if !ok {
+			continue
+		}
+
+		id, ok := jwkKey.KeyID()
+		if !ok {
+			continue
+		}
+
+		var raw any
+		if err := jwk.Export(jwkKey, &raw); err != nil {
+			return err
+		}
+
+		key, err := NewVerificationKey(id, raw)
+		if err != nil {
+			return err
+		}
+
+		keys[id] = key
+	}
+
+	s.keys = keys
+	s.fetchedAt = time.Now()
+	return nil
+}
+
+// MarshalJWKS encodes the public part of the asymmetric keys as a JSON Web
+// Key Set, the symmetric keys are never published
+func MarshalJWKS(algorithm string, keys ...Key) ([]byte, error) {
+	set := jwk.NewSet()
+	for _, key := range keys {
+		publicKey, ok := publicKeyOf(key)
+		if !ok {
+			continue
+		}
+
+		jwkKey, err := jwk.Import(publicKey)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := jwkKey.Set(jwk.KeyIDKey, KeyID(key)); err != nil {
+			return nil, err
+		}
+
+		if err := jwkKey.Set(jwk.KeyUsageKey, jwk.ForSignature); err != nil {
+			return nil, err
+		}
+
+		if algorithm != "" {
+			if err := jwkKey.Set(jwk.AlgorithmKey, algorithm); err != nil {
+				return nil, err
+			}
+		}
+
+		if err := set.AddKey(jwkKey); err != nil {
+			return nil, err
+		}
+	}
+
+	return json.Marshal(set)
+}
+
+func publicKeyOf(key Key) (crypto.PublicKey, bool) {
+	switch k := unwrapKey(key).(type) {
+	case AsymetricKey:
+		return k.publicKey, k.publicKey != nil
+	case *AsymetricKey:
+		return k.publicKey, k.publicKey != nil
+	}
+
+	return nil, false
+}
@@ -0,0 +1,124 @@
+package token
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/lestrrat-go/jwx/v3/jwa"
+)
+
+func generatePEMKey(t *testing.T, algorithm string) []byte {
+	t.Helper()
+
+	var (
+		private crypto.Signer
+		err     error
+	)
+
+	switch algorithm {
+	case "RS256":
+		private, err = rsa.GenerateKey(rand.Reader, 2048)
+	case "ES256":
+		private, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case "EdDSA":
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+}
+
+func TestAsymmetricJwtWithRemoteKeySet(t *testing.T) {
+	for _, name := range []string{"RS256", "ES256", "EdDSA"} {
+		t.Run(name, func(t *testing.T) {
+			algorithm, ok := jwa.LookupSignatureAlgorithm(name)
+			if !ok {
+				t.Fatalf("unknown algorithm %s", name)
+			}
+
+			key, err := ParsePEMKey(generatePEMKey(t, name))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			encoding := NewJwtEncoding(algorithm)
+			encoded, err := encoding.Encode(key, "user", "webapp")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			kid, ok := encoding.KeyID(encoded)
+			if !ok || kid != key.KeyID() {
+				t.Fatalf("expected kid %q, got %q", key.KeyID(), kid)
+			}
+
+			jwks, err := MarshalJWKS(name, key, NewSymetricKey([]byte("secret")))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.Write(jwks)
+			}))
+			defer server.Close()
+
+			remote := NewRemoteKeySet(server.URL, WithHTTPClient(server.Client()))
+			verificationKey, err := remote.Key(context.Background(), kid)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if CanSign(verificationKey) {
+				t.Fatal("remote key must not be able to sign")
+			}
+
+			decoded, err := encoding.Decode(verificationKey, encoded)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if decoded.Subject != "user" {
+				t.Fatalf("expected subject user, got %q", decoded.Subject)
+			}
+
+			if _, err := remote.Key(context.Background(), "unknown"); err != ErrKeyNotFound {
+				t.Fatalf("expected ErrKeyNotFound, got %v", err)
+			}
+		})
+	}
+}
+
+func TestHeadlessJwtWithAsymmetricKey(t *testing.T) {
+	key, err := ParsePEMKey(generatePEMKey(t, "ES256"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encoding := NewHeadlessJwtEncoding(jwa.ES256())
+	encoded, err := encoding.Encode(key, "user", "webapp")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := encoding.Decode(key, encoded); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -1,19 +1,53 @@
 package token
 
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+
+	"github.com/lestrrat-go/jwx/v3/jwk"
+)
+
 type (
 	Key interface {
 		Public() []byte
 		Private() []byte
 	}
 
+	// KeyIdentifier is implemented by the keys having an identifier, it is
+	// written as the kid header so the verifier can pick the key directly
+	KeyIdentifier interface {
+		KeyID() string
+	}
+
 	SymetricKey []byte
 
 	AsymetricKey struct {
+		id      string
 		public  []byte
 		private []byte
+
+		publicKey  crypto.PublicKey
+		privateKey crypto.Signer
+	}
+
+	identifiedKey struct {
+		Key
+		id string
 	}
 )
 
+var (
+	ErrInvalidPEM         = errors.New("invalid PEM encoded key")
+	ErrUnsupportedKeyType = errors.New("unsupported key type (supported types: RSA, ECDSA, Ed25519)")
+)
+
 func NewSymetricKey(key []byte) SymetricKey {
 	return key
 }
@@ -25,6 +59,109 @@ func NewAsymetricKey(public, private []byte) AsymetricKey {
 	}
 }
 
+// ParsePEMKey parses a PEM encoded RSA, ECDSA or Ed25519 key, a private key
+// can both sign and verify while a public key can only verify. The key ID
+// is the RFC 7638 thumbprint of the public key.
+func ParsePEMKey(data []byte) (AsymetricKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return AsymetricKey{}, ErrInvalidPEM
+	}
+
+	var (
+		parsed any
+		err    error
+	)
+
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		parsed, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		parsed, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		return AsymetricKey{}, fmt.Errorf("%w: unexpected block %s", ErrInvalidPEM, block.Type)
+	}
+
+	if err != nil {
+		return AsymetricKey{}, err
+	}
+
+	key := AsymetricKey{}
+	switch k := parsed.(type) {
+	case *rsa.PrivateKey, *ecdsa.PrivateKey, ed25519.PrivateKey:
+		key.privateKey = k.(crypto.Signer)
+		key.publicKey = key.privateKey.Public()
+		key.private = data
+		key.public, err = encodePublicPEM(key.publicKey)
+	case *rsa.PublicKey, *ecdsa.PublicKey, ed25519.PublicKey:
+		key.publicKey = k
+		key.public = data
+	default:
+		return AsymetricKey{}, ErrUnsupportedKeyType
+	}
+
+	if err != nil {
+		return AsymetricKey{}, err
+	}
+
+	key.id, err = thumbprint(key.publicKey)
+	if err != nil {
+		return AsymetricKey{}, err
+	}
+
+	return key, nil
+}
+
+// NewVerificationKey creates a verify only key from the public key
+func NewVerificationKey(id string, publicKey crypto.PublicKey) (AsymetricKey, error) {
+	public, err := encodePublicPEM(publicKey)
+	if err != nil {
+		return AsymetricKey{}, err
+	}
+
+	return AsymetricKey{
+		id:        id,
+		public:    public,
+		publicKey: publicKey,
+	}, nil
+}
+
+// WithKeyID assigns the identifier to the key
+func WithKeyID(id string, key Key) Key {
+	return identifiedKey{
+		Key: key,
+		id:  id,
+	}
+}
+
+// KeyID returns the identifier of the key, or empty when the key doesn't
+// have any
+func KeyID(key Key) string {
+	if k, ok := key.(KeyIdentifier); ok {
+		return k.KeyID()
+	}
+
+	return ""
+}
+
+// CanSign returns true when the key can be used to create tokens
+func CanSign(key Key) bool {
+	switch k := unwrapKey(key).(type) {
+	case AsymetricKey:
+		return k.privateKey != nil || len(k.private) > 0
+	case *AsymetricKey:
+		return k.privateKey != nil || len(k.private) > 0
+	}
+
+	return len(key.Private()) > 0
+}
+
 func (k SymetricKey) Public() []byte {
 	return k
 }
@@ -40,3 +177,87 @@ func (k AsymetricKey) Public() []byte {
 func (k AsymetricKey) Private() []byte {
 	return k.private
 }
+
+func (k AsymetricKey) KeyID() string {
+	return k.id
+}
+
+// PublicKey returns the parsed public key, it is nil when the key is
+// created using NewAsymetricKey
+func (k AsymetricKey) PublicKey() crypto.PublicKey {
+	return k.publicKey
+}
+
+func (k identifiedKey) KeyID() string {
+	return k.id
+}
+
+func (k identifiedKey) Unwrap() Key {
+	return k.Key
+}
+
+// signingKey returns the key in the form accepted by the jwx library
+func signingKey(key Key) any {
+	switch k := unwrapKey(key).(type) {
+	case AsymetricKey:
+		if k.privateKey != nil {
+			return k.privateKey
+		}
+	case *AsymetricKey:
+		if k.privateKey != nil {
+			return k.privateKey
+		}
+	}
+
+	return key.Private()
+}
+
+// verificationKey returns the key in the form accepted by the jwx library
+func verificationKey(key Key) any {
+	switch k := unwrapKey(key).(type) {
+	case AsymetricKey:
+		if k.publicKey != nil {
+			return k.publicKey
+		}
+	case *AsymetricKey:
+		if k.publicKey != nil {
+			return k.publicKey
+		}
+	}
+
+	return key.Public()
+}
+
+func unwrapKey(key Key) Key {
+	for {
+		wrapped, ok := key.(interface{ Unwrap() Key })
+		if !ok {
+			return key
+		}
+
+		key = wrapped.Unwrap()
+	}
+}
+
+func encodePublicPEM(publicKey crypto.PublicKey) ([]byte, error) {
+	der, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), nil
+}
+
+func thumbprint(publicKey crypto.PublicKey) (string, error) {
+	key, err := jwk.Import(publicKey)
+	if err != nil {
+		return "", err
+	}
+
+	sum, err := key.Thumbprint(crypto.SHA256)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(sum), nil
+}
@@ -16,6 +16,15 @@ func (ks *KeyStore) Remove(id string) {
 	ks.keys.Delete(id)
 }
 
+func (ks *KeyStore) Get(id string) (Key, bool) {
+	value, ok := ks.keys.Load(id)
+	if !ok {
+		return nil, false
+	}
+
+	return value.(Key), true
+}
+
 func (ks *KeyStore) Keys() []Key {
 	var keys []Key
 	ks.keys.Range(func(_, value any) bool {
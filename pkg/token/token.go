@@ -1,15 +1,71 @@
 package token
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
 	"time"
 )
 
 type (
 	Token struct {
+		ID        string
 		Issuer    string
 		Subject   string
 		Audience  []string
 		ExpiresAt time.Time
 		IssuedAt  time.Time
+		// Claims are the private claims of the token, e.g. tenant or scopes
+		Claims map[string]any
 	}
 )
+
+var (
+	ErrReservedClaim = errors.New("claim name is reserved")
+
+	// registeredClaims are set by the encoding from the token fields
+	registeredClaims = map[string]struct{}{
+		"iss": {}, "sub": {}, "aud": {}, "exp": {}, "nbf": {}, "iat": {}, "jti": {},
+	}
+)
+
+// Claim returns the private claim of the token converted into T, the
+// decoded values are JSON types so they are converted through JSON when
+// not directly assignable, e.g. []any into []string
+func Claim[T any](t *Token, name string) (T, bool) {
+	var result T
+	if t == nil {
+		return result, false
+	}
+
+	value, ok := t.Claims[name]
+	if !ok {
+		return result, false
+	}
+
+	if typed, ok := value.(T); ok {
+		return typed, true
+	}
+
+	jsoned, err := json.Marshal(value)
+	if err != nil {
+		return result, false
+	}
+
+	if err := json.Unmarshal(jsoned, &result); err != nil {
+		return result, false
+	}
+
+	return result, true
+}
+
+// validateClaims rejects the private claims overriding the registered ones
+func validateClaims(claims map[string]any) error {
+	for name := range claims {
+		if _, ok := registeredClaims[name]; ok {
+			return fmt.Errorf("%w: %s", ErrReservedClaim, name)
+		}
+	}
+
+	return nil
+}
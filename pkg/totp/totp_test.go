@@ -0,0 +1,77 @@
+package totp
+
+import (
+	"encoding/base32"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestCode(t *testing.T) {
+	// the SHA-1 test vectors of RFC 6238, truncated into 6 digits
+	secret := base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+	vectors := map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1111111111:  "050471",
+		1234567890:  "005924",
+		2000000000:  "279037",
+		20000000000: "353130",
+	}
+
+	for unix, expected := range vectors {
+		code, err := Code(secret, Counter(time.Unix(unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if code != expected {
+			t.Fatalf("expected %s at %d, got %s", expected, unix, code)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	previous, err := Code(secret, Counter(now)-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	counter, ok, err := Validate(secret, previous, now, 1)
+	if err != nil || !ok || counter != Counter(now)-1 {
+		t.Fatalf("expected the previous step to be accepted, got %d %v %v", counter, ok, err)
+	}
+
+	if _, ok, _ := Validate(secret, previous, now, 0); ok {
+		t.Fatal("expected the previous step to be refused without skew")
+	}
+
+	if _, ok, _ := Validate(secret, "12345", now, 1); ok {
+		t.Fatal("expected the short code to be refused")
+	}
+
+	if _, _, err := Validate("not base32!", "123456", now, 1); err != ErrInvalidSecret {
+		t.Fatalf("expected ErrInvalidSecret, got %v", err)
+	}
+}
+
+func TestURI(t *testing.T) {
+	uri, err := url.Parse(URI("webapp", "demo@example.com", "JBSWY3DPEHPK3PXP"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if uri.Scheme != "otpauth" || uri.Host != "totp" || uri.Path != "/webapp:demo@example.com" {
+		t.Fatalf("unexpected uri %s", uri)
+	}
+
+	if uri.Query().Get("secret") != "JBSWY3DPEHPK3PXP" || uri.Query().Get("issuer") != "webapp" {
+		t.Fatalf("unexpected query %s", uri.RawQuery)
+	}
+}
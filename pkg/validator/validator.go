@@ -15,6 +15,12 @@ func Validate(i interface{}) error {
 	return validate.Struct(i)
 }
 
+// RegisterValidation adds a custom validation tag, it must be called before
+// validating the structs using the tag
+func RegisterValidation(tag string, fn validator.Func) error {
+	return validate.RegisterValidation(tag, fn)
+}
+
 func GetValidationErrors(err error) (*validator.ValidationErrors, bool) {
 	var validationErrors validator.ValidationErrors
 	if errors.As(err, &validationErrors) {
@@ -4,18 +4,29 @@ import (
 	"net/http"
 
 	"github.com/euiko/webapp/core"
+	"github.com/euiko/webapp/pkg/limit"
 	"github.com/go-chi/chi/v5"
 )
 
 // internal createServer function
-func (a *App) createServer() http.Server {
+func (a *App) createServer() (*http.Server, error) {
 	// use chi as the router
 	router := newRouter(chi.NewRouter())
 
 	// use default middlewares
 	router.Use(newInjectAppMiddleware(a))
 	router.Use(a.middlewares...)
+	if a.settings.Server.Secure.Enabled {
+		router.Use(newSecureHeadersMiddleware(&a.settings))
+	}
+	if a.settings.Server.Compression.Enabled {
+		router.Use(newCompressionMiddleware(&a.settings))
+	}
+	router.Use(limit.MaxBodySize(a.settings.Server.MaxBodySize))
 	router.Use(newSessionMiddleware(&a.settings))
+	if a.settings.Server.CSRF.Enabled {
+		router.Use(newCSRFMiddleware(&a.settings))
+	}
 
 	// register routes
 	visitModules(a.modules, func(module core.ServiceModule) error {
@@ -37,12 +48,22 @@ func (a *App) createServer() http.Server {
 	})
 
 	// creates http server
-	// TODO: add https support
-	return http.Server{
+	server := &http.Server{
 		Addr:         a.settings.Server.Addr,
 		Handler:      router,
 		ReadTimeout:  a.settings.Server.ReadTimeout,
 		WriteTimeout: a.settings.Server.WriteTimeout,
 		IdleTimeout:  a.settings.Server.IdleTimeout,
 	}
+
+	if a.settings.Server.TLS.Enabled {
+		tlsConfig, err := newTLSConfig(&a.settings.Server.TLS)
+		if err != nil {
+			return nil, err
+		}
+
+		server.TLSConfig = tlsConfig
+	}
+
+	return server, nil
 }
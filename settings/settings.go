@@ -32,7 +32,79 @@ type (
 		WriteTimeout time.Duration `mapstructure:"write_timeout"`
 		IdleTimeout  time.Duration `mapstructure:"idle_timeout"`
 		ApiPrefix    string        `mapstructure:"api_prefix"`
-		// TODO: add https support
+		MaxBodySize  int64         `mapstructure:"max_body_size"`
+		Session      Session       `mapstructure:"session"`
+		CSRF         CSRF          `mapstructure:"csrf"`
+		Compression  Compression   `mapstructure:"compression"`
+		Secure       SecureHeaders `mapstructure:"secure_headers"`
+		TLS          TLS           `mapstructure:"tls"`
+	}
+
+	TLS struct {
+		Enabled bool `mapstructure:"enabled"`
+		// CertFile and KeyFile are the PEM encoded certificate and key of
+		// the server
+		CertFile string `mapstructure:"cert_file"`
+		KeyFile  string `mapstructure:"key_file"`
+		// ClientAuth is the policy of the client certificates (valid
+		// policies: none, request, verify_if_given, require), the verified
+		// certificates may authenticate the requests
+		ClientAuth string `mapstructure:"client_auth"`
+		// ClientCAFiles are the PEM encoded CAs verifying the client
+		// certificates
+		ClientCAFiles []string `mapstructure:"client_ca_files"`
+	}
+
+	Session struct {
+		Store          string        `mapstructure:"store"`
+		TTL            time.Duration `mapstructure:"ttl"`
+		Sliding        bool          `mapstructure:"sliding"`
+		SigningKeys    []string      `mapstructure:"signing_keys"`
+		EncryptionKeys []string      `mapstructure:"encryption_keys"`
+		Cookie         SessionCookie `mapstructure:"cookie"`
+	}
+
+	SessionCookie struct {
+		Name     string        `mapstructure:"name"`
+		Path     string        `mapstructure:"path"`
+		Domain   string        `mapstructure:"domain"`
+		SameSite string        `mapstructure:"same_site"`
+		Secure   bool          `mapstructure:"secure"`
+		HTTPOnly bool          `mapstructure:"http_only"`
+		MaxAge   time.Duration `mapstructure:"max_age"`
+	}
+
+	CSRF struct {
+		Enabled        bool     `mapstructure:"enabled"`
+		Mode           string   `mapstructure:"mode"`
+		CookieName     string   `mapstructure:"cookie_name"`
+		CookieSecure   bool     `mapstructure:"cookie_secure"`
+		HeaderName     string   `mapstructure:"header_name"`
+		FieldName      string   `mapstructure:"field_name"`
+		TrustedOrigins []string `mapstructure:"trusted_origins"`
+	}
+
+	Compression struct {
+		Enabled      bool     `mapstructure:"enabled"`
+		MinSize      int      `mapstructure:"min_size"`
+		Encodings    []string `mapstructure:"encodings"`
+		ContentTypes []string `mapstructure:"content_types"`
+	}
+
+	SecureHeaders struct {
+		Enabled                   bool                `mapstructure:"enabled"`
+		HSTSMaxAge                time.Duration       `mapstructure:"hsts_max_age"`
+		HSTSIncludeSubdomains     bool                `mapstructure:"hsts_include_subdomains"`
+		HSTSPreload               bool                `mapstructure:"hsts_preload"`
+		ContentTypeNosniff        bool                `mapstructure:"content_type_nosniff"`
+		ReferrerPolicy            string              `mapstructure:"referrer_policy"`
+		PermissionsPolicy         string              `mapstructure:"permissions_policy"`
+		FrameAncestors            []string            `mapstructure:"frame_ancestors"`
+		CrossOriginOpenerPolicy   string              `mapstructure:"cross_origin_opener_policy"`
+		CrossOriginEmbedderPolicy string              `mapstructure:"cross_origin_embedder_policy"`
+		CSP                       map[string][]string `mapstructure:"csp"`
+		CSPNonceDirectives        []string            `mapstructure:"csp_nonce_directives"`
+		CSPReportOnly             bool                `mapstructure:"csp_report_only"`
 	}
 
 	Database struct {
@@ -77,7 +149,67 @@ func New() Settings {
 			WriteTimeout: 60 * time.Second,
 			IdleTimeout:  0,
 			ApiPrefix:    "/api",
-			// TODO: add https support
+			MaxBodySize:  10 << 20, // 10MB
+			Session: Session{
+				Store:          "cookie",
+				TTL:            24 * time.Hour,
+				Sliding:        true,
+				SigningKeys:    []string{},
+				EncryptionKeys: []string{},
+				Cookie: SessionCookie{
+					Name:     "session",
+					Path:     "/",
+					Domain:   "",
+					SameSite: "lax",
+					Secure:   false,
+					HTTPOnly: true,
+					MaxAge:   0,
+				},
+			},
+			CSRF: CSRF{
+				Enabled:        false,
+				Mode:           "synchronizer",
+				CookieName:     "csrf_token",
+				CookieSecure:   false,
+				HeaderName:     "X-CSRF-Token",
+				FieldName:      "csrf_token",
+				TrustedOrigins: []string{},
+			},
+			Compression: Compression{
+				Enabled:      false,
+				MinSize:      1024,
+				Encodings:    []string{"br", "zstd", "gzip", "deflate"},
+				ContentTypes: []string{},
+			},
+			Secure: SecureHeaders{
+				Enabled:                   false,
+				HSTSMaxAge:                365 * 24 * time.Hour,
+				HSTSIncludeSubdomains:     true,
+				HSTSPreload:               false,
+				ContentTypeNosniff:        true,
+				ReferrerPolicy:            "strict-origin-when-cross-origin",
+				PermissionsPolicy:         "",
+				FrameAncestors:            []string{"'self'"},
+				CrossOriginOpenerPolicy:   "same-origin",
+				CrossOriginEmbedderPolicy: "",
+				CSP: map[string][]string{
+					"default-src": {"'self'"},
+					"base-uri":    {"'self'"},
+					"object-src":  {"'none'"},
+					"img-src":     {"'self'", "data:"},
+					"script-src":  {"'self'"},
+					"style-src":   {"'self'"},
+				},
+				CSPNonceDirectives: []string{"script-src", "style-src"},
+				CSPReportOnly:      false,
+			},
+			TLS: TLS{
+				Enabled:       false,
+				CertFile:      "",
+				KeyFile:       "",
+				ClientAuth:    "none",
+				ClientCAFiles: []string{},
+			},
 		},
 		DB: Database{
 			Sql: SqlDatabase{
@@ -0,0 +1,60 @@
+package webapp
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/euiko/webapp/settings"
+)
+
+// newTLSConfig loads the server certificate and the client CAs of the tls
+// settings
+func newTLSConfig(s *settings.TLS) (*tls.Config, error) {
+	certificate, err := tls.LoadX509KeyPair(s.CertFile, s.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the server certificate: %w", err)
+	}
+
+	config := tls.Config{
+		Certificates: []tls.Certificate{certificate},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	switch s.ClientAuth {
+	case "", "none":
+		config.ClientAuth = tls.NoClientCert
+	case "request":
+		config.ClientAuth = tls.RequestClientCert
+	case "verify_if_given":
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	case "require":
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	default:
+		return nil, errors.New("invalid client auth (valid policies: none, request, verify_if_given, require)")
+	}
+
+	if len(s.ClientCAFiles) == 0 {
+		if config.ClientAuth == tls.VerifyClientCertIfGiven || config.ClientAuth == tls.RequireAndVerifyClientCert {
+			return nil, errors.New("client ca files are required to verify the client certificates")
+		}
+
+		return &config, nil
+	}
+
+	config.ClientCAs = x509.NewCertPool()
+	for _, file := range s.ClientCAFiles {
+		pem, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the client ca %s: %w", file, err)
+		}
+
+		if !config.ClientCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in the client ca %s", file)
+		}
+	}
+
+	return &config, nil
+}
@@ -8,8 +8,10 @@ import (
 
 	"github.com/euiko/webapp/core"
 	"github.com/euiko/webapp/db"
+	"github.com/euiko/webapp/db/sqldb"
 	"github.com/euiko/webapp/internal/cli"
 	"github.com/euiko/webapp/pkg/log"
+	"github.com/euiko/webapp/pkg/session"
 	"github.com/euiko/webapp/pkg/signal"
 	"github.com/euiko/webapp/settings"
 	"github.com/go-chi/chi/v5/middleware"
@@ -112,6 +114,11 @@ func (a *App) Run(ctx context.Context) error {
 	// initialize logger
 	initializeLogger(a.settings.Log)
 
+	// register the sessions schema when they are stored in the database
+	if a.settings.Server.Session.Store == "sql" {
+		sqldb.AddMigrationFS(session.MigrationFS)
+	}
+
 	// initialize modules
 	log.Trace("initializing modules...")
 	for _, module := range a.modules {
@@ -146,10 +153,13 @@ func (a *App) Start(ctx context.Context) error {
 	var err error
 	// create and initialize server
 	log.Info("starting the server...", log.WithField("addr", a.settings.Server.Addr))
-	server := a.createServer()
 	if err := db.Init(&a.settings.DB); err != nil {
 		return err
 	}
+	server, err := a.createServer()
+	if err != nil {
+		return err
+	}
 
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
@@ -167,7 +177,13 @@ func (a *App) Start(ctx context.Context) error {
 	}
 
 	go func() {
-		if e := server.ListenAndServe(); e != nil && e != http.ErrServerClosed {
+		listen := server.ListenAndServe
+		if server.TLSConfig != nil {
+			// the certificates are already loaded into the tls config
+			listen = func() error { return server.ListenAndServeTLS("", "") }
+		}
+
+		if e := listen(); e != nil && e != http.ErrServerClosed {
 			err = e
 			cancel()
 		}